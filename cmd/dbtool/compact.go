@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/flagutil"
+)
+
+// runCompact implements the compact subcommand and returns the exit code
+func runCompact(args []string) int {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	dbPath := fs.String("db", defaultDBPath, "path to the SQLite database file")
+	maxAge := fs.String("max-age", "", "drop history recorded longer ago, e.g. 30d")
+	maxVersions := fs.Int("max-versions", 0, "keep at most this many versions of each resource")
+	var kinds flagutil.StringSlice
+	fs.Var(&kinds, "kind", "retention of a kind as KIND=AGE[/VERSIONS], e.g. Event=1h (can be repeated)")
+	full := fs.Bool("full", false, "rebuild the whole database with VACUUM instead of freeing pages incrementally")
+	fs.Parse(args)
+
+	retention, err := db.ParseRetention(*maxAge, *maxVersions, kinds)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid retention: %v\n", err)
+		return 2
+	}
+
+	store, err := db.New(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	stats, err := store.Compact(retention)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Compaction failed: %v\n", err)
+		return 1
+	}
+
+	stats.ReclaimedBytes, err = store.Vacuum(*full)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Vacuum failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Dropped %d versions of %d resources\n", stats.EventsDeleted, stats.Resources)
+	fmt.Printf("Wrote %d full snapshots\n", stats.SnapshotsWritten)
+	fmt.Printf("Reclaimed %.1f MiB\n", float64(stats.ReclaimedBytes)/(1<<20))
+	return 0
+}
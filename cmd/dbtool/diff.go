@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+)
+
+// runDiff implements the diff subcommand and returns the exit code
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	dbPath := fs.String("db", defaultDBPath, "path to the SQLite database file")
+	from := fs.String("from", "", "start of the period (e.g. 14:00, 2024-05-01 14:00, -20m)")
+	to := fs.String("to", "now", "end of the period")
+	namespace := fs.String("namespace", "", "only compare resources in this namespace")
+	output := fs.String("output", "text", "output format (text or json)")
+	fs.Parse(args)
+
+	if *from == "" {
+		fmt.Fprintln(os.Stderr, "--from is required")
+		return 2
+	}
+
+	now := time.Now()
+	fromTime, err := parseTime(*from, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --from: %v\n", err)
+		return 2
+	}
+	toTime, err := parseTime(*to, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --to: %v\n", err)
+		return 2
+	}
+
+	store, err := db.New(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	result, err := store.DiffAt(fromTime, toTime, *namespace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to compare snapshots: %v\n", err)
+		return 1
+	}
+
+	switch *output {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode diff: %v\n", err)
+			return 1
+		}
+	case "text":
+		printDiff(result)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown output format %q\n", *output)
+		return 2
+	}
+
+	return 0
+}
+
+// printDiff writes a human-readable summary of the differences
+func printDiff(result *db.SnapshotDiff) {
+	fmt.Printf("Changes between %s and %s\n\n",
+		result.From.Format(time.RFC3339), result.To.Format(time.RFC3339))
+
+	if len(result.Added) == 0 && len(result.Removed) == 0 && len(result.Changed) == 0 {
+		fmt.Println("No changes")
+		return
+	}
+
+	for _, r := range result.Added {
+		fmt.Printf("+ %s\n", describeKey(r.Key()))
+	}
+	for _, r := range result.Removed {
+		fmt.Printf("- %s\n", describeKey(r.Key()))
+	}
+	for _, c := range result.Changed {
+		fmt.Printf("~ %s\n", describeKey(c.Key))
+		for _, field := range c.Fields {
+			fmt.Printf("    %s\n", field)
+		}
+	}
+
+	fmt.Printf("\n%d added, %d removed, %d changed\n", len(result.Added), len(result.Removed), len(result.Changed))
+}
+
+// describeKey formats a resource key as "Kind namespace/name (apiVersion)"
+func describeKey(key db.ResourceKey) string {
+	name := key.Name
+	if key.Namespace != "" {
+		name = key.Namespace + "/" + key.Name
+	}
+	return fmt.Sprintf("%s %s (%s)", key.Kind, name, key.APIVersion)
+}
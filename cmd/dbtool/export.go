@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/export"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/query"
+)
+
+// runExport implements the export subcommand and returns the exit code
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dbPath := fs.String("db", defaultDBPath, "path to the SQLite database file")
+	queryText := fs.String("query", "", "only export resources matching this query, e.g. \"ns:prod kind:Deployment\"")
+	at := fs.String("at", "", "export resources as they were at this time (e.g. 14:00, -20m)")
+	output := fs.String("output", "-", "file to write the manifests to, - for stdout")
+	kustomize := fs.String("kustomize", "", "write a Kustomize tree to this directory instead")
+	redactSecrets := fs.Bool("redact-secrets", false, "replace the values of Secrets with a placeholder")
+	includeGenerated := fs.Bool("include-generated", false, "include resources Kubernetes creates by itself, such as ReplicaSet Pods")
+	fs.Parse(args)
+
+	q, err := query.Parse(*queryText)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --query: %v\n", err)
+		if perr, ok := err.(*query.ParseError); ok {
+			fmt.Fprintln(os.Stderr, perr.Context())
+		}
+		return 2
+	}
+
+	var atTime time.Time
+	if *at != "" {
+		if atTime, err = parseTime(*at, time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --at: %v\n", err)
+			return 2
+		}
+	}
+
+	store, err := db.New(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	var resources []db.Resource
+	if atTime.IsZero() {
+		resources, err = store.QueryAll(q)
+	} else {
+		resources, err = queryAt(store, q, atTime)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read resources: %v\n", err)
+		return 1
+	}
+
+	manifests, err := export.Prepare(resources, export.Options{
+		RedactSecrets:    *redactSecrets,
+		IncludeGenerated: *includeGenerated,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to export resources: %v\n", err)
+		return 1
+	}
+
+	if *kustomize != "" {
+		if err := export.WriteKustomize(*kustomize, manifests); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write Kustomize tree: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Exported %d resources to %s\n", len(manifests), *kustomize)
+		return 0
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create output file: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if err := export.Write(out, manifests); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write manifests: %v\n", err)
+		return 1
+	}
+	if *output != "-" {
+		fmt.Fprintf(os.Stderr, "Exported %d resources to %s\n", len(manifests), *output)
+	}
+	return 0
+}
+
+// queryAt returns the resources matching a query as they were at the given
+// time, evaluating the query in memory over the replayed snapshot
+func queryAt(store *db.ResourceStore, q *query.Query, at time.Time) ([]db.Resource, error) {
+	snapshot, err := store.SnapshotAt(at, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []db.Resource
+	for _, r := range snapshot {
+		var obj map[string]interface{}
+		if err := json.Unmarshal([]byte(r.Data), &obj); err != nil {
+			return nil, fmt.Errorf("failed to decode %s/%s: %v", r.Kind, r.Name, err)
+		}
+		record := query.Record{
+			Name:       r.Name,
+			Namespace:  r.Namespace,
+			Kind:       r.Kind,
+			APIVersion: r.APIVersion,
+			Object:     obj,
+			Data:       r.Data,
+		}
+		if q.Match(record, at) {
+			resources = append(resources, r)
+		}
+	}
+	return resources, nil
+}
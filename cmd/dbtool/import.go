@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/importer"
+)
+
+// runImport implements the import subcommand and returns the exit code
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dbPath := fs.String("db", defaultDBPath, "path to the SQLite database file")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: dbtool import [flags] FILE...\n\n"+
+			"Loads NDJSON event logs (watcher --output ndjson) and kubectl get -o json\n"+
+			"output into the database. FILE - reads stdin.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	store, err := db.New(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	code := 0
+	for _, path := range fs.Args() {
+		n, err := importer.File(store, path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+			code = 1
+		}
+		fmt.Printf("Imported %d changes from %s\n", n, path)
+	}
+	return code
+}
@@ -0,0 +1,97 @@
+// Resource database tool
+//
+// This command-line tool works offline on the SQLite database written by the
+// TUI, e.g. to review what changed in the cluster during an incident.
+//
+// Subcommands:
+// - diff: compare the cluster inventory at two points in time
+// - compact: apply history retention and reclaim space
+// - export: write stored resources as manifests for kubectl apply
+// - import: load saved event logs and kubectl dumps
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultDBPath is the database location used by the TUI
+var defaultDBPath = filepath.Join(os.TempDir(), "k8s-resources.db")
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var code int
+	switch os.Args[1] {
+	case "diff":
+		code = runDiff(os.Args[2:])
+	case "compact":
+		code = runCompact(os.Args[2:])
+	case "export":
+		code = runExport(os.Args[2:])
+	case "import":
+		code = runImport(os.Args[2:])
+	case "help", "-h", "--help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", os.Args[1])
+		usage()
+		code = 2
+	}
+
+	os.Exit(code)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: dbtool <command> [flags]
+
+Commands:
+  diff     compare the cluster inventory at two points in time
+  compact  drop history outside the retention and reclaim space
+  export   write stored resources as YAML or a Kustomize tree
+  import   load NDJSON event logs and kubectl get -o json output
+
+Run "dbtool <command> -h" for the flags of a command.
+`)
+}
+
+// parseTime accepts RFC 3339 timestamps, local dates and times such as
+// "2006-01-02 15:04" or "15:04" (today), "now", and durations relative to
+// now such as "-20m"
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "now" {
+		return now, nil
+	}
+
+	if strings.HasPrefix(s, "-") {
+		if d, err := time.ParseDuration(s); err == nil {
+			return now.Add(d), nil
+		}
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return time.Date(now.Year(), now.Month(), now.Day(),
+				t.Hour(), t.Minute(), t.Second(), 0, now.Location()), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("can't parse time %q (use RFC 3339, \"2006-01-02 15:04\", \"15:04\" or \"-20m\")", s)
+}
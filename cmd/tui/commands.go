@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher"
+)
+
+// watchController carries out the commands of the TUI command palette
+// against the watcher and the store
+type watchController struct {
+	watcher *watcher.K8sWatcher
+	store   db.Store
+	writer  resourceWriter
+}
+
+// Watch starts watching resource types, e.g. deploy or po,svc
+func (c *watchController) Watch(spec, namespace string) error {
+	resources, err := c.watcher.ResolveResources(spec)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, resource := range resources {
+		if err := c.watcher.Watch(resource, namespace); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Unwatch stops watching resource types
+func (c *watchController) Unwatch(spec, namespace string) error {
+	resources, err := c.watcher.ResolveResources(spec)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, resource := range resources {
+		if err := c.watcher.Unwatch(resource, namespace); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Purge removes the stored resources of types that aren't watched
+func (c *watchController) Purge(spec, namespace string) (int, error) {
+	resources, err := c.watcher.ResolveResources(spec)
+	if err != nil {
+		return 0, err
+	}
+
+	// Purged resources of a watched type would be stored again by the next
+	// relist, so only unwatched types can be purged
+	for _, resource := range resources {
+		for _, watched := range c.watcher.Watched() {
+			if watched.Resource.Kind == resource.Kind && watched.Resource.APIVersion == resource.APIVersion &&
+				(watched.Namespace == "" || namespace == "" || watched.Namespace == namespace) {
+				return 0, fmt.Errorf("%s is still watched, unwatch it first", watched)
+			}
+		}
+	}
+
+	// Store the changes still queued, so that none of them come back after
+	// the purge
+	if flusher, ok := c.writer.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	purged := 0
+	for _, resource := range resources {
+		n, err := c.store.PurgeType(resource.Kind, resource.APIVersion, namespace)
+		if err != nil {
+			return purged, err
+		}
+		purged += n
+	}
+	return purged, nil
+}
+
+// Watched describes the resource types being watched
+func (c *watchController) Watched() []string {
+	var watched []string
+	for _, w := range c.watcher.Watched() {
+		watched = append(watched, w.String())
+	}
+	return watched
+}
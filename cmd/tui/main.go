@@ -1,7 +1,9 @@
 // Resource TUI for Kubernetes
 //
 // This command-line tool connects to a Kubernetes cluster, watches resources,
-// stores them in SQLite, and provides a TUI to search and display resources.
+// stores them in SQLite or PostgreSQL, and provides a TUI to search and display
+// resources. With --offline it browses an existing database, or event logs
+// and dumps loaded with --import, without a cluster.
 
 package main
 
@@ -15,20 +17,52 @@ import (
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db/postgres"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/flagutil"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/importer"
 	"github.com/worldsayshi/go-k8s-watcher/pkg/ui"
 	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher/replay"
 	"k8s.io/apimachinery/pkg/watch"
 )
 
+// resourceWriter receives the changes seen by the watcher
+type resourceWriter interface {
+	Upsert(resource db.Resource) error
+	Delete(kind, apiVersion, namespace, name string) error
+}
+
 func main() {
 	// Parse command-line flags
 	kubeconfigPath := flag.String("kubeconfig", "", "path to the kubeconfig file")
-	dbPath := flag.String("db", filepath.Join(os.TempDir(), "k8s-resources.db"), "path to the SQLite database file")
+	dbPath := flag.String("db", filepath.Join(os.TempDir(), "k8s-resources.db"),
+		"path to the SQLite database file, or a postgres:// connection URL")
 	logFilePath := flag.String("log", filepath.Join(os.TempDir(), "k8s-tui.log"), "path to the log file")
+	maxAge := flag.String("retention-max-age", "", "drop history recorded longer ago, e.g. 30d (default: keep all)")
+	maxVersions := flag.Int("retention-max-versions", 0, "keep at most this many versions of each resource (default: keep all)")
+	var kindRetention flagutil.StringSlice
+	flag.Var(&kindRetention, "retention", "history retention of a kind as KIND=AGE[/VERSIONS], e.g. Event=1h (can be repeated)")
+	compactInterval := flag.Duration("compact-interval", 10*time.Minute, "how often to apply the retention and vacuum the database, 0 for only at startup")
+	offline := flag.Bool("offline", false, "browse the database without connecting to a cluster")
+	replayPath := flag.String("replay", "", "watch a fixture recorded with watcher --record instead of a cluster")
+	replaySpeed := flag.Float64("replay-speed", 1, "replay speed, e.g. 10 for ten times faster or 0 for no delays")
+	var imports flagutil.StringSlice
+	flag.Var(&imports, "import", "load an NDJSON event log or kubectl get -o json output into the database first (can be repeated)")
 	flag.Parse()
 
+	retention, err := db.ParseRetention(*maxAge, *maxVersions, kindRetention)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid retention: %v\n", err)
+		os.Exit(2)
+	}
+	if *compactInterval < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid --compact-interval %v: must not be negative\n", *compactInterval)
+		os.Exit(2)
+	}
+
 	// Set up logging to a file instead of stdout
 	logFile, err := os.OpenFile(*logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
@@ -40,11 +74,56 @@ func main() {
 	log.Printf("TUI application started, logs redirected to %s", *logFilePath)
 
 	// Create database store
-	store, err := db.New(*dbPath)
-	if err != nil {
-		log.Fatalf("Failed to create database: %v", err)
+	var store db.Store
+	var writer resourceWriter
+	if postgres.IsDSN(*dbPath) {
+		if len(imports) > 0 {
+			fmt.Fprintln(os.Stderr, "--import needs a SQLite database")
+			os.Exit(2)
+		}
+		pgStore, err := postgres.New(*dbPath)
+		if err != nil {
+			log.Fatalf("Failed to connect to database: %v", err)
+		}
+		defer pgStore.Close()
+		store, writer = pgStore, pgStore
+	} else {
+		sqliteStore, err := db.New(*dbPath)
+		if err != nil {
+			log.Fatalf("Failed to create database: %v", err)
+		}
+		defer sqliteStore.Close()
+
+		for _, path := range imports {
+			n, err := importer.File(sqliteStore, path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+				sqliteStore.Close()
+				os.Exit(1)
+			}
+			log.Printf("Imported %d changes from %s", n, path)
+		}
+
+		// Group the flood of events from the initial list into transactions
+		batchWriter := db.NewBatchWriter(sqliteStore, 500, 250*time.Millisecond)
+		defer batchWriter.Close()
+		store, writer = sqliteStore, batchWriter
+
+		// Bound the growth of the history. Offline databases are left as
+		// they are, as their history may be older than the retention.
+		if !*offline {
+			compactor := db.NewCompactor(sqliteStore, retention, *compactInterval)
+			defer compactor.Close()
+		}
+	}
+
+	if *offline {
+		log.Println("Offline mode, not connecting to a cluster")
+		if err := ui.Run(store, nil); err != nil {
+			log.Printf("Error in UI: %v", err)
+		}
+		return
 	}
-	defer store.Close()
 
 	// Setup watcher options - watch all resources in all namespaces
 	opts := watcher.Options{
@@ -53,8 +132,22 @@ func main() {
 		Namespace:      "", // Empty string means all namespaces
 	}
 
-	// Create Kubernetes watcher
-	k8sWatcher, err := watcher.NewWatcher(opts)
+	// Create Kubernetes watcher, or replay a recorded cluster
+	var k8sWatcher *watcher.K8sWatcher
+	if *replayPath != "" {
+		var fixture *replay.Fixture
+		fixture, err = replay.Load(*replayPath)
+		if err != nil {
+			log.Fatalf("Failed to load replay: %v", err)
+		}
+		client := replay.NewClient(fixture, *replaySpeed)
+		k8sWatcher, err = watcher.NewWatcherWithClients(opts, watcher.Clients{
+			Dynamic:   client,
+			Discovery: client.Discovery(),
+		})
+	} else {
+		k8sWatcher, err = watcher.NewWatcher(opts)
+	}
 	if err != nil {
 		log.Fatalf("Failed to create watcher: %v", err)
 	}
@@ -88,13 +181,13 @@ func main() {
 					ResourceVersion: event.ResourceVersion,
 					Data:            string(resourceData),
 				}
-				if err := store.Upsert(r); err != nil {
+				if err := writer.Upsert(r); err != nil {
 					log.Printf("Failed to store resource: %v", err)
 				}
 
 			case watch.Deleted:
 				// Remove resource from the database
-				if err := store.Delete(
+				if err := writer.Delete(
 					event.Resource.Kind,
 					event.Resource.APIVersion,
 					event.Namespace,
@@ -105,21 +198,29 @@ func main() {
 			}
 		}
 
+		log.Println("Collecting resources...")
 		if err := k8sWatcher.Start(ctx, eventHandler); err != nil {
-			log.Printf("Failed to start watcher: %v", err)
+			if ctx.Err() == nil {
+				log.Printf("Failed to start watcher: %v", err)
+			}
 			cancel()
 			return
 		}
 
-		log.Println("Resource watcher started. Collecting resources...")
+		log.Println("Resources collected, watching for changes")
 	}()
 
-	// Run the TUI
-	if err := ui.Run(store); err != nil {
+	// Run the TUI, whose command palette changes what is watched
+	controller := &watchController{watcher: k8sWatcher, store: store, writer: writer}
+	if err := ui.Run(store, controller); err != nil {
 		log.Printf("Error in UI: %v", err)
 	}
 
 	// Clean up when UI exits
 	cancel()
-	k8sWatcher.Stop()
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer stopCancel()
+	if running := k8sWatcher.Stop(stopCtx); running != nil {
+		log.Printf("Watchers still running at exit: %v", running)
+	}
 }
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/cloudevents"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/config"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/rules"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher"
+)
+
+// configReloadInterval is how often the config file is checked for changes
+const configReloadInterval = 2 * time.Second
+
+// configuredFlags are the flags that the config file replaces
+var configuredFlags = []string{
+	"namespace", "all", "kind", "api-version", "all-namespaces", "kubeconfig",
+	"cloudevents-sink", "cloudevents-mode", "cluster", "rules", "filter", "output",
+	"inline-warnings",
+}
+
+// configuredWatch sends events to the sinks of a config file, which are
+// replaced when the file changes
+type configuredWatch struct {
+	path   string
+	config *config.Config
+	// status is where status messages go
+	status io.Writer
+	// store writes events to the database of the config, nil without one
+	store *eventStore
+
+	mu    sync.RWMutex
+	sinks *sinks
+}
+
+// sinks are the destinations of events set up for a config
+type sinks struct {
+	handler watcher.EventHandler
+	// engines are the rules engines, which a reload keeps when their rules
+	// are unchanged, so their pending and firing alerts carry over
+	engines []*rulesEngine
+	closers []io.Closer
+}
+
+// rulesEngine is a running rules engine and the rules it was created with
+type rulesEngine struct {
+	*rules.Engine
+	config *rules.Config
+	// cancel stops the engine's evaluation of pending alerts
+	cancel context.CancelFunc
+}
+
+// Close stops the engine and releases its notifiers
+func (e *rulesEngine) Close() error {
+	e.cancel()
+	return e.Engine.Close()
+}
+
+// close releases the files and rules engines of the sinks, except the
+// engines kept by the sinks of a reloaded config
+func (s *sinks) close(keep ...*rulesEngine) {
+	closers := s.closers
+	for _, e := range s.engines {
+		if !slices.Contains(keep, e) {
+			closers = append(closers, e)
+		}
+	}
+	for _, c := range closers {
+		if err := c.Close(); err != nil {
+			log.Printf("Failed to close sink: %v", err)
+		}
+	}
+}
+
+// engine returns a running engine of the sinks with the given rules, or nil
+func (s *sinks) engine(config *rules.Config, taken []*rulesEngine) *rulesEngine {
+	if s == nil {
+		return nil
+	}
+	for _, e := range s.engines {
+		if !slices.Contains(taken, e) && reflect.DeepEqual(e.config, config) {
+			return e
+		}
+	}
+	return nil
+}
+
+// checkConfigFlags fails when flags that the config file replaces are set
+func checkConfigFlags() error {
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		for _, name := range configuredFlags {
+			if f.Name == name && err == nil {
+				err = fmt.Errorf("--%s can't be combined with --config, set it in the config file", name)
+			}
+		}
+	})
+	return err
+}
+
+// loadConfiguredWatch loads a config file and sets up its store and sinks
+func loadConfiguredWatch(ctx context.Context, path string) (*configuredWatch, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &configuredWatch{path: path, config: cfg, status: os.Stdout}
+	for _, sink := range cfg.Sinks {
+		if sink.Type == config.SinkNDJSON && sink.Path == "" {
+			// Status messages go to stderr when stdout carries events
+			c.status = os.Stderr
+		}
+	}
+
+	if cfg.Store != nil {
+		if c.store, err = openStore(cfg.Store); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.sinks, err = c.newSinks(ctx, cfg, nil); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// newSinks sets up the sinks of a config, with its transforms applied to
+// the events they receive. Rules engines of the previous sinks whose rules
+// are unchanged are reused.
+func (c *configuredWatch) newSinks(ctx context.Context, cfg *config.Config, previous *sinks) (*sinks, error) {
+	s := &sinks{}
+	// reused engines belong to the previous sinks until the reload succeeds
+	var reused []*rulesEngine
+
+	sinkConfigs := cfg.Sinks
+	if len(sinkConfigs) == 0 {
+		sinkConfigs = []config.Sink{{Type: config.SinkLog}}
+	}
+
+	var handlers []watcher.EventHandler
+	for i, sink := range sinkConfigs {
+		switch sink.Type {
+		case config.SinkLog:
+			handlers = append(handlers, logHandler(sink.Warnings.Duration))
+
+		case config.SinkNDJSON:
+			if sink.Path == "" {
+				handlers = append(handlers, ndjsonHandler(os.Stdout))
+				continue
+			}
+			f, err := os.OpenFile(sink.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				s.close()
+				return nil, fmt.Errorf("sinks[%d]: %v", i, err)
+			}
+			s.closers = append(s.closers, f)
+			handlers = append(handlers, ndjsonHandler(f))
+
+		case config.SinkCloudEvents:
+			mode, err := cloudevents.ParseMode(sink.Mode)
+			if err != nil {
+				s.close()
+				return nil, fmt.Errorf("sinks[%d]: %v", i, err)
+			}
+			emitter := cloudevents.NewEmitter(sink.URL, sink.Cluster, mode)
+			s.closers = append(s.closers, emitter)
+			handlers = append(handlers, emitter.Handler())
+
+		case config.SinkRules:
+			rulesConfig, err := rules.LoadConfig(sink.Path)
+			if err != nil {
+				s.close()
+				return nil, fmt.Errorf("sinks[%d]: %v", i, err)
+			}
+			if e := previous.engine(rulesConfig, reused); e != nil {
+				reused = append(reused, e)
+				handlers = append(handlers, e.HandleEvent)
+				continue
+			}
+
+			engine, err := newRulesEngine(rulesConfig)
+			if err != nil {
+				s.close()
+				return nil, fmt.Errorf("sinks[%d]: %v", i, err)
+			}
+			engineCtx, cancel := context.WithCancel(ctx)
+			s.engines = append(s.engines, &rulesEngine{Engine: engine, config: rulesConfig, cancel: cancel})
+			go engine.Run(engineCtx)
+			handlers = append(handlers, engine.HandleEvent)
+		}
+	}
+	if c.store != nil {
+		handlers = append(handlers, c.store.handle)
+	}
+
+	handler, err := config.TransformHandler(cfg.Transforms, func(event watcher.ResourceEvent) {
+		for _, h := range handlers {
+			h(event)
+		}
+	})
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	s.handler = handler
+	s.engines = append(s.engines, reused...)
+	return s, nil
+}
+
+// inlinesWarnings reports whether a log sink of the config lists warnings
+func (c *configuredWatch) inlinesWarnings() bool {
+	for _, sink := range c.config.Sinks {
+		if sink.Warnings.Duration != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// handle passes an event to the current sinks
+func (c *configuredWatch) handle(event watcher.ResourceEvent) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.sinks.handler(event)
+}
+
+// watch reloads the config file when it changes, until ctx is done
+func (c *configuredWatch) watch(ctx context.Context, w *watcher.K8sWatcher) {
+	config.Watch(ctx, c.path, configReloadInterval, func(cfg *config.Config, err error) {
+		if err != nil {
+			log.Printf("Keeping the current configuration: %v", err)
+			return
+		}
+		c.reload(ctx, w, cfg)
+	})
+}
+
+// reload replaces the sinks and changes the watches to those of a new
+// config. The kubeconfig, context and store are only set up at startup.
+func (c *configuredWatch) reload(ctx context.Context, w *watcher.K8sWatcher, cfg *config.Config) {
+	if cfg.Kubeconfig != c.config.Kubeconfig || cfg.Context != c.config.Context ||
+		!reflect.DeepEqual(cfg.Store, c.config.Store) {
+		log.Printf("Changes to the kubeconfig, context or store of %s take effect after a restart", c.path)
+	}
+
+	// Replace the sinks first, so that the resources of new watches are
+	// sent to the new sinks
+	sinks, err := c.newSinks(ctx, cfg, c.sinks)
+	if err != nil {
+		log.Printf("Keeping the current configuration: %v", err)
+		return
+	}
+	c.mu.Lock()
+	old := c.sinks
+	c.sinks = sinks
+	c.mu.Unlock()
+	old.close(sinks.engines...)
+	c.config = cfg
+
+	if err := w.Reconfigure(cfg.Options()); err != nil {
+		log.Printf("Reloaded %s, but not every watch could be changed: %v", c.path, err)
+		return
+	}
+	log.Printf("Reloaded %s", c.path)
+}
+
+// close releases the sinks and the store
+func (c *configuredWatch) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sinks != nil {
+		c.sinks.close()
+	}
+	if c.store != nil {
+		c.store.Close()
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/config"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher/replay"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// configMapRules alerts on ConfigMaps after an hour, so alerts stay pending
+const configMapRules = `rules:
+  - name: configmap-seen
+    match: resource.kind == 'ConfigMap'
+    for: 1h
+    message: "{{ .Name }}"
+`
+
+func TestReloadKeepsUnchangedRulesEngines(t *testing.T) {
+	dir := t.TempDir()
+	rulesPath := filepath.Join(dir, "rules.yaml")
+	if err := os.WriteFile(rulesPath, []byte(configMapRules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{Sinks: []config.Sink{{Type: config.SinkRules, Path: rulesPath}}}
+
+	// Reloads reconfigure a watcher that isn't running
+	client := replay.NewClient(&replay.Fixture{}, 0)
+	w, err := watcher.NewWatcherWithClients(cfg.Options(), watcher.Clients{Dynamic: client, Discovery: client.Discovery()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := &configuredWatch{path: filepath.Join(dir, "watcher.yaml"), config: cfg}
+	if c.sinks, err = c.newSinks(ctx, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+
+	c.handle(watcher.ResourceEvent{
+		Type:     watch.Added,
+		Resource: watcher.ResourceToWatch{Kind: "ConfigMap", APIVersion: "v1"},
+		Name:     "settings", Namespace: "default",
+		Object: map[string]interface{}{"metadata": map[string]interface{}{"name": "settings"}},
+	})
+	engine := c.sinks.engines[0]
+	if n := len(engine.Alerts()); n != 1 {
+		t.Fatalf("%d alerts before the reload, want 1", n)
+	}
+
+	// Other changes keep the engine with its pending alert
+	changed := &config.Config{
+		Sinks:   append([]config.Sink{{Type: config.SinkLog}}, cfg.Sinks...),
+		Filters: []string{"true"},
+	}
+	c.reload(ctx, w, changed)
+	if len(c.sinks.engines) != 1 || c.sinks.engines[0] != engine {
+		t.Fatalf("engines after reload = %v, want the running engine", c.sinks.engines)
+	}
+	if n := len(engine.Alerts()); n != 1 {
+		t.Errorf("%d alerts after the reload, want 1", n)
+	}
+
+	// Changed rules get a new engine
+	if err := os.WriteFile(rulesPath, []byte(configMapRules+"    severity: critical\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.reload(ctx, w, changed)
+	if len(c.sinks.engines) != 1 || c.sinks.engines[0] == engine {
+		t.Fatal("the engine was kept after its rules changed")
+	}
+	if n := len(c.sinks.engines[0].Alerts()); n != 0 {
+		t.Errorf("%d alerts in the new engine, want 0", n)
+	}
+}
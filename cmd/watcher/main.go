@@ -9,6 +9,11 @@
 // - Monitor specific namespaces or across all namespaces
 // - Automatically discover available resources in the cluster
 // - Reconnect automatically if connection is lost
+// - Wait for objects to reach a condition (watcher wait)
+// - Write events as NDJSON for import into a resource database (--output ndjson)
+// - Record the watch streams to a fixture and replay them without a cluster
+// - Configure everything in a YAML file that is reloaded when it changes
+// - List the recent Warning events of modified objects (--inline-warnings)
 
 package main
 
@@ -17,56 +22,62 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/worldsayshi/go-k8s-watcher/pkg/cloudevents"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/flagutil"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/rules"
 	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher/replay"
 	"k8s.io/apimachinery/pkg/watch"
 )
 
 func main() {
+	// Dispatch subcommands
+	if len(os.Args) > 1 && os.Args[1] == "wait" {
+		os.Exit(runWait(os.Args[2:]))
+	}
+
 	// Parse command line arguments
 	namespace := flag.String("namespace", "default", "namespace to watch (for namespaced resources)")
 	watchAll := flag.Bool("all", false, "watch all available resources")
-	resourceKind := flag.String("kind", "", "specific resource kind to watch (e.g., Pod, Deployment)")
-	apiVersion := flag.String("api-version", "", "API version of the resource (e.g., v1, apps/v1)")
+	resourceKind := flag.String("kind", "", "resource types to watch as kubectl takes them (e.g., Pod, deploy, deployments.apps, po,svc,cm)")
+	apiVersion := flag.String("api-version", "", "API version of the --kind (e.g., v1, apps/v1), resolved if empty")
 	allNamespaces := flag.Bool("all-namespaces", false, "watch resources across all namespaces")
 	kubeconfigPath := flag.String("kubeconfig", "", "path to the kubeconfig file")
+	cloudEventsSink := flag.String("cloudevents-sink", "", "URL to post events to as CloudEvents")
+	cloudEventsMode := flag.String("cloudevents-mode", "binary", "CloudEvents HTTP content mode (binary or structured)")
+	clusterName := flag.String("cluster", "", "cluster name used as the source of CloudEvents")
+	rulesPath := flag.String("rules", "", "path to a YAML file with alerting rules")
+	var filters flagutil.StringSlice
+	flag.Var(&filters, "filter", "CEL expression events must satisfy, e.g. \"resource.kind == 'Pod'\" (can be repeated)")
+	output := flag.String("output", "text", "event output format: text, or ndjson for one JSON event per line on stdout")
+	recordPath := flag.String("record", "", "record the watch streams to a fixture file for --replay")
+	replayPath := flag.String("replay", "", "watch a fixture file recorded with --record instead of a cluster")
+	replaySpeed := flag.Float64("replay-speed", 1, "replay speed, e.g. 10 for ten times faster or 0 for no delays")
+	inlineWarnings := flag.Duration("inline-warnings", 0, "list the Warning events seen within this duration, e.g. 15m, when logging a modified object; needs Events to be watched")
+	configPath := flag.String("config", "", "path to a YAML file configuring what to watch and where events go, reloaded when it changes")
 
-	flag.Parse()
-
-	// Set up the watcher options
-	opts := watcher.Options{
-		KubeconfigPath: *kubeconfigPath,
-		WatchAll:       *watchAll,
-	}
-
-	// Determine namespace to watch
-	if *allNamespaces {
-		opts.Namespace = "" // Empty string means all namespaces
-	} else {
-		opts.Namespace = *namespace
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: watcher [flags] [TYPE[,TYPE...]...]\n\n"+
+			"Resource types can be given as arguments like kubectl get takes them, e.g. po,svc or deployments.apps.\n\nFlags:\n")
+		flag.PrintDefaults()
 	}
+	flag.Parse()
 
-	// If specific resource is requested
-	if *resourceKind != "" && *apiVersion != "" {
-		opts.ResourceTypes = []watcher.ResourceToWatch{
-			{
-				Kind:       *resourceKind,
-				APIVersion: *apiVersion,
-				// Let the watcher determine if resource is namespaced
-				Namespaced: true, // Default value, will be checked by the watcher
-			},
+	// Resource types given as arguments are the same as --kind
+	if flag.NArg() > 0 {
+		if *resourceKind != "" || *configPath != "" {
+			log.Fatal("Resource types can't be given both as arguments and with --kind or --config")
 		}
-	}
-
-	// Create a new watcher
-	k8sWatcher, err := watcher.NewWatcher(opts)
-	if err != nil {
-		log.Fatalf("Failed to create watcher: %v", err)
+		*resourceKind = strings.Join(flag.Args(), ",")
 	}
 
 	// Create a context that can be canceled on SIGINT/SIGTERM
@@ -82,30 +93,207 @@ func main() {
 		cancel()
 	}()
 
-	// Log which namespace we're watching
-	if *allNamespaces {
-		fmt.Println("Starting to watch resources across all namespaces")
+	var (
+		opts    watcher.Options
+		handler watcher.EventHandler
+		status  io.Writer
+		// configured is set when the watcher is set up by a config file
+		configured *configuredWatch
+	)
+
+	if *configPath != "" {
+		if err := checkConfigFlags(); err != nil {
+			log.Fatal(err)
+		}
+		var err error
+		configured, err = loadConfiguredWatch(ctx, *configPath)
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		defer configured.close()
+		opts = configured.config.Options()
+		handler = configured.handle
+		status = configured.status
 	} else {
-		fmt.Printf("Starting to watch resources in namespace: %s\n", *namespace)
+		// Status messages go to stderr when stdout carries events
+		status = os.Stdout
+		var logEvent watcher.EventHandler
+		switch *output {
+		case "text":
+			logEvent = logHandler(*inlineWarnings)
+		case "ndjson":
+			if *inlineWarnings != 0 {
+				log.Fatal("--inline-warnings only applies to --output text")
+			}
+			status = os.Stderr
+			logEvent = ndjsonHandler(os.Stdout)
+		default:
+			log.Fatalf("Unknown output format %q (expected text or ndjson)", *output)
+		}
+
+		// Set up the watcher options
+		opts = watcher.Options{
+			KubeconfigPath: *kubeconfigPath,
+			WatchAll:       *watchAll,
+			Filters:        filters,
+		}
+
+		// Determine namespace to watch
+		if *allNamespaces {
+			opts.Namespace = "" // Empty string means all namespaces
+		} else {
+			opts.Namespace = *namespace
+		}
+
+		// If specific resources are requested. The watcher resolves their
+		// API version and scope.
+		if *resourceKind != "" {
+			opts.ResourceTypes = []watcher.ResourceToWatch{{Kind: *resourceKind, APIVersion: *apiVersion}}
+		} else if *apiVersion != "" {
+			log.Fatal("--api-version needs --kind")
+		}
+
+		// Collect the handlers every event is passed to
+		handlers := []watcher.EventHandler{logEvent}
+
+		// Forward events as CloudEvents if a sink is configured
+		if *cloudEventsSink != "" {
+			mode, err := cloudevents.ParseMode(*cloudEventsMode)
+			if err != nil {
+				log.Fatalf("Invalid --cloudevents-mode: %v", err)
+			}
+			emitter := cloudevents.NewEmitter(*cloudEventsSink, *clusterName, mode)
+			defer emitter.Close()
+			handlers = append(handlers, emitter.Handler())
+		}
+
+		// Evaluate alerting rules if a rules file is given
+		if *rulesPath != "" {
+			rulesConfig, err := rules.LoadConfig(*rulesPath)
+			if err != nil {
+				log.Fatalf("Failed to load rules: %v", err)
+			}
+			engine, err := newRulesEngine(rulesConfig)
+			if err != nil {
+				log.Fatalf("Failed to load rules: %v", err)
+			}
+			defer engine.Close()
+			handlers = append(handlers, engine.HandleEvent)
+			go engine.Run(ctx)
+		}
+
+		handler = func(event watcher.ResourceEvent) {
+			for _, h := range handlers {
+				h(event)
+			}
+		}
+	}
+
+	// Create a new watcher
+	k8sWatcher, recorder, err := newWatcher(opts, *recordPath, *replayPath, *replaySpeed)
+	if err != nil {
+		log.Fatalf("Failed to create watcher: %v", err)
+	}
+
+	// Log which namespace we're watching
+	switch {
+	case configured != nil:
+		fmt.Fprintf(status, "Starting to watch resources configured in %s\n", *configPath)
+	case *allNamespaces:
+		fmt.Fprintln(status, "Starting to watch resources across all namespaces")
+	default:
+		fmt.Fprintf(status, "Starting to watch resources in namespace: %s\n", *namespace)
 	}
 
 	// Start the watcher with our event handler
-	if err := k8sWatcher.Start(ctx, eventHandler); err != nil {
+	if err := k8sWatcher.Start(ctx, handler); err != nil {
 		log.Fatalf("Failed to start watcher: %v", err)
 	}
 
-	fmt.Println("Watchers started. Press Ctrl+C to exit.")
+	// Apply changes to the config file while watching
+	if configured != nil {
+		go configured.watch(ctx, k8sWatcher)
+	}
+
+	// Warnings are learned from the watched Events
+	if (*inlineWarnings != 0 || (configured != nil && configured.inlinesWarnings())) && !watchesEvents(k8sWatcher) {
+		log.Println("Warnings are only inlined while Events are watched, e.g. add events to the resource types")
+	}
 
-	// Wait for context to be done (from signal handler)
-	<-ctx.Done()
+	fmt.Fprintln(status, "Watchers started. Press Ctrl+C to exit.")
+
+	// Wait for a signal, or for the watcher to give up on its own
+	select {
+	case <-ctx.Done():
+	case <-k8sWatcher.Done():
+		log.Printf("Watcher ended: %v", k8sWatcher.Err())
+	}
 
 	// Stop the watcher gracefully
-	k8sWatcher.Stop()
-	fmt.Println("Watcher stopped cleanly")
+	if running := stopWatcher(k8sWatcher); running != nil {
+		fmt.Fprintf(status, "Watchers still running at exit: %v\n", running)
+	} else {
+		fmt.Fprintln(status, "Watcher stopped cleanly")
+	}
+
+	if recorder != nil {
+		if err := recorder.Save(*recordPath); err != nil {
+			log.Fatalf("Failed to save recording: %v", err)
+		}
+		fmt.Fprintf(status, "Recording saved to %s\n", *recordPath)
+	}
+}
+
+// stopWatcher stops a watcher, giving its resource watchers a few seconds to
+// exit, and returns those that didn't
+func stopWatcher(w *watcher.K8sWatcher) []watcher.ResourceToWatch {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return w.Stop(ctx)
+}
+
+// newWatcher creates a watcher for the cluster, for a recording of one when
+// replayPath is set, or for the cluster while recording it when recordPath
+// is set
+func newWatcher(opts watcher.Options, recordPath, replayPath string, speed float64) (*watcher.K8sWatcher, *replay.Recorder, error) {
+	if replayPath != "" {
+		fixture, err := replay.Load(replayPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		client := replay.NewClient(fixture, speed)
+		w, err := watcher.NewWatcherWithClients(opts, watcher.Clients{
+			Dynamic:   client,
+			Discovery: client.Discovery(),
+		})
+		return w, nil, err
+	}
+
+	if recordPath == "" {
+		w, err := watcher.NewWatcher(opts)
+		return w, nil, err
+	}
+
+	clients, err := watcher.NewClients(opts.KubeconfigPath, opts.Context)
+	if err != nil {
+		return nil, nil, err
+	}
+	recorder := replay.NewRecorder(clients.Dynamic)
+	if err := recorder.RecordDiscovery(clients.Discovery); err != nil {
+		return nil, nil, err
+	}
+	clients.Dynamic = recorder
+	w, err := watcher.NewWatcherWithClients(opts, clients)
+	return w, recorder, err
 }
 
 // eventHandler processes resource events
 func eventHandler(event watcher.ResourceEvent) {
+	log.Println(formatEvent(event))
+}
+
+// formatEvent describes a resource event in a log line
+func formatEvent(event watcher.ResourceEvent) string {
 	var logMsg string
 
 	// Create a resource string for display
@@ -161,17 +349,51 @@ func eventHandler(event watcher.ResourceEvent) {
 		}
 	}
 
-	// Log the event
-	log.Println(logMsg)
+	return logMsg
+}
 
-	// Debug extra information for specific objects we're interested in
-	if event.Type == watch.Modified && (contains(event.Name, "nginx") ||
-		contains(event.Name, "test-app") ||
-		contains(event.Name, "test-config")) {
-		log.Printf("[DEBUG] Detected change to watched object: %s/%s", event.Namespace, event.Name)
+// ndjsonHandler writes every change as a line of JSON, which the TUI and
+// dbtool import can load into a resource database
+func ndjsonHandler(w io.Writer) watcher.EventHandler {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+
+	return func(event watcher.ResourceEvent) {
+		if event.Type == watch.Error {
+			log.Printf("Watch error for %s %s/%s: %v", event.Resource.Kind, event.Namespace, event.Name, event.Error)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		err := encoder.Encode(watcher.JSONEvent{Type: event.Type, Time: time.Now(), Object: event.Object})
+		if err != nil {
+			log.Printf("Failed to write event: %v", err)
+		}
 	}
 }
 
+// newRulesEngine creates an engine for the rules of a rules file, with its
+// notifiers
+func newRulesEngine(config *rules.Config) (*rules.Engine, error) {
+	notifiers, err := rules.NewNotifiers(config.Notifiers)
+	if err != nil {
+		return nil, err
+	}
+
+	engine, err := rules.NewEngine(config.Rules, notifiers...)
+	if err != nil {
+		// Release the files of the notifiers
+		for _, notifier := range notifiers {
+			if closer, ok := notifier.(io.Closer); ok {
+				closer.Close()
+			}
+		}
+		return nil, err
+	}
+	return engine, nil
+}
+
 // getSpecFromObject extracts and formats the spec section from an object
 func getSpecFromObject(obj map[string]interface{}) (string, bool) {
 	spec, found := obj["spec"]
@@ -186,8 +408,3 @@ func getSpecFromObject(obj map[string]interface{}) (string, bool) {
 
 	return string(specBytes), true
 }
-
-// contains checks if a string contains a substring
-func contains(s, substr string) bool {
-	return strings.Contains(s, substr)
-}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/config"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db/postgres"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// defaultCompactInterval is how often the retention is applied by default
+const defaultCompactInterval = 10 * time.Minute
+
+// eventStore writes the watched resources and their history to a database,
+// which the TUI can browse with --offline
+type eventStore struct {
+	writer interface {
+		Upsert(resource db.Resource) error
+		Delete(kind, apiVersion, namespace, name string) error
+	}
+	// closers release the store, in order
+	closers []func() error
+}
+
+// openStore opens the database of a config
+func openStore(cfg *config.Store) (*eventStore, error) {
+	retention, err := cfg.ParseRetention()
+	if err != nil {
+		return nil, err
+	}
+
+	if postgres.IsDSN(cfg.Path) {
+		pgStore, err := postgres.New(cfg.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to database: %v", err)
+		}
+		return &eventStore{writer: pgStore, closers: []func() error{pgStore.Close}}, nil
+	}
+
+	sqliteStore, err := db.New(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create database: %v", err)
+	}
+
+	// Group the flood of events from the initial list into transactions
+	batchWriter := db.NewBatchWriter(sqliteStore, 500, 250*time.Millisecond)
+
+	// Bound the growth of the history
+	interval := cfg.CompactInterval.Duration
+	if interval == 0 {
+		interval = defaultCompactInterval
+	}
+	compactor := db.NewCompactor(sqliteStore, retention, interval)
+
+	return &eventStore{
+		writer: batchWriter,
+		closers: []func() error{
+			func() error { compactor.Close(); return nil },
+			batchWriter.Close,
+			sqliteStore.Close,
+		},
+	}, nil
+}
+
+// handle stores a resource event
+func (s *eventStore) handle(event watcher.ResourceEvent) {
+	switch event.Type {
+	case watch.Added, watch.Modified:
+		data, err := json.Marshal(event.Object)
+		if err != nil {
+			log.Printf("Failed to encode resource: %v", err)
+			return
+		}
+		r := db.Resource{
+			Name:            event.Name,
+			Namespace:       event.Namespace,
+			Kind:            event.Resource.Kind,
+			APIVersion:      event.Resource.APIVersion,
+			ResourceVersion: event.ResourceVersion,
+			Data:            string(data),
+		}
+		if err := s.writer.Upsert(r); err != nil {
+			log.Printf("Failed to store resource: %v", err)
+		}
+
+	case watch.Deleted:
+		if err := s.writer.Delete(event.Resource.Kind, event.Resource.APIVersion, event.Namespace, event.Name); err != nil {
+			log.Printf("Failed to delete resource: %v", err)
+		}
+	}
+}
+
+// Close flushes the pending writes and closes the database
+func (s *eventStore) Close() {
+	for _, c := range s.closers {
+		if err := c(); err != nil {
+			log.Printf("Failed to close store: %v", err)
+		}
+	}
+}
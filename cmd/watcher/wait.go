@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// waitCondition checks whether an object has reached the desired state
+type waitCondition interface {
+	Met(event watcher.ResourceEvent) (bool, error)
+}
+
+// runWait implements the wait subcommand and returns the exit code
+func runWait(args []string) int {
+	fs := flag.NewFlagSet("wait", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: watcher wait --kind KIND [--name NAME | -l SELECTOR] --for CONDITION
+
+Waits until the selected objects satisfy a condition. Conditions:
+  delete                        the objects no longer exist
+  condition=NAME[=STATUS]       status.conditions has NAME with STATUS (default True)
+  jsonpath={.path}[=VALUE]      the JSONPath result equals VALUE (or is non-empty)
+  cel:EXPRESSION                the CEL expression is true (same variables as --filter)
+
+Exits 0 when the condition holds and 1 on timeout or error.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+
+	kind := fs.String("kind", "", "resource kind to wait for (e.g. Deployment or deploy)")
+	apiVersion := fs.String("api-version", "", "API version of the resource (resolved from the kind if empty)")
+	name := fs.String("name", "", "name of the object to wait for")
+	selector := fs.String("l", "", "label selector for the objects to wait for")
+	namespace := fs.String("namespace", "default", "namespace of the objects")
+	allNamespaces := fs.Bool("all-namespaces", false, "wait for objects across all namespaces")
+	forCondition := fs.String("for", "", "condition to wait for")
+	timeout := fs.Duration("timeout", 30*time.Second, "how long to wait before giving up")
+	kubeconfigPath := fs.String("kubeconfig", "", "path to the kubeconfig file")
+	fs.Parse(args)
+
+	if *kind == "" || *forCondition == "" {
+		fs.Usage()
+		return 2
+	}
+	if *name != "" && *selector != "" {
+		fmt.Fprintln(os.Stderr, "--name and -l can't be used together")
+		return 2
+	}
+
+	condition, waitForDelete, err := parseWaitCondition(*forCondition)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --for: %v\n", err)
+		return 2
+	}
+
+	opts := watcher.Options{
+		KubeconfigPath: *kubeconfigPath,
+		Namespace:      *namespace,
+		LabelSelector:  *selector,
+		ResourceTypes: []watcher.ResourceToWatch{
+			{Kind: *kind, APIVersion: *apiVersion},
+		},
+	}
+	if *allNamespaces {
+		opts.Namespace = ""
+	}
+	if *name != "" {
+		opts.FieldSelector = "metadata.name=" + *name
+	}
+
+	k8sWatcher, err := watcher.NewWatcher(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create watcher: %v\n", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	state := newWaitState(condition, waitForDelete)
+	if err := k8sWatcher.Start(ctx, state.handle); err != nil {
+		if ctx.Err() != nil {
+			fmt.Fprintf(os.Stderr, "Timed out waiting for %s: %s\n", *forCondition, state.describePending())
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Failed to start watcher: %v\n", err)
+		return 1
+	}
+	defer stopWatcher(k8sWatcher)
+
+	// Start returns once the current state has been listed
+	state.markSynced()
+
+	select {
+	case <-k8sWatcher.Done():
+		if ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", k8sWatcher.Err())
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Timed out waiting for %s: %s\n", *forCondition, state.describePending())
+		return 1
+
+	case <-state.done:
+		for _, key := range state.keys() {
+			fmt.Printf("%s/%s condition met\n", strings.ToLower(*kind), key)
+		}
+		if waitForDelete {
+			fmt.Printf("%s deleted\n", strings.ToLower(*kind))
+		}
+		return 0
+
+	case <-ctx.Done():
+		fmt.Fprintf(os.Stderr, "Timed out waiting for %s: %s\n", *forCondition, state.describePending())
+		return 1
+	}
+}
+
+// waitState tracks the latest state of the matched objects
+type waitState struct {
+	condition     waitCondition
+	waitForDelete bool
+
+	mu      sync.Mutex
+	objects map[string]watcher.ResourceEvent
+	met     map[string]bool
+	synced  bool
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newWaitState(condition waitCondition, waitForDelete bool) *waitState {
+	return &waitState{
+		condition:     condition,
+		waitForDelete: waitForDelete,
+		objects:       make(map[string]watcher.ResourceEvent),
+		met:           make(map[string]bool),
+		done:          make(chan struct{}),
+	}
+}
+
+// handle updates the state from a watch event
+func (s *waitState) handle(event watcher.ResourceEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := event.Name
+	if event.Namespace != "" {
+		key = event.Namespace + "/" + event.Name
+	}
+
+	switch event.Type {
+	case watch.Added, watch.Modified:
+		s.objects[key] = event
+		if !s.waitForDelete {
+			met, err := s.condition.Met(event)
+			if err != nil {
+				log.Printf("Condition not met for %s: %v", key, err)
+			}
+			s.met[key] = met
+		}
+	case watch.Deleted:
+		delete(s.objects, key)
+		delete(s.met, key)
+	case watch.Error:
+		log.Printf("Watch error: %v", event.Error)
+		return
+	}
+
+	s.check()
+}
+
+// markSynced records that the initial state of all objects is known
+func (s *waitState) markSynced() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.synced = true
+	s.check()
+}
+
+// check finishes the wait if the condition holds for every object.
+// Must be called with the lock held.
+func (s *waitState) check() {
+	if s.waitForDelete {
+		// Only trust an empty set once the initial list is complete
+		if s.synced && len(s.objects) == 0 {
+			s.finish()
+		}
+		return
+	}
+
+	// Objects that don't exist yet can't satisfy the condition
+	if len(s.objects) == 0 {
+		return
+	}
+	for key := range s.objects {
+		if !s.met[key] {
+			return
+		}
+	}
+	s.finish()
+}
+
+func (s *waitState) finish() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// keys returns the matched objects in a stable order
+func (s *waitState) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// describePending lists the objects that haven't reached the condition
+func (s *waitState) describePending() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.objects) == 0 {
+		if s.waitForDelete {
+			return "initial state was never listed"
+		}
+		return "no matching objects found"
+	}
+
+	var pending []string
+	for key := range s.objects {
+		if s.waitForDelete || !s.met[key] {
+			pending = append(pending, key)
+		}
+	}
+	sort.Strings(pending)
+	return "still waiting for " + strings.Join(pending, ", ")
+}
+
+// parseWaitCondition parses the value of --for
+func parseWaitCondition(s string) (waitCondition, bool, error) {
+	switch {
+	case s == "delete":
+		return nil, true, nil
+
+	case strings.HasPrefix(s, "condition="):
+		spec := strings.TrimPrefix(s, "condition=")
+		name, status, found := strings.Cut(spec, "=")
+		if !found {
+			status = "True"
+		}
+		if name == "" {
+			return nil, false, fmt.Errorf("condition name is empty")
+		}
+		return statusCondition{name: name, status: status}, false, nil
+
+	case strings.HasPrefix(s, "jsonpath="):
+		return parseJSONPathCondition(strings.TrimPrefix(s, "jsonpath="))
+
+	case strings.HasPrefix(s, "cel:"), strings.HasPrefix(s, "cel="):
+		filter, err := watcher.CompileFilter(s[len("cel:"):])
+		if err != nil {
+			return nil, false, err
+		}
+		return celCondition{filter: filter}, false, nil
+	}
+
+	return nil, false, fmt.Errorf("unknown condition %q (expected delete, condition=, jsonpath= or cel:)", s)
+}
+
+// parseJSONPathCondition parses {.path} or {.path}=value
+func parseJSONPathCondition(spec string) (waitCondition, bool, error) {
+	expr, value := spec, ""
+	hasValue := false
+	if end := strings.LastIndex(spec, "}"); end != -1 && strings.HasPrefix(spec[end+1:], "=") {
+		expr, value, hasValue = spec[:end+1], spec[end+2:], true
+	}
+	if !strings.HasPrefix(expr, "{") {
+		expr = "{" + expr + "}"
+	}
+
+	parser := jsonpath.New("wait").AllowMissingKeys(true)
+	if err := parser.Parse(expr); err != nil {
+		return nil, false, fmt.Errorf("invalid JSONPath %s: %v", expr, err)
+	}
+
+	return jsonPathCondition{parser: parser, value: value, hasValue: hasValue}, false, nil
+}
+
+// statusCondition waits for an entry in status.conditions
+type statusCondition struct {
+	name   string
+	status string
+}
+
+func (c statusCondition) Met(event watcher.ResourceEvent) (bool, error) {
+	conditions, _, err := unstructured.NestedSlice(event.Object, "status", "conditions")
+	if err != nil {
+		return false, err
+	}
+
+	for _, condition := range conditions {
+		fields, ok := condition.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if fields["type"] == c.name {
+			return strings.EqualFold(fmt.Sprint(fields["status"]), c.status), nil
+		}
+	}
+
+	return false, nil
+}
+
+// jsonPathCondition waits for a JSONPath expression to produce a value
+type jsonPathCondition struct {
+	parser   *jsonpath.JSONPath
+	value    string
+	hasValue bool
+}
+
+func (c jsonPathCondition) Met(event watcher.ResourceEvent) (bool, error) {
+	var out bytes.Buffer
+	if err := c.parser.Execute(&out, event.Object); err != nil {
+		return false, err
+	}
+
+	if !c.hasValue {
+		return out.Len() > 0, nil
+	}
+	return out.String() == c.value, nil
+}
+
+// celCondition waits for a CEL expression to be true
+type celCondition struct {
+	filter *watcher.Filter
+}
+
+func (c celCondition) Met(event watcher.ResourceEvent) (bool, error) {
+	return c.filter.Match(event)
+}
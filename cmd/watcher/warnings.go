@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/events"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// logHandler logs a line per event. When maxAge is set, the line of a
+// modified object lists the Warning events about it seen within maxAge,
+// which are learned from the watched Events.
+func logHandler(maxAge time.Duration) watcher.EventHandler {
+	if maxAge == 0 {
+		return eventHandler
+	}
+
+	warnings := events.NewWarnings(maxAge)
+	return func(event watcher.ResourceEvent) {
+		if events.IsEvent(event.Resource.Kind, event.Resource.APIVersion) {
+			trackWarning(warnings, event)
+		}
+
+		logMsg := formatEvent(event)
+		if event.Type == watch.Modified && event.PreviousResourceVersion != event.ResourceVersion {
+			uid, _, _ := unstructured.NestedString(event.Object, "metadata", "uid")
+			if recent := warnings.Recent(uid); len(recent) > 0 {
+				logMsg += ", Warnings: " + formatWarnings(recent)
+			}
+		}
+		log.Println(logMsg)
+	}
+}
+
+// trackWarning updates the warnings with a change to an Event
+func trackWarning(warnings *events.Warnings, event watcher.ResourceEvent) {
+	e, ok := events.FromObject(event.Object)
+	if !ok {
+		return
+	}
+	switch event.Type {
+	case watch.Added, watch.Modified:
+		warnings.Add(e)
+	case watch.Deleted:
+		warnings.Remove(e)
+	}
+}
+
+// formatWarnings describes aggregated warnings on one line, e.g.
+// BackOff x5 (30s ago): Back-off restarting failed container
+func formatWarnings(summaries []events.Summary) string {
+	var parts []string
+	for _, s := range summaries {
+		part := s.Reason
+		if s.Count > 1 {
+			part += fmt.Sprintf(" x%d", s.Count)
+		}
+		part += fmt.Sprintf(" (%s ago): %s", time.Since(s.LastTimestamp).Round(time.Second), strings.Join(strings.Fields(s.Message), " "))
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// watchesEvents reports whether Kubernetes Events are among the watched
+// resource types
+func watchesEvents(w *watcher.K8sWatcher) bool {
+	for _, watched := range w.Watched() {
+		if events.IsEvent(watched.Resource.Kind, watched.Resource.APIVersion) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+// Package cloudevents converts resource events into CloudEvents and delivers them over HTTP
+package cloudevents
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// SpecVersion is the CloudEvents specification version produced by this package
+const SpecVersion = "1.0"
+
+// Event is a CloudEvents event carrying a Kubernetes object as JSON data
+type Event struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+}
+
+// FromResourceEvent converts a resource event into a CloudEvent.
+// The cluster name is used to build the event source.
+func FromResourceEvent(event watcher.ResourceEvent, cluster string) (Event, error) {
+	action, err := eventAction(event.Type)
+	if err != nil {
+		return Event{}, err
+	}
+
+	data, err := json.Marshal(event.Object)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to encode object: %v", err)
+	}
+
+	return Event{
+		SpecVersion:     SpecVersion,
+		ID:              eventID(event),
+		Source:          eventSource(event.Resource, cluster),
+		Type:            EventType(event.Resource, action),
+		Subject:         eventSubject(event),
+		Time:            time.Now().UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+		Data:            data,
+	}, nil
+}
+
+// EventType returns the CloudEvents type for an action on a resource type,
+// e.g. io.k8s.apps.deployment.modified
+func EventType(resource watcher.ResourceToWatch, action string) string {
+	group := resource.GroupVersionResource().Group
+	if group == "" {
+		group = "core"
+	}
+	return fmt.Sprintf("io.k8s.%s.%s.%s", group, strings.ToLower(resource.Kind), action)
+}
+
+// eventAction maps a watch event type to the action suffix of the CloudEvents type
+func eventAction(eventType watch.EventType) (string, error) {
+	switch eventType {
+	case watch.Added:
+		return "added", nil
+	case watch.Modified:
+		return "modified", nil
+	case watch.Deleted:
+		return "deleted", nil
+	default:
+		return "", fmt.Errorf("event type %s cannot be converted to a CloudEvent", eventType)
+	}
+}
+
+// eventSource builds a source URI reference from the cluster and the GVR,
+// following the Kubernetes API path layout
+func eventSource(resource watcher.ResourceToWatch, cluster string) string {
+	gvr := resource.GroupVersionResource()
+
+	apiPath := path.Join("/apis", gvr.Group, gvr.Version, gvr.Resource)
+	if gvr.Group == "" {
+		apiPath = path.Join("/api", gvr.Version, gvr.Resource)
+	}
+
+	if cluster == "" {
+		return apiPath
+	}
+	return path.Join("/clusters", cluster, apiPath)
+}
+
+// eventSubject identifies the object within the source as namespace/name
+func eventSubject(event watcher.ResourceEvent) string {
+	if event.Namespace == "" {
+		return event.Name
+	}
+	return event.Namespace + "/" + event.Name
+}
+
+// eventID derives a unique ID from the object UID and resource version.
+// Objects without a UID fall back to the subject.
+func eventID(event watcher.ResourceEvent) string {
+	uid, _, _ := unstructured.NestedString(event.Object, "metadata", "uid")
+	if uid == "" {
+		uid = eventSubject(event)
+	}
+	return fmt.Sprintf("%s-%s", uid, event.ResourceVersion)
+}
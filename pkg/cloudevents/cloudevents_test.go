@@ -0,0 +1,234 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+var deploymentResource = watcher.ResourceToWatch{Kind: "Deployment", APIVersion: "apps/v1", Namespaced: true}
+
+// deploymentEvent returns an event about a Deployment in the prod namespace
+func deploymentEvent(eventType watch.EventType, resourceVersion string) watcher.ResourceEvent {
+	return watcher.ResourceEvent{
+		Type:            eventType,
+		Resource:        deploymentResource,
+		Name:            "web",
+		Namespace:       "prod",
+		ResourceVersion: resourceVersion,
+		Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":            "web",
+				"namespace":       "prod",
+				"uid":             "abc",
+				"resourceVersion": resourceVersion,
+			},
+		},
+	}
+}
+
+func TestFromResourceEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   watcher.ResourceEvent
+		cluster string
+		want    Event
+	}{
+		{
+			name:    "namespaced with cluster",
+			event:   deploymentEvent(watch.Modified, "7"),
+			cluster: "staging",
+			want: Event{
+				SpecVersion: SpecVersion, ID: "abc-7", Source: "/clusters/staging/apis/apps/v1/deployments",
+				Type: "io.k8s.apps.deployment.modified", Subject: "prod/web", DataContentType: "application/json",
+			},
+		},
+		{
+			name: "core cluster-scoped without a uid",
+			event: watcher.ResourceEvent{
+				Type: watch.Deleted, Resource: watcher.ResourceToWatch{Kind: "Namespace", APIVersion: "v1"},
+				Name: "team-a", ResourceVersion: "3", Object: map[string]interface{}{"kind": "Namespace"},
+			},
+			want: Event{
+				SpecVersion: SpecVersion, ID: "team-a-3", Source: "/api/v1/namespaces",
+				Type: "io.k8s.core.namespace.deleted", Subject: "team-a", DataContentType: "application/json",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromResourceEvent(tt.event, tt.cluster)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := time.Parse(time.RFC3339Nano, got.Time); err != nil {
+				t.Errorf("Time = %q, want RFC 3339: %v", got.Time, err)
+			}
+			var object map[string]interface{}
+			if err := json.Unmarshal(got.Data, &object); err != nil || object["kind"] != tt.event.Object["kind"] {
+				t.Errorf("Data = %s, want the object", got.Data)
+			}
+			got.Time, got.Data = "", nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FromResourceEvent() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := FromResourceEvent(watcher.ResourceEvent{Type: watch.Error, Resource: deploymentResource}, ""); err == nil {
+		t.Error("FromResourceEvent() converted an error event")
+	}
+}
+
+// received is a request received by a sink
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// newSink starts a server that records the requests it receives
+func newSink(t *testing.T, status int) (*httptest.Server, func() []received) {
+	var mu sync.Mutex
+	var requests []received
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, received{header: r.Header, body: body})
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []received {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]received(nil), requests...)
+	}
+}
+
+func TestSendModes(t *testing.T) {
+	event, err := FromResourceEvent(deploymentEvent(watch.Added, "1"), "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("binary", func(t *testing.T) {
+		server, requests := newSink(t, http.StatusAccepted)
+		emitter := NewEmitter(server.URL, "staging", Binary)
+		defer emitter.Close()
+		if err := emitter.Send(t.Context(), event); err != nil {
+			t.Fatal(err)
+		}
+
+		got := requests()
+		if len(got) != 1 {
+			t.Fatalf("received %d requests, want 1", len(got))
+		}
+		for header, want := range map[string]string{
+			"Content-Type":   "application/json",
+			"Ce-Specversion": SpecVersion,
+			"Ce-Id":          event.ID,
+			"Ce-Source":      event.Source,
+			"Ce-Type":        event.Type,
+			"Ce-Subject":     event.Subject,
+			"Ce-Time":        event.Time,
+		} {
+			if value := got[0].header.Get(header); value != want {
+				t.Errorf("%s = %q, want %q", header, value, want)
+			}
+		}
+		if string(got[0].body) != string(event.Data) {
+			t.Errorf("body = %s, want the object %s", got[0].body, event.Data)
+		}
+	})
+
+	t.Run("structured", func(t *testing.T) {
+		server, requests := newSink(t, http.StatusOK)
+		emitter := NewEmitter(server.URL, "staging", Structured)
+		defer emitter.Close()
+		if err := emitter.Send(t.Context(), event); err != nil {
+			t.Fatal(err)
+		}
+
+		got := requests()
+		if len(got) != 1 {
+			t.Fatalf("received %d requests, want 1", len(got))
+		}
+		if ct := got[0].header.Get("Content-Type"); ct != "application/cloudevents+json" {
+			t.Errorf("Content-Type = %q, want application/cloudevents+json", ct)
+		}
+		if got[0].header.Get("Ce-Id") != "" {
+			t.Error("structured mode sent ce- headers")
+		}
+		var decoded Event
+		if err := json.Unmarshal(got[0].body, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.ID != event.ID || decoded.Type != event.Type || string(decoded.Data) != string(event.Data) {
+			t.Errorf("body = %s, want the whole event", got[0].body)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		server, _ := newSink(t, http.StatusBadRequest)
+		emitter := NewEmitter(server.URL, "", Binary)
+		defer emitter.Close()
+		if err := emitter.Send(t.Context(), event); err == nil {
+			t.Error("Send() succeeded, want the status reported")
+		}
+	})
+}
+
+func TestHandlerDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var ids []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		mu.Lock()
+		ids = append(ids, r.Header.Get("Ce-Id"))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	emitter := NewEmitter(server.URL, "", Binary)
+	handler := emitter.Handler()
+
+	// The sink holds every request, but handling returns right away
+	handled := make(chan struct{})
+	go func() {
+		handler(deploymentEvent(watch.Added, "1"))
+		handler(deploymentEvent(watch.Modified, "2"))
+		close(handled)
+	}()
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Handler blocked on a slow sink")
+	}
+
+	// Close delivers the queued events in order
+	close(release)
+	if err := emitter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ids) != 2 || ids[0] != "abc-1" || ids[1] != "abc-2" {
+		t.Errorf("delivered %v, want [abc-1 abc-2]", ids)
+	}
+
+	// Events after Close are dropped
+	handler(deploymentEvent(watch.Modified, "3"))
+}
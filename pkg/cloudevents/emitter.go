@@ -0,0 +1,206 @@
+package cloudevents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher"
+)
+
+// Mode selects the CloudEvents HTTP content mode
+type Mode int
+
+const (
+	// Binary mode sends attributes as ce-* headers and the object as the body
+	Binary Mode = iota
+	// Structured mode sends the whole event as an application/cloudevents+json body
+	Structured
+)
+
+// ParseMode converts "binary" or "structured" into a Mode
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "binary", "":
+		return Binary, nil
+	case "structured":
+		return Structured, nil
+	default:
+		return Binary, fmt.Errorf("unknown CloudEvents mode %q (expected binary or structured)", s)
+	}
+}
+
+const (
+	// queueSize is how many events wait for delivery before new ones are dropped
+	queueSize = 1000
+	// sendTimeout bounds the delivery of a single event
+	sendTimeout = 10 * time.Second
+	// closeTimeout is how long Close waits for queued events to be delivered
+	closeTimeout = 5 * time.Second
+)
+
+// Emitter delivers resource events to an HTTP endpoint as CloudEvents.
+// Events are queued and sent in the background, so a slow sink doesn't
+// hold up the watcher.
+type Emitter struct {
+	url     string
+	cluster string
+	mode    Mode
+	client  *http.Client
+
+	// ctx is cancelled when Close gives up on the queued events
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.RWMutex
+	queue  chan Event
+	closed bool
+}
+
+// NewEmitter creates an emitter that posts to url and starts delivering
+// events until Close is called.
+// The cluster name is used to build the source of each event.
+func NewEmitter(url, cluster string, mode Mode) *Emitter {
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &Emitter{
+		url:     url,
+		cluster: cluster,
+		mode:    mode,
+		client:  &http.Client{Timeout: sendTimeout},
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		queue:   make(chan Event, queueSize),
+	}
+	go e.run()
+	return e
+}
+
+// run sends the queued events until the queue is closed
+func (e *Emitter) run() {
+	defer close(e.done)
+	dropped := 0
+	for event := range e.queue {
+		if e.ctx.Err() != nil {
+			dropped++
+			continue
+		}
+		if err := e.Send(e.ctx, event); err != nil {
+			log.Printf("Failed to emit CloudEvent: %v", err)
+		}
+	}
+	if dropped > 0 {
+		log.Printf("Dropped %d undelivered CloudEvents at shutdown", dropped)
+	}
+}
+
+// Close stops accepting events and waits a few seconds for the queued
+// ones to be delivered, dropping the rest
+func (e *Emitter) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	close(e.queue)
+	e.mu.Unlock()
+
+	select {
+	case <-e.done:
+	case <-time.After(closeTimeout):
+		e.cancel()
+		<-e.done
+	}
+	e.cancel()
+	return nil
+}
+
+// Send delivers a single CloudEvent
+func (e *Emitter) Send(ctx context.Context, event Event) error {
+	req, err := NewRequest(ctx, e.url, event, e.mode)
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send event %s: %v", event.ID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("event %s rejected with status %s", event.ID, resp.Status)
+	}
+
+	return nil
+}
+
+// Handler returns an event handler that converts and queues every event.
+// Events that cannot be converted or delivered, or that arrive while the
+// queue is full, are logged and dropped.
+func (e *Emitter) Handler() watcher.EventHandler {
+	return func(event watcher.ResourceEvent) {
+		ce, err := FromResourceEvent(event, e.cluster)
+		if err != nil {
+			log.Printf("Skipping CloudEvent for %s/%s: %v", event.Namespace, event.Name, err)
+			return
+		}
+
+		e.mu.RLock()
+		defer e.mu.RUnlock()
+		if e.closed {
+			return
+		}
+		select {
+		case e.queue <- ce:
+		default:
+			log.Printf("Dropping CloudEvent %s, %d events are waiting for %s", ce.ID, queueSize, e.url)
+		}
+	}
+}
+
+// NewRequest builds an HTTP request carrying the event in the given mode
+func NewRequest(ctx context.Context, url string, event Event, mode Mode) (*http.Request, error) {
+	var body []byte
+	var err error
+
+	if mode == Structured {
+		body, err = json.Marshal(event)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode event: %v", err)
+		}
+	} else {
+		body = event.Data
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	if mode == Structured {
+		req.Header.Set("Content-Type", "application/cloudevents+json")
+		return req, nil
+	}
+
+	req.Header.Set("Content-Type", event.DataContentType)
+	req.Header.Set("ce-specversion", event.SpecVersion)
+	req.Header.Set("ce-id", event.ID)
+	req.Header.Set("ce-source", event.Source)
+	req.Header.Set("ce-type", event.Type)
+	if event.Subject != "" {
+		req.Header.Set("ce-subject", event.Subject)
+	}
+	if event.Time != "" {
+		req.Header.Set("ce-time", event.Time)
+	}
+
+	return req, nil
+}
@@ -0,0 +1,377 @@
+// Package config loads the declarative configuration of the watcher: what to
+// watch, how events are transformed and filtered, and where they are sent
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/cloudevents"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/rules"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// Sink types
+const (
+	// SinkLog logs a line per event
+	SinkLog = "log"
+	// SinkNDJSON writes one JSON event per line to a file or stdout
+	SinkNDJSON = "ndjson"
+	// SinkCloudEvents posts events as CloudEvents
+	SinkCloudEvents = "cloudevents"
+	// SinkRules evaluates the alerting rules of a rules file
+	SinkRules = "rules"
+)
+
+// Config is the content of a watcher configuration file
+type Config struct {
+	// Kubeconfig is the path of the kubeconfig file, empty for the default
+	// loading rules
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	// Context is the kubeconfig context, empty for the current context
+	Context string `json:"context,omitempty"`
+	// Namespaces to watch, all of them by default
+	Namespaces Namespaces `json:"namespaces,omitempty"`
+	// WatchAll watches every resource type discovered in the cluster
+	// instead of Resources
+	WatchAll bool `json:"watchAll,omitempty"`
+	// Resources are the resource types to watch, the default set when
+	// empty
+	Resources []Resource `json:"resources,omitempty"`
+	// LabelSelector and FieldSelector apply to every resource type
+	LabelSelector string `json:"labelSelector,omitempty"`
+	FieldSelector string `json:"fieldSelector,omitempty"`
+	// Filters are CEL expressions events must satisfy (see watcher.Filter)
+	Filters []string `json:"filters,omitempty"`
+	// Transforms change the objects of events before they reach the sinks
+	Transforms []Transform `json:"transforms,omitempty"`
+	// Sinks receive the events, a log sink when empty
+	Sinks []Sink `json:"sinks,omitempty"`
+	// Backoff controls the retries of failing watches
+	Backoff Backoff `json:"backoff,omitempty"`
+	// Store keeps the watched resources and their history in a database
+	Store *Store `json:"store,omitempty"`
+}
+
+// Namespaces selects the namespaces to watch
+type Namespaces struct {
+	// Include lists the namespaces to watch, empty for all namespaces
+	Include []string `json:"include,omitempty"`
+	// Exclude lists namespaces to leave out when watching all namespaces
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Resource is a resource type to watch
+type Resource struct {
+	// Kind of the resource, e.g. Deployment. Without an API version, it can
+	// also be resource names as kubectl takes them, e.g. deploy or po,svc.
+	Kind string `json:"kind"`
+	// APIVersion, e.g. apps/v1, resolved from the kind when empty
+	APIVersion string `json:"apiVersion,omitempty"`
+	// LabelSelector and FieldSelector restrict the watched objects of this
+	// resource type
+	LabelSelector string `json:"labelSelector,omitempty"`
+	FieldSelector string `json:"fieldSelector,omitempty"`
+}
+
+// Sink is a destination of events
+type Sink struct {
+	// Type is log, ndjson, cloudevents or rules
+	Type string `json:"type"`
+	// Path is the file ndjson events are appended to (stdout when empty),
+	// or the rules file of a rules sink
+	Path string `json:"path,omitempty"`
+	// URL is where CloudEvents are posted
+	URL string `json:"url,omitempty"`
+	// Mode is the CloudEvents HTTP content mode, binary or structured
+	Mode string `json:"mode,omitempty"`
+	// Cluster is the cluster name used as the source of CloudEvents
+	Cluster string `json:"cluster,omitempty"`
+	// Warnings lists the Warning events seen within this duration about an
+	// object when a log sink reports it modified. It needs Events to be
+	// watched.
+	Warnings rules.Duration `json:"warnings,omitempty"`
+}
+
+// Backoff controls the retries of failing watches (see watcher.Backoff)
+type Backoff struct {
+	Initial    rules.Duration `json:"initial,omitempty"`
+	Max        rules.Duration `json:"max,omitempty"`
+	MaxRetries int            `json:"maxRetries,omitempty"`
+}
+
+// Store configures the resource database
+type Store struct {
+	// Path is a SQLite database file or a PostgreSQL DSN
+	Path string `json:"path"`
+	// Retention limits the history kept
+	Retention Retention `json:"retention,omitempty"`
+	// CompactInterval is how often the retention is applied to a SQLite
+	// database (default 10m)
+	CompactInterval rules.Duration `json:"compactInterval,omitempty"`
+}
+
+// Retention limits the history kept in the store (see db.Retention)
+type Retention struct {
+	// MaxAge drops versions recorded longer ago, e.g. 30d
+	MaxAge string `json:"maxAge,omitempty"`
+	// MaxVersions keeps at most this many versions of each resource
+	MaxVersions int `json:"maxVersions,omitempty"`
+	// Kinds override the policy of a kind as AGE[/VERSIONS], e.g. Event: 1h
+	Kinds map[string]string `json:"kinds,omitempty"`
+}
+
+// Load reads and validates a configuration file
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	config, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// Parse parses and validates the content of a configuration file
+func Parse(data []byte) (*Config, error) {
+	var config Config
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// Validate checks the configuration and returns every problem found, each
+// prefixed with the path of the setting, e.g. resources[1].kind
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(path, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+
+	// Namespaces
+	included := make(map[string]bool)
+	for i, ns := range c.Namespaces.Include {
+		path := fmt.Sprintf("namespaces.include[%d]", i)
+		if msgs := validation.IsDNS1123Label(ns); len(msgs) > 0 {
+			add(path, "invalid namespace %q: %s", ns, strings.Join(msgs, "; "))
+		} else if included[ns] {
+			add(path, "%q is listed twice", ns)
+		}
+		included[ns] = true
+	}
+	excluded := make(map[string]bool)
+	for i, ns := range c.Namespaces.Exclude {
+		path := fmt.Sprintf("namespaces.exclude[%d]", i)
+		if msgs := validation.IsDNS1123Label(ns); len(msgs) > 0 {
+			add(path, "invalid namespace %q: %s", ns, strings.Join(msgs, "; "))
+		} else if excluded[ns] {
+			add(path, "%q is listed twice", ns)
+		} else if included[ns] {
+			add(path, "%q is also included", ns)
+		}
+		excluded[ns] = true
+	}
+	if len(c.Namespaces.Include) > 0 && len(c.Namespaces.Exclude) > 0 && len(errs) == 0 {
+		add("namespaces.exclude", "only applies when watching all namespaces, so include must be empty")
+	}
+
+	// Selectors
+	if _, err := labels.Parse(c.LabelSelector); err != nil {
+		add("labelSelector", "%v", err)
+	}
+	if _, err := fields.ParseSelector(c.FieldSelector); err != nil {
+		add("fieldSelector", "%v", err)
+	}
+
+	// Resources
+	if c.WatchAll && len(c.Resources) > 0 {
+		add("resources", "can't be combined with watchAll")
+	}
+	seen := make(map[string]int)
+	for i, r := range c.Resources {
+		path := fmt.Sprintf("resources[%d]", i)
+		switch {
+		case r.Kind == "":
+			add(path+".kind", "required")
+		case strings.Contains(r.Kind, "/"):
+			add(path+".kind", "%q isn't a kind, give the version in apiVersion", r.Kind)
+		}
+		if r.APIVersion != "" {
+			if _, err := schema.ParseGroupVersion(r.APIVersion); err != nil {
+				add(path+".apiVersion", "%v", err)
+			}
+		}
+		if _, err := labels.Parse(r.LabelSelector); err != nil {
+			add(path+".labelSelector", "%v", err)
+		}
+		if _, err := fields.ParseSelector(r.FieldSelector); err != nil {
+			add(path+".fieldSelector", "%v", err)
+		}
+
+		key := strings.ToLower(r.Kind) + " " + r.APIVersion
+		if first, ok := seen[key]; ok && r.Kind != "" {
+			add(path, "duplicates resources[%d]", first)
+		} else {
+			seen[key] = i
+		}
+	}
+
+	// Filters
+	for i, filter := range c.Filters {
+		if _, err := watcher.CompileFilter(filter); err != nil {
+			add(fmt.Sprintf("filters[%d]", i), "%v", err)
+		}
+	}
+
+	// Transforms
+	for i, t := range c.Transforms {
+		path := fmt.Sprintf("transforms[%d]", i)
+		if len(t.RemoveFields) == 0 && len(t.RedactFields) == 0 {
+			add(path, "needs removeFields or redactFields")
+		}
+		for j, field := range t.RemoveFields {
+			if _, err := parseFieldPath(field); err != nil {
+				add(fmt.Sprintf("%s.removeFields[%d]", path, j), "%v", err)
+			}
+		}
+		for j, field := range t.RedactFields {
+			if _, err := parseFieldPath(field); err != nil {
+				add(fmt.Sprintf("%s.redactFields[%d]", path, j), "%v", err)
+			}
+		}
+	}
+
+	// Sinks
+	for i, sink := range c.Sinks {
+		path := fmt.Sprintf("sinks[%d]", i)
+		onlyFor := func(field, value string, types ...string) {
+			for _, t := range types {
+				if sink.Type == t {
+					return
+				}
+			}
+			if value != "" {
+				add(path+"."+field, "only applies to %s sinks", strings.Join(types, " and "))
+			}
+		}
+
+		switch sink.Type {
+		case SinkLog, SinkNDJSON:
+		case SinkRules:
+			if sink.Path == "" {
+				add(path+".path", "required for rules sinks")
+			}
+		case SinkCloudEvents:
+			if sink.URL == "" {
+				add(path+".url", "required for cloudevents sinks")
+			} else if u, err := url.ParseRequestURI(sink.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				add(path+".url", "%q isn't an http or https URL", sink.URL)
+			}
+			if sink.Mode != "" {
+				if _, err := cloudevents.ParseMode(sink.Mode); err != nil {
+					add(path+".mode", "%v", err)
+				}
+			}
+		case "":
+			add(path+".type", "required, one of log, ndjson, cloudevents or rules")
+		default:
+			add(path+".type", "unknown sink type %q, expected log, ndjson, cloudevents or rules", sink.Type)
+		}
+		if sink.Type != "" {
+			onlyFor("path", sink.Path, SinkNDJSON, SinkRules)
+			onlyFor("url", sink.URL, SinkCloudEvents)
+			onlyFor("mode", sink.Mode, SinkCloudEvents)
+			onlyFor("cluster", sink.Cluster, SinkCloudEvents)
+			if sink.Warnings.Duration != 0 {
+				onlyFor("warnings", sink.Warnings.String(), SinkLog)
+			}
+		}
+		if sink.Warnings.Duration < 0 {
+			add(path+".warnings", "must not be negative")
+		}
+	}
+
+	// Backoff
+	if c.Backoff.Initial.Duration < 0 {
+		add("backoff.initial", "must not be negative")
+	}
+	if c.Backoff.Max.Duration < 0 {
+		add("backoff.max", "must not be negative")
+	} else if c.Backoff.Max.Duration > 0 && c.Backoff.Max.Duration < c.Backoff.Initial.Duration {
+		add("backoff.max", "%s is shorter than backoff.initial", c.Backoff.Max)
+	}
+	if c.Backoff.MaxRetries < 0 {
+		add("backoff.maxRetries", "must not be negative")
+	}
+
+	// Store
+	if c.Store != nil {
+		if c.Store.Path == "" {
+			add("store.path", "required")
+		}
+		if c.Store.CompactInterval.Duration < 0 {
+			add("store.compactInterval", "must not be negative")
+		}
+		if _, err := c.Store.ParseRetention(); err != nil {
+			add("store.retention", "%v", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ParseRetention returns the retention of the store
+func (s *Store) ParseRetention() (db.Retention, error) {
+	var kinds []string
+	for _, kind := range slices.Sorted(maps.Keys(s.Retention.Kinds)) {
+		kinds = append(kinds, kind+"="+s.Retention.Kinds[kind])
+	}
+	return db.ParseRetention(s.Retention.MaxAge, s.Retention.MaxVersions, kinds)
+}
+
+// Options returns the watcher options of the configuration
+func (c *Config) Options() watcher.Options {
+	options := watcher.Options{
+		KubeconfigPath:    c.Kubeconfig,
+		Context:           c.Context,
+		Namespaces:        c.Namespaces.Include,
+		ExcludeNamespaces: c.Namespaces.Exclude,
+		WatchAll:          c.WatchAll,
+		LabelSelector:     c.LabelSelector,
+		FieldSelector:     c.FieldSelector,
+		Filters:           c.Filters,
+		Backoff: watcher.Backoff{
+			Initial:    c.Backoff.Initial.Duration,
+			Max:        c.Backoff.Max.Duration,
+			MaxRetries: c.Backoff.MaxRetries,
+		},
+	}
+
+	for _, r := range c.Resources {
+		options.ResourceTypes = append(options.ResourceTypes, watcher.ResourceToWatch{
+			Kind:          r.Kind,
+			APIVersion:    r.APIVersion,
+			LabelSelector: r.LabelSelector,
+			FieldSelector: r.FieldSelector,
+		})
+	}
+	return options
+}
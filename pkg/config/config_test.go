@@ -0,0 +1,301 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const example = `
+kubeconfig: /etc/kube/config
+context: staging
+namespaces:
+  exclude: [kube-system]
+labelSelector: team=payments
+resources:
+  - kind: Deployment
+    apiVersion: apps/v1
+  - kind: Pod
+    fieldSelector: status.phase!=Succeeded
+filters:
+  - "object.metadata.name != 'noisy'"
+transforms:
+  - removeFields: [metadata.managedFields]
+  - kinds: [Secret]
+    redactFields: [data]
+sinks:
+  - type: ndjson
+    path: /var/log/events.ndjson
+  - type: cloudevents
+    url: http://broker.local/events
+    mode: structured
+    cluster: staging
+backoff:
+  initial: 1s
+  max: 30s
+  maxRetries: 10
+store:
+  path: /var/lib/watcher.db
+  retention:
+    maxAge: 30d
+    kinds:
+      Event: 1h
+`
+
+func TestParse(t *testing.T) {
+	config, err := Parse([]byte(example))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := watcher.Options{
+		KubeconfigPath:    "/etc/kube/config",
+		Context:           "staging",
+		ExcludeNamespaces: []string{"kube-system"},
+		LabelSelector:     "team=payments",
+		Filters:           []string{"object.metadata.name != 'noisy'"},
+		ResourceTypes: []watcher.ResourceToWatch{
+			{Kind: "Deployment", APIVersion: "apps/v1"},
+			{Kind: "Pod", FieldSelector: "status.phase!=Succeeded"},
+		},
+		Backoff: watcher.Backoff{Initial: time.Second, Max: 30 * time.Second, MaxRetries: 10},
+	}
+	if got := config.Options(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Options() = %+v, want %+v", got, want)
+	}
+
+	retention, err := config.Store.ParseRetention()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := retention.For("Event").MaxAge; got != time.Hour {
+		t.Errorf("Event retention = %v, want 1h", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{
+			name:   "unknown field",
+			config: "resource: []",
+			want:   []string{`unknown field "resource"`},
+		},
+		{
+			name:   "namespaces",
+			config: "namespaces: {include: [prod, Prod], exclude: [prod]}",
+			want:   []string{`namespaces.include[1]: invalid namespace "Prod"`, `namespaces.exclude[0]: "prod" is also included`},
+		},
+		{
+			name:   "exclude with include",
+			config: "namespaces: {include: [prod], exclude: [dev]}",
+			want:   []string{"namespaces.exclude: only applies when watching all namespaces"},
+		},
+		{
+			name: "resources",
+			config: `
+resources:
+  - apiVersion: v1
+  - kind: apps/v1/Deployment
+  - kind: Pod
+    apiVersion: a/b/c
+  - kind: Service
+    labelSelector: "app in (web"
+  - kind: service
+`,
+			want: []string{
+				"resources[0].kind: required",
+				`resources[1].kind: "apps/v1/Deployment" isn't a kind`,
+				"resources[2].apiVersion:",
+				"resources[3].labelSelector:",
+				"resources[4]: duplicates resources[3]",
+			},
+		},
+		{
+			name:   "watchAll with resources",
+			config: "watchAll: true\nresources: [{kind: Pod}]",
+			want:   []string{"resources: can't be combined with watchAll"},
+		},
+		{
+			name:   "filters",
+			config: `filters: ["object.metadata.name == 'a'", "object."]`,
+			want:   []string{"filters[1]:"},
+		},
+		{
+			name:   "transforms",
+			config: "transforms: [{kinds: [Pod]}, {removeFields: [metadata..name, 'data[a']}]",
+			want: []string{
+				"transforms[0]: needs removeFields or redactFields",
+				`transforms[1].removeFields[0]: invalid field path "metadata..name": empty key`,
+				`transforms[1].removeFields[1]: invalid field path "data[a": missing ]`,
+			},
+		},
+		{
+			name: "sinks",
+			config: `
+sinks:
+  - type: cloudevents
+  - type: cloudevents
+    url: broker.local
+    mode: batched
+  - type: rules
+  - type: log
+    url: http://x
+  - type: kafka
+  - path: x
+  - type: ndjson
+    warnings: 10m
+  - type: log
+    warnings: -1m
+`,
+			want: []string{
+				"sinks[0].url: required for cloudevents sinks",
+				`sinks[1].url: "broker.local" isn't an http or https URL`,
+				`sinks[1].mode: unknown CloudEvents mode "batched"`,
+				"sinks[2].path: required for rules sinks",
+				"sinks[3].url: only applies to cloudevents sinks",
+				`sinks[4].type: unknown sink type "kafka"`,
+				"sinks[5].type: required",
+				"sinks[6].warnings: only applies to log sinks",
+				"sinks[7].warnings: must not be negative",
+			},
+		},
+		{
+			name:   "backoff",
+			config: "backoff: {initial: 10s, max: 5s, maxRetries: -1}",
+			want:   []string{"backoff.max: 5s is shorter than backoff.initial", "backoff.maxRetries: must not be negative"},
+		},
+		{
+			name:   "store",
+			config: "store: {retention: {kinds: {Event: 1x}}}",
+			want:   []string{"store.path: required", "store.retention:"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.config))
+			if err == nil {
+				t.Fatal("Parse() succeeded, want an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q doesn't mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTransformHandler(t *testing.T) {
+	var got watcher.ResourceEvent
+	handler, err := TransformHandler([]Transform{
+		{RemoveFields: []string{"metadata.managedFields", "metadata.annotations[kubectl.kubernetes.io/last-applied-configuration]"}},
+		{Kinds: []string{"secret"}, RedactFields: []string{"data", "metadata.labels.token"}},
+	}, func(event watcher.ResourceEvent) { got = event })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	object := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":          "creds",
+			"managedFields": []interface{}{"x"},
+			"annotations": map[string]interface{}{
+				"kubectl.kubernetes.io/last-applied-configuration": "{}",
+				"owner": "payments",
+			},
+			"labels": map[string]interface{}{"token": "abc"},
+		},
+		"data": map[string]interface{}{"password": "aHVudGVyMg=="},
+	}
+	handler(watcher.ResourceEvent{
+		Type:     watch.Added,
+		Resource: watcher.ResourceToWatch{Kind: "Secret", APIVersion: "v1"},
+		Object:   object,
+	})
+
+	want := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":        "creds",
+			"annotations": map[string]interface{}{"owner": "payments"},
+			"labels":      map[string]interface{}{"token": Redacted},
+		},
+		"data": map[string]interface{}{"password": Redacted},
+	}
+	if !reflect.DeepEqual(got.Object, want) {
+		t.Errorf("transformed object = %v, want %v", got.Object, want)
+	}
+
+	// The watcher keeps the original object
+	if _, ok := object["metadata"].(map[string]interface{})["managedFields"]; !ok {
+		t.Error("the original object was changed")
+	}
+
+	// Transforms limited to other kinds don't apply
+	handler(watcher.ResourceEvent{Type: watch.Added, Resource: watcher.ResourceToWatch{Kind: "ConfigMap"}, Object: object})
+	if data := got.Object["data"].(map[string]interface{}); data["password"] == Redacted {
+		t.Error("a ConfigMap was redacted by a Secret transform")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watcher.yaml")
+	if err := os.WriteFile(path, []byte("resources: [{kind: Pod}]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	type reload struct {
+		config *Config
+		err    error
+	}
+	reloads := make(chan reload, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Watch(ctx, path, 10*time.Millisecond, func(config *Config, err error) {
+		reloads <- reload{config, err}
+	})
+
+	next := func() reload {
+		t.Helper()
+		select {
+		case r := <-reloads:
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a reload")
+			return reload{}
+		}
+	}
+
+	// Give the watcher time to read the first version
+	time.Sleep(50 * time.Millisecond)
+	if err := os.WriteFile(path, []byte("resources: [{kind: Pod}, {kind: Service}]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if r := next(); r.err != nil || len(r.config.Resources) != 2 {
+		t.Errorf("reload = %+v, want the two resources", r)
+	}
+
+	if err := os.WriteFile(path, []byte("resources: [{}]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if r := next(); r.err == nil || !strings.Contains(r.err.Error(), "resources[0].kind: required") {
+		t.Errorf("reload error = %v, want the validation error", r.err)
+	}
+
+	select {
+	case r := <-reloads:
+		t.Errorf("unexpected reload %+v of an unchanged file", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
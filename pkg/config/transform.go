@@ -0,0 +1,163 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Redacted replaces the values of redacted fields
+const Redacted = "REDACTED"
+
+// Transform changes the objects of events, e.g. to drop noisy fields or
+// hide secrets. Fields are given as dot-separated paths such as
+// metadata.managedFields, with keys that contain dots in brackets, e.g.
+// metadata.annotations[kubectl.kubernetes.io/last-applied-configuration].
+type Transform struct {
+	// Kinds limits the transform to these kinds, empty for all kinds
+	Kinds []string `json:"kinds,omitempty"`
+	// RemoveFields are dropped from the objects
+	RemoveFields []string `json:"removeFields,omitempty"`
+	// RedactFields have their value replaced, or every value of a map
+	// replaced, e.g. the data of a Secret
+	RedactFields []string `json:"redactFields,omitempty"`
+}
+
+// compiledTransform is a Transform with parsed field paths
+type compiledTransform struct {
+	kinds  []string
+	remove [][]string
+	redact [][]string
+}
+
+// appliesTo reports whether the transform changes objects of a kind
+func (t compiledTransform) appliesTo(kind string) bool {
+	if len(t.kinds) == 0 {
+		return true
+	}
+	for _, k := range t.kinds {
+		if strings.EqualFold(k, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply changes an object in place
+func (t compiledTransform) apply(obj map[string]interface{}) {
+	for _, path := range t.remove {
+		unstructured.RemoveNestedField(obj, path...)
+	}
+	for _, path := range t.redact {
+		value, found, _ := unstructured.NestedFieldNoCopy(obj, path...)
+		if !found {
+			continue
+		}
+		if m, ok := value.(map[string]interface{}); ok {
+			for key := range m {
+				m[key] = Redacted
+			}
+			continue
+		}
+		_ = unstructured.SetNestedField(obj, Redacted, path...)
+	}
+}
+
+// TransformHandler returns a handler that applies transforms to the objects
+// of events before passing them to next. Objects are copied before they are
+// changed, as the watcher keeps them to compare with later versions.
+func TransformHandler(transforms []Transform, next watcher.EventHandler) (watcher.EventHandler, error) {
+	if len(transforms) == 0 {
+		return next, nil
+	}
+
+	var compiled []compiledTransform
+	for i, t := range transforms {
+		c := compiledTransform{kinds: t.Kinds}
+		for _, field := range t.RemoveFields {
+			path, err := parseFieldPath(field)
+			if err != nil {
+				return nil, fmt.Errorf("transform %d: %v", i+1, err)
+			}
+			c.remove = append(c.remove, path)
+		}
+		for _, field := range t.RedactFields {
+			path, err := parseFieldPath(field)
+			if err != nil {
+				return nil, fmt.Errorf("transform %d: %v", i+1, err)
+			}
+			c.redact = append(c.redact, path)
+		}
+		compiled = append(compiled, c)
+	}
+
+	transform := func(obj map[string]interface{}, kind string) map[string]interface{} {
+		if obj == nil {
+			return nil
+		}
+		copied := false
+		for _, t := range compiled {
+			if !t.appliesTo(kind) {
+				continue
+			}
+			if !copied {
+				obj = (&unstructured.Unstructured{Object: obj}).DeepCopy().Object
+				copied = true
+			}
+			t.apply(obj)
+		}
+		return obj
+	}
+
+	return func(event watcher.ResourceEvent) {
+		if event.Type != watch.Error {
+			event.Object = transform(event.Object, event.Resource.Kind)
+			event.OldObject = transform(event.OldObject, event.Resource.Kind)
+		}
+		next(event)
+	}, nil
+}
+
+// parseFieldPath splits a field path such as a.b[c.d].e into its keys
+func parseFieldPath(s string) ([]string, error) {
+	var path []string
+	rest := s
+	for rest != "" {
+		var key string
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid field path %q: missing ]", s)
+			}
+			key, rest = rest[1:end], rest[end+1:]
+		} else {
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			key, rest = rest[:end], rest[end:]
+		}
+		if key == "" {
+			return nil, fmt.Errorf("invalid field path %q: empty key", s)
+		}
+		path = append(path, key)
+
+		// Keys are separated by dots, or directly followed by a bracket
+		if strings.HasPrefix(rest, ".") {
+			rest = rest[1:]
+			if rest == "" {
+				return nil, fmt.Errorf("invalid field path %q: empty key", s)
+			}
+		} else if rest != "" && !strings.HasPrefix(rest, "[") {
+			return nil, fmt.Errorf("invalid field path %q: expected . or [ after %q", s, key)
+		}
+	}
+
+	if len(path) == 0 {
+		return nil, fmt.Errorf("empty field path")
+	}
+	return path, nil
+}
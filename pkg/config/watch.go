@@ -0,0 +1,51 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Watch checks a configuration file for changes every interval until ctx is
+// done. When its content changed, reload is called with the new
+// configuration, or with the error that made it unusable. Files that are
+// replaced rather than written in place, as many editors do, are picked up
+// as well.
+func Watch(ctx context.Context, path string, interval time.Duration, reload func(*Config, error)) {
+	last, _ := os.ReadFile(path)
+	var lastErr string
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			// Report a missing file once, it may be in the middle of being
+			// replaced
+			if err.Error() != lastErr {
+				lastErr = err.Error()
+				reload(nil, fmt.Errorf("failed to read config file: %v", err))
+			}
+			continue
+		}
+		lastErr = ""
+		if bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+
+		config, err := Parse(data)
+		if err != nil {
+			err = fmt.Errorf("invalid config file %s: %v", path, err)
+		}
+		reload(config, err)
+	}
+}
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// maxFlushAttempts is how many times a batch whose transaction fails is
+// written before its operations are dropped, bounding the queue while the
+// database can't be written, e.g. when the disk is full
+const maxFlushAttempts = 3
+
+// BatchWriter groups writes into transactions, committing a batch when it
+// reaches its size limit or has waited for the flush interval. This avoids
+// a commit per event when a watch starts and lists every resource. Writes
+// become visible to readers when their batch commits.
+type BatchWriter struct {
+	store    *ResourceStore
+	maxSize  int
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending []batchOp
+	closed  bool
+	// failedFlushes counts the failed transactions of the pending batch
+	failedFlushes int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// batchOp is a pending upsert, or a delete when resource is nil
+type batchOp struct {
+	resource *Resource
+	key      ResourceKey
+	// at is when the change was seen
+	at time.Time
+}
+
+// NewBatchWriter creates a writer that commits every maxSize writes and at
+// least every interval. Close flushes the remaining writes.
+func NewBatchWriter(store *ResourceStore, maxSize int, interval time.Duration) *BatchWriter {
+	w := &BatchWriter{
+		store:    store,
+		maxSize:  max(maxSize, 1),
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// run flushes pending writes on every interval until the writer is closed
+func (w *BatchWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.Flush(); err != nil {
+				log.Printf("Failed to write batch: %v", err)
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Upsert queues a resource to be stored. If the batch is full it is
+// written immediately and any errors from the batch are returned.
+func (w *BatchWriter) Upsert(resource Resource) error {
+	return w.add(batchOp{resource: &resource, key: resource.Key(), at: time.Now()})
+}
+
+// Delete queues a resource to be removed. If the batch is full it is
+// written immediately and any errors from the batch are returned.
+func (w *BatchWriter) Delete(kind, apiVersion, namespace, name string) error {
+	return w.add(batchOp{key: ResourceKey{Kind: kind, APIVersion: apiVersion, Namespace: namespace, Name: name}, at: time.Now()})
+}
+
+// add queues an operation, writing the batch when it is full. A batch
+// that failed is retried each time another maxSize operations are queued,
+// rather than on every operation.
+func (w *BatchWriter) add(op batchOp) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return fmt.Errorf("batch writer is closed")
+	}
+
+	w.pending = append(w.pending, op)
+	if len(w.pending)%w.maxSize == 0 {
+		return w.flushLocked()
+	}
+	return nil
+}
+
+// Flush writes all queued operations
+func (w *BatchWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flushLocked()
+}
+
+// Close writes the remaining operations and stops the writer
+func (w *BatchWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	err := w.flushLocked()
+	w.mu.Unlock()
+
+	close(w.stop)
+	<-w.done
+	return err
+}
+
+// flushLocked writes the pending operations in one transaction. Each
+// operation runs in a savepoint so one failure doesn't lose the others.
+// When the transaction fails, the operations stay queued for the next
+// flush, and are dropped after maxFlushAttempts failures.
+func (w *BatchWriter) flushLocked() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+
+	var failures []error
+	err := w.store.write(func(tx *sql.Tx) error {
+		for _, op := range w.pending {
+			if err := writeOp(tx, w.store, op); err != nil {
+				failures = append(failures, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		w.failedFlushes++
+		if w.failedFlushes < maxFlushAttempts {
+			return err
+		}
+		dropped := len(w.pending)
+		w.pending = nil
+		w.failedFlushes = 0
+		return fmt.Errorf("dropped %d writes after %d failed attempts: %v", dropped, maxFlushAttempts, err)
+	}
+
+	w.pending = nil
+	w.failedFlushes = 0
+	return errors.Join(failures...)
+}
+
+// writeOp applies an operation within a savepoint of the batch transaction
+func writeOp(tx *sql.Tx, store *ResourceStore, op batchOp) error {
+	if _, err := tx.Exec("SAVEPOINT batch_op"); err != nil {
+		return fmt.Errorf("failed to create savepoint: %v", err)
+	}
+
+	var err error
+	if op.resource != nil {
+		err = store.upsert(tx, *op.resource, op.at)
+	} else {
+		err = store.delete(tx, op.key, op.at)
+	}
+
+	if err != nil {
+		if _, rollbackErr := tx.Exec("ROLLBACK TO batch_op"); rollbackErr != nil {
+			return fmt.Errorf("%s/%s: %v (rollback failed: %v)", op.key.Kind, op.key.Name, err, rollbackErr)
+		}
+	}
+	if _, releaseErr := tx.Exec("RELEASE batch_op"); releaseErr != nil && err == nil {
+		err = fmt.Errorf("failed to release savepoint: %v", releaseErr)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s/%s: %v", op.key.Kind, op.key.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchWriterKeepsFailedBatches(t *testing.T) {
+	store := newTestStore(t)
+	writer := NewBatchWriter(store, 1000, time.Hour)
+	defer writer.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := writer.Upsert(benchResource(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A transaction that can't begin or commit loses nothing
+	db := store.db
+	closed, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "closed.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	store.db = closed
+	if err := writer.Flush(); err == nil {
+		t.Fatal("expected an error flushing to a closed database")
+	}
+	store.db = db
+
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := store.ResourceCount(); count != 3 {
+		t.Fatalf("expected 3 resources after the retried flush, got %d", count)
+	}
+
+	// A failing operation is reported without keeping it or losing the others
+	broken := benchResource(3)
+	broken.Name = ""
+	for _, r := range []Resource{broken, benchResource(4)} {
+		if err := writer.Upsert(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.db.Exec(`CREATE TRIGGER reject_unnamed BEFORE INSERT ON resources WHEN NEW.name = ''
+		BEGIN SELECT RAISE(ABORT, 'unnamed resource'); END`); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Flush(); err == nil {
+		t.Fatal("expected the failed operation to be reported")
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("expected the failed operation to be dropped, got %v", err)
+	}
+	if count, _ := store.ResourceCount(); count != 4 {
+		t.Fatalf("expected 4 resources, got %d", count)
+	}
+}
+
+func TestBatchWriterDropsBatchesThatKeepFailing(t *testing.T) {
+	store := newTestStore(t)
+	writer := NewBatchWriter(store, 2, time.Hour)
+	defer writer.Close()
+
+	db := store.db
+	closed, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "closed.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	store.db = closed
+
+	// Writes are retried once per full batch, until they are dropped
+	var errs []error
+	for i := 0; i < 2*maxFlushAttempts; i++ {
+		errs = append(errs, writer.Upsert(benchResource(i)))
+		if n := len(writer.pending); n > 2*maxFlushAttempts {
+			t.Fatalf("%d writes queued, want at most %d", n, 2*maxFlushAttempts)
+		}
+	}
+	for i, err := range errs {
+		switch {
+		case i%2 == 0 && err != nil:
+			t.Errorf("Upsert %d failed without a full batch: %v", i, err)
+		case i%2 == 1 && err == nil:
+			t.Errorf("Upsert %d of a full batch succeeded on a closed database", i)
+		}
+	}
+	last := errs[len(errs)-1]
+	if last == nil || !strings.Contains(last.Error(), fmt.Sprintf("dropped %d writes", 2*maxFlushAttempts)) {
+		t.Errorf("last error = %v, want the writes dropped", last)
+	}
+	if len(writer.pending) != 0 {
+		t.Errorf("%d writes queued after dropping them", len(writer.pending))
+	}
+
+	// Once the database recovers, new writes are stored
+	store.db = db
+	for i := 10; i < 12; i++ {
+		if err := writer.Upsert(benchResource(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count, _ := store.ResourceCount(); count != 2 {
+		t.Fatalf("expected 2 resources after recovering, got %d", count)
+	}
+}
+
+// benchResource returns a Pod shaped like the ones a watch lists
+func benchResource(i int) Resource {
+	name := fmt.Sprintf("pod-%d", i)
+	return Resource{
+		Name:            name,
+		Namespace:       "bench",
+		Kind:            "Pod",
+		APIVersion:      "v1",
+		ResourceVersion: fmt.Sprint(i),
+		Data: fmt.Sprintf(`{"metadata":{"name":%q,"uid":"uid-%d","labels":{"app":"bench","shard":"%d"}},`+
+			`"spec":{"containers":[{"name":"app","image":"nginx:1.20"}]},"status":{"phase":"Running"}}`, name, i, i%10),
+	}
+}
+
+func TestBatchWriter(t *testing.T) {
+	store := newTestStore(t)
+	writer := NewBatchWriter(store, 10, time.Hour)
+
+	for i := 0; i < 25; i++ {
+		if err := writer.Upsert(benchResource(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Two full batches have been written, the rest waits for a flush
+	if count, _ := store.ResourceCount(); count != 20 {
+		t.Fatalf("expected 20 resources before flushing, got %d", count)
+	}
+
+	if err := writer.Delete("Pod", "v1", "bench", "pod-0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := store.ResourceCount(); count != 24 {
+		t.Fatalf("expected 24 resources after closing, got %d", count)
+	}
+
+	if err := writer.Upsert(benchResource(99)); err == nil {
+		t.Fatal("expected an error writing to a closed writer")
+	}
+}
+
+func TestBatchWriterFlushesOnInterval(t *testing.T) {
+	store := newTestStore(t)
+	writer := NewBatchWriter(store, 1000, 10*time.Millisecond)
+	defer writer.Close()
+
+	if err := writer.Upsert(benchResource(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if count, _ := store.ResourceCount(); count == 1 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("batch wasn't flushed on the interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// BenchmarkIngest compares storing the initial list of a watch one
+// transaction per event, as before batching and WAL, with batched writes.
+// Run with: go test -run '^$' -bench Ingest ./pkg/db
+func BenchmarkIngest(b *testing.B) {
+	cases := []struct {
+		name      string
+		legacy    bool
+		batchSize int
+	}{
+		{"rollback-journal/per-event", true, 0},
+		{"wal/per-event", false, 0},
+		{"wal/batch-100", false, 100},
+		{"wal/batch-1000", false, 1000},
+	}
+
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			store, err := New(filepath.Join(b.TempDir(), "bench.db"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer store.Close()
+
+			if c.legacy {
+				// The defaults before WAL was enabled
+				store.db.SetMaxOpenConns(1)
+				if _, err := store.db.Exec("PRAGMA journal_mode=DELETE; PRAGMA synchronous=FULL"); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ResetTimer()
+			if c.batchSize == 0 {
+				for i := 0; i < b.N; i++ {
+					if err := store.Upsert(benchResource(i)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			} else {
+				writer := NewBatchWriter(store, c.batchSize, time.Second)
+				for i := 0; i < b.N; i++ {
+					if err := writer.Upsert(benchResource(i)); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := writer.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "events/s")
+		})
+	}
+}
+
+// BenchmarkSearchDuringIngest measures reads while a batch writer is busy
+func BenchmarkSearchDuringIngest(b *testing.B) {
+	store, err := New(filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer store.Close()
+
+	writer := NewBatchWriter(store, 500, 100*time.Millisecond)
+	stop := make(chan struct{})
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				writer.Upsert(benchResource(i))
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := store.Search("pod-1"); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	close(stop)
+	writer.Close()
+}
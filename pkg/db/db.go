@@ -8,15 +8,22 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/health"
 )
 
-// ResourceStore manages the SQLite database for Kubernetes resources
+// ResourceStore manages the SQLite database for Kubernetes resources.
+// The database runs in WAL mode, so reads don't wait for writes; writes are
+// serialized by the store.
 type ResourceStore struct {
-	db   *sql.DB
-	mu   sync.RWMutex
+	db *sql.DB
+	// mu serializes writes
+	mu   sync.Mutex
 	path string
+	// fullText is set when the FTS5 index is available and maintained
+	fullText bool
 }
 
 // Resource represents a Kubernetes resource in the database
@@ -28,8 +35,27 @@ type Resource struct {
 	APIVersion      string `json:"apiVersion"`
 	ResourceVersion string `json:"resourceVersion"`
 	Data            string `json:"data"`
+	// DeletedAt is set for tombstones, which keep the final state of a
+	// deleted resource
+	DeletedAt time.Time `json:"deletedAt,omitzero"`
+	// Health and HealthReason are evaluated by the store when the resource
+	// is stored (see pkg/health)
+	Health       health.Status `json:"health,omitempty"`
+	HealthReason string        `json:"healthReason,omitempty"`
 }
 
+// Deleted reports whether the resource is a tombstone
+func (r Resource) Deleted() bool {
+	return !r.DeletedAt.IsZero()
+}
+
+// connectionOptions apply to every connection in the pool: foreign keys for
+// cascading deletes, WAL so readers don't block on the writer, a busy
+// timeout instead of immediate SQLITE_BUSY errors, NORMAL synchronous
+// mode, which is safe with WAL and avoids an fsync per commit, and
+// incremental auto-vacuum, which takes effect for new databases
+const connectionOptions = "?_foreign_keys=1&_journal_mode=WAL&_busy_timeout=5000&_synchronous=NORMAL&_auto_vacuum=incremental"
+
 // New creates a new ResourceStore with the specified database file
 func New(dbPath string) (*ResourceStore, error) {
 	// Ensure the directory exists
@@ -38,7 +64,7 @@ func New(dbPath string) (*ResourceStore, error) {
 		return nil, fmt.Errorf("failed to create directory for database: %v", err)
 	}
 
-	db, err := sql.Open("sqlite3", dbPath)
+	db, err := sql.Open("sqlite3", dbPath+connectionOptions)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
@@ -56,30 +82,21 @@ func New(dbPath string) (*ResourceStore, error) {
 	return store, nil
 }
 
-// Initialize sets up the database schema
+// Initialize sets up the database schema by applying pending migrations
+// and prepares the full-text index
 func (s *ResourceStore) initialize() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Create resources table if it doesn't exist
-	_, err := s.db.Exec(`
-		CREATE TABLE IF NOT EXISTS resources (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT NOT NULL,
-			namespace TEXT NOT NULL,
-			kind TEXT NOT NULL,
-			api_version TEXT NOT NULL,
-			resource_version TEXT NOT NULL,
-			data TEXT NOT NULL,
-			UNIQUE(kind, api_version, namespace, name)
-		);
-		CREATE INDEX IF NOT EXISTS idx_resources_search ON resources(name, namespace, kind);
-	`)
-	if err != nil {
-		return fmt.Errorf("failed to create tables: %v", err)
+	if err := migrate(s.db); err != nil {
+		return err
 	}
 
-	return nil
+	if err := s.evaluateHealth(); err != nil {
+		return err
+	}
+
+	return s.setupFullText()
 }
 
 // Close closes the database connection
@@ -89,48 +106,204 @@ func (s *ResourceStore) Close() error {
 	return s.db.Close()
 }
 
-// Upsert adds or updates a resource in the database
+// Upsert adds or updates a resource in the database and records the
+// change in the event history
 func (s *ResourceStore) Upsert(resource Resource) error {
+	return s.write(func(tx *sql.Tx) error {
+		return s.upsert(tx, resource, time.Now())
+	})
+}
+
+// Delete marks a resource as deleted, keeping its final state as a
+// tombstone, and records the deletion in the event history
+func (s *ResourceStore) Delete(kind, apiVersion, namespace, name string) error {
+	return s.write(func(tx *sql.Tx) error {
+		return s.delete(tx, ResourceKey{Kind: kind, APIVersion: apiVersion, Namespace: namespace, Name: name}, time.Now())
+	})
+}
+
+// PurgeType removes every stored resource of a type, including tombstones
+// and history, e.g. once the type is no longer watched. An empty namespace
+// purges all namespaces. It returns the number of resources removed.
+func (s *ResourceStore) PurgeType(kind, apiVersion, namespace string) (int, error) {
+	where := "kind = ? AND api_version = ?"
+	args := []interface{}{kind, apiVersion}
+	if namespace != "" {
+		where += " AND namespace = ?"
+		args = append(args, namespace)
+	}
+
+	var purged int64
+	err := s.write(func(tx *sql.Tx) error {
+		if s.fullText {
+			if _, err := tx.Exec("DELETE FROM resources_fts WHERE rowid IN (SELECT id FROM resources WHERE "+where+")", args...); err != nil {
+				return fmt.Errorf("failed to remove resources from full-text index: %v", err)
+			}
+		}
+
+		result, err := tx.Exec("DELETE FROM resources WHERE "+where, args...)
+		if err != nil {
+			return fmt.Errorf("failed to purge resources: %v", err)
+		}
+		if purged, err = result.RowsAffected(); err != nil {
+			return fmt.Errorf("failed to purge resources: %v", err)
+		}
+
+		if _, err := tx.Exec("DELETE FROM events WHERE "+where, args...); err != nil {
+			return fmt.Errorf("failed to purge history: %v", err)
+		}
+		return nil
+	})
+	return int(purged), err
+}
+
+// write runs fn in a transaction, committing if it succeeds
+func (s *ResourceStore) write(fn func(tx *sql.Tx) error) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, err := s.db.Exec(`
-		INSERT INTO resources (name, namespace, kind, api_version, resource_version, data)
-		VALUES (?, ?, ?, ?, ?, ?)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// upsert stores a resource within a transaction, recording the change as
+// seen at the given time
+func (s *ResourceStore) upsert(tx *sql.Tx, resource Resource, at time.Time) error {
+	previous, err := currentVersion(tx, resource.Key())
+	if err != nil {
+		return err
+	}
+
+	// Relists deliver objects we already have, which aren't changes
+	if previous != nil && !previous.Deleted() &&
+		previous.ResourceVersion == resource.ResourceVersion && previous.Data == resource.Data {
+		return nil
+	}
+
+	h := health.EvaluateJSON(resource.Kind, resource.APIVersion, resource.Data)
+	_, err = tx.Exec(`
+		INSERT INTO resources (name, namespace, kind, api_version, resource_version, data, health, health_reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(kind, api_version, namespace, name)
-		DO UPDATE SET resource_version = ?, data = ?
+		DO UPDATE SET resource_version = ?, data = ?, deleted_at = NULL, health = ?, health_reason = ?
 	`, resource.Name, resource.Namespace, resource.Kind, resource.APIVersion,
-		resource.ResourceVersion, resource.Data, resource.ResourceVersion, resource.Data)
+		resource.ResourceVersion, resource.Data, h.Status, h.Reason, resource.ResourceVersion, resource.Data, h.Status, h.Reason)
 
 	if err != nil {
 		return fmt.Errorf("failed to upsert resource: %v", err)
 	}
 
+	// A resource created again after its deletion starts a new history
+	eventType, previousRV, previousData := EventAdded, "", ""
+	if previous != nil && !previous.Deleted() {
+		eventType, previousRV, previousData = EventModified, previous.ResourceVersion, previous.Data
+	}
+
+	if err := recordEvent(tx, resource.Key(), eventType, resource.ResourceVersion, previousRV, resource.Data, previousData, at); err != nil {
+		return err
+	}
+
+	current, err := currentVersion(tx, resource.Key())
+	if err != nil {
+		return err
+	}
+
+	if err := indexMetadata(tx, current.ID, resource.Data); err != nil {
+		return err
+	}
+
+	if s.fullText {
+		if err := indexFullText(tx, current.ID, resource); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// Delete removes a resource from the database
-func (s *ResourceStore) Delete(kind, apiVersion, namespace, name string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	_, err := s.db.Exec(`
-		DELETE FROM resources
-		WHERE kind = ? AND api_version = ? AND namespace = ? AND name = ?
-	`, kind, apiVersion, namespace, name)
+// delete turns a resource into a tombstone within a transaction, deleted at
+// the given time. Its metadata and full-text entries stay, so tombstones can
+// be queried.
+func (s *ResourceStore) delete(tx *sql.Tx, key ResourceKey, at time.Time) error {
+	previous, err := currentVersion(tx, key)
+	if err != nil {
+		return err
+	}
+	if previous == nil || previous.Deleted() {
+		return nil
+	}
 
+	_, err = tx.Exec("UPDATE resources SET deleted_at = ?, health = ?, health_reason = ? WHERE id = ?",
+		at.UnixNano(), health.Deleted.Status, health.Deleted.Reason, previous.ID)
 	if err != nil {
 		return fmt.Errorf("failed to delete resource: %v", err)
 	}
 
+	return recordEvent(tx, key, EventDeleted, previous.ResourceVersion, previous.ResourceVersion, previous.Data, "", at)
+}
+
+// purge removes a tombstone for good
+func (s *ResourceStore) purge(tx *sql.Tx, id int64) error {
+	if _, err := tx.Exec("DELETE FROM resources WHERE id = ? AND deleted_at IS NOT NULL", id); err != nil {
+		return fmt.Errorf("failed to purge resource: %v", err)
+	}
+
+	if s.fullText {
+		return unindexFullText(tx, id)
+	}
 	return nil
 }
 
-// Search performs a fuzzy search for resources
-func (s *ResourceStore) Search(query string) ([]Resource, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// currentVersion returns the stored version of a resource, which may be a
+// tombstone, or nil if it isn't stored
+func currentVersion(tx *sql.Tx, key ResourceKey) (*Resource, error) {
+	r := Resource{
+		Name:       key.Name,
+		Namespace:  key.Namespace,
+		Kind:       key.Kind,
+		APIVersion: key.APIVersion,
+	}
+
+	var deletedAt sql.NullInt64
+	err := tx.QueryRow(`
+		SELECT id, resource_version, data, deleted_at FROM resources
+		WHERE kind = ? AND api_version = ? AND namespace = ? AND name = ?
+	`, key.Kind, key.APIVersion, key.Namespace, key.Name).Scan(&r.ID, &r.ResourceVersion, &r.Data, &deletedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read resource: %v", err)
+	}
 
+	r.DeletedAt = unixTime(deletedAt)
+	return &r, nil
+}
+
+// unixTime converts a nullable Unix nanosecond column, NULL being the zero
+// time
+func unixTime(n sql.NullInt64) time.Time {
+	if !n.Valid {
+		return time.Time{}
+	}
+	return time.Unix(0, n.Int64)
+}
+
+// Search performs a fuzzy search for resources, excluding tombstones
+func (s *ResourceStore) Search(query string) ([]Resource, error) {
 	var resources []Resource
 
 	var rows *sql.Rows
@@ -139,8 +312,9 @@ func (s *ResourceStore) Search(query string) ([]Resource, error) {
 	if query == "" {
 		// Return everything when query is empty
 		rows, err = s.db.Query(`
-			SELECT id, name, namespace, kind, api_version, resource_version, data
+			SELECT id, name, namespace, kind, api_version, resource_version, data, health, health_reason
 			FROM resources
+			WHERE deleted_at IS NULL
 			ORDER BY namespace, kind, name
 			LIMIT 100
 		`)
@@ -148,9 +322,9 @@ func (s *ResourceStore) Search(query string) ([]Resource, error) {
 		// Use LIKE for simple pattern matching
 		searchPattern := "%" + query + "%"
 		rows, err = s.db.Query(`
-			SELECT id, name, namespace, kind, api_version, resource_version, data
+			SELECT id, name, namespace, kind, api_version, resource_version, data, health, health_reason
 			FROM resources
-			WHERE name LIKE ? OR namespace LIKE ? OR kind LIKE ?
+			WHERE deleted_at IS NULL AND (name LIKE ? OR namespace LIKE ? OR kind LIKE ?)
 			ORDER BY namespace, kind, name
 			LIMIT 100
 		`, searchPattern, searchPattern, searchPattern)
@@ -163,7 +337,8 @@ func (s *ResourceStore) Search(query string) ([]Resource, error) {
 
 	for rows.Next() {
 		var r Resource
-		if err := rows.Scan(&r.ID, &r.Name, &r.Namespace, &r.Kind, &r.APIVersion, &r.ResourceVersion, &r.Data); err != nil {
+		if err := rows.Scan(&r.ID, &r.Name, &r.Namespace, &r.Kind, &r.APIVersion, &r.ResourceVersion, &r.Data,
+			&r.Health, &r.HealthReason); err != nil {
 			return nil, fmt.Errorf("failed to scan row: %v", err)
 		}
 		resources = append(resources, r)
@@ -176,13 +351,11 @@ func (s *ResourceStore) Search(query string) ([]Resource, error) {
 	return resources, nil
 }
 
-// ResourceCount returns the total number of resources in the database
+// ResourceCount returns the number of resources in the database, excluding
+// tombstones
 func (s *ResourceStore) ResourceCount() (int, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
 	var count int
-	err := s.db.QueryRow("SELECT COUNT(*) FROM resources").Scan(&count)
+	err := s.db.QueryRow("SELECT COUNT(*) FROM resources WHERE deleted_at IS NULL").Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count resources: %v", err)
 	}
@@ -190,16 +363,22 @@ func (s *ResourceStore) ResourceCount() (int, error) {
 	return count, nil
 }
 
-// CleanDatabase removes all resources from the database
+// CleanDatabase removes all resources and their history from the database
 func (s *ResourceStore) CleanDatabase() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, err := s.db.Exec("DELETE FROM resources")
+	_, err := s.db.Exec("DELETE FROM resources; DELETE FROM events")
 	if err != nil {
 		return fmt.Errorf("failed to clean database: %v", err)
 	}
 
+	if s.fullText {
+		if _, err := s.db.Exec("DELETE FROM resources_fts"); err != nil {
+			return fmt.Errorf("failed to clean full-text index: %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,393 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Markers around matched terms in search snippets
+const (
+	HighlightStart = "«"
+	HighlightEnd   = "»"
+)
+
+// SearchResult is a resource matched by a full-text search
+type SearchResult struct {
+	Resource
+	// Snippet is an excerpt of the matching content with matches highlighted
+	Snippet string `json:"snippet"`
+	// Rank orders results, lower is better
+	Rank float64 `json:"rank"`
+}
+
+// fullTextDocument is the searchable text extracted from an object
+type fullTextDocument struct {
+	labels      string
+	annotations string
+	images      string
+	env         string
+	content     string
+}
+
+// setupFullText creates the FTS5 index if SQLite was built with FTS5
+// (go build -tags sqlite_fts5). The index is derived from the resources table
+// and is rebuilt whenever it may have missed writes.
+func (s *ResourceStore) setupFullText() error {
+	var enabled bool
+	if err := s.db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil {
+		return fmt.Errorf("failed to check for FTS5 support: %v", err)
+	}
+
+	exists, err := tableExists(s.db, "resources_fts")
+	if err != nil {
+		return err
+	}
+
+	if !enabled {
+		log.Printf("Full-text index unavailable (build with -tags sqlite_fts5), falling back to substring search")
+		if exists {
+			// Writes from this binary won't reach the index, so have the next
+			// binary with FTS5 rebuild it
+			return setMeta(s.db, "fulltext_stale", "1")
+		}
+		return nil
+	}
+
+	stale, err := getMeta(s.db, "fulltext_stale")
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		_, err := s.db.Exec(`
+			CREATE VIRTUAL TABLE resources_fts USING fts5(
+				name, namespace, kind, labels, annotations, images, env, content
+			)
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to create full-text index: %v", err)
+		}
+	}
+
+	if !exists || stale == "1" {
+		if err := s.rebuildFullText(); err != nil {
+			return err
+		}
+	}
+
+	s.fullText = true
+	return nil
+}
+
+// rebuildFullText indexes every stored resource from scratch
+func (s *ResourceStore) rebuildFullText() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM resources_fts"); err != nil {
+		return fmt.Errorf("failed to clear full-text index: %v", err)
+	}
+
+	rows, err := tx.Query("SELECT id, name, namespace, kind, data FROM resources")
+	if err != nil {
+		return fmt.Errorf("failed to read resources: %v", err)
+	}
+
+	var resources []Resource
+	for rows.Next() {
+		var r Resource
+		if err := rows.Scan(&r.ID, &r.Name, &r.Namespace, &r.Kind, &r.Data); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan row: %v", err)
+		}
+		resources = append(resources, r)
+	}
+	rows.Close()
+
+	for _, r := range resources {
+		if err := indexFullText(tx, r.ID, r); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM store_meta WHERE key = 'fulltext_stale'"); err != nil {
+		return fmt.Errorf("failed to update store metadata: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit full-text index: %v", err)
+	}
+
+	if len(resources) > 0 {
+		log.Printf("Rebuilt full-text index for %d resources", len(resources))
+	}
+	return nil
+}
+
+// indexFullText replaces the index entry of a resource
+func indexFullText(tx *sql.Tx, id int64, r Resource) error {
+	if err := unindexFullText(tx, id); err != nil {
+		return err
+	}
+
+	doc := newFullTextDocument(r.Data)
+	_, err := tx.Exec(`
+		INSERT INTO resources_fts (rowid, name, namespace, kind, labels, annotations, images, env, content)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, id, r.Name, r.Namespace, r.Kind, doc.labels, doc.annotations, doc.images, doc.env, doc.content)
+	if err != nil {
+		return fmt.Errorf("failed to index resource: %v", err)
+	}
+	return nil
+}
+
+// unindexFullText removes the index entry of a resource
+func unindexFullText(tx *sql.Tx, id int64) error {
+	if _, err := tx.Exec("DELETE FROM resources_fts WHERE rowid = ?", id); err != nil {
+		return fmt.Errorf("failed to remove resource from full-text index: %v", err)
+	}
+	return nil
+}
+
+// FullTextAvailable reports whether searches use the FTS5 index
+func (s *ResourceStore) FullTextAvailable() bool {
+	return s.fullText
+}
+
+// FullTextSearch searches the contents of resources, including labels,
+// annotations, container images and environment variable names. Every term
+// of the query must match; a trailing * matches a prefix. Results are ranked
+// by relevance when the FTS5 index is available, otherwise a substring
+// search over the object data is used.
+func (s *ResourceStore) FullTextSearch(query string, limit int) ([]SearchResult, error) {
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	if !s.fullText {
+		return s.substringSearch(terms, limit)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT r.id, r.name, r.namespace, r.kind, r.api_version, r.resource_version, r.data, r.health, r.health_reason,
+			snippet(resources_fts, -1, ?, ?, '…', 12),
+			bm25(resources_fts, 10.0, 2.0, 2.0, 5.0, 2.0, 5.0, 5.0, 1.0) AS rank
+		FROM resources_fts
+		JOIN resources r ON r.id = resources_fts.rowid
+		WHERE resources_fts MATCH ? AND r.deleted_at IS NULL
+		ORDER BY rank
+		LIMIT ?
+	`, HighlightStart, HighlightEnd, matchExpression(terms), limit)
+	if err != nil {
+		return nil, fmt.Errorf("full-text search failed: %v", err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(&r.ID, &r.Name, &r.Namespace, &r.Kind, &r.APIVersion, &r.ResourceVersion, &r.Data,
+			&r.Health, &r.HealthReason, &r.Snippet, &r.Rank); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		results = append(results, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return results, nil
+}
+
+// substringSearch is the fallback used without FTS5: every term must appear
+// in the object data, and results are ordered by namespace, kind and name
+func (s *ResourceStore) substringSearch(terms []string, limit int) ([]SearchResult, error) {
+	where := make([]string, len(terms))
+	args := make([]interface{}, 0, len(terms)+1)
+	for i, term := range terms {
+		where[i] = "data LIKE ?"
+		args = append(args, "%"+strings.TrimSuffix(term, "*")+"%")
+	}
+	args = append(args, limit)
+
+	rows, err := s.db.Query(`
+		SELECT id, name, namespace, kind, api_version, resource_version, data, health, health_reason
+		FROM resources
+		WHERE deleted_at IS NULL AND `+strings.Join(where, " AND ")+`
+		ORDER BY namespace, kind, name
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("search query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(&r.ID, &r.Name, &r.Namespace, &r.Kind, &r.APIVersion, &r.ResourceVersion, &r.Data,
+			&r.Health, &r.HealthReason); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		r.Snippet = substringSnippet(r.Data, strings.TrimSuffix(terms[0], "*"))
+		results = append(results, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return results, nil
+}
+
+// matchExpression quotes every term so characters such as ':' and '.' in
+// "nginx:1.20" are searched for rather than parsed as FTS5 syntax
+func matchExpression(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		prefix := strings.HasSuffix(term, "*")
+		term = strings.ReplaceAll(strings.TrimSuffix(term, "*"), `"`, `""`)
+		quoted[i] = `"` + term + `"`
+		if prefix {
+			quoted[i] += "*"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// substringSnippet returns the text around the first case-insensitive
+// occurrence of term, with the match highlighted
+func substringSnippet(data, term string) string {
+	idx := strings.Index(strings.ToLower(data), strings.ToLower(term))
+	if idx == -1 {
+		return ""
+	}
+
+	start := max(0, idx-40)
+	end := min(len(data), idx+len(term)+40)
+
+	snippet := data[start:idx] + HighlightStart + data[idx:idx+len(term)] + HighlightEnd + data[idx+len(term):end]
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(data) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+// newFullTextDocument extracts the searchable text from an object
+func newFullTextDocument(data string) fullTextDocument {
+	obj, err := decodeObject(data)
+	if err != nil {
+		return fullTextDocument{content: data}
+	}
+
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	labels, _ := metadata["labels"].(map[string]interface{})
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+
+	var images, env, content []string
+	collectFields(obj, "", &images, &env, &content)
+
+	return fullTextDocument{
+		labels:      joinPairs(labels),
+		annotations: joinPairs(annotations),
+		images:      strings.Join(images, "\n"),
+		env:         strings.Join(env, "\n"),
+		content:     strings.Join(content, "\n"),
+	}
+}
+
+// collectFields walks an object collecting container images, environment
+// variable names and every key and scalar value as general content
+func collectFields(value interface{}, key string, images, env, content *[]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			// Skip bookkeeping that duplicates or obscures the object
+			if k == "managedFields" || k == "kubectl.kubernetes.io/last-applied-configuration" {
+				continue
+			}
+			*content = append(*content, k)
+			collectFields(child, k, images, env, content)
+		}
+
+	case []interface{}:
+		for _, item := range v {
+			if key == "env" {
+				if entry, ok := item.(map[string]interface{}); ok {
+					if name, ok := entry["name"].(string); ok {
+						*env = append(*env, name)
+					}
+				}
+			}
+			collectFields(item, key, images, env, content)
+		}
+
+	case string:
+		if key == "image" {
+			*images = append(*images, v)
+		}
+		*content = append(*content, v)
+
+	case nil:
+		// Nothing to index
+
+	default:
+		*content = append(*content, fmt.Sprint(v))
+	}
+}
+
+// joinPairs formats a string map as sorted key=value lines
+func joinPairs(m map[string]interface{}) string {
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", k, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\n")
+}
+
+// tableExists reports whether a table exists in the database
+func tableExists(db *sql.DB, name string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = ?", name).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect schema: %v", err)
+	}
+	return count > 0, nil
+}
+
+// getMeta reads a store_meta value, empty if unset
+func getMeta(db *sql.DB, key string) (string, error) {
+	var value string
+	err := db.QueryRow("SELECT value FROM store_meta WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read store metadata: %v", err)
+	}
+	return value, nil
+}
+
+// setMeta writes a store_meta value
+func setMeta(db *sql.DB, key, value string) error {
+	_, err := db.Exec(`
+		INSERT INTO store_meta (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value
+	`, key, value)
+	if err != nil {
+		return fmt.Errorf("failed to write store metadata: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// These tests pass with and without -tags sqlite_fts5; without it they
+// exercise the substring fallback.
+
+func newTestStore(t *testing.T) *ResourceStore {
+	t.Helper()
+	store, err := New(filepath.Join(t.TempDir(), "resources.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestFullTextSearch(t *testing.T) {
+	store := newTestStore(t)
+
+	resources := []Resource{
+		{Name: "web", Namespace: "prod", Kind: "Deployment", APIVersion: "apps/v1", ResourceVersion: "1",
+			Data: `{"metadata":{"name":"web","labels":{"app":"web"}},"spec":{"template":{"spec":{"containers":[` +
+				`{"name":"nginx","image":"nginx:1.20","env":[{"name":"DATABASE_URL","value":"postgres://db"}]}]}}}}`},
+		{Name: "app-config", Namespace: "prod", Kind: "ConfigMap", APIVersion: "v1", ResourceVersion: "1",
+			Data: `{"metadata":{"name":"app-config"},"data":{"LOG_LEVEL":"debug"}}`},
+	}
+	for _, r := range resources {
+		if err := store.Upsert(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"nginx:1.20", []string{"web"}},
+		{"DATABASE_URL", []string{"web"}},
+		{"LOG_LEVEL debug", []string{"app-config"}},
+		{"LOG_LEVEL nginx", nil},
+		{`"unbalanced`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			results, err := store.FullTextSearch(tt.query, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for _, r := range results {
+				names = append(names, r.Name)
+				if !strings.Contains(r.Snippet, HighlightStart) {
+					t.Errorf("expected a highlighted snippet for %s, got %q", r.Name, r.Snippet)
+				}
+			}
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("expected %v, got %v", tt.want, names)
+			}
+		})
+	}
+}
+
+func TestFullTextIndexFollowsWrites(t *testing.T) {
+	store := newTestStore(t)
+
+	r := Resource{Name: "app-config", Namespace: "prod", Kind: "ConfigMap", APIVersion: "v1",
+		ResourceVersion: "1", Data: `{"data":{"FEATURE_OLD":"on"}}`}
+	if err := store.Upsert(r); err != nil {
+		t.Fatal(err)
+	}
+
+	r.ResourceVersion, r.Data = "2", `{"data":{"FEATURE_NEW":"on"}}`
+	if err := store.Upsert(r); err != nil {
+		t.Fatal(err)
+	}
+
+	expectCount := func(query string, want int) {
+		t.Helper()
+		results, err := store.FullTextSearch(query, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != want {
+			t.Fatalf("expected %d results for %q, got %d", want, query, len(results))
+		}
+	}
+
+	expectCount("FEATURE_OLD", 0)
+	expectCount("FEATURE_NEW", 1)
+
+	if err := store.Delete(r.Kind, r.APIVersion, r.Namespace, r.Name); err != nil {
+		t.Fatal(err)
+	}
+	expectCount("FEATURE_NEW", 0)
+}
+
+func TestPurgeTypeRemovesFullTextEntries(t *testing.T) {
+	store := newTestStore(t)
+
+	for _, r := range []Resource{
+		{Name: "app-config", Namespace: "prod", Kind: "ConfigMap", APIVersion: "v1", ResourceVersion: "1",
+			Data: `{"metadata":{"name":"app-config"},"data":{"LOG_LEVEL":"debug"}}`},
+		{Name: "app-secret", Namespace: "prod", Kind: "Secret", APIVersion: "v1", ResourceVersion: "2",
+			Data: `{"metadata":{"name":"app-secret"},"data":{"LOG_LEVEL":"ZGVidWc="}}`},
+	} {
+		if err := store.Upsert(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := store.PurgeType("Secret", "v1", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := store.FullTextSearch("LOG_LEVEL", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Name != "app-config" {
+		t.Errorf("FullTextSearch after purge = %+v, want only app-config", results)
+	}
+}
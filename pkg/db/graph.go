@@ -0,0 +1,433 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Relation describes how a node relates to its parent in a graph
+type Relation string
+
+// Relations between resources
+const (
+	// RelationOwns links an owner to a resource listing it in ownerReferences
+	RelationOwns Relation = "owns"
+	// RelationSelects links a Service to the Pods matching its selector
+	RelationSelects Relation = "selects"
+	// RelationMounts links a Pod or workload to the ConfigMaps, Secrets and
+	// PersistentVolumeClaims in its volumes
+	RelationMounts Relation = "mounts"
+	// RelationReferences links a Pod or workload to the ConfigMaps and
+	// Secrets its containers read environment variables from
+	RelationReferences Relation = "references"
+	// RelationRoutes links an Ingress to its backend Services
+	RelationRoutes Relation = "routes"
+)
+
+// maxGraphDepth bounds ownership traversal in case of reference cycles
+const maxGraphDepth = 16
+
+// Node is a resource in an ownership or relation graph
+type Node struct {
+	Resource Resource
+	UID      string
+	// Relation is how this node relates to its parent, empty for the root
+	Relation Relation
+	// Missing is set for referenced resources that aren't stored; only the
+	// kind, namespace and name of Resource are known
+	Missing  bool
+	Children []*Node
+}
+
+// Descendants returns the tree of resources owned by a resource, directly
+// or through intermediate owners, e.g. Deployment → ReplicaSets → Pods
+func (s *ResourceStore) Descendants(r Resource) (*Node, error) {
+	root, err := s.node(r.Key())
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, fmt.Errorf("%s/%s isn't stored", r.Kind, r.Name)
+	}
+	if err := s.addDescendants(root, 0, false, map[string]bool{}); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// Tree returns the whole ownership tree containing a resource, rooted at
+// its topmost owner, with the related resources of every node as leaves
+func (s *ResourceStore) Tree(r Resource) (*Node, error) {
+	owners, err := s.Owners(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start from the topmost stored owner
+	rootKey := r.Key()
+	for i := len(owners) - 1; i >= 0; i-- {
+		if !owners[i].Missing {
+			rootKey = owners[i].Resource.Key()
+			break
+		}
+	}
+
+	root, err := s.node(rootKey)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, fmt.Errorf("%s/%s isn't stored", r.Kind, r.Name)
+	}
+	if err := s.addDescendants(root, 0, true, map[string]bool{}); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// Owners returns the chain of owners of a resource, from its controller
+// (or first owner) up to the root. Owners that aren't stored end the chain
+// as a missing node.
+func (s *ResourceStore) Owners(r Resource) ([]*Node, error) {
+	current, err := s.node(r.Key())
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, fmt.Errorf("%s/%s isn't stored", r.Kind, r.Name)
+	}
+
+	var chain []*Node
+	seen := map[string]bool{current.UID: true}
+	for len(chain) < maxGraphDepth {
+		var ownerUID, ownerKind, ownerName string
+		err := s.db.QueryRow(`
+			SELECT owner_uid, owner_kind, owner_name FROM owner_refs
+			WHERE resource_id = ?
+			ORDER BY controller DESC, owner_kind, owner_name
+			LIMIT 1
+		`, current.Resource.ID).Scan(&ownerUID, &ownerKind, &ownerName)
+		if err == sql.ErrNoRows {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read owner references: %v", err)
+		}
+		if seen[ownerUID] {
+			break
+		}
+		seen[ownerUID] = true
+
+		owners, err := s.nodes("uid = ?", ownerUID)
+		if err != nil {
+			return nil, err
+		}
+		if len(owners) == 0 {
+			chain = append(chain, &Node{
+				Resource: Resource{Kind: ownerKind, Name: ownerName, Namespace: current.Resource.Namespace},
+				UID:      ownerUID,
+				Relation: RelationOwns,
+				Missing:  true,
+			})
+			break
+		}
+
+		owners[0].Relation = RelationOwns
+		chain = append(chain, owners[0])
+		current = owners[0]
+	}
+
+	return chain, nil
+}
+
+// addDescendants attaches the owned resources of a node, and their related
+// resources when withRelated is set
+func (s *ResourceStore) addDescendants(n *Node, depth int, withRelated bool, seen map[string]bool) error {
+	if n.UID != "" {
+		seen[n.UID] = true
+	}
+
+	if withRelated {
+		related, err := s.Related(n.Resource)
+		if err != nil {
+			return err
+		}
+		n.Children = append(n.Children, related...)
+	}
+
+	if n.UID == "" || depth >= maxGraphDepth {
+		return nil
+	}
+
+	owned, err := s.nodes("id IN (SELECT resource_id FROM owner_refs WHERE owner_uid = ?)", n.UID)
+	if err != nil {
+		return err
+	}
+	for _, child := range owned {
+		if seen[child.UID] {
+			continue
+		}
+		child.Relation = RelationOwns
+		if err := s.addDescendants(child, depth+1, withRelated, seen); err != nil {
+			return err
+		}
+		n.Children = append(n.Children, child)
+	}
+
+	sortNodes(n.Children)
+	return nil
+}
+
+// Related returns the resources a resource uses or routes to, other than
+// through ownership: Service → Pods, Pod → ConfigMaps, Secrets and
+// PersistentVolumeClaims, and Ingress → Services
+func (s *ResourceStore) Related(r Resource) ([]*Node, error) {
+	obj, err := decodeObject(r.Data)
+	if err != nil {
+		return nil, nil
+	}
+
+	var result []*Node
+	add := func(relation Relation, kind, name string) error {
+		nodes, err := s.nodes("kind = ? AND namespace = ? AND name = ?", kind, r.Namespace, name)
+		if err != nil {
+			return err
+		}
+		if len(nodes) == 0 {
+			nodes = []*Node{{Resource: Resource{Kind: kind, Namespace: r.Namespace, Name: name}, Missing: true}}
+		}
+		for _, n := range nodes {
+			n.Relation = relation
+			result = append(result, n)
+		}
+		return nil
+	}
+
+	switch r.Kind {
+	case "Service":
+		selector := stringMap(nestedValue(obj, "spec", "selector"))
+		if len(selector) > 0 {
+			pods, err := s.selectPods(r.Namespace, selector)
+			if err != nil {
+				return nil, err
+			}
+			for _, pod := range pods {
+				pod.Relation = RelationSelects
+				result = append(result, pod)
+			}
+		}
+
+	case "Ingress":
+		for _, name := range ingressBackends(obj) {
+			if err := add(RelationRoutes, "Service", name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if spec := podSpec(r.Kind, obj); spec != nil {
+		for _, ref := range podSpecReferences(spec) {
+			if err := add(ref.relation, ref.kind, ref.name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sortNodes(result)
+	return result, nil
+}
+
+// selectPods returns the Pods in a namespace matching every selector label
+func (s *ResourceStore) selectPods(namespace string, selector map[string]string) ([]*Node, error) {
+	conditions := []string{"kind = 'Pod'", "namespace = ?"}
+	args := []interface{}{namespace}
+	for key, value := range selector {
+		conditions = append(conditions, "id IN (SELECT resource_id FROM labels WHERE key = ? AND value = ?)")
+		args = append(args, key, value)
+	}
+	return s.nodes(strings.Join(conditions, " AND "), args...)
+}
+
+// node returns the stored resource with the given key, or nil
+func (s *ResourceStore) node(key ResourceKey) (*Node, error) {
+	nodes, err := s.nodes("kind = ? AND api_version = ? AND namespace = ? AND name = ?",
+		key.Kind, key.APIVersion, key.Namespace, key.Name)
+	if err != nil || len(nodes) == 0 {
+		return nil, err
+	}
+	return nodes[0], nil
+}
+
+// nodes returns the stored resources matching a condition, excluding
+// tombstones
+func (s *ResourceStore) nodes(where string, args ...interface{}) ([]*Node, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, namespace, kind, api_version, resource_version, data, uid
+		FROM resources
+		WHERE deleted_at IS NULL AND (`+where+`)
+		ORDER BY kind, name
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("graph query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var nodes []*Node
+	for rows.Next() {
+		n := &Node{}
+		r := &n.Resource
+		if err := rows.Scan(&r.ID, &r.Name, &r.Namespace, &r.Kind, &r.APIVersion, &r.ResourceVersion, &r.Data, &n.UID); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		nodes = append(nodes, n)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return nodes, nil
+}
+
+// sortNodes orders nodes by relation, kind and name
+func sortNodes(nodes []*Node) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := nodes[i], nodes[j]
+		if a.Relation != b.Relation {
+			return a.Relation < b.Relation
+		}
+		if a.Resource.Kind != b.Resource.Kind {
+			return a.Resource.Kind < b.Resource.Kind
+		}
+		return a.Resource.Name < b.Resource.Name
+	})
+}
+
+// reference is a named resource used by a pod spec
+type reference struct {
+	relation Relation
+	kind     string
+	name     string
+}
+
+// podSpec returns the pod spec of a Pod or of a workload's pod template
+func podSpec(kind string, obj map[string]interface{}) map[string]interface{} {
+	switch kind {
+	case "Pod":
+		spec, _ := nestedValue(obj, "spec").(map[string]interface{})
+		return spec
+	case "CronJob":
+		spec, _ := nestedValue(obj, "spec", "jobTemplate", "spec", "template", "spec").(map[string]interface{})
+		return spec
+	case "Deployment", "ReplicaSet", "StatefulSet", "DaemonSet", "Job", "ReplicationController":
+		spec, _ := nestedValue(obj, "spec", "template", "spec").(map[string]interface{})
+		return spec
+	}
+	return nil
+}
+
+// podSpecReferences lists the ConfigMaps, Secrets and claims a pod spec uses
+func podSpecReferences(spec map[string]interface{}) []reference {
+	var refs []reference
+	seen := map[reference]bool{}
+	add := func(relation Relation, kind string, name interface{}) {
+		ref := reference{relation: relation, kind: kind}
+		ref.name, _ = name.(string)
+		if ref.name != "" && !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+
+	for _, v := range asList(spec["volumes"]) {
+		volume, _ := v.(map[string]interface{})
+		add(RelationMounts, "ConfigMap", nestedValue(volume, "configMap", "name"))
+		add(RelationMounts, "Secret", nestedValue(volume, "secret", "secretName"))
+		add(RelationMounts, "PersistentVolumeClaim", nestedValue(volume, "persistentVolumeClaim", "claimName"))
+		for _, s := range asList(nestedValue(volume, "projected", "sources")) {
+			source, _ := s.(map[string]interface{})
+			add(RelationMounts, "ConfigMap", nestedValue(source, "configMap", "name"))
+			add(RelationMounts, "Secret", nestedValue(source, "secret", "name"))
+		}
+	}
+
+	for _, field := range []string{"initContainers", "containers", "ephemeralContainers"} {
+		for _, c := range asList(spec[field]) {
+			container, _ := c.(map[string]interface{})
+			for _, e := range asList(container["envFrom"]) {
+				envFrom, _ := e.(map[string]interface{})
+				add(RelationReferences, "ConfigMap", nestedValue(envFrom, "configMapRef", "name"))
+				add(RelationReferences, "Secret", nestedValue(envFrom, "secretRef", "name"))
+			}
+			for _, e := range asList(container["env"]) {
+				env, _ := e.(map[string]interface{})
+				add(RelationReferences, "ConfigMap", nestedValue(env, "valueFrom", "configMapKeyRef", "name"))
+				add(RelationReferences, "Secret", nestedValue(env, "valueFrom", "secretKeyRef", "name"))
+			}
+		}
+	}
+
+	return refs
+}
+
+// ingressBackends lists the Services an Ingress routes to
+func ingressBackends(obj map[string]interface{}) []string {
+	var names []string
+	seen := map[string]bool{}
+	addBackend := func(backend interface{}) {
+		b, _ := backend.(map[string]interface{})
+		name, _ := nestedValue(b, "service", "name").(string)
+		if name == "" {
+			// extensions/v1beta1 and networking.k8s.io/v1beta1
+			name, _ = b["serviceName"].(string)
+		}
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	addBackend(nestedValue(obj, "spec", "defaultBackend"))
+	addBackend(nestedValue(obj, "spec", "backend"))
+	for _, rule := range asList(nestedValue(obj, "spec", "rules")) {
+		r, _ := rule.(map[string]interface{})
+		for _, path := range asList(nestedValue(r, "http", "paths")) {
+			p, _ := path.(map[string]interface{})
+			addBackend(p["backend"])
+		}
+	}
+	return names
+}
+
+// nestedValue returns the value at a path of map keys, or nil
+func nestedValue(obj map[string]interface{}, path ...string) interface{} {
+	var current interface{} = obj
+	for _, key := range path {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[key]
+	}
+	return current
+}
+
+// asList returns v as a list, or nil
+func asList(v interface{}) []interface{} {
+	list, _ := v.([]interface{})
+	return list
+}
+
+// stringMap returns the string values of a map
+func stringMap(v interface{}) map[string]string {
+	m, _ := v.(map[string]interface{})
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		if s, ok := v.(string); ok {
+			result[k] = s
+		}
+	}
+	return result
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// storeGraph stores a Deployment with a ReplicaSet and two Pods, a Service
+// selecting the Pods, an Ingress routing to the Service and a ConfigMap
+func storeGraph(t *testing.T, store *ResourceStore) {
+	t.Helper()
+
+	pod := func(name string) Resource {
+		return Resource{Name: name, Namespace: "prod", Kind: "Pod", APIVersion: "v1", ResourceVersion: "1",
+			Data: fmt.Sprintf(`{"metadata":{"name":%q,"uid":"%s-uid","labels":{"app":"web"},
+				"ownerReferences":[{"kind":"ReplicaSet","name":"web-abc","uid":"rs-uid","controller":true}]},
+				"spec":{"volumes":[{"name":"config","configMap":{"name":"web-config"}}],
+				"containers":[{"name":"web","envFrom":[{"secretRef":{"name":"web-secret"}}]}]}}`, name, name)}
+	}
+
+	resources := []Resource{
+		{Name: "web", Namespace: "prod", Kind: "Deployment", APIVersion: "apps/v1", ResourceVersion: "1",
+			Data: `{"metadata":{"name":"web","uid":"deploy-uid"}}`},
+		{Name: "web-abc", Namespace: "prod", Kind: "ReplicaSet", APIVersion: "apps/v1", ResourceVersion: "1",
+			Data: `{"metadata":{"name":"web-abc","uid":"rs-uid",
+				"ownerReferences":[{"kind":"Deployment","name":"web","uid":"deploy-uid","controller":true}]}}`},
+		pod("web-abc-1"),
+		pod("web-abc-2"),
+		{Name: "web", Namespace: "prod", Kind: "Service", APIVersion: "v1", ResourceVersion: "1",
+			Data: `{"metadata":{"name":"web","uid":"svc-uid"},"spec":{"selector":{"app":"web"}}}`},
+		{Name: "web", Namespace: "prod", Kind: "Ingress", APIVersion: "networking.k8s.io/v1", ResourceVersion: "1",
+			Data: `{"metadata":{"name":"web","uid":"ing-uid"},"spec":{"rules":[{"http":{"paths":[
+				{"path":"/","backend":{"service":{"name":"web","port":{"number":80}}}}]}}]}}`},
+		{Name: "web-config", Namespace: "prod", Kind: "ConfigMap", APIVersion: "v1", ResourceVersion: "1",
+			Data: `{"metadata":{"name":"web-config","uid":"cm-uid"}}`},
+	}
+	for _, r := range resources {
+		if err := store.Upsert(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// describe renders a tree as indented "relation Kind/name" lines
+func describe(n *Node, depth int, b *strings.Builder) {
+	fmt.Fprintf(b, "%s%s %s/%s", strings.Repeat("  ", depth), n.Relation, n.Resource.Kind, n.Resource.Name)
+	if n.Missing {
+		b.WriteString(" (missing)")
+	}
+	b.WriteString("\n")
+	for _, child := range n.Children {
+		describe(child, depth+1, b)
+	}
+}
+
+func TestOwners(t *testing.T) {
+	store := newTestStore(t)
+	storeGraph(t, store)
+
+	owners, err := store.Owners(Resource{Name: "web-abc-1", Namespace: "prod", Kind: "Pod", APIVersion: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var chain []string
+	for _, n := range owners {
+		chain = append(chain, n.Resource.Kind+"/"+n.Resource.Name)
+	}
+	if got := strings.Join(chain, " -> "); got != "ReplicaSet/web-abc -> Deployment/web" {
+		t.Fatalf("unexpected owner chain %s", got)
+	}
+}
+
+func TestTree(t *testing.T) {
+	store := newTestStore(t)
+	storeGraph(t, store)
+
+	root, err := store.Tree(Resource{Name: "web-abc-2", Namespace: "prod", Kind: "Pod", APIVersion: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	describe(root, 0, &b)
+	want := ` Deployment/web
+  owns ReplicaSet/web-abc
+    owns Pod/web-abc-1
+      mounts ConfigMap/web-config
+      references Secret/web-secret (missing)
+    owns Pod/web-abc-2
+      mounts ConfigMap/web-config
+      references Secret/web-secret (missing)
+`
+	if b.String() != want {
+		t.Fatalf("unexpected tree:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestRelated(t *testing.T) {
+	store := newTestStore(t)
+	storeGraph(t, store)
+
+	tests := []struct {
+		resource Resource
+		want     string
+	}{
+		{Resource{Name: "web", Namespace: "prod", Kind: "Service", APIVersion: "v1"}, "selects Pod/web-abc-1, selects Pod/web-abc-2"},
+		{Resource{Name: "web", Namespace: "prod", Kind: "Ingress", APIVersion: "networking.k8s.io/v1"}, "routes Service/web"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.resource.Kind, func(t *testing.T) {
+			stored, err := store.node(tt.resource.Key())
+			if err != nil || stored == nil {
+				t.Fatalf("resource not found: %v", err)
+			}
+			related, err := store.Related(stored.Resource)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, n := range related {
+				got = append(got, fmt.Sprintf("%s %s/%s", n.Relation, n.Resource.Kind, n.Resource.Name))
+			}
+			if strings.Join(got, ", ") != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, strings.Join(got, ", "))
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/health"
+)
+
+// evaluateHealth evaluates the health of the resources stored before the
+// health was recorded; Upsert and Delete keep it up to date afterwards
+func (s *ResourceStore) evaluateHealth() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, kind, api_version, data, deleted_at IS NOT NULL FROM resources WHERE health = ''")
+	if err != nil {
+		return fmt.Errorf("failed to read resources: %v", err)
+	}
+
+	type evaluated struct {
+		id     int64
+		health health.Health
+	}
+	var resources []evaluated
+	for rows.Next() {
+		var r Resource
+		var deleted bool
+		if err := rows.Scan(&r.ID, &r.Kind, &r.APIVersion, &r.Data, &deleted); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan row: %v", err)
+		}
+		h := health.Deleted
+		if !deleted {
+			h = health.EvaluateJSON(r.Kind, r.APIVersion, r.Data)
+		}
+		resources = append(resources, evaluated{r.ID, h})
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	for _, r := range resources {
+		if _, err := tx.Exec("UPDATE resources SET health = ?, health_reason = ? WHERE id = ?", r.health.Status, r.health.Reason, r.id); err != nil {
+			return fmt.Errorf("failed to store health: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit health: %v", err)
+	}
+
+	if len(resources) > 0 {
+		log.Printf("Evaluated the health of %d stored resources", len(resources))
+	}
+	return nil
+}
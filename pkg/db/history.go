@@ -0,0 +1,255 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Event types recorded in the history
+const (
+	EventAdded    = "ADDED"
+	EventModified = "MODIFIED"
+	EventDeleted  = "DELETED"
+)
+
+// Encodings of the data column of the events table
+const (
+	encodingFull  = "full"
+	encodingPatch = "patch"
+)
+
+// snapshotInterval is the number of patches stored between full copies of an
+// object, bounding the work needed to reconstruct a version
+const snapshotInterval = 10
+
+// ResourceKey identifies a resource across its versions
+type ResourceKey struct {
+	Kind       string `json:"kind"`
+	APIVersion string `json:"apiVersion"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+}
+
+// Key returns the key identifying the resource
+func (r Resource) Key() ResourceKey {
+	return ResourceKey{
+		Kind:       r.Kind,
+		APIVersion: r.APIVersion,
+		Namespace:  r.Namespace,
+		Name:       r.Name,
+	}
+}
+
+// Event is a recorded change to a resource
+type Event struct {
+	ID                      int64       `json:"-"`
+	Key                     ResourceKey `json:"key"`
+	Type                    string      `json:"type"`
+	ResourceVersion         string      `json:"resourceVersion"`
+	PreviousResourceVersion string      `json:"previousResourceVersion"`
+	RecordedAt              time.Time   `json:"recordedAt"`
+	// Data is the full object after the event, or its final state for deletions
+	Data string `json:"data"`
+}
+
+// recordEvent appends an event to the history. Modifications are stored as
+// merge patches against the previous version, with a full copy every
+// snapshotInterval versions and whenever a change can't be expressed as a
+// merge patch.
+func recordEvent(tx *sql.Tx, key ResourceKey, eventType, resourceVersion, previousResourceVersion, data, previousData string, at time.Time) error {
+	encoding := encodingFull
+	stored := data
+
+	if eventType == EventModified && previousData != "" {
+		// Patches need a full copy to apply to, which resources stored
+		// before history was recorded don't have
+		var lastFull sql.NullInt64
+		err := tx.QueryRow(`
+			SELECT MAX(id) FROM events
+			WHERE kind = ? AND api_version = ? AND namespace = ? AND name = ? AND encoding = ?
+		`, key.Kind, key.APIVersion, key.Namespace, key.Name, encodingFull).Scan(&lastFull)
+		if err != nil {
+			return fmt.Errorf("failed to find last full version: %v", err)
+		}
+
+		var patches int
+		if lastFull.Valid {
+			err = tx.QueryRow(`
+				SELECT COUNT(*) FROM events
+				WHERE kind = ? AND api_version = ? AND namespace = ? AND name = ? AND id > ?
+			`, key.Kind, key.APIVersion, key.Namespace, key.Name, lastFull.Int64).Scan(&patches)
+			if err != nil {
+				return fmt.Errorf("failed to count patches: %v", err)
+			}
+		}
+
+		if lastFull.Valid && patches < snapshotInterval {
+			patch, err := diffObjects(previousData, data)
+			if err == nil {
+				encoding = encodingPatch
+				stored = patch
+			}
+		}
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO events (kind, api_version, namespace, name, type, resource_version,
+			previous_resource_version, recorded_at, encoding, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, key.Kind, key.APIVersion, key.Namespace, key.Name, eventType, resourceVersion,
+		previousResourceVersion, at.UnixNano(), encoding, stored)
+	if err != nil {
+		return fmt.Errorf("failed to record event: %v", err)
+	}
+
+	return nil
+}
+
+// diffObjects returns the merge patch between two JSON objects. It fails
+// when the change sets a field to null, which a merge patch would remove.
+func diffObjects(previousData, data string) (string, error) {
+	previous, err := decodeObject(previousData)
+	if err != nil {
+		return "", err
+	}
+	current, err := decodeObject(data)
+	if err != nil {
+		return "", err
+	}
+
+	merge, ok := createMergePatch(previous, current)
+	if !ok {
+		return "", fmt.Errorf("the change sets a field to null")
+	}
+	patch, err := json.Marshal(merge)
+	if err != nil {
+		return "", err
+	}
+	return string(patch), nil
+}
+
+// History returns every recorded event of a resource, oldest first,
+// with the full object state of each version
+func (s *ResourceStore) History(key ResourceKey) ([]Event, error) {
+	rows, err := s.db.Query(`
+		SELECT id, type, resource_version, previous_resource_version, recorded_at, encoding, data
+		FROM events
+		WHERE kind = ? AND api_version = ? AND namespace = ? AND name = ?
+		ORDER BY id
+	`, key.Kind, key.APIVersion, key.Namespace, key.Name)
+	if err != nil {
+		return nil, fmt.Errorf("history query failed: %v", err)
+	}
+	defer rows.Close()
+
+	return scanEvents(rows, key)
+}
+
+// StateAt returns the resource as it was at the given time, or nil if it
+// didn't exist then
+func (s *ResourceStore) StateAt(key ResourceKey, at time.Time) (*Resource, error) {
+	// Replay from the last full copy recorded before the requested time
+	rows, err := s.db.Query(`
+		SELECT id, type, resource_version, previous_resource_version, recorded_at, encoding, data
+		FROM events
+		WHERE kind = ? AND api_version = ? AND namespace = ? AND name = ?
+		AND recorded_at <= ?
+		AND id >= COALESCE((
+			SELECT MAX(id) FROM events
+			WHERE kind = ? AND api_version = ? AND namespace = ? AND name = ?
+			AND recorded_at <= ? AND encoding = ?
+		), 0)
+		ORDER BY id
+	`, key.Kind, key.APIVersion, key.Namespace, key.Name, at.UnixNano(),
+		key.Kind, key.APIVersion, key.Namespace, key.Name, at.UnixNano(), encodingFull)
+	if err != nil {
+		return nil, fmt.Errorf("state query failed: %v", err)
+	}
+	defer rows.Close()
+
+	events, err := scanEvents(rows, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	last := events[len(events)-1]
+	if last.Type == EventDeleted {
+		return nil, nil
+	}
+
+	return &Resource{
+		Name:            key.Name,
+		Namespace:       key.Namespace,
+		Kind:            key.Kind,
+		APIVersion:      key.APIVersion,
+		ResourceVersion: last.ResourceVersion,
+		Data:            last.Data,
+	}, nil
+}
+
+// scanEvents reads event rows in order and reconstructs full object data
+// by applying patches to the preceding version
+func scanEvents(rows *sql.Rows, key ResourceKey) ([]Event, error) {
+	var events []Event
+	var replay eventReplay
+
+	for rows.Next() {
+		var e Event
+		var recordedAt int64
+		var encoding, data string
+		if err := rows.Scan(&e.ID, &e.Type, &e.ResourceVersion, &e.PreviousResourceVersion, &recordedAt, &encoding, &data); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+
+		e.Key = key
+		e.RecordedAt = time.Unix(0, recordedAt)
+		if err := replay.apply(&e, encoding, data); err != nil {
+			return nil, err
+		}
+
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return events, nil
+}
+
+// eventReplay rebuilds the versions of one resource from consecutive events
+type eventReplay struct {
+	current interface{}
+	last    *Event
+}
+
+// apply sets the full object data of the next event from its stored form
+func (r *eventReplay) apply(e *Event, encoding, data string) error {
+	if encoding == encodingPatch {
+		var patch interface{}
+		if err := decodeJSON(data, &patch); err != nil {
+			return fmt.Errorf("failed to decode patch: %v", err)
+		}
+		r.current = applyMergePatch(r.current, patch)
+
+		full, err := json.Marshal(r.current)
+		if err != nil {
+			return fmt.Errorf("failed to encode object: %v", err)
+		}
+		e.Data = string(full)
+	} else {
+		if err := decodeJSON(data, &r.current); err != nil {
+			return fmt.Errorf("failed to decode object: %v", err)
+		}
+		e.Data = data
+	}
+
+	r.last = e
+	return nil
+}
@@ -0,0 +1,245 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// sameJSON reports whether two JSON documents hold the same value
+func sameJSON(t *testing.T, a, b string) bool {
+	t.Helper()
+	var va, vb interface{}
+	if err := decodeJSON(a, &va); err != nil {
+		t.Fatalf("invalid JSON %s: %v", a, err)
+	}
+	if err := decodeJSON(b, &vb); err != nil {
+		t.Fatalf("invalid JSON %s: %v", b, err)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// encodings returns the encoding of every recorded event of a resource
+func encodings(t *testing.T, store *ResourceStore, key ResourceKey) []string {
+	t.Helper()
+	rows, err := store.db.Query(`
+		SELECT encoding FROM events
+		WHERE kind = ? AND api_version = ? AND namespace = ? AND name = ?
+		ORDER BY id
+	`, key.Kind, key.APIVersion, key.Namespace, key.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var encoding string
+		if err := rows.Scan(&encoding); err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, encoding)
+	}
+	return result
+}
+
+func TestHistoryRoundTrip(t *testing.T) {
+	store := newTestStore(t)
+
+	versions := []string{
+		`{"metadata":{"name":"web","labels":{"app":"web"}},"spec":{"replicas":1,"ports":[80]}}`,
+		// Added, changed and removed fields
+		`{"metadata":{"name":"web","labels":{"app":"web","tier":"frontend"}},"spec":{"replicas":2,"ports":[80]}}`,
+		`{"metadata":{"name":"web","labels":{"tier":"frontend"}},"spec":{"replicas":2,"ports":[80,443]}}`,
+		// Integers beyond float64 precision and text that needs escaping
+		`{"metadata":{"name":"web","generation":9007199254740993},"spec":{"replicas":2,"note":"naïve \"quoted\"\n"}}`,
+		// A field set to null, which a merge patch would remove
+		`{"metadata":{"name":"web","generation":9007199254740993},"spec":{"replicas":2,"note":null}}`,
+		// An object with a null field replacing a scalar
+		`{"metadata":{"name":"web","generation":9007199254740993},"spec":{"replicas":2,"note":{"text":null}}}`,
+		// Arrays are replaced as a whole, keeping their nulls
+		`{"metadata":{"name":"web"},"spec":{"replicas":3,"args":["a",null]}}`,
+		// A type change of a nested field
+		`{"metadata":{"name":"web"},"spec":{"replicas":"3","args":{"a":"b"}}}`,
+	}
+	// Cross the snapshot interval so the history mixes full copies and patches
+	for i := 0; i < snapshotInterval+3; i++ {
+		versions = append(versions, fmt.Sprintf(`{"metadata":{"name":"web"},"spec":{"replicas":%d}}`, 10+i))
+	}
+
+	r := Resource{Name: "web", Namespace: "prod", Kind: "Deployment", APIVersion: "apps/v1"}
+	for i, data := range versions {
+		r.ResourceVersion = fmt.Sprint(i + 1)
+		r.Data = data
+		if err := store.Upsert(r); err != nil {
+			t.Fatalf("Upsert(version %d): %v", i+1, err)
+		}
+	}
+
+	history, err := store.History(r.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != len(versions) {
+		t.Fatalf("History() has %d versions, want %d", len(history), len(versions))
+	}
+	for i, e := range history {
+		if !sameJSON(t, e.Data, versions[i]) {
+			t.Errorf("version %d = %s, want %s", i+1, e.Data, versions[i])
+		}
+		if e.ResourceVersion != fmt.Sprint(i+1) {
+			t.Errorf("version %d has resource version %s", i+1, e.ResourceVersion)
+		}
+
+		// Every version can be reconstructed at the time it was recorded
+		state, err := store.StateAt(r.Key(), e.RecordedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i+1 < len(history) && history[i+1].RecordedAt.Equal(e.RecordedAt) {
+			// A later version recorded at the same instant wins
+			continue
+		}
+		if state == nil || !sameJSON(t, state.Data, versions[i]) {
+			t.Errorf("StateAt(version %d) = %+v, want %s", i+1, state, versions[i])
+		}
+	}
+
+	got := encodings(t, store, r.Key())
+	for _, i := range []int{4, 5} {
+		if got[i] != encodingFull {
+			t.Errorf("version %d sets a field to null but is stored as a %s", i+1, got[i])
+		}
+	}
+	if got[1] != encodingPatch || got[len(got)-1] != encodingPatch {
+		t.Errorf("encodings = %v, want modifications stored as patches", got)
+	}
+	patches := 0
+	for _, encoding := range got {
+		if encoding == encodingPatch {
+			patches++
+			if patches > snapshotInterval {
+				t.Fatalf("encodings = %v, want a full copy every %d patches", got, snapshotInterval)
+			}
+		} else {
+			patches = 0
+		}
+	}
+}
+
+func TestHistoryAfterDeletion(t *testing.T) {
+	store := newTestStore(t)
+
+	r := Resource{Name: "settings", Namespace: "default", Kind: "ConfigMap", APIVersion: "v1",
+		ResourceVersion: "1", Data: `{"metadata":{"name":"settings"},"data":{"a":"1"}}`}
+	if err := store.Upsert(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(r.Kind, r.APIVersion, r.Namespace, r.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	// The re-created resource starts over from a full copy, not a patch
+	// against the deleted one
+	r.ResourceVersion = "5"
+	r.Data = `{"metadata":{"name":"settings"},"data":{"b":"2"}}`
+	if err := store.Upsert(r); err != nil {
+		t.Fatal(err)
+	}
+	r.ResourceVersion = "6"
+	r.Data = `{"metadata":{"name":"settings"},"data":{"b":"3"}}`
+	if err := store.Upsert(r); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := store.History(r.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, e := range history {
+		types = append(types, e.Type)
+	}
+	if want := []string{EventAdded, EventDeleted, EventAdded, EventModified}; !reflect.DeepEqual(types, want) {
+		t.Fatalf("History() types = %v, want %v", types, want)
+	}
+	if !sameJSON(t, history[1].Data, `{"metadata":{"name":"settings"},"data":{"a":"1"}}`) {
+		t.Errorf("deletion = %s, want the final state", history[1].Data)
+	}
+	if !sameJSON(t, history[3].Data, r.Data) {
+		t.Errorf("latest version = %s, want %s", history[3].Data, r.Data)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		modified string
+		patch    string
+		ok       bool
+	}{
+		{
+			name:     "unchanged",
+			original: `{"a":1,"b":{"c":[1,2]}}`,
+			modified: `{"a":1,"b":{"c":[1,2]}}`,
+			patch:    `{}`,
+			ok:       true,
+		},
+		{
+			name:     "nested changes and removals",
+			original: `{"a":1,"b":{"c":"x","d":"y"},"e":true}`,
+			modified: `{"a":2,"b":{"c":"x","f":"z"}}`,
+			patch:    `{"a":2,"b":{"d":null,"f":"z"},"e":null}`,
+			ok:       true,
+		},
+		{
+			name:     "array elements",
+			original: `{"a":[{"b":1}]}`,
+			modified: `{"a":[{"b":1},{"b":null}]}`,
+			patch:    `{"a":[{"b":1},{"b":null}]}`,
+			ok:       true,
+		},
+		{
+			name:     "unchanged null",
+			original: `{"a":null,"b":1}`,
+			modified: `{"a":null,"b":2}`,
+			patch:    `{"b":2}`,
+			ok:       true,
+		},
+		{name: "changed to null", original: `{"a":1}`, modified: `{"a":null}`},
+		{name: "added null", original: `{}`, modified: `{"a":null}`},
+		{name: "added object with a null", original: `{"a":{}}`, modified: `{"a":{"b":{"c":null}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original, err := decodeObject(tt.original)
+			if err != nil {
+				t.Fatal(err)
+			}
+			modified, err := decodeObject(tt.modified)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			patch, ok := createMergePatch(original, modified)
+			if ok != tt.ok {
+				t.Fatalf("createMergePatch() ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			want, err := decodeObject(tt.patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(patch, want) {
+				t.Errorf("createMergePatch() = %v, want %v", patch, want)
+			}
+			if applied := applyMergePatch(original, patch); !reflect.DeepEqual(applied, modified) {
+				t.Errorf("applyMergePatch() = %v, want %v", applied, modified)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Change is a change to a resource read from a saved event stream or dump
+type Change struct {
+	// Type is EventAdded, EventModified or EventDeleted
+	Type     string
+	Resource Resource
+	// Time is when the change was seen; the zero time means now
+	Time time.Time
+}
+
+// Import writes changes in a single transaction, recording each at its own
+// time so the history of a saved stream can be browsed as it happened.
+// Changes should be in order and imported into a store without newer
+// history of the same resources. Changes that fail are skipped and their
+// errors returned together.
+func (s *ResourceStore) Import(changes []Change) error {
+	now := time.Now()
+
+	var failures []error
+	err := s.write(func(tx *sql.Tx) error {
+		for _, c := range changes {
+			at := c.Time
+			if at.IsZero() {
+				at = now
+			}
+			key := c.Resource.Key()
+
+			if c.Type == EventDeleted {
+				// Streams that start after a resource was created still
+				// carry its final state, which is kept as the tombstone
+				previous, err := currentVersion(tx, key)
+				if err != nil {
+					return err
+				}
+				if previous == nil && c.Resource.Data != "" {
+					resource := c.Resource
+					if err := writeOp(tx, s, batchOp{resource: &resource, key: key, at: at}); err != nil {
+						failures = append(failures, err)
+						continue
+					}
+				}
+				if err := writeOp(tx, s, batchOp{key: key, at: at}); err != nil {
+					failures = append(failures, err)
+				}
+				continue
+			}
+
+			resource := c.Resource
+			if err := writeOp(tx, s, batchOp{resource: &resource, key: key, at: at}); err != nil {
+				failures = append(failures, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return errors.Join(failures...)
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestImportKeepsEventTimes(t *testing.T) {
+	store := newTestStore(t)
+	start := time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC)
+
+	config := func(version string) Resource {
+		return Resource{
+			Name: "settings", Namespace: "default", Kind: "ConfigMap", APIVersion: "v1", ResourceVersion: version,
+			Data: `{"metadata":{"name":"settings"},"data":{"version":"` + version + `"}}`,
+		}
+	}
+	// Only the deletion of this one is in the stream
+	gone := Resource{
+		Name: "old", Namespace: "default", Kind: "ConfigMap", APIVersion: "v1", ResourceVersion: "7",
+		Data: `{"metadata":{"name":"old"}}`,
+	}
+
+	err := store.Import([]Change{
+		{Type: EventAdded, Resource: config("1"), Time: start},
+		{Type: EventModified, Resource: config("2"), Time: start.Add(time.Minute)},
+		{Type: EventDeleted, Resource: gone, Time: start.Add(2 * time.Minute)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := store.History(config("1").Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || !events[0].RecordedAt.Equal(start) || !events[1].RecordedAt.Equal(start.Add(time.Minute)) {
+		t.Fatalf("History() = %+v, want versions recorded at 14:00 and 14:01", events)
+	}
+
+	state, err := store.StateAt(config("1").Key(), start.Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state == nil || state.ResourceVersion != "1" {
+		t.Errorf("StateAt(14:00:30) = %+v, want version 1", state)
+	}
+
+	// The deleted resource is kept as a tombstone
+	if n := countRows(t, store, "SELECT COUNT(*) FROM resources WHERE name = 'old' AND deleted_at IS NOT NULL"); n != 1 {
+		t.Errorf("expected a tombstone for the deleted resource, got %d rows", n)
+	}
+	events, err = store.History(gone.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1].Type != EventDeleted || !events[1].RecordedAt.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("History(old) = %+v, want an addition and a deletion at 14:02", events)
+	}
+}
@@ -0,0 +1,326 @@
+// Package memory provides an in-memory resource store for tests and
+// short-lived sessions
+package memory
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/events"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/health"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/query"
+)
+
+// resultLimit matches the number of results returned by the SQL stores
+const resultLimit = 100
+
+// Store keeps resources and their full history in memory
+type Store struct {
+	mu     sync.RWMutex
+	nextID int64
+	// resources holds the current version of every resource, and tombstones
+	resources map[db.ResourceKey]db.Resource
+	events    map[db.ResourceKey][]db.Event
+}
+
+var (
+	_ db.Store      = (*Store)(nil)
+	_ db.EventStore = (*Store)(nil)
+)
+
+// New creates an empty store
+func New() *Store {
+	return &Store{
+		resources: make(map[db.ResourceKey]db.Resource),
+		events:    make(map[db.ResourceKey][]db.Event),
+	}
+}
+
+// Upsert adds or updates a resource and records the change
+func (s *Store) Upsert(resource db.Resource) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := resource.Key()
+	previous, exists := s.resources[key]
+
+	// Relists deliver objects we already have, which aren't changes
+	if exists && !previous.Deleted() &&
+		previous.ResourceVersion == resource.ResourceVersion && previous.Data == resource.Data {
+		return nil
+	}
+
+	event := db.Event{Key: key, Type: db.EventAdded, ResourceVersion: resource.ResourceVersion, Data: resource.Data}
+	resource.DeletedAt = time.Time{}
+	h := health.EvaluateJSON(resource.Kind, resource.APIVersion, resource.Data)
+	resource.Health, resource.HealthReason = h.Status, h.Reason
+	if exists {
+		resource.ID = previous.ID
+		// A resource created again after its deletion starts a new history
+		if !previous.Deleted() {
+			event.Type = db.EventModified
+			event.PreviousResourceVersion = previous.ResourceVersion
+		}
+	} else {
+		s.nextID++
+		resource.ID = s.nextID
+	}
+
+	s.resources[key] = resource
+	s.record(event)
+	return nil
+}
+
+// Delete turns a resource into a tombstone and records its final state
+func (s *Store) Delete(kind, apiVersion, namespace, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := db.ResourceKey{Kind: kind, APIVersion: apiVersion, Namespace: namespace, Name: name}
+	previous, exists := s.resources[key]
+	if !exists || previous.Deleted() {
+		return nil
+	}
+
+	tombstone := previous
+	tombstone.DeletedAt = time.Now()
+	tombstone.Health, tombstone.HealthReason = health.Deleted.Status, health.Deleted.Reason
+	s.resources[key] = tombstone
+	s.record(db.Event{
+		Key:                     key,
+		Type:                    db.EventDeleted,
+		ResourceVersion:         previous.ResourceVersion,
+		PreviousResourceVersion: previous.ResourceVersion,
+		Data:                    previous.Data,
+	})
+	return nil
+}
+
+// PurgeType removes every resource of a type with its tombstones and
+// history, in a namespace or in all namespaces when it is empty
+func (s *Store) PurgeType(kind, apiVersion, namespace string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matches := func(key db.ResourceKey) bool {
+		return key.Kind == kind && key.APIVersion == apiVersion && (namespace == "" || key.Namespace == namespace)
+	}
+
+	purged := 0
+	for key := range s.resources {
+		if matches(key) {
+			delete(s.resources, key)
+			purged++
+		}
+	}
+	for key := range s.events {
+		if matches(key) {
+			delete(s.events, key)
+		}
+	}
+	return purged, nil
+}
+
+// record appends an event to the history of its resource
+func (s *Store) record(e db.Event) {
+	s.nextID++
+	e.ID = s.nextID
+	e.RecordedAt = time.Now()
+	s.events[e.Key] = append(s.events[e.Key], e)
+}
+
+// Search matches a substring against name, namespace and kind, ignoring
+// case, excluding tombstones
+func (s *Store) Search(q string) ([]db.Resource, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	needle := strings.ToLower(q)
+	var resources []db.Resource
+	for _, r := range s.resources {
+		if r.Deleted() {
+			continue
+		}
+		if needle == "" || strings.Contains(strings.ToLower(r.Name), needle) ||
+			strings.Contains(strings.ToLower(r.Namespace), needle) || strings.Contains(strings.ToLower(r.Kind), needle) {
+			resources = append(resources, r)
+		}
+	}
+	return sortAndLimit(resources), nil
+}
+
+// ResourceCount returns the number of stored resources, excluding tombstones
+func (s *Store) ResourceCount() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, r := range s.resources {
+		if !r.Deleted() {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// History returns the recorded versions of a resource, oldest first
+func (s *Store) History(key db.ResourceKey) ([]db.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]db.Event(nil), s.events[key]...), nil
+}
+
+// StateAt returns a resource as it was at the given time, or nil if it
+// didn't exist then
+func (s *Store) StateAt(key db.ResourceKey, at time.Time) (*db.Resource, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var last *db.Event
+	for i, e := range s.events[key] {
+		if e.RecordedAt.After(at) {
+			break
+		}
+		last = &s.events[key][i]
+	}
+	if last == nil || last.Type == db.EventDeleted {
+		return nil, nil
+	}
+
+	return &db.Resource{
+		Name:            key.Name,
+		Namespace:       key.Namespace,
+		Kind:            key.Kind,
+		APIVersion:      key.APIVersion,
+		ResourceVersion: last.ResourceVersion,
+		Data:            last.Data,
+	}, nil
+}
+
+// Query returns the resources matching a parsed query
+func (s *Store) Query(q *query.Query) ([]db.Resource, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	var resources []db.Resource
+	for _, r := range s.resources {
+		record := query.Record{
+			Name:       r.Name,
+			Namespace:  r.Namespace,
+			Kind:       r.Kind,
+			APIVersion: r.APIVersion,
+			Object:     decodeObject(r.Data),
+			Data:       r.Data,
+			Deleted:    r.Deleted(),
+			Health:     string(r.Health),
+		}
+		if q.Match(record, now) {
+			resources = append(resources, r)
+		}
+	}
+	return sortAndLimit(resources), nil
+}
+
+// QueryValues returns the stored values of a query key, for completion
+func (s *Store) QueryValues(key string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := map[string]bool{}
+	for _, r := range s.resources {
+		switch key {
+		case query.KeyKind:
+			seen[r.Kind] = true
+		case query.KeyNamespace:
+			if r.Namespace != "" {
+				seen[r.Namespace] = true
+			}
+		case query.KeyAPIVersion:
+			seen[r.APIVersion] = true
+		case query.KeyLabel:
+			metadata, _ := decodeObject(r.Data)["metadata"].(map[string]interface{})
+			labels, _ := metadata["labels"].(map[string]interface{})
+			for k := range labels {
+				seen[k] = true
+			}
+		}
+	}
+
+	values := make([]string, 0, len(seen))
+	for v := range seen {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+// Close does nothing; the store lives as long as it is referenced
+func (s *Store) Close() error {
+	return nil
+}
+
+// ObjectEvents returns the stored Kubernetes Events about a resource, of
+// both APIs, tombstones included
+func (s *Store) ObjectEvents(r db.Resource) ([]db.Resource, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stored, ok := s.resources[r.Key()]
+	if !ok {
+		return nil, nil
+	}
+	metadata, _ := decodeObject(stored.Data)["metadata"].(map[string]interface{})
+	uid, _ := metadata["uid"].(string)
+	if uid == "" {
+		return nil, nil
+	}
+
+	var matches []db.Resource
+	for key, resource := range s.resources {
+		if !events.IsEvent(key.Kind, key.APIVersion) {
+			continue
+		}
+		if e, ok := events.Parse(resource.Data); ok && e.InvolvedUID == uid {
+			matches = append(matches, resource)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].ID < matches[j].ID })
+	return matches, nil
+}
+
+// sortAndLimit orders resources by namespace, kind and name like the SQL
+// stores and truncates them to resultLimit
+func sortAndLimit(resources []db.Resource) []db.Resource {
+	sort.Slice(resources, func(i, j int) bool {
+		a, b := resources[i], resources[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	if len(resources) > resultLimit {
+		resources = resources[:resultLimit]
+	}
+	return resources
+}
+
+// decodeObject decodes resource data, keeping numbers exact; invalid data
+// decodes to an empty object
+func decodeObject(data string) map[string]interface{} {
+	var obj map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(data)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&obj); err != nil {
+		return map[string]interface{}{}
+	}
+	return obj
+}
@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db/storetest"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) db.Store {
+		return New()
+	})
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// objectData guards JSON functions against data that isn't valid JSON; it
+// takes the data as two arguments
+const objectData = "CASE WHEN json_valid(?) THEN ? ELSE '{}' END"
+
+// isEvent matches the Kubernetes Events of both APIs
+const isEvent = "kind = 'Event' AND (api_version = 'v1' OR api_version LIKE 'events.k8s.io/%')"
+
+// indexMetadata replaces the uid, labels, annotations and owner references
+// stored for a resource with those of its data, and for Events the uid of
+// the object they are about
+func indexMetadata(tx *sql.Tx, id int64, data string) error {
+	_, err := tx.Exec(`
+		UPDATE resources SET uid = COALESCE(json_extract(`+objectData+`, '$.metadata.uid'), ''),
+			involved_uid = CASE WHEN `+isEvent+` THEN COALESCE(json_extract(`+objectData+`, '$.regarding.uid'),
+				json_extract(`+objectData+`, '$.involvedObject.uid'), '') ELSE '' END
+		WHERE id = ?
+	`, data, data, data, data, data, data, id)
+	if err != nil {
+		return fmt.Errorf("failed to index uid: %v", err)
+	}
+
+	for _, table := range []string{"labels", "annotations", "owner_refs"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE resource_id = ?", id); err != nil {
+			return fmt.Errorf("failed to clear %s: %v", table, err)
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO labels (resource_id, key, value)
+		SELECT ?, key, CAST(value AS TEXT) FROM json_each(`+objectData+`, '$.metadata.labels')
+	`, id, data, data)
+	if err != nil {
+		return fmt.Errorf("failed to index labels: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO annotations (resource_id, key, value)
+		SELECT ?, key, CAST(value AS TEXT) FROM json_each(`+objectData+`, '$.metadata.annotations')
+	`, id, data, data)
+	if err != nil {
+		return fmt.Errorf("failed to index annotations: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO owner_refs (resource_id, owner_uid, owner_kind, owner_name, controller)
+		SELECT ?, COALESCE(json_extract(value, '$.uid'), ''), COALESCE(json_extract(value, '$.kind'), ''),
+			COALESCE(json_extract(value, '$.name'), ''), COALESCE(json_extract(value, '$.controller'), 0)
+		FROM json_each(`+objectData+`, '$.metadata.ownerReferences')
+	`, id, data, data)
+	if err != nil {
+		return fmt.Errorf("failed to index owner references: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+)
+
+const labeledPod = `{"metadata":{"name":"web-1","uid":"pod-uid","labels":{"app":"web","tier":"frontend"},
+	"annotations":{"note":"x"},"ownerReferences":[{"kind":"ReplicaSet","name":"web-abc","uid":"rs-uid","controller":true}]}}`
+
+func countRows(t *testing.T, store *ResourceStore, query string, args ...interface{}) int {
+	t.Helper()
+	var count int
+	if err := store.db.QueryRow(query, args...).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestMetadataTablesFollowWrites(t *testing.T) {
+	store := newTestStore(t)
+
+	pod := Resource{Name: "web-1", Namespace: "prod", Kind: "Pod", APIVersion: "v1", ResourceVersion: "1", Data: labeledPod}
+	if err := store.Upsert(pod); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := countRows(t, store, "SELECT COUNT(*) FROM labels"); n != 2 {
+		t.Fatalf("expected 2 labels, got %d", n)
+	}
+	if n := countRows(t, store, "SELECT COUNT(*) FROM annotations"); n != 1 {
+		t.Fatalf("expected 1 annotation, got %d", n)
+	}
+	if n := countRows(t, store, "SELECT COUNT(*) FROM owner_refs WHERE owner_uid = 'rs-uid' AND controller = 1"); n != 1 {
+		t.Fatalf("expected the controller owner reference, got %d", n)
+	}
+	if n := countRows(t, store, "SELECT COUNT(*) FROM resources WHERE uid = 'pod-uid'"); n != 1 {
+		t.Fatalf("expected the uid to be stored, got %d", n)
+	}
+
+	// Updates replace the previous labels
+	pod.ResourceVersion, pod.Data = "2", `{"metadata":{"name":"web-1","uid":"pod-uid","labels":{"app":"web"}}}`
+	if err := store.Upsert(pod); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, store, "SELECT COUNT(*) FROM labels"); n != 1 {
+		t.Fatalf("expected 1 label after the update, got %d", n)
+	}
+	if n := countRows(t, store, "SELECT COUNT(*) FROM owner_refs"); n != 0 {
+		t.Fatalf("expected no owner references after the update, got %d", n)
+	}
+
+	// Tombstones keep their metadata, purging them cascades
+	if err := store.Delete(pod.Kind, pod.APIVersion, pod.Namespace, pod.Name); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, store, "SELECT COUNT(*) FROM labels"); n != 1 {
+		t.Fatalf("expected the tombstone to keep its label, got %d", n)
+	}
+	err := store.write(func(tx *sql.Tx) error {
+		current, err := currentVersion(tx, pod.Key())
+		if err != nil {
+			return err
+		}
+		return store.purge(tx, current.ID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, store, "SELECT COUNT(*) FROM labels"); n != 0 {
+		t.Fatalf("expected labels to be deleted with the resource, got %d", n)
+	}
+}
+
+func TestMetadataBackfill(t *testing.T) {
+	path := newFixture(t, 3, true)
+
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Exec(`
+		INSERT INTO resources (name, namespace, kind, api_version, resource_version, data)
+		VALUES ('web-1', 'prod', 'Pod', 'v1', '1', ?), ('broken', 'prod', 'Pod', 'v1', '1', 'not json')
+	`, labeledPod)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := New(path)
+	if err != nil {
+		t.Fatalf("upgrade failed: %v", err)
+	}
+	defer store.Close()
+
+	if n := countRows(t, store, "SELECT COUNT(*) FROM labels WHERE key = 'app' AND value = 'web'"); n != 1 {
+		t.Fatalf("expected existing labels to be backfilled, got %d", n)
+	}
+	if n := countRows(t, store, "SELECT COUNT(*) FROM owner_refs WHERE owner_uid = 'rs-uid'"); n != 1 {
+		t.Fatalf("expected existing owner references to be backfilled, got %d", n)
+	}
+}
@@ -0,0 +1,198 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the schema migrations, named NNNN_description.sql.
+// Migrations are applied in order and must never be edited once released;
+// schema changes are made by adding a new file.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is a single schema change
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// LoadMigrations reads the migrations directory of a store's files, named
+// NNNN_description.sql, and checks that their versions are numbered 1, 2,
+// 3, ... without gaps
+func LoadMigrations(files fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %v", err)
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		prefix, name, found := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(prefix)
+		if !found || err != nil {
+			return nil, fmt.Errorf("migration %s isn't named NNNN_description.sql", entry.Name())
+		}
+
+		data, err := fs.ReadFile(files, path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %v", entry.Name(), err)
+		}
+
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must be contiguous, expected %d but found %d", i+1, m.Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// SchemaVersion returns the schema version of the database
+func (s *ResourceStore) SchemaVersion() (int, error) {
+	return schemaVersion(s.db)
+}
+
+// schemaVersion returns the highest applied migration
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %v", err)
+	}
+	return version, nil
+}
+
+// migrate brings the schema up to date. Databases that were created before
+// schema versioning are detected from their tables and adopted.
+func migrate(db *sql.DB) error {
+	migrations, err := LoadMigrations(migrationFiles)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if current == 0 {
+		current, err = adoptUnversionedSchema(db, migrations)
+		if err != nil {
+			return err
+		}
+	}
+
+	latest := len(migrations)
+	if current > latest {
+		return fmt.Errorf("database schema version %d is newer than this binary supports (%d); "+
+			"upgrade the binary or use a different database file", current, latest)
+	}
+
+	for _, m := range migrations[current:] {
+		if err := applyMigration(db, m); err != nil {
+			return err
+		}
+		log.Printf("Applied database migration %04d_%s", m.Version, m.Name)
+	}
+
+	return nil
+}
+
+// applyMigration runs a migration and records it in a single transaction
+func applyMigration(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %d: %v", m.Version, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return fmt.Errorf("migration %04d_%s failed: %v", m.Version, m.Name, err)
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		m.Version, m.Name, time.Now().UnixNano(),
+	); err != nil {
+		return fmt.Errorf("failed to record migration %d: %v", m.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d: %v", m.Version, err)
+	}
+
+	return nil
+}
+
+// unversionedTables maps the versions released before schema_migrations
+// existed to the table that version introduced
+var unversionedTables = []struct {
+	version int
+	table   string
+}{
+	{1, "resources"},
+	{2, "events"},
+}
+
+// adoptUnversionedSchema records the migrations that an unversioned
+// database already contains and returns its version
+func adoptUnversionedSchema(db *sql.DB, migrations []Migration) (int, error) {
+	version := 0
+	for _, known := range unversionedTables {
+		var count int
+		err := db.QueryRow(
+			"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", known.table,
+		).Scan(&count)
+		if err != nil {
+			return 0, fmt.Errorf("failed to inspect schema: %v", err)
+		}
+		if count == 0 {
+			break
+		}
+		version = known.version
+	}
+
+	for _, m := range migrations[:version] {
+		if _, err := db.Exec(
+			"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+			m.Version, m.Name, time.Now().UnixNano(),
+		); err != nil {
+			return 0, fmt.Errorf("failed to record existing schema: %v", err)
+		}
+	}
+
+	if version > 0 {
+		log.Printf("Adopted unversioned database at schema version %d", version)
+	}
+
+	return version, nil
+}
@@ -0,0 +1,347 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/health"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/query"
+)
+
+// newFixture creates a database file at the given schema version from the
+// current migration files, with one resource stored. Unversioned fixtures
+// lack the schema_migrations table, like databases written before schema
+// versioning was introduced. The databases that released versions wrote
+// are in testdata (see TestUpgradeFromReleasedDatabases).
+func newFixture(t *testing.T, version int, versioned bool) string {
+	t.Helper()
+
+	migrations, err := LoadMigrations(migrationFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "fixture.db")
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if versioned {
+		if _, err := conn.Exec(`CREATE TABLE schema_migrations (
+			version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at INTEGER NOT NULL)`); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, m := range migrations[:version] {
+		if _, err := conn.Exec(m.SQL); err != nil {
+			t.Fatalf("migration %d: %v", m.Version, err)
+		}
+		if versioned {
+			if _, err := conn.Exec("INSERT INTO schema_migrations VALUES (?, ?, 0)", m.Version, m.Name); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if version >= 1 {
+		_, err := conn.Exec(`
+			INSERT INTO resources (name, namespace, kind, api_version, resource_version, data)
+			VALUES ('app-config', 'default', 'ConfigMap', 'v1', '1', '{"data":{"key":"value"}}')
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return path
+}
+
+func TestMigrationsAreContiguous(t *testing.T) {
+	migrations, err := LoadMigrations(migrationFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations found")
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	file := &fstest.MapFile{Data: []byte("SELECT 1")}
+	tests := []struct {
+		name  string
+		files []string
+		err   string
+	}{
+		{name: "ordered by number", files: []string{"0010_j.sql", "0002_b.sql", "0001_a.sql", "0003_c.sql",
+			"0004_d.sql", "0005_e.sql", "0006_f.sql", "0007_g.sql", "0008_h.sql", "0009_i.sql"}},
+		{name: "gap", files: []string{"0001_a.sql", "0003_c.sql"}, err: "expected 2 but found 3"},
+		{name: "duplicate", files: []string{"0001_a.sql", "0001_b.sql"}, err: "expected 2 but found 1"},
+		{name: "not starting at 1", files: []string{"0002_b.sql"}, err: "expected 1 but found 2"},
+		{name: "no number", files: []string{"create.sql"}, err: "isn't named NNNN_description.sql"},
+		{name: "no description", files: []string{"0001.sql"}, err: "isn't named NNNN_description.sql"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := fstest.MapFS{}
+			for _, name := range tt.files {
+				files["migrations/"+name] = file
+			}
+			migrations, err := LoadMigrations(files)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("LoadMigrations() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, m := range migrations {
+				if m.Version != i+1 {
+					t.Errorf("migration %d has version %d", i, m.Version)
+				}
+			}
+		})
+	}
+}
+
+func TestUpgradeFromEveryVersion(t *testing.T) {
+	migrations, err := LoadMigrations(migrationFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := len(migrations)
+
+	type fixture struct {
+		name      string
+		version   int
+		versioned bool
+	}
+
+	var fixtures []fixture
+	for version := 0; version < latest; version++ {
+		fixtures = append(fixtures, fixture{"versioned", version, true})
+	}
+	for _, known := range unversionedTables {
+		fixtures = append(fixtures, fixture{"unversioned", known.version, false})
+	}
+
+	for _, f := range fixtures {
+		t.Run(fmt.Sprintf("%s-v%d", f.name, f.version), func(t *testing.T) {
+			store, err := New(newFixture(t, f.version, f.versioned))
+			if err != nil {
+				t.Fatalf("upgrade failed: %v", err)
+			}
+			defer store.Close()
+
+			version, err := store.SchemaVersion()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != latest {
+				t.Fatalf("expected schema version %d, got %d", latest, version)
+			}
+
+			// Existing resources survive the upgrade
+			count, err := store.ResourceCount()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.version >= 1 && count != 1 {
+				t.Fatalf("expected the fixture resource to survive, found %d resources", count)
+			}
+
+			// The upgraded schema accepts writes and records history
+			r := Resource{Name: "app-config", Namespace: "default", Kind: "ConfigMap", APIVersion: "v1",
+				ResourceVersion: "2", Data: `{"data":{"key":"changed"}}`}
+			if err := store.Upsert(r); err != nil {
+				t.Fatalf("upsert after upgrade failed: %v", err)
+			}
+			events, err := store.History(r.Key())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != 1 {
+				t.Fatalf("expected 1 history event, got %d", len(events))
+			}
+			if events[0].Data != r.Data {
+				t.Fatalf("expected history to hold the full object, got %s", events[0].Data)
+			}
+		})
+	}
+}
+
+// releasedFixtures are the databases in testdata written by earlier
+// versions of the store, with whether they recorded history
+var releasedFixtures = []struct {
+	file    string
+	history bool
+}{
+	{"unversioned-resources.db", false},
+	{"unversioned-events.db", true},
+	{"v2.db", true},
+	{"v3.db", true},
+	{"v4.db", true},
+	{"v5.db", true},
+	{"v6.db", true},
+}
+
+func TestUpgradeFromReleasedDatabases(t *testing.T) {
+	migrations, err := LoadMigrations(migrationFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range releasedFixtures {
+		t.Run(f.file, func(t *testing.T) {
+			// Upgrading changes the file, so open a copy
+			data, err := os.ReadFile(filepath.Join("testdata", f.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), f.file)
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			store, err := New(path)
+			if err != nil {
+				t.Fatalf("upgrade failed: %v", err)
+			}
+			defer store.Close()
+
+			if version, err := store.SchemaVersion(); err != nil || version != len(migrations) {
+				t.Fatalf("SchemaVersion() = %d, %v, want %d", version, err, len(migrations))
+			}
+
+			run := func(input string) []string {
+				t.Helper()
+				q, err := query.Parse(input)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resources, err := store.Query(q)
+				if err != nil {
+					t.Fatalf("Query(%q): %v", input, err)
+				}
+				var names []string
+				for _, r := range resources {
+					names = append(names, r.Name)
+				}
+				sort.Strings(names)
+				return names
+			}
+
+			// The resources survive, with the columns and tables added since
+			// filled in from their data
+			if got, want := run(""), []string{"settings", "web", "web-1", "web-1.a"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("resources = %v, want %v", got, want)
+			}
+			if got, want := run("label:app=web"), []string{"web", "web-1"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Query(label:app=web) = %v, want %v", got, want)
+			}
+			if got, want := run("health:degraded"), []string{"web-1"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Query(health:degraded) = %v, want %v", got, want)
+			}
+			pods, err := store.Search("web-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var pod Resource
+			for _, r := range pods {
+				if r.Kind == "Pod" {
+					pod = r
+				}
+			}
+			if pod.Health != health.Degraded || pod.HealthReason != "CrashLoopBackOff" {
+				t.Errorf("pod health = %s (%s), want Degraded (CrashLoopBackOff)", pod.Health, pod.HealthReason)
+			}
+			owners, err := store.Owners(pod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(owners) != 1 || owners[0].Resource.Name != "web-abc" {
+				t.Errorf("Owners() = %v, want the ReplicaSet", owners)
+			}
+			events, err := store.ObjectEvents(pod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != 1 || events[0].Name != "web-1.a" {
+				t.Errorf("ObjectEvents() = %v, want the BackOff event", events)
+			}
+
+			settings := ResourceKey{Kind: "ConfigMap", APIVersion: "v1", Namespace: "default", Name: "settings"}
+			history, err := store.History(settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.history {
+				if len(history) != 2 || !strings.Contains(history[0].Data, `"a"`) || !strings.Contains(history[1].Data, `"b"`) {
+					t.Errorf("History() = %+v, want both versions", history)
+				}
+			} else if len(history) != 0 {
+				t.Errorf("History() = %+v, want none before history was recorded", history)
+			}
+
+			// The upgraded database records new versions on top of the old ones
+			r := Resource{Name: "settings", Namespace: "default", Kind: "ConfigMap", APIVersion: "v1", ResourceVersion: "20",
+				Data: `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"settings","namespace":"default"},"data":{"mode":"c"}}`}
+			if err := store.Upsert(r); err != nil {
+				t.Fatalf("upsert after upgrade failed: %v", err)
+			}
+			after, err := store.History(settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(after) != len(history)+1 || !sameJSON(t, after[len(after)-1].Data, r.Data) {
+				t.Errorf("History() after upsert = %+v, want the new version appended", after)
+			}
+		})
+	}
+}
+
+func TestReopenIsIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resources.db")
+
+	for i := 0; i < 2; i++ {
+		store, err := New(path)
+		if err != nil {
+			t.Fatalf("open %d failed: %v", i+1, err)
+		}
+		store.Close()
+	}
+}
+
+func TestRejectNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resources.db")
+
+	store, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.db.Exec("INSERT INTO schema_migrations VALUES (9999, 'from_the_future', 0)"); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	_, err = New(path)
+	if err == nil {
+		t.Fatal("expected an error opening a database with a newer schema")
+	}
+	if !strings.Contains(err.Error(), "newer than this binary supports") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
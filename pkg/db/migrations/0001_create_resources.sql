@@ -0,0 +1,12 @@
+-- Latest state of every watched resource
+CREATE TABLE IF NOT EXISTS resources (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	namespace TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	api_version TEXT NOT NULL,
+	resource_version TEXT NOT NULL,
+	data TEXT NOT NULL,
+	UNIQUE(kind, api_version, namespace, name)
+);
+CREATE INDEX IF NOT EXISTS idx_resources_search ON resources(name, namespace, kind);
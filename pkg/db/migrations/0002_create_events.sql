@@ -0,0 +1,17 @@
+-- History of every change to a resource. Data holds either the full object
+-- or a merge patch against the previous version, depending on encoding.
+CREATE TABLE IF NOT EXISTS events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL,
+	api_version TEXT NOT NULL,
+	namespace TEXT NOT NULL,
+	name TEXT NOT NULL,
+	type TEXT NOT NULL,
+	resource_version TEXT NOT NULL,
+	previous_resource_version TEXT NOT NULL,
+	recorded_at INTEGER NOT NULL,
+	encoding TEXT NOT NULL,
+	data TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_events_key ON events(kind, api_version, namespace, name, id);
+CREATE INDEX IF NOT EXISTS idx_events_recorded_at ON events(recorded_at);
@@ -0,0 +1,6 @@
+-- Key/value settings describing the state of the store itself, e.g. whether
+-- derived indexes need to be rebuilt
+CREATE TABLE IF NOT EXISTS store_meta (
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
@@ -0,0 +1,44 @@
+-- Labels, annotations and owner references of every resource, kept in sync
+-- by Upsert so selector and ownership queries don't scan the JSON data
+ALTER TABLE resources ADD COLUMN uid TEXT NOT NULL DEFAULT '';
+UPDATE resources SET uid = COALESCE(json_extract(data, '$.metadata.uid'), '') WHERE json_valid(data);
+CREATE INDEX IF NOT EXISTS idx_resources_uid ON resources(uid);
+
+CREATE TABLE IF NOT EXISTS labels (
+	resource_id INTEGER NOT NULL REFERENCES resources(id) ON DELETE CASCADE,
+	key TEXT NOT NULL,
+	value TEXT NOT NULL,
+	PRIMARY KEY (resource_id, key)
+);
+CREATE INDEX IF NOT EXISTS idx_labels_key_value ON labels(key, value);
+
+CREATE TABLE IF NOT EXISTS annotations (
+	resource_id INTEGER NOT NULL REFERENCES resources(id) ON DELETE CASCADE,
+	key TEXT NOT NULL,
+	value TEXT NOT NULL,
+	PRIMARY KEY (resource_id, key)
+);
+CREATE INDEX IF NOT EXISTS idx_annotations_key ON annotations(key);
+
+CREATE TABLE IF NOT EXISTS owner_refs (
+	resource_id INTEGER NOT NULL REFERENCES resources(id) ON DELETE CASCADE,
+	owner_uid TEXT NOT NULL,
+	owner_kind TEXT NOT NULL,
+	owner_name TEXT NOT NULL,
+	controller INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (resource_id, owner_uid)
+);
+CREATE INDEX IF NOT EXISTS idx_owner_refs_owner ON owner_refs(owner_uid);
+
+INSERT INTO labels (resource_id, key, value)
+SELECT r.id, l.key, CAST(l.value AS TEXT)
+FROM resources r, json_each(CASE WHEN json_valid(r.data) THEN r.data ELSE '{}' END, '$.metadata.labels') AS l;
+
+INSERT INTO annotations (resource_id, key, value)
+SELECT r.id, a.key, CAST(a.value AS TEXT)
+FROM resources r, json_each(CASE WHEN json_valid(r.data) THEN r.data ELSE '{}' END, '$.metadata.annotations') AS a;
+
+INSERT OR IGNORE INTO owner_refs (resource_id, owner_uid, owner_kind, owner_name, controller)
+SELECT r.id, COALESCE(json_extract(o.value, '$.uid'), ''), COALESCE(json_extract(o.value, '$.kind'), ''),
+	COALESCE(json_extract(o.value, '$.name'), ''), COALESCE(json_extract(o.value, '$.controller'), 0)
+FROM resources r, json_each(CASE WHEN json_valid(r.data) THEN r.data ELSE '{}' END, '$.metadata.ownerReferences') AS o;
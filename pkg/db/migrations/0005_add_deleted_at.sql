@@ -0,0 +1,5 @@
+-- Deleted resources are kept as tombstones holding their final state.
+-- deleted_at is the deletion time in Unix nanoseconds, NULL while the
+-- resource exists.
+ALTER TABLE resources ADD COLUMN deleted_at INTEGER;
+CREATE INDEX IF NOT EXISTS idx_resources_deleted_at ON resources(deleted_at);
@@ -0,0 +1,7 @@
+-- The uid of the object a Kubernetes Event is about, its regarding in
+-- events.k8s.io or its involvedObject in core v1, kept in sync by Upsert so
+-- the events of an object are found without scanning the JSON data
+ALTER TABLE resources ADD COLUMN involved_uid TEXT NOT NULL DEFAULT '';
+UPDATE resources SET involved_uid = COALESCE(json_extract(data, '$.regarding.uid'), json_extract(data, '$.involvedObject.uid'), '')
+WHERE kind = 'Event' AND (api_version = 'v1' OR api_version LIKE 'events.k8s.io/%') AND json_valid(data);
+CREATE INDEX IF NOT EXISTS idx_resources_involved_uid ON resources(involved_uid);
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// ObjectEvents returns the stored Kubernetes Events about a resource, of
+// both the core v1 and the events.k8s.io API, tombstones included
+func (s *ResourceStore) ObjectEvents(r Resource) ([]Resource, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, namespace, kind, api_version, resource_version, data, deleted_at
+		FROM resources
+		WHERE involved_uid = (
+			SELECT uid FROM resources
+			WHERE kind = ? AND api_version = ? AND namespace = ? AND name = ? AND uid != ''
+		)
+		ORDER BY id
+	`, r.Kind, r.APIVersion, r.Namespace, r.Name)
+	if err != nil {
+		return nil, fmt.Errorf("events query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var events []Resource
+	for rows.Next() {
+		var e Resource
+		var deletedAt sql.NullInt64
+		if err := rows.Scan(&e.ID, &e.Name, &e.Namespace, &e.Kind, &e.APIVersion, &e.ResourceVersion, &e.Data, &deletedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		e.DeletedAt = unixTime(deletedAt)
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+// createMergePatch returns a JSON merge patch (RFC 7386) that turns original
+// into modified. Fields removed in modified are set to null in the patch.
+// Merge patches can't set a field to an explicit null, since null removes
+// it, so it reports false when modified sets one and the patch would lose it.
+func createMergePatch(original, modified map[string]interface{}) (map[string]interface{}, bool) {
+	patch := make(map[string]interface{})
+
+	for key := range original {
+		if _, ok := modified[key]; !ok {
+			patch[key] = nil
+		}
+	}
+
+	for key, newValue := range modified {
+		oldValue, ok := original[key]
+		if !ok {
+			if hasNull(newValue) {
+				return nil, false
+			}
+			patch[key] = newValue
+			continue
+		}
+
+		oldMap, oldIsMap := oldValue.(map[string]interface{})
+		newMap, newIsMap := newValue.(map[string]interface{})
+		if oldIsMap && newIsMap {
+			nested, ok := createMergePatch(oldMap, newMap)
+			if !ok {
+				return nil, false
+			}
+			if len(nested) > 0 {
+				patch[key] = nested
+			}
+			continue
+		}
+
+		if !reflect.DeepEqual(oldValue, newValue) {
+			if hasNull(newValue) {
+				return nil, false
+			}
+			patch[key] = newValue
+		}
+	}
+
+	return patch, true
+}
+
+// hasNull reports whether applying value as a merge patch would drop part
+// of it: when it is null or an object with a null field. Arrays are
+// replaced as a whole, so nulls in them are kept.
+func hasNull(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	if m, ok := value.(map[string]interface{}); ok {
+		for _, v := range m {
+			if hasNull(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyMergePatch applies a JSON merge patch (RFC 7386) to target
+func applyMergePatch(target interface{}, patch interface{}) interface{} {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetMap, ok := target.(map[string]interface{})
+	if !ok {
+		targetMap = make(map[string]interface{})
+	}
+
+	for key, value := range patchMap {
+		if value == nil {
+			delete(targetMap, key)
+			continue
+		}
+		targetMap[key] = applyMergePatch(targetMap[key], value)
+	}
+
+	return targetMap
+}
+
+// decodeObject parses an object stored as JSON
+func decodeObject(data string) (map[string]interface{}, error) {
+	var obj map[string]interface{}
+	if err := decodeJSON(data, &obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// decodeJSON parses JSON keeping numbers as json.Number, so integers like
+// generations survive a round trip without loss of precision
+func decodeJSON(data string, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(data)))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
@@ -0,0 +1,32 @@
+-- Latest state of every watched resource
+CREATE TABLE IF NOT EXISTS resources (
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	namespace TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	api_version TEXT NOT NULL,
+	resource_version TEXT NOT NULL,
+	uid TEXT NOT NULL DEFAULT '',
+	data JSONB NOT NULL,
+	UNIQUE (kind, api_version, namespace, name)
+);
+CREATE INDEX IF NOT EXISTS idx_resources_search ON resources (name, namespace, kind);
+CREATE INDEX IF NOT EXISTS idx_resources_uid ON resources (uid);
+-- Serves label containment queries such as data @> '{"metadata":{"labels":{...}}}'
+CREATE INDEX IF NOT EXISTS idx_resources_data ON resources USING GIN (data jsonb_path_ops);
+
+-- History of every change to a resource, each holding the full object
+CREATE TABLE IF NOT EXISTS events (
+	id BIGSERIAL PRIMARY KEY,
+	kind TEXT NOT NULL,
+	api_version TEXT NOT NULL,
+	namespace TEXT NOT NULL,
+	name TEXT NOT NULL,
+	type TEXT NOT NULL,
+	resource_version TEXT NOT NULL,
+	previous_resource_version TEXT NOT NULL,
+	recorded_at BIGINT NOT NULL,
+	data JSONB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_events_key ON events (kind, api_version, namespace, name, id);
+CREATE INDEX IF NOT EXISTS idx_events_recorded_at ON events (recorded_at);
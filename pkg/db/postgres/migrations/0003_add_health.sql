@@ -0,0 +1,6 @@
+-- The health of every resource (see pkg/health), evaluated by Upsert so the
+-- TUI can colour and filter resources by it. Resources stored before this
+-- migration are evaluated when the store opens.
+ALTER TABLE resources ADD COLUMN IF NOT EXISTS health TEXT NOT NULL DEFAULT '';
+ALTER TABLE resources ADD COLUMN IF NOT EXISTS health_reason TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_resources_health ON resources (health);
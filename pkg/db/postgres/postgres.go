@@ -0,0 +1,523 @@
+// Package postgres provides a PostgreSQL resource store, so several
+// watchers and viewers can share one database. Objects are stored as JSONB
+// and queried with the JSONB operators.
+package postgres
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/health"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/query"
+)
+
+// migrationFiles holds the schema migrations, named NNNN_description.sql
+// and loaded like those of the SQLite store (see db.LoadMigrations)
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Store keeps resources in PostgreSQL
+type Store struct {
+	db *sql.DB
+}
+
+var (
+	_ db.Store      = (*Store)(nil)
+	_ db.EventStore = (*Store)(nil)
+)
+
+// IsDSN reports whether a database argument is a PostgreSQL connection URL
+// rather than an SQLite file path
+func IsDSN(s string) bool {
+	return strings.HasPrefix(s, "postgres://") || strings.HasPrefix(s, "postgresql://")
+}
+
+// New connects to PostgreSQL and brings the schema up to date
+func New(dsn string) (*Store, error) {
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+
+	if err := migrate(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := evaluateHealth(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Store{db: conn}, nil
+}
+
+// Close closes the database connection
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Upsert adds or updates a resource and records the change
+func (s *Store) Upsert(resource db.Resource) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// A new resource is inserted right away. Concurrent first inserts of
+	// the same resource wait for each other, and all but one find it
+	// stored.
+	h := health.EvaluateJSON(resource.Kind, resource.APIVersion, resource.Data)
+	var id int64
+	err = tx.QueryRow(`
+		INSERT INTO resources (name, namespace, kind, api_version, resource_version, uid, data, health, health_reason)
+		VALUES ($1, $2, $3, $4, $5, COALESCE($6::jsonb #>> '{metadata,uid}', ''), $6::jsonb, $7, $8)
+		ON CONFLICT (kind, api_version, namespace, name) DO NOTHING
+		RETURNING id
+	`, resource.Name, resource.Namespace, resource.Kind, resource.APIVersion, resource.ResourceVersion, resource.Data,
+		h.Status, h.Reason).Scan(&id)
+	inserted := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to insert resource: %v", err)
+	}
+
+	eventType, previousRV := db.EventAdded, ""
+	if !inserted {
+		// Lock the stored row so concurrent writers record versions in order
+		var unchanged, deleted bool
+		err = tx.QueryRow(`
+			SELECT resource_version, data = $5::jsonb, deleted_at IS NOT NULL FROM resources
+			WHERE kind = $1 AND api_version = $2 AND namespace = $3 AND name = $4
+			FOR UPDATE
+		`, resource.Kind, resource.APIVersion, resource.Namespace, resource.Name, resource.Data).Scan(&previousRV, &unchanged, &deleted)
+		if err != nil {
+			return fmt.Errorf("failed to read resource: %v", err)
+		}
+
+		// Relists deliver objects we already have, which aren't changes
+		if !deleted && previousRV == resource.ResourceVersion && unchanged {
+			return nil
+		}
+
+		_, err = tx.Exec(`
+			UPDATE resources SET resource_version = $5, uid = COALESCE($6::jsonb #>> '{metadata,uid}', ''), data = $6::jsonb,
+				deleted_at = NULL, health = $7, health_reason = $8
+			WHERE kind = $1 AND api_version = $2 AND namespace = $3 AND name = $4
+		`, resource.Kind, resource.APIVersion, resource.Namespace, resource.Name, resource.ResourceVersion, resource.Data,
+			h.Status, h.Reason)
+		if err != nil {
+			return fmt.Errorf("failed to update resource: %v", err)
+		}
+
+		// A resource created again after its deletion starts a new history
+		if deleted {
+			previousRV = ""
+		} else {
+			eventType = db.EventModified
+		}
+	}
+
+	if err := recordEvent(tx, resource.Key(), eventType, resource.ResourceVersion, previousRV, resource.Data); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit resource: %v", err)
+	}
+	return nil
+}
+
+// Delete turns a resource into a tombstone and records its final state
+func (s *Store) Delete(kind, apiVersion, namespace, name string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var resourceVersion, data string
+	err = tx.QueryRow(`
+		UPDATE resources SET deleted_at = $5, health = $6, health_reason = $7
+		WHERE kind = $1 AND api_version = $2 AND namespace = $3 AND name = $4 AND deleted_at IS NULL
+		RETURNING resource_version, data::text
+	`, kind, apiVersion, namespace, name, time.Now().UnixNano(), health.Deleted.Status, health.Deleted.Reason).Scan(&resourceVersion, &data)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete resource: %v", err)
+	}
+
+	key := db.ResourceKey{Kind: kind, APIVersion: apiVersion, Namespace: namespace, Name: name}
+	if err := recordEvent(tx, key, db.EventDeleted, resourceVersion, resourceVersion, data); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit deletion: %v", err)
+	}
+	return nil
+}
+
+// PurgeType removes every resource of a type with its tombstones and
+// history, in a namespace or in all namespaces when it is empty
+func (s *Store) PurgeType(kind, apiVersion, namespace string) (int, error) {
+	where := "kind = ? AND api_version = ?"
+	args := []interface{}{kind, apiVersion}
+	if namespace != "" {
+		where += " AND namespace = ?"
+		args = append(args, namespace)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(rebind("DELETE FROM resources WHERE "+where), args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge resources: %v", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge resources: %v", err)
+	}
+	if _, err := tx.Exec(rebind("DELETE FROM events WHERE "+where), args...); err != nil {
+		return 0, fmt.Errorf("failed to purge history: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit purge: %v", err)
+	}
+	return int(purged), nil
+}
+
+// recordEvent appends an event with the full object to the history
+func recordEvent(tx *sql.Tx, key db.ResourceKey, eventType, resourceVersion, previousResourceVersion, data string) error {
+	_, err := tx.Exec(`
+		INSERT INTO events (kind, api_version, namespace, name, type, resource_version,
+			previous_resource_version, recorded_at, data)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9::jsonb)
+	`, key.Kind, key.APIVersion, key.Namespace, key.Name, eventType, resourceVersion,
+		previousResourceVersion, time.Now().UnixNano(), data)
+	if err != nil {
+		return fmt.Errorf("failed to record event: %v", err)
+	}
+	return nil
+}
+
+// Search matches a substring against name, namespace and kind, ignoring
+// case, excluding tombstones
+func (s *Store) Search(q string) ([]db.Resource, error) {
+	pattern := "%" + q + "%"
+	return s.resources(`deleted_at IS NULL AND (name ILIKE $1 OR namespace ILIKE $1 OR kind ILIKE $1)`, pattern)
+}
+
+// Query returns the resources matching a parsed query
+func (s *Store) Query(q *query.Query) ([]db.Resource, error) {
+	where, args := q.Where(query.Options{Now: time.Now(), Dialect: query.Postgres})
+	return s.resources(rebind(where), args...)
+}
+
+// ObjectEvents returns the stored Kubernetes Events about a resource, of
+// both the core v1 and the events.k8s.io API, tombstones included
+func (s *Store) ObjectEvents(r db.Resource) ([]db.Resource, error) {
+	var uid string
+	err := s.db.QueryRow(`
+		SELECT uid FROM resources WHERE kind = $1 AND api_version = $2 AND namespace = $3 AND name = $4
+	`, r.Kind, r.APIVersion, r.Namespace, r.Name).Scan(&uid)
+	if err == sql.ErrNoRows || uid == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read resource: %v", err)
+	}
+
+	// Containment queries are served by the GIN index on data. Every event
+	// is returned, as busy objects have many, in the order they were last
+	// observed (see events.FromObject).
+	rows, err := s.db.Query(`
+		SELECT id, name, namespace, kind, api_version, resource_version, data::text, deleted_at, health, health_reason
+		FROM resources
+		WHERE kind = 'Event' AND (api_version = 'v1' OR api_version LIKE 'events.k8s.io/%') AND (
+			data @> jsonb_build_object('regarding', jsonb_build_object('uid', $1::text)) OR
+			data @> jsonb_build_object('involvedObject', jsonb_build_object('uid', $1::text)))
+		ORDER BY COALESCE(data #>> '{series,lastObservedTime}', data ->> 'lastTimestamp',
+			data ->> 'deprecatedLastTimestamp', data ->> 'eventTime', data #>> '{metadata,creationTimestamp}')::timestamptz NULLS FIRST, id
+	`, uid)
+	if err != nil {
+		return nil, fmt.Errorf("events query failed: %v", err)
+	}
+	return scanResources(rows)
+}
+
+// resources returns at most 100 resources matching a condition
+func (s *Store) resources(where string, args ...interface{}) ([]db.Resource, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, namespace, kind, api_version, resource_version, data::text, deleted_at, health, health_reason
+		FROM resources
+		WHERE `+where+`
+		ORDER BY namespace, kind, name
+		LIMIT 100
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %v", err)
+	}
+	return scanResources(rows)
+}
+
+// scanResources reads the resources of a query and closes its rows
+func scanResources(rows *sql.Rows) ([]db.Resource, error) {
+	defer rows.Close()
+
+	var resources []db.Resource
+	for rows.Next() {
+		var r db.Resource
+		var deletedAt sql.NullInt64
+		if err := rows.Scan(&r.ID, &r.Name, &r.Namespace, &r.Kind, &r.APIVersion, &r.ResourceVersion, &r.Data, &deletedAt,
+			&r.Health, &r.HealthReason); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		if deletedAt.Valid {
+			r.DeletedAt = time.Unix(0, deletedAt.Int64)
+		}
+		resources = append(resources, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+	return resources, nil
+}
+
+// evaluateHealth evaluates the health of the resources stored before the
+// health was recorded
+func evaluateHealth(conn *sql.DB) error {
+	rows, err := conn.Query("SELECT id, kind, api_version, data::text, deleted_at IS NOT NULL FROM resources WHERE health = ''")
+	if err != nil {
+		return fmt.Errorf("failed to read resources: %v", err)
+	}
+
+	evaluated := map[int64]health.Health{}
+	for rows.Next() {
+		var id int64
+		var kind, apiVersion, data string
+		var deleted bool
+		if err := rows.Scan(&id, &kind, &apiVersion, &data, &deleted); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan row: %v", err)
+		}
+		evaluated[id] = health.Deleted
+		if !deleted {
+			evaluated[id] = health.EvaluateJSON(kind, apiVersion, data)
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	for id, h := range evaluated {
+		if _, err := conn.Exec("UPDATE resources SET health = $1, health_reason = $2 WHERE id = $3", h.Status, h.Reason, id); err != nil {
+			return fmt.Errorf("failed to store health: %v", err)
+		}
+	}
+	return nil
+}
+
+// ResourceCount returns the number of stored resources, excluding tombstones
+func (s *Store) ResourceCount() (int, error) {
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM resources WHERE deleted_at IS NULL").Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count resources: %v", err)
+	}
+	return count, nil
+}
+
+// History returns the recorded versions of a resource, oldest first
+func (s *Store) History(key db.ResourceKey) ([]db.Event, error) {
+	return s.events(key, "")
+}
+
+// StateAt returns a resource as it was at the given time, or nil if it
+// didn't exist then
+func (s *Store) StateAt(key db.ResourceKey, at time.Time) (*db.Resource, error) {
+	events, err := s.events(key, "AND recorded_at <= $5 ORDER BY id DESC LIMIT 1", at.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 || events[0].Type == db.EventDeleted {
+		return nil, nil
+	}
+
+	return &db.Resource{
+		Name:            key.Name,
+		Namespace:       key.Namespace,
+		Kind:            key.Kind,
+		APIVersion:      key.APIVersion,
+		ResourceVersion: events[0].ResourceVersion,
+		Data:            events[0].Data,
+	}, nil
+}
+
+// events reads the events of a resource, ordered by id unless the extra
+// clause orders them
+func (s *Store) events(key db.ResourceKey, clause string, args ...interface{}) ([]db.Event, error) {
+	if clause == "" {
+		clause = "ORDER BY id"
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, type, resource_version, previous_resource_version, recorded_at, data::text
+		FROM events
+		WHERE kind = $1 AND api_version = $2 AND namespace = $3 AND name = $4
+		`+clause, append([]interface{}{key.Kind, key.APIVersion, key.Namespace, key.Name}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("history query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var events []db.Event
+	for rows.Next() {
+		e := db.Event{Key: key}
+		var recordedAt int64
+		if err := rows.Scan(&e.ID, &e.Type, &e.ResourceVersion, &e.PreviousResourceVersion, &recordedAt, &e.Data); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		e.RecordedAt = time.Unix(0, recordedAt)
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+	return events, nil
+}
+
+// QueryValues returns the stored values of a query key, for completion
+func (s *Store) QueryValues(key string) ([]string, error) {
+	var statement string
+	switch key {
+	case query.KeyKind:
+		statement = "SELECT DISTINCT kind FROM resources"
+	case query.KeyNamespace:
+		statement = "SELECT DISTINCT namespace FROM resources WHERE namespace != ''"
+	case query.KeyAPIVersion:
+		statement = "SELECT DISTINCT api_version FROM resources"
+	case query.KeyLabel:
+		statement = `SELECT DISTINCT jsonb_object_keys(data -> 'metadata' -> 'labels') FROM resources
+			WHERE jsonb_typeof(data -> 'metadata' -> 'labels') = 'object'`
+	default:
+		return nil, nil
+	}
+
+	rows, err := s.db.Query(statement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read values of %s: %v", key, err)
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// CleanDatabase removes all resources and their history
+func (s *Store) CleanDatabase() error {
+	if _, err := s.db.Exec("TRUNCATE resources, events"); err != nil {
+		return fmt.Errorf("failed to clean database: %v", err)
+	}
+	return nil
+}
+
+// rebind replaces ? placeholders outside string literals with $1, $2, ...
+func rebind(statement string) string {
+	var b strings.Builder
+	n := 0
+	inString := false
+	for _, c := range statement {
+		switch {
+		case c == '\'':
+			inString = !inString
+			b.WriteRune(c)
+		case c == '?' && !inString:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// migrate applies the pending migrations, each in its own transaction
+func migrate(conn *sql.DB) error {
+	migrations, err := db.LoadMigrations(migrationFiles)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at BIGINT NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+
+	var current int
+	if err := conn.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this binary supports (%d); "+
+			"upgrade the binary or use a different database", current, len(migrations))
+	}
+
+	for _, m := range migrations[current:] {
+		tx, err := conn.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %v", m.Version, err)
+		}
+		if _, err := tx.Exec(m.SQL); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %04d_%s failed: %v", m.Version, m.Name, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)",
+			m.Version, m.Name, time.Now().UnixNano()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %v", m.Version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %v", m.Version, err)
+		}
+		log.Printf("Applied database migration %04d_%s", m.Version, m.Name)
+	}
+
+	return nil
+}
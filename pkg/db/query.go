@@ -0,0 +1,99 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/query"
+)
+
+// Query returns the resources matching a parsed query, at most 100.
+// Tombstones are only included when the query asks for deleted resources.
+func (s *ResourceStore) Query(q *query.Query) ([]Resource, error) {
+	return s.query(q, 100)
+}
+
+// QueryAll returns every resource matching a parsed query, e.g. for export
+func (s *ResourceStore) QueryAll(q *query.Query) ([]Resource, error) {
+	return s.query(q, 0)
+}
+
+// query runs a parsed query, returning at most limit resources unless limit is 0
+func (s *ResourceStore) query(q *query.Query, limit int) ([]Resource, error) {
+	opts := query.Options{Now: time.Now()}
+	if s.fullText {
+		opts.FullTextTable = "resources_fts"
+	}
+	where, args := q.Where(opts)
+
+	var limitClause string
+	if limit > 0 {
+		limitClause = fmt.Sprintf("LIMIT %d", limit)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, name, namespace, kind, api_version, resource_version, data, deleted_at, health, health_reason
+		FROM resources
+		WHERE `+where+`
+		ORDER BY namespace, kind, name
+		`+limitClause+`
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var resources []Resource
+	for rows.Next() {
+		var r Resource
+		var deletedAt sql.NullInt64
+		if err := rows.Scan(&r.ID, &r.Name, &r.Namespace, &r.Kind, &r.APIVersion, &r.ResourceVersion, &r.Data, &deletedAt,
+			&r.Health, &r.HealthReason); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		r.DeletedAt = unixTime(deletedAt)
+		resources = append(resources, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return resources, nil
+}
+
+// QueryValues returns the stored values of a query key, for completion.
+// Keys without a fixed set of values return nothing.
+func (s *ResourceStore) QueryValues(key string) ([]string, error) {
+	var statement string
+	switch key {
+	case query.KeyKind:
+		statement = "SELECT DISTINCT kind FROM resources"
+	case query.KeyNamespace:
+		statement = "SELECT DISTINCT namespace FROM resources WHERE namespace != ''"
+	case query.KeyAPIVersion:
+		statement = "SELECT DISTINCT api_version FROM resources"
+	case query.KeyLabel:
+		statement = "SELECT DISTINCT key FROM labels"
+	default:
+		return nil, nil
+	}
+
+	rows, err := s.db.Query(statement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read values of %s: %v", key, err)
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		values = append(values, v)
+	}
+
+	return values, rows.Err()
+}
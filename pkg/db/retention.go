@@ -0,0 +1,452 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/query"
+)
+
+// RetentionPolicy limits the history kept for a resource. Zero values
+// don't limit. The current version of an existing resource is always kept;
+// the whole history of a deleted resource expires with its age, and its
+// tombstone with it.
+type RetentionPolicy struct {
+	// MaxAge drops versions recorded longer ago
+	MaxAge time.Duration
+	// MaxVersions keeps at most this many of the most recent versions
+	MaxVersions int
+}
+
+// Retention is the default policy with per-kind overrides, e.g. keeping
+// Events for an hour and Deployments for 90 days
+type Retention struct {
+	Default RetentionPolicy
+	// Kinds override the non-zero fields of the default policy
+	Kinds map[string]RetentionPolicy
+}
+
+// For returns the policy applying to a kind, which is matched ignoring case
+func (r Retention) For(kind string) RetentionPolicy {
+	policy := r.Default
+	for k, override := range r.Kinds {
+		if !strings.EqualFold(k, kind) {
+			continue
+		}
+		if override.MaxAge != 0 {
+			policy.MaxAge = override.MaxAge
+		}
+		if override.MaxVersions != 0 {
+			policy.MaxVersions = override.MaxVersions
+		}
+	}
+	return policy
+}
+
+// ParseRetention builds a retention from command-line settings: a maximum
+// age such as 30d (empty for none), a maximum number of versions (0 for
+// none) and per-kind policies accepted by ParseKindRetention
+func ParseRetention(maxAge string, maxVersions int, kinds []string) (Retention, error) {
+	retention := Retention{Default: RetentionPolicy{MaxVersions: maxVersions}}
+	if maxVersions < 0 {
+		return Retention{}, fmt.Errorf("invalid version count %d", maxVersions)
+	}
+	if maxAge != "" {
+		d, err := query.ParseDuration(maxAge)
+		if err != nil {
+			return Retention{}, err
+		}
+		retention.Default.MaxAge = d
+	}
+
+	for _, s := range kinds {
+		kind, policy, err := ParseKindRetention(s)
+		if err != nil {
+			return Retention{}, err
+		}
+		if retention.Kinds == nil {
+			retention.Kinds = make(map[string]RetentionPolicy)
+		}
+		retention.Kinds[kind] = policy
+	}
+	return retention, nil
+}
+
+// ParseKindRetention parses a per-kind policy written as KIND=AGE,
+// KIND=AGE/VERSIONS or KIND=/VERSIONS, e.g. Event=1h or Deployment=90d/50
+func ParseKindRetention(s string) (string, RetentionPolicy, error) {
+	var policy RetentionPolicy
+	kind, rest, found := strings.Cut(s, "=")
+	if !found || kind == "" || rest == "" {
+		return "", RetentionPolicy{}, fmt.Errorf("invalid retention %q, expected KIND=AGE[/VERSIONS]", s)
+	}
+
+	age, versions, _ := strings.Cut(rest, "/")
+	if age != "" {
+		d, err := query.ParseDuration(age)
+		if err != nil {
+			return "", RetentionPolicy{}, err
+		}
+		policy.MaxAge = d
+	}
+	if versions != "" {
+		n, err := strconv.Atoi(versions)
+		if err != nil || n < 1 {
+			return "", RetentionPolicy{}, fmt.Errorf("invalid version count %q in retention %q", versions, s)
+		}
+		policy.MaxVersions = n
+	}
+	return kind, policy, nil
+}
+
+// CompactionStats reports what a compaction did
+type CompactionStats struct {
+	// Resources is the number of histories that were shortened
+	Resources int
+	// EventsDeleted is the number of versions dropped
+	EventsDeleted int
+	// SnapshotsWritten is the number of patches replaced by full copies
+	SnapshotsWritten int
+	// ReclaimedBytes is the space returned by vacuuming
+	ReclaimedBytes int64
+}
+
+func (c CompactionStats) String() string {
+	return fmt.Sprintf("dropped %d versions of %d resources, wrote %d snapshots, reclaimed %d bytes",
+		c.EventsDeleted, c.Resources, c.SnapshotsWritten, c.ReclaimedBytes)
+}
+
+// historySummary describes the stored history of a resource
+type historySummary struct {
+	key      ResourceKey
+	versions int
+	oldest   int64
+}
+
+// Compact applies the retention policies to the history. The first version
+// kept of each resource is rewritten as a full copy when it was stored as a
+// patch, and long patch chains get a full copy every snapshotInterval
+// versions. Each resource is compacted in its own transaction, so writes
+// continue in between.
+func (s *ResourceStore) Compact(retention Retention) (CompactionStats, error) {
+	var stats CompactionStats
+	now := time.Now()
+
+	rows, err := s.db.Query(`
+		SELECT kind, api_version, namespace, name, COUNT(*), MIN(recorded_at)
+		FROM events
+		GROUP BY kind, api_version, namespace, name
+	`)
+	if err != nil {
+		return stats, fmt.Errorf("failed to summarize history: %v", err)
+	}
+
+	var candidates []historySummary
+	for rows.Next() {
+		var h historySummary
+		if err := rows.Scan(&h.key.Kind, &h.key.APIVersion, &h.key.Namespace, &h.key.Name, &h.versions, &h.oldest); err != nil {
+			rows.Close()
+			return stats, fmt.Errorf("failed to scan row: %v", err)
+		}
+		policy := retention.For(h.key.Kind)
+		if (policy.MaxVersions > 0 && h.versions > policy.MaxVersions) ||
+			(policy.MaxAge > 0 && h.oldest < now.Add(-policy.MaxAge).UnixNano()) {
+			candidates = append(candidates, h)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return stats, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	for _, h := range candidates {
+		err := s.write(func(tx *sql.Tx) error {
+			deleted, snapshots, err := compactHistory(tx, h.key, retention.For(h.key.Kind), now)
+			if err != nil {
+				return err
+			}
+			if err := s.purgeExpired(tx, h.key); err != nil {
+				return err
+			}
+			if deleted > 0 {
+				stats.Resources++
+			}
+			stats.EventsDeleted += deleted
+			stats.SnapshotsWritten += snapshots
+			return nil
+		})
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, nil
+}
+
+// purgeExpired removes the tombstone of a deleted resource once none of its
+// history is left
+func (s *ResourceStore) purgeExpired(tx *sql.Tx, key ResourceKey) error {
+	var id int64
+	err := tx.QueryRow(`
+		SELECT id FROM resources r
+		WHERE kind = ? AND api_version = ? AND namespace = ? AND name = ? AND deleted_at IS NOT NULL
+		AND NOT EXISTS (
+			SELECT 1 FROM events e
+			WHERE e.kind = r.kind AND e.api_version = r.api_version AND e.namespace = r.namespace AND e.name = r.name
+		)
+	`, key.Kind, key.APIVersion, key.Namespace, key.Name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find expired tombstone: %v", err)
+	}
+	return s.purge(tx, id)
+}
+
+// storedEvent is an event with its stored encoding and full object data
+type storedEvent struct {
+	Event
+	encoding string
+}
+
+// compactHistory drops the expired versions of one resource and returns the
+// number of versions dropped and of snapshots written
+func compactHistory(tx *sql.Tx, key ResourceKey, policy RetentionPolicy, now time.Time) (int, int, error) {
+	rows, err := tx.Query(`
+		SELECT id, type, resource_version, previous_resource_version, recorded_at, encoding, data
+		FROM events
+		WHERE kind = ? AND api_version = ? AND namespace = ? AND name = ?
+		ORDER BY id
+	`, key.Kind, key.APIVersion, key.Namespace, key.Name)
+	if err != nil {
+		return 0, 0, fmt.Errorf("history query failed: %v", err)
+	}
+
+	var events []storedEvent
+	var replay eventReplay
+	for rows.Next() {
+		var e storedEvent
+		var recordedAt int64
+		var data string
+		if err := rows.Scan(&e.ID, &e.Type, &e.ResourceVersion, &e.PreviousResourceVersion, &recordedAt, &e.encoding, &data); err != nil {
+			rows.Close()
+			return 0, 0, fmt.Errorf("failed to scan row: %v", err)
+		}
+		e.RecordedAt = time.Unix(0, recordedAt)
+		if err := replay.apply(&e.Event, e.encoding, data); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("error iterating rows: %v", err)
+	}
+	if len(events) == 0 {
+		return 0, 0, nil
+	}
+
+	// Versions are kept from first onwards
+	first := 0
+	if policy.MaxVersions > 0 && len(events) > policy.MaxVersions {
+		first = len(events) - policy.MaxVersions
+	}
+	if policy.MaxAge > 0 {
+		cutoff := now.Add(-policy.MaxAge)
+		for first < len(events) && events[first].RecordedAt.Before(cutoff) {
+			first++
+		}
+	}
+	if first == len(events) && events[len(events)-1].Type != EventDeleted {
+		first--
+	}
+
+	if first > 0 {
+		limit := events[len(events)-1].ID + 1
+		if first < len(events) {
+			limit = events[first].ID
+		}
+		_, err := tx.Exec(`
+			DELETE FROM events
+			WHERE kind = ? AND api_version = ? AND namespace = ? AND name = ? AND id < ?
+		`, key.Kind, key.APIVersion, key.Namespace, key.Name, limit)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to delete expired versions: %v", err)
+		}
+	}
+
+	// Replace patches that no longer follow a full copy, or follow too many
+	// other patches
+	snapshots := 0
+	patches := 0
+	for i := first; i < len(events); i++ {
+		e := events[i]
+		if e.encoding == encodingPatch && (i == first || patches >= snapshotInterval) {
+			_, err := tx.Exec("UPDATE events SET encoding = ?, data = ? WHERE id = ?", encodingFull, e.Data, e.ID)
+			if err != nil {
+				return 0, 0, fmt.Errorf("failed to write snapshot: %v", err)
+			}
+			snapshots++
+			patches = 0
+		} else if e.encoding == encodingPatch {
+			patches++
+		} else {
+			patches = 0
+		}
+	}
+
+	return first, snapshots, nil
+}
+
+// Vacuum returns the free pages left by deletions to the file system and
+// reports the bytes reclaimed. Databases in incremental auto-vacuum mode
+// free their pages cheaply; others, or any database when full is set, are
+// rebuilt with VACUUM, which also switches them to incremental mode.
+func (s *ResourceStore) Vacuum(full bool) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Pragmas apply to a connection, so run everything on the same one
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	before, err := databaseSize(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	var mode int
+	if err := conn.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return 0, fmt.Errorf("failed to read auto_vacuum mode: %v", err)
+	}
+
+	if full || mode != autoVacuumIncremental {
+		if _, err := conn.ExecContext(ctx, "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+			return 0, fmt.Errorf("failed to set auto_vacuum mode: %v", err)
+		}
+		if _, err := conn.ExecContext(ctx, "VACUUM"); err != nil {
+			return 0, fmt.Errorf("vacuum failed: %v", err)
+		}
+	} else {
+		// Each step of the pragma frees pages, so read it to the end
+		rows, err := conn.QueryContext(ctx, "PRAGMA incremental_vacuum")
+		if err != nil {
+			return 0, fmt.Errorf("incremental vacuum failed: %v", err)
+		}
+		for rows.Next() {
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, fmt.Errorf("incremental vacuum failed: %v", err)
+		}
+	}
+
+	// Shrink the file now rather than at the next automatic checkpoint
+	if _, err := conn.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return 0, fmt.Errorf("checkpoint failed: %v", err)
+	}
+
+	after, err := databaseSize(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	return before - after, nil
+}
+
+// autoVacuumIncremental is the value of PRAGMA auto_vacuum in incremental mode
+const autoVacuumIncremental = 2
+
+// databaseSize returns the size of the database in bytes
+func databaseSize(ctx context.Context, conn *sql.Conn) (int64, error) {
+	var pages, pageSize int64
+	if err := conn.QueryRowContext(ctx, "PRAGMA page_count").Scan(&pages); err != nil {
+		return 0, fmt.Errorf("failed to read page count: %v", err)
+	}
+	if err := conn.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, fmt.Errorf("failed to read page size: %v", err)
+	}
+	return pages * pageSize, nil
+}
+
+// Compactor applies retention policies and vacuums the database in the
+// background
+type Compactor struct {
+	store     *ResourceStore
+	retention Retention
+	interval  time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewCompactor creates a compactor that runs right away, so a database
+// that grew while nothing was watching shrinks at startup, and then every
+// interval until closed. An interval of 0 or less only compacts at startup.
+func NewCompactor(store *ResourceStore, retention Retention, interval time.Duration) *Compactor {
+	c := &Compactor{
+		store:     store,
+		retention: retention,
+		interval:  interval,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// run compacts once and then on every interval until the compactor is
+// closed
+func (c *Compactor) run() {
+	defer close(c.done)
+
+	// Without an interval, the ticker never fires
+	var tick <-chan time.Time
+	if c.interval > 0 {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		stats, err := c.Run()
+		if err != nil {
+			log.Printf("Compaction failed: %v", err)
+		} else {
+			log.Printf("Compaction %s", stats)
+		}
+
+		select {
+		case <-tick:
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Run compacts the history and vacuums the database once
+func (c *Compactor) Run() (CompactionStats, error) {
+	stats, err := c.store.Compact(c.retention)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.ReclaimedBytes, err = c.store.Vacuum(false)
+	return stats, err
+}
+
+// Close stops the compactor, waiting for a running compaction to finish
+func (c *Compactor) Close() {
+	close(c.stop)
+	<-c.done
+}
@@ -0,0 +1,209 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// writeVersions stores n versions of a ConfigMap
+func writeVersions(t *testing.T, store *ResourceStore, name string, n int) ResourceKey {
+	t.Helper()
+	r := Resource{Name: name, Namespace: "default", Kind: "ConfigMap", APIVersion: "v1"}
+	for i := 1; i <= n; i++ {
+		r.ResourceVersion = fmt.Sprint(i)
+		r.Data = fmt.Sprintf(`{"metadata":{"name":%q},"data":{"version":"%d","padding":"%0512d"}}`, name, i, i)
+		if err := store.Upsert(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r.Key()
+}
+
+// age moves the recorded history of a resource into the past
+func age(t *testing.T, store *ResourceStore, key ResourceKey, d time.Duration) {
+	t.Helper()
+	_, err := store.db.Exec("UPDATE events SET recorded_at = recorded_at - ? WHERE kind = ? AND name = ?",
+		d.Nanoseconds(), key.Kind, key.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompactMaxVersions(t *testing.T) {
+	store := newTestStore(t)
+	key := writeVersions(t, store, "settings", 25)
+
+	before, err := store.History(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := store.Compact(Retention{Default: RetentionPolicy{MaxVersions: 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Resources != 1 || stats.EventsDeleted != 20 {
+		t.Errorf("Compact() = %+v, want 20 versions of 1 resource dropped", stats)
+	}
+	if stats.SnapshotsWritten != 1 {
+		t.Errorf("Compact() wrote %d snapshots, want 1 for the first kept patch", stats.SnapshotsWritten)
+	}
+
+	after, err := store.History(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != 5 {
+		t.Fatalf("History() has %d versions, want 5", len(after))
+	}
+	for i, e := range after {
+		if want := before[20+i]; e.Data != want.Data || e.ResourceVersion != want.ResourceVersion {
+			t.Errorf("version %d = %s, want %s", i, e.Data, want.Data)
+		}
+	}
+
+	// Compacting again has nothing to do
+	stats, err = store.Compact(Retention{Default: RetentionPolicy{MaxVersions: 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.EventsDeleted != 0 || stats.SnapshotsWritten != 0 {
+		t.Errorf("second Compact() = %+v, want no changes", stats)
+	}
+}
+
+func TestCompactMaxAge(t *testing.T) {
+	store := newTestStore(t)
+	live := writeVersions(t, store, "live", 3)
+	deleted := writeVersions(t, store, "deleted", 3)
+	if err := store.Delete(deleted.Kind, deleted.APIVersion, deleted.Namespace, deleted.Name); err != nil {
+		t.Fatal(err)
+	}
+	recent := writeVersions(t, store, "recent", 3)
+	age(t, store, live, 48*time.Hour)
+	age(t, store, deleted, 48*time.Hour)
+
+	if _, err := store.Compact(Retention{Default: RetentionPolicy{MaxAge: 24 * time.Hour}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[ResourceKey]int{
+		// The current version of an existing resource is always kept
+		live:    1,
+		deleted: 0,
+		recent:  3,
+	}
+	for key, want := range tests {
+		events, err := store.History(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != want {
+			t.Errorf("History(%s) has %d versions, want %d", key.Name, len(events), want)
+		}
+	}
+
+	// The tombstone goes with the history
+	if n := countRows(t, store, "SELECT COUNT(*) FROM resources WHERE name = 'deleted'"); n != 0 {
+		t.Errorf("expected the expired tombstone to be purged, got %d rows", n)
+	}
+
+	state, err := store.StateAt(live, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state == nil || state.ResourceVersion != "3" {
+		t.Errorf("StateAt(now) = %+v, want version 3", state)
+	}
+}
+
+func TestCompactorRunsAtStartup(t *testing.T) {
+	// Without an interval, the compactor only runs at startup
+	for _, interval := range []time.Duration{time.Hour, 0, -time.Second} {
+		store := newTestStore(t)
+		key := writeVersions(t, store, "settings", 10)
+
+		// The first compaction doesn't wait for the interval
+		compactor := NewCompactor(store, Retention{Default: RetentionPolicy{MaxVersions: 2}}, interval)
+		compactor.Close()
+
+		history, err := store.History(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 2 {
+			t.Errorf("interval %v: History() has %d versions after startup, want 2", interval, len(history))
+		}
+	}
+}
+
+func TestRetentionFor(t *testing.T) {
+	retention := Retention{
+		Default: RetentionPolicy{MaxAge: 30 * 24 * time.Hour, MaxVersions: 100},
+		Kinds: map[string]RetentionPolicy{
+			"Event":      {MaxAge: time.Hour},
+			"Deployment": {MaxAge: 90 * 24 * time.Hour, MaxVersions: 10},
+		},
+	}
+
+	tests := map[string]RetentionPolicy{
+		"event":      {MaxAge: time.Hour, MaxVersions: 100},
+		"Deployment": {MaxAge: 90 * 24 * time.Hour, MaxVersions: 10},
+		"Pod":        retention.Default,
+	}
+	for kind, want := range tests {
+		if got := retention.For(kind); got != want {
+			t.Errorf("For(%q) = %+v, want %+v", kind, got, want)
+		}
+	}
+}
+
+func TestParseKindRetention(t *testing.T) {
+	tests := []struct {
+		input string
+		kind  string
+		want  RetentionPolicy
+		err   bool
+	}{
+		{input: "Event=1h", kind: "Event", want: RetentionPolicy{MaxAge: time.Hour}},
+		{input: "Deployment=90d/50", kind: "Deployment", want: RetentionPolicy{MaxAge: 90 * 24 * time.Hour, MaxVersions: 50}},
+		{input: "Pod=/5", kind: "Pod", want: RetentionPolicy{MaxVersions: 5}},
+		{input: "Pod", err: true},
+		{input: "Pod=soon", err: true},
+		{input: "Pod=1h/0", err: true},
+	}
+	for _, tt := range tests {
+		kind, policy, err := ParseKindRetention(tt.input)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseKindRetention(%q) error = %v, want error %v", tt.input, err, tt.err)
+			continue
+		}
+		if kind != tt.kind || policy != tt.want {
+			t.Errorf("ParseKindRetention(%q) = %q, %+v, want %q, %+v", tt.input, kind, policy, tt.kind, tt.want)
+		}
+	}
+}
+
+func TestVacuumReclaimsSpace(t *testing.T) {
+	store := newTestStore(t)
+	for i := 0; i < 20; i++ {
+		writeVersions(t, store, fmt.Sprintf("config-%d", i), 20)
+	}
+
+	stats, err := store.Compact(Retention{Default: RetentionPolicy{MaxVersions: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.EventsDeleted != 20*19 {
+		t.Errorf("Compact() dropped %d versions, want %d", stats.EventsDeleted, 20*19)
+	}
+
+	reclaimed, err := store.Vacuum(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed <= 0 {
+		t.Errorf("Vacuum() reclaimed %d bytes, want more than 0", reclaimed)
+	}
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/diff"
+)
+
+// ResourceChange describes a resource that differs between two points in time
+type ResourceChange struct {
+	Key    ResourceKey   `json:"key"`
+	From   Resource      `json:"-"`
+	To     Resource      `json:"-"`
+	Fields []diff.Change `json:"fields"`
+}
+
+// SnapshotDiff is the difference between the cluster inventory at two points in time
+type SnapshotDiff struct {
+	From    time.Time        `json:"from"`
+	To      time.Time        `json:"to"`
+	Added   []Resource       `json:"added"`
+	Removed []Resource       `json:"removed"`
+	Changed []ResourceChange `json:"changed"`
+}
+
+// SnapshotAt materializes every resource as it was at the given time.
+// An empty namespace includes all namespaces and cluster-scoped resources.
+func (s *ResourceStore) SnapshotAt(at time.Time, namespace string) ([]Resource, error) {
+	// For every resource, replay its events from the last full copy recorded
+	// before the requested time
+	rows, err := s.db.Query(`
+		WITH base AS (
+			SELECT kind, api_version, namespace, name, MAX(id) AS full_id
+			FROM events
+			WHERE recorded_at <= ? AND encoding = ? AND (? = '' OR namespace = ?)
+			GROUP BY kind, api_version, namespace, name
+		)
+		SELECT e.kind, e.api_version, e.namespace, e.name, e.id, e.type, e.resource_version,
+			e.previous_resource_version, e.recorded_at, e.encoding, e.data
+		FROM events e
+		JOIN base b ON e.kind = b.kind AND e.api_version = b.api_version
+			AND e.namespace = b.namespace AND e.name = b.name AND e.id >= b.full_id
+		WHERE e.recorded_at <= ?
+		ORDER BY e.kind, e.api_version, e.namespace, e.name, e.id
+	`, at.UnixNano(), encodingFull, namespace, namespace, at.UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("snapshot query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var resources []Resource
+	var key ResourceKey
+	var replay eventReplay
+
+	// flush keeps the last version of the previous resource unless it was deleted
+	flush := func() {
+		if last := replay.last; last != nil && last.Type != EventDeleted {
+			resources = append(resources, Resource{
+				Name:            key.Name,
+				Namespace:       key.Namespace,
+				Kind:            key.Kind,
+				APIVersion:      key.APIVersion,
+				ResourceVersion: last.ResourceVersion,
+				Data:            last.Data,
+			})
+		}
+	}
+
+	for rows.Next() {
+		var rowKey ResourceKey
+		var e Event
+		var recordedAt int64
+		var encoding, data string
+		if err := rows.Scan(&rowKey.Kind, &rowKey.APIVersion, &rowKey.Namespace, &rowKey.Name,
+			&e.ID, &e.Type, &e.ResourceVersion, &e.PreviousResourceVersion, &recordedAt, &encoding, &data); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+
+		if rowKey != key {
+			flush()
+			key = rowKey
+			replay = eventReplay{}
+		}
+
+		e.Key = rowKey
+		e.RecordedAt = time.Unix(0, recordedAt)
+		if err := replay.apply(&e, encoding, data); err != nil {
+			return nil, err
+		}
+	}
+	flush()
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %v", err)
+	}
+
+	return resources, nil
+}
+
+// DiffAt compares the inventory at two points in time. Field changes ignore
+// metadata that changes on every write (see diff.DefaultIgnored).
+func (s *ResourceStore) DiffAt(from, to time.Time, namespace string) (*SnapshotDiff, error) {
+	before, err := s.SnapshotAt(from, namespace)
+	if err != nil {
+		return nil, err
+	}
+	after, err := s.SnapshotAt(to, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	result := DiffSnapshots(before, after)
+	result.From, result.To = from, to
+	return result, nil
+}
+
+// DiffSnapshots compares two inventories
+func DiffSnapshots(before, after []Resource) *SnapshotDiff {
+	result := &SnapshotDiff{}
+
+	beforeByKey := make(map[ResourceKey]Resource, len(before))
+	for _, r := range before {
+		beforeByKey[r.Key()] = r
+	}
+
+	afterKeys := make(map[ResourceKey]bool, len(after))
+	for _, r := range after {
+		afterKeys[r.Key()] = true
+
+		old, existed := beforeByKey[r.Key()]
+		if !existed {
+			result.Added = append(result.Added, r)
+			continue
+		}
+		if old.ResourceVersion == r.ResourceVersion && old.Data == r.Data {
+			continue
+		}
+
+		oldObj, err := decodeObject(old.Data)
+		if err != nil {
+			continue
+		}
+		newObj, err := decodeObject(r.Data)
+		if err != nil {
+			continue
+		}
+
+		if fields := diff.Objects(oldObj, newObj, diff.DefaultIgnored...); len(fields) > 0 {
+			result.Changed = append(result.Changed, ResourceChange{
+				Key:    r.Key(),
+				From:   old,
+				To:     r,
+				Fields: fields,
+			})
+		}
+	}
+
+	for _, r := range before {
+		if !afterKeys[r.Key()] {
+			result.Removed = append(result.Removed, r)
+		}
+	}
+
+	sort.Slice(result.Added, func(i, j int) bool { return keyLess(result.Added[i].Key(), result.Added[j].Key()) })
+	sort.Slice(result.Removed, func(i, j int) bool { return keyLess(result.Removed[i].Key(), result.Removed[j].Key()) })
+	sort.Slice(result.Changed, func(i, j int) bool { return keyLess(result.Changed[i].Key, result.Changed[j].Key) })
+
+	return result
+}
+
+// keyLess orders keys by namespace, kind and name
+func keyLess(a, b ResourceKey) bool {
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	if a.Kind != b.Kind {
+		return a.Kind < b.Kind
+	}
+	return a.Name < b.Name
+}
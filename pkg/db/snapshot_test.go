@@ -0,0 +1,212 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/diff"
+)
+
+// keys returns namespace/kind/name of each resource in order
+func keys(resources []Resource) []string {
+	result := []string{}
+	for _, r := range resources {
+		result = append(result, fmt.Sprintf("%s/%s/%s", r.Namespace, r.Kind, r.Name))
+	}
+	return result
+}
+
+// importSnapshotHistory stores a history in which a ConfigMap changes
+// often, another one is deleted, and a Deployment is deleted and created
+// again between start and start+5m
+func importSnapshotHistory(t *testing.T, store *ResourceStore, start time.Time) {
+	t.Helper()
+	config := func(name, namespace, version, data string) Resource {
+		return Resource{Name: name, Namespace: namespace, Kind: "ConfigMap", APIVersion: "v1", ResourceVersion: version,
+			Data: fmt.Sprintf(`{"metadata":{"name":%q,"resourceVersion":%q},"data":%s}`, name, version, data)}
+	}
+	deployment := func(version string, replicas int, image string) Resource {
+		return Resource{Name: "web", Namespace: "prod", Kind: "Deployment", APIVersion: "apps/v1", ResourceVersion: version,
+			Data: fmt.Sprintf(`{"metadata":{"name":"web","resourceVersion":%q,"generation":%s},`+
+				`"spec":{"replicas":%d,"template":{"spec":{"containers":[{"name":"web","image":%q}]}}}}`,
+				version, version, replicas, image)}
+	}
+
+	changes := []Change{
+		{Type: EventAdded, Resource: config("settings", "default", "1", `{"mode":"a"}`), Time: start},
+		{Type: EventAdded, Resource: config("old", "default", "2", `{}`), Time: start},
+		{Type: EventAdded, Resource: deployment("3", 1, "web:1"), Time: start},
+		{Type: EventModified, Resource: deployment("4", 3, "web:1"), Time: start.Add(time.Minute)},
+		{Type: EventDeleted, Resource: config("old", "default", "2", `{}`), Time: start.Add(2 * time.Minute)},
+		{Type: EventDeleted, Resource: deployment("4", 3, "web:1"), Time: start.Add(3 * time.Minute)},
+		{Type: EventAdded, Resource: deployment("20", 3, "web:2"), Time: start.Add(4 * time.Minute)},
+		{Type: EventAdded, Resource: config("new", "default", "21", `{}`), Time: start.Add(5 * time.Minute)},
+	}
+	// Enough modifications that the settings are replayed from a later full copy
+	for i := 0; i < 2*snapshotInterval; i++ {
+		changes = append(changes, Change{
+			Type:     EventModified,
+			Resource: config("settings", "default", fmt.Sprint(100+i), fmt.Sprintf(`{"mode":"b","step":"%d"}`, i)),
+			Time:     start.Add(time.Minute + time.Duration(i)*time.Second),
+		})
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Time.Before(changes[j].Time) })
+
+	if err := store.Import(changes); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotAt(t *testing.T) {
+	store := newTestStore(t)
+	start := time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC)
+	importSnapshotHistory(t, store, start)
+
+	tests := []struct {
+		name      string
+		at        time.Time
+		namespace string
+		want      []string
+	}{
+		{name: "before anything was stored", at: start.Add(-time.Minute), want: []string{}},
+		{
+			name: "at the start",
+			at:   start,
+			want: []string{"default/ConfigMap/old", "default/ConfigMap/settings", "prod/Deployment/web"},
+		},
+		{
+			name: "while the Deployment was deleted",
+			at:   start.Add(3*time.Minute + 30*time.Second),
+			want: []string{"default/ConfigMap/settings"},
+		},
+		{
+			name: "after it was created again",
+			at:   start.Add(6 * time.Minute),
+			want: []string{"default/ConfigMap/new", "default/ConfigMap/settings", "prod/Deployment/web"},
+		},
+		{name: "in a namespace", at: start.Add(6 * time.Minute), namespace: "prod", want: []string{"prod/Deployment/web"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resources, err := store.SnapshotAt(tt.at, tt.namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := keys(resources); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SnapshotAt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Versions are reconstructed from patches as they were at the time
+	for _, tt := range []struct {
+		at      time.Time
+		version string
+		data    string
+	}{
+		{start.Add(30 * time.Second), "1", `{"mode":"a"}`},
+		{start.Add(time.Minute + 4*time.Second), "104", `{"mode":"b","step":"4"}`},
+		{start.Add(time.Minute + 15*time.Second), "115", `{"mode":"b","step":"15"}`},
+		{start.Add(10 * time.Minute), "119", `{"mode":"b","step":"19"}`},
+	} {
+		resources, err := store.SnapshotAt(tt.at, "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var settings *Resource
+		for i := range resources {
+			if resources[i].Name == "settings" {
+				settings = &resources[i]
+			}
+		}
+		want := fmt.Sprintf(`{"metadata":{"name":"settings","resourceVersion":%q},"data":%s}`, tt.version, tt.data)
+		if settings == nil || settings.ResourceVersion != tt.version || !sameJSON(t, settings.Data, want) {
+			t.Errorf("settings at %s = %+v, want version %s", tt.at.Format(time.TimeOnly), settings, tt.version)
+		}
+	}
+}
+
+func TestDiffAt(t *testing.T) {
+	store := newTestStore(t)
+	start := time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC)
+	importSnapshotHistory(t, store, start)
+
+	result, err := store.DiffAt(start, start.Add(6*time.Minute), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := keys(result.Added); !reflect.DeepEqual(got, []string{"default/ConfigMap/new"}) {
+		t.Errorf("Added = %v", got)
+	}
+	if got := keys(result.Removed); !reflect.DeepEqual(got, []string{"default/ConfigMap/old"}) {
+		t.Errorf("Removed = %v", got)
+	}
+	if !result.From.Equal(start) || !result.To.Equal(start.Add(6*time.Minute)) {
+		t.Errorf("DiffAt() covers %s to %s", result.From, result.To)
+	}
+
+	// The Deployment deleted and created again in between is compared as a
+	// change, without the metadata that changes on every write
+	changed := map[string][]string{}
+	for _, c := range result.Changed {
+		for _, field := range c.Fields {
+			changed[c.Key.Name] = append(changed[c.Key.Name], field.String())
+		}
+	}
+	want := map[string][]string{
+		"settings": {`data.mode: "a" -> "b"`, `data.step: added "19"`},
+		"web":      {"spec.replicas: 1 -> 3", `spec.template.spec.containers[0].image: "web:1" -> "web:2"`},
+	}
+	if !reflect.DeepEqual(changed, want) {
+		t.Errorf("Changed = %v, want %v", changed, want)
+	}
+
+	// Across the deletion the Deployment was removed, then added
+	result, err = store.DiffAt(start.Add(2*time.Minute), start.Add(3*time.Minute), "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := keys(result.Removed); !reflect.DeepEqual(got, []string{"prod/Deployment/web"}) || len(result.Added) != 0 {
+		t.Errorf("DiffAt(deletion) removed %v and added %v", got, keys(result.Added))
+	}
+	result, err = store.DiffAt(start.Add(3*time.Minute), start.Add(4*time.Minute), "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := keys(result.Added); !reflect.DeepEqual(got, []string{"prod/Deployment/web"}) || len(result.Removed) != 0 {
+		t.Errorf("DiffAt(re-creation) added %v and removed %v", got, keys(result.Removed))
+	}
+
+	// Nothing changes between two times without events
+	result, err = store.DiffAt(start.Add(10*time.Minute), start.Add(20*time.Minute), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Added)+len(result.Removed)+len(result.Changed) != 0 {
+		t.Errorf("DiffAt() without events = %+v, want no differences", result)
+	}
+}
+
+func TestDiffSnapshotsIgnoresWriteMetadata(t *testing.T) {
+	before := []Resource{{Name: "web", Kind: "Deployment", APIVersion: "apps/v1", ResourceVersion: "1",
+		Data: `{"metadata":{"name":"web","resourceVersion":"1","generation":1,"managedFields":[{"manager":"a"}]}}`}}
+	after := []Resource{{Name: "web", Kind: "Deployment", APIVersion: "apps/v1", ResourceVersion: "2",
+		Data: `{"metadata":{"name":"web","resourceVersion":"2","generation":2,"managedFields":[{"manager":"b"}]}}`}}
+
+	result := DiffSnapshots(before, after)
+	if len(result.Changed) != 0 {
+		t.Errorf("Changed = %+v, want none for metadata that changes on every write", result.Changed)
+	}
+
+	after[0].Data = `{"metadata":{"name":"web","resourceVersion":"2","labels":{"app":"web"}}}`
+	result = DiffSnapshots(before, after)
+	want := []diff.Change{{Path: "metadata.labels", New: map[string]interface{}{"app": "web"}}}
+	if len(result.Changed) != 1 || !reflect.DeepEqual(result.Changed[0].Fields, want) {
+		t.Errorf("Changed = %+v, want the added label", result.Changed)
+	}
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/query"
+)
+
+// Store is the resource storage used by the TUI and commands. ResourceStore
+// implements it with SQLite; the memory and postgres packages provide
+// alternatives. Every implementation records the history of each resource.
+type Store interface {
+	// Upsert adds or updates a resource and records the change
+	Upsert(resource Resource) error
+	// Delete keeps the final state of a resource as a tombstone and records
+	// the deletion
+	Delete(kind, apiVersion, namespace, name string) error
+	// PurgeType removes every resource of a type with its tombstones and
+	// history, in a namespace or in all namespaces when it is empty, and
+	// returns the number of resources removed
+	PurgeType(kind, apiVersion, namespace string) (int, error)
+	// Search matches a substring against name, namespace and kind,
+	// excluding tombstones
+	Search(query string) ([]Resource, error)
+	// ResourceCount returns the number of stored resources, excluding
+	// tombstones
+	ResourceCount() (int, error)
+	// History returns the recorded versions of a resource, oldest first
+	History(key ResourceKey) ([]Event, error)
+	// StateAt returns a resource as it was at the given time, or nil
+	StateAt(key ResourceKey, at time.Time) (*Resource, error)
+	// Query returns the resources matching a parsed query; tombstones only
+	// match queries with a deleted term
+	Query(q *query.Query) ([]Resource, error)
+	// QueryValues returns the stored values of a query key, for completion
+	QueryValues(key string) ([]string, error)
+	// Close releases the store
+	Close() error
+}
+
+// FullTextSearcher is implemented by stores that rank resources by their
+// contents
+type FullTextSearcher interface {
+	FullTextSearch(query string, limit int) ([]SearchResult, error)
+}
+
+// GraphStore is implemented by stores that relate resources to each other
+type GraphStore interface {
+	Tree(r Resource) (*Node, error)
+}
+
+// EventStore is implemented by stores that relate Kubernetes Events to the
+// objects they are about
+type EventStore interface {
+	ObjectEvents(r Resource) ([]Resource, error)
+}
+
+var (
+	_ Store            = (*ResourceStore)(nil)
+	_ FullTextSearcher = (*ResourceStore)(nil)
+	_ GraphStore       = (*ResourceStore)(nil)
+	_ EventStore       = (*ResourceStore)(nil)
+)
@@ -0,0 +1,56 @@
+package db_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db/storetest"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/query"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) db.Store {
+		store, err := db.New(filepath.Join(t.TempDir(), "resources.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+func TestQueryAllIsUnlimited(t *testing.T) {
+	store, err := db.New(filepath.Join(t.TempDir(), "resources.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for i := 0; i < 150; i++ {
+		name := fmt.Sprintf("config-%03d", i)
+		err := store.Upsert(db.Resource{
+			Name: name, Namespace: "default", Kind: "ConfigMap", APIVersion: "v1", ResourceVersion: "1",
+			Data: fmt.Sprintf(`{"metadata":{"name":%q}}`, name),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q, err := query.Parse("kind:ConfigMap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited, err := store.Query(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := store.QueryAll(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limited) != 100 || len(all) != 150 {
+		t.Errorf("Query() returned %d and QueryAll() %d resources, want 100 and 150", len(limited), len(all))
+	}
+}
@@ -0,0 +1,180 @@
+package storetest
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/health"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/query"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher/replay"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// rollout is a recording of a Deployment in prod becoming available while
+// one of its Pods starts, and another crashes and is deleted
+//
+//go:embed testdata/rollout.json
+var rollout []byte
+
+// rolloutEvents is the number of events in the rollout recording
+const rolloutEvents = 7
+
+// Replay stores the events of the rollout recording, replayed through a
+// watcher the way the watcher and the TUI store them
+func Replay(t *testing.T, store db.Store) {
+	t.Helper()
+	fixture, err := replay.Parse(rollout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := replay.NewClient(fixture, 0)
+
+	w, err := watcher.NewWatcherWithClients(watcher.Options{
+		ResourceTypes: []watcher.ResourceToWatch{
+			{Kind: "Pod", APIVersion: "v1"},
+			{Kind: "Deployment", APIVersion: "apps/v1"},
+		},
+	}, watcher.Clients{Dynamic: client, Discovery: client.Discovery()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	stored := 0
+	handle := func(event watcher.ResourceEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		stored++
+
+		switch event.Type {
+		case watch.Added, watch.Modified:
+			data, err := json.Marshal(event.Object)
+			if err != nil {
+				t.Errorf("failed to encode %s: %v", event.Name, err)
+				return
+			}
+			r := db.Resource{
+				Name:            event.Name,
+				Namespace:       event.Namespace,
+				Kind:            event.Resource.Kind,
+				APIVersion:      event.Resource.APIVersion,
+				ResourceVersion: event.ResourceVersion,
+				Data:            string(data),
+			}
+			if err := store.Upsert(r); err != nil {
+				t.Errorf("Upsert(%s): %v", event.Name, err)
+			}
+		case watch.Deleted:
+			if err := store.Delete(event.Resource.Kind, event.Resource.APIVersion, event.Namespace, event.Name); err != nil {
+				t.Errorf("Delete(%s): %v", event.Name, err)
+			}
+		}
+	}
+	if err := w.Start(context.Background(), handle); err != nil {
+		t.Fatal(err)
+	}
+
+	// The watches stay open, as they were when the recording ended
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := stored
+		mu.Unlock()
+		if n >= rolloutEvents {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stored %d events, want %d", n, rolloutEvents)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if running := w.Stop(ctx); running != nil {
+		t.Errorf("watchers still running after Stop: %v", running)
+	}
+	if n := client.Remaining(); n != 0 {
+		t.Errorf("%d recorded calls weren't replayed", n)
+	}
+}
+
+func testReplay(t *testing.T, store db.Store) {
+	Replay(t, store)
+
+	run := func(input string) []db.Resource {
+		t.Helper()
+		q, err := query.Parse(input)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", input, err)
+		}
+		resources, err := store.Query(q)
+		if err != nil {
+			t.Fatalf("Query(%q): %v", input, err)
+		}
+		return resources
+	}
+
+	got := names(run("ns:prod"))
+	sort.Strings(got)
+	if want := []string{"web", "web-2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Query(ns:prod) = %v, want %v", got, want)
+	}
+	if got := names(run("health:healthy")); len(got) != 2 {
+		t.Errorf("Query(health:healthy) = %v, want web and web-2", got)
+	}
+
+	// The crashing Pod was deleted in its last state
+	deleted := run("deleted:true")
+	if len(deleted) != 1 || deleted[0].Name != "web-1" || deleted[0].Health != health.Missing {
+		t.Fatalf("Query(deleted:true) = %+v, want the web-1 tombstone", deleted)
+	}
+	if deleted[0].ResourceVersion != "15" {
+		t.Errorf("tombstone resourceVersion = %s, want 15", deleted[0].ResourceVersion)
+	}
+
+	tests := []struct {
+		key   db.ResourceKey
+		types []string
+		// versions are the resource versions recorded, oldest first
+		versions []string
+	}{
+		{
+			key:      db.ResourceKey{Kind: "Pod", APIVersion: "v1", Namespace: "prod", Name: "web-1"},
+			types:    []string{db.EventAdded, db.EventModified, db.EventDeleted},
+			versions: []string{"10", "15", "15"},
+		},
+		{
+			key:      db.ResourceKey{Kind: "Pod", APIVersion: "v1", Namespace: "prod", Name: "web-2"},
+			types:    []string{db.EventAdded, db.EventModified},
+			versions: []string{"11", "13"},
+		},
+		{
+			key:      db.ResourceKey{Kind: "Deployment", APIVersion: "apps/v1", Namespace: "prod", Name: "web"},
+			types:    []string{db.EventAdded, db.EventModified},
+			versions: []string{"12", "14"},
+		},
+	}
+	for _, tt := range tests {
+		events, err := store.History(tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var types, versions []string
+		for _, e := range events {
+			types = append(types, e.Type)
+			versions = append(versions, e.ResourceVersion)
+		}
+		if !reflect.DeepEqual(types, tt.types) || !reflect.DeepEqual(versions, tt.versions) {
+			t.Errorf("History(%s) = %v %v, want %v %v", tt.key.Name, types, versions, tt.types, tt.versions)
+		}
+	}
+}
@@ -0,0 +1,533 @@
+// Package storetest checks that a db.Store implementation behaves like the
+// SQLite store, so every backend can run the same tests
+package storetest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/health"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/query"
+)
+
+// Run runs the conformance tests. open must return an empty store, which
+// Run closes when each test ends.
+func Run(t *testing.T, open func(t *testing.T) db.Store) {
+	tests := map[string]func(t *testing.T, store db.Store){
+		"UpsertAndSearch": testUpsertAndSearch,
+		"UnchangedUpsert": testUnchangedUpsert,
+		"Delete":          testDelete,
+		"Tombstones":      testTombstones,
+		"PurgeType":       testPurgeType,
+		"History":         testHistory,
+		"Query":           testQuery,
+		"QueryValues":     testQueryValues,
+		"Replay":          testReplay,
+		"ObjectEvents":    testObjectEvents,
+		"Health":          testHealth,
+	}
+
+	names := make([]string, 0, len(tests))
+	for name := range tests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			t.Cleanup(func() { store.Close() })
+			tests[name](t, store)
+		})
+	}
+}
+
+// fixtures are the resources most tests start from
+var fixtures = []db.Resource{
+	{Name: "web", Namespace: "prod", Kind: "Deployment", APIVersion: "apps/v1", ResourceVersion: "1",
+		Data: `{"metadata":{"name":"web","namespace":"prod","labels":{"app":"web","tier":"frontend"},` +
+			`"creationTimestamp":"2020-01-01T00:00:00Z"},"spec":{"replicas":3,"paused":false}}`},
+	{Name: "web-1", Namespace: "prod", Kind: "Pod", APIVersion: "v1", ResourceVersion: "2",
+		Data: `{"metadata":{"name":"web-1","namespace":"prod","labels":{"app":"web"}},` +
+			`"spec":{"containers":[{"name":"nginx","image":"nginx:1.25"}]},"status":{"phase":"Running"}}`},
+	{Name: "db-0", Namespace: "staging", Kind: "Pod", APIVersion: "v1", ResourceVersion: "3",
+		Data: `{"metadata":{"name":"db-0","namespace":"staging","labels":{"app":"db"}},` +
+			`"spec":{"containers":[{"name":"postgres","image":"postgres:16"}]},"status":{"phase":"Pending"}}`},
+	{Name: "kube-system", Kind: "Namespace", APIVersion: "v1", ResourceVersion: "4",
+		Data: `{"metadata":{"name":"kube-system"}}`},
+}
+
+func load(t *testing.T, store db.Store) {
+	t.Helper()
+	for _, r := range fixtures {
+		if err := store.Upsert(r); err != nil {
+			t.Fatalf("Upsert(%s): %v", r.Name, err)
+		}
+	}
+}
+
+// names returns the names of resources in the order given
+func names(resources []db.Resource) []string {
+	result := []string{}
+	for _, r := range resources {
+		result = append(result, r.Name)
+	}
+	return result
+}
+
+func testUpsertAndSearch(t *testing.T, store db.Store) {
+	load(t, store)
+
+	count, err := store.ResourceCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(fixtures) {
+		t.Errorf("ResourceCount() = %d, want %d", count, len(fixtures))
+	}
+
+	// Results are ordered by namespace, kind and name
+	tests := map[string][]string{
+		"":       {"kube-system", "web", "web-1", "db-0"},
+		"WEB":    {"web", "web-1"},
+		"pod":    {"web-1", "db-0"},
+		"stag":   {"db-0"},
+		"absent": {},
+	}
+	for q, want := range tests {
+		got, err := store.Search(q)
+		if err != nil {
+			t.Fatalf("Search(%q): %v", q, err)
+		}
+		if !reflect.DeepEqual(names(got), want) {
+			t.Errorf("Search(%q) = %v, want %v", q, names(got), want)
+		}
+	}
+
+	updated := fixtures[0]
+	updated.ResourceVersion = "10"
+	updated.Data = `{"metadata":{"name":"web","namespace":"prod"},"spec":{"replicas":5}}`
+	if err := store.Upsert(updated); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Search("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ResourceVersion != "10" || got[0].Data != updated.Data {
+		t.Errorf("Search after update returned %+v", got)
+	}
+}
+
+func testUnchangedUpsert(t *testing.T, store db.Store) {
+	load(t, store)
+	load(t, store)
+
+	events, err := store.History(fixtures[0].Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Errorf("relisting recorded %d events, want 1", len(events))
+	}
+}
+
+func testDelete(t *testing.T, store db.Store) {
+	load(t, store)
+
+	r := fixtures[1]
+	if err := store.Delete(r.Kind, r.APIVersion, r.Namespace, r.Name); err != nil {
+		t.Fatal(err)
+	}
+	// Deleting something unknown is not an error
+	if err := store.Delete("Pod", "v1", "prod", "missing"); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := store.ResourceCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(fixtures)-1 {
+		t.Errorf("ResourceCount() = %d, want %d", count, len(fixtures)-1)
+	}
+
+	events, err := store.History(r.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1].Type != db.EventDeleted {
+		t.Fatalf("History() = %+v, want ADDED then DELETED", events)
+	}
+	if events[1].Data != r.Data {
+		t.Errorf("deletion recorded %s, want the final state %s", events[1].Data, r.Data)
+	}
+}
+
+func testTombstones(t *testing.T, store db.Store) {
+	load(t, store)
+
+	r := fixtures[1]
+	before := time.Now()
+	if err := store.Delete(r.Kind, r.APIVersion, r.Namespace, r.Name); err != nil {
+		t.Fatal(err)
+	}
+	// Deleting a tombstone again changes nothing
+	if err := store.Delete(r.Kind, r.APIVersion, r.Namespace, r.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(input string) []db.Resource {
+		t.Helper()
+		q, err := query.Parse(input)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", input, err)
+		}
+		resources, err := store.Query(q)
+		if err != nil {
+			t.Fatalf("Query(%q): %v", input, err)
+		}
+		return resources
+	}
+
+	if got := names(run("web")); !reflect.DeepEqual(got, []string{"web"}) {
+		t.Errorf("tombstone found by default: %v", got)
+	}
+	if got, err := store.Search("web"); err != nil || !reflect.DeepEqual(names(got), []string{"web"}) {
+		t.Errorf("Search found tombstone: %v, %v", names(got), err)
+	}
+
+	deleted := run("deleted:true")
+	if len(deleted) != 1 || deleted[0].Name != r.Name {
+		t.Fatalf("Query(deleted:true) = %v, want [%s]", names(deleted), r.Name)
+	}
+	if !deleted[0].Deleted() || deleted[0].DeletedAt.Before(before.Add(-time.Second)) {
+		t.Errorf("tombstone DeletedAt = %v, want the deletion time", deleted[0].DeletedAt)
+	}
+	if deleted[0].Data != r.Data || deleted[0].ResourceVersion != r.ResourceVersion {
+		t.Errorf("tombstone = %+v, want the final state", deleted[0])
+	}
+	if got := names(run("deleted:true label:app=web")); !reflect.DeepEqual(got, []string{r.Name}) {
+		t.Errorf("Query(deleted:true label:app=web) = %v", got)
+	}
+	if got := names(run("-deleted:true kind:Pod")); !reflect.DeepEqual(got, []string{"db-0"}) {
+		t.Errorf("Query(-deleted:true kind:Pod) = %v", got)
+	}
+
+	// Creating the resource again revives it with a new history
+	revived := r
+	revived.ResourceVersion = "20"
+	if err := store.Upsert(revived); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(run("deleted:true")); len(got) != 0 {
+		t.Errorf("Query(deleted:true) after re-creation = %v", got)
+	}
+	live := run("name:" + r.Name)
+	if len(live) != 1 || live[0].Deleted() || live[0].ResourceVersion != "20" {
+		t.Errorf("re-created resource = %+v", live)
+	}
+
+	events, err := store.History(r.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	if want := []string{db.EventAdded, db.EventDeleted, db.EventAdded}; !reflect.DeepEqual(types, want) {
+		t.Errorf("History() types = %v, want %v", types, want)
+	}
+}
+
+func testPurgeType(t *testing.T, store db.Store) {
+	load(t, store)
+
+	// Tombstones are purged along with live resources
+	web1 := fixtures[1]
+	if err := store.Delete(web1.Kind, web1.APIVersion, web1.Namespace, web1.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := store.PurgeType("Pod", "v1", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("PurgeType(Pod in prod) = %d, want 1", purged)
+	}
+	if events, err := store.History(web1.Key()); err != nil || len(events) != 0 {
+		t.Errorf("History() of a purged resource = %+v, %v, want none", events, err)
+	}
+
+	// Other namespaces and kinds are kept until purged themselves
+	q := &query.Query{Terms: []query.Term{{Key: query.KeyDeleted, Value: "true"}}}
+	if deleted, err := store.Query(q); err != nil || len(deleted) != 0 {
+		t.Errorf("tombstones after purge = %v, %v", names(deleted), err)
+	}
+	count, err := store.ResourceCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("ResourceCount() = %d after purging one namespace, want 3", count)
+	}
+
+	if purged, err := store.PurgeType("Pod", "v1", ""); err != nil || purged != 1 {
+		t.Errorf("PurgeType(Pod) = %d, %v, want 1", purged, err)
+	}
+	if count, err := store.ResourceCount(); err != nil || count != 2 {
+		t.Errorf("ResourceCount() = %d, %v after purging Pods, want 2", count, err)
+	}
+	if purged, err := store.PurgeType("Pod", "v1", ""); err != nil || purged != 0 {
+		t.Errorf("PurgeType(Pod) again = %d, %v, want 0", purged, err)
+	}
+}
+
+func testHistory(t *testing.T, store db.Store) {
+	r := fixtures[0]
+	if err := store.Upsert(r); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	between := time.Now()
+	time.Sleep(5 * time.Millisecond)
+
+	updated := r
+	updated.ResourceVersion = "10"
+	updated.Data = `{"metadata":{"name":"web","namespace":"prod"},"spec":{"replicas":5}}`
+	if err := store.Upsert(updated); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(r.Kind, r.APIVersion, r.Namespace, r.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := store.History(r.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	want := []string{db.EventAdded, db.EventModified, db.EventDeleted}
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("History() types = %v, want %v", types, want)
+	}
+	if events[1].Data != updated.Data || events[1].PreviousResourceVersion != "1" {
+		t.Errorf("modification = %+v", events[1])
+	}
+
+	state, err := store.StateAt(r.Key(), between)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state == nil || state.ResourceVersion != "1" || state.Data != r.Data {
+		t.Errorf("StateAt(between) = %+v, want the first version", state)
+	}
+
+	state, err = store.StateAt(r.Key(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != nil {
+		t.Errorf("StateAt(now) = %+v, want nil after deletion", state)
+	}
+
+	state, err = store.StateAt(r.Key(), between.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != nil {
+		t.Errorf("StateAt(before creation) = %+v, want nil", state)
+	}
+}
+
+func testQuery(t *testing.T, store db.Store) {
+	load(t, store)
+
+	tests := map[string][]string{
+		"kind:pod":                       {"web-1", "db-0"},
+		"kind:Pod,Namespace":             {"kube-system", "web-1", "db-0"},
+		"ns:prod":                        {"web", "web-1"},
+		"-ns:prod":                       {"kube-system", "db-0"},
+		"name:web*":                      {"web", "web-1"},
+		"label:app=web":                  {"web", "web-1"},
+		"label:app!=web":                 {"kube-system", "db-0"},
+		"label:tier":                     {"web"},
+		"status.phase=Running":           {"web-1"},
+		"status.phase!=Running kind:Pod": {"db-0"},
+		"spec.replicas>=3":               {"web"},
+		"spec.replicas<3":                {},
+		"spec.paused=false":              {"web"},
+		"spec.containers[0].image=post*": {"db-0"},
+		"text:nginx":                     {"web-1"},
+		"age>1d":                         {"web"},
+		"pod staging":                    {"db-0"},
+	}
+	for input, want := range tests {
+		q, err := query.Parse(input)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", input, err)
+		}
+		got, err := store.Query(q)
+		if err != nil {
+			t.Fatalf("Query(%q): %v", input, err)
+		}
+		if !reflect.DeepEqual(names(got), want) {
+			t.Errorf("Query(%q) = %v, want %v", input, names(got), want)
+		}
+	}
+}
+
+func testQueryValues(t *testing.T, store db.Store) {
+	load(t, store)
+
+	tests := map[string][]string{
+		query.KeyKind:       {"Deployment", "Namespace", "Pod"},
+		query.KeyNamespace:  {"prod", "staging"},
+		query.KeyAPIVersion: {"apps/v1", "v1"},
+		query.KeyLabel:      {"app", "tier"},
+	}
+	for key, want := range tests {
+		got, err := store.QueryValues(key)
+		if err != nil {
+			t.Fatalf("QueryValues(%q): %v", key, err)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("QueryValues(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func testObjectEvents(t *testing.T, store db.Store) {
+	eventStore, ok := store.(db.EventStore)
+	if !ok {
+		t.Skip("the store doesn't relate events to objects")
+	}
+
+	pod := db.Resource{Name: "web-1", Namespace: "prod", Kind: "Pod", APIVersion: "v1", ResourceVersion: "1",
+		Data: `{"metadata":{"name":"web-1","namespace":"prod","uid":"pod-uid"}}`}
+	resources := []db.Resource{
+		pod,
+		{Name: "web-1.a", Namespace: "prod", Kind: "Event", APIVersion: "events.k8s.io/v1", ResourceVersion: "2",
+			Data: `{"metadata":{"name":"web-1.a","uid":"event-a"},"regarding":{"kind":"Pod","uid":"pod-uid"},` +
+				`"type":"Warning","reason":"BackOff"}`},
+		{Name: "web-1.b", Namespace: "prod", Kind: "Event", APIVersion: "v1", ResourceVersion: "3",
+			Data: `{"metadata":{"name":"web-1.b","uid":"event-b"},"involvedObject":{"kind":"Pod","uid":"pod-uid"},` +
+				`"type":"Normal","reason":"Pulled"}`},
+		{Name: "db-0.c", Namespace: "prod", Kind: "Event", APIVersion: "v1", ResourceVersion: "4",
+			Data: `{"metadata":{"name":"db-0.c","uid":"event-c"},"involvedObject":{"kind":"Pod","uid":"other-uid"}}`},
+		// Only Events are related, not other objects with the same fields
+		{Name: "lookalike", Namespace: "prod", Kind: "ConfigMap", APIVersion: "v1", ResourceVersion: "5",
+			Data: `{"metadata":{"name":"lookalike"},"involvedObject":{"uid":"pod-uid"}}`},
+	}
+	for _, r := range resources {
+		if err := store.Upsert(r); err != nil {
+			t.Fatalf("Upsert(%s): %v", r.Name, err)
+		}
+	}
+	// Deleted events are still shown
+	if err := store.Delete("Event", "v1", "prod", "web-1.b"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := eventStore.ObjectEvents(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotNames := names(got)
+	sort.Strings(gotNames)
+	if want := []string{"web-1.a", "web-1.b"}; !reflect.DeepEqual(gotNames, want) {
+		t.Errorf("ObjectEvents() = %v, want %v", gotNames, want)
+	}
+
+	// Busy objects keep every event, oldest first
+	for i := 0; i < 150; i++ {
+		name := fmt.Sprintf("web-1.busy-%03d", i)
+		err := store.Upsert(db.Resource{Name: name, Namespace: "prod", Kind: "Event", APIVersion: "v1", ResourceVersion: "10",
+			Data: fmt.Sprintf(`{"metadata":{"name":%q},"involvedObject":{"kind":"Pod","uid":"pod-uid"},`+
+				`"lastTimestamp":"2024-01-01T10:%02d:%02dZ"}`, name, i/60, i%60)})
+		if err != nil {
+			t.Fatalf("Upsert(%s): %v", name, err)
+		}
+	}
+	got, err = eventStore.ObjectEvents(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 152 || got[len(got)-1].Name != "web-1.busy-149" {
+		t.Errorf("ObjectEvents() of a busy object = %d events, want 152 ending with web-1.busy-149", len(got))
+	}
+
+	// Objects without a uid have no events
+	got, err = eventStore.ObjectEvents(fixtures[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("ObjectEvents() of an unstored object = %v, want none", names(got))
+	}
+}
+
+func testHealth(t *testing.T, store db.Store) {
+	load(t, store)
+	crashing := db.Resource{Name: "web-2", Namespace: "prod", Kind: "Pod", APIVersion: "v1", ResourceVersion: "5",
+		Data: `{"metadata":{"name":"web-2","namespace":"prod"},"status":{"phase":"Running",` +
+			`"containerStatuses":[{"name":"nginx","state":{"waiting":{"reason":"CrashLoopBackOff"}}}]}}`}
+	if err := store.Upsert(crashing); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(input string) []db.Resource {
+		t.Helper()
+		q, err := query.Parse(input)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", input, err)
+		}
+		resources, err := store.Query(q)
+		if err != nil {
+			t.Fatalf("Query(%q): %v", input, err)
+		}
+		return resources
+	}
+
+	degraded := run("health:degraded")
+	if len(degraded) != 1 || degraded[0].Name != "web-2" {
+		t.Fatalf("Query(health:degraded) = %v, want [web-2]", names(degraded))
+	}
+	if degraded[0].Health != health.Degraded || degraded[0].HealthReason != "CrashLoopBackOff" {
+		t.Errorf("health = %s (%s), want Degraded (CrashLoopBackOff)", degraded[0].Health, degraded[0].HealthReason)
+	}
+	got := names(run("kind:Pod health:healthy,progressing"))
+	sort.Strings(got)
+	if want := []string{"db-0", "web-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Query(kind:Pod health:healthy,progressing) = %v, want %v", got, want)
+	}
+
+	// Recovering updates the health
+	crashing.ResourceVersion = "6"
+	crashing.Data = `{"metadata":{"name":"web-2","namespace":"prod"},"status":{"phase":"Running"}}`
+	if err := store.Upsert(crashing); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(run("health:degraded")); len(got) != 0 {
+		t.Errorf("Query(health:degraded) after recovering = %v, want none", got)
+	}
+
+	// Deleted resources are missing, and found without asking for tombstones
+	if err := store.Delete("Pod", "v1", "prod", "web-2"); err != nil {
+		t.Fatal(err)
+	}
+	missing := run("health:missing")
+	if len(missing) != 1 || missing[0].Name != "web-2" || missing[0].Health != health.Missing {
+		t.Errorf("Query(health:missing) = %+v, want the web-2 tombstone", missing)
+	}
+}
@@ -0,0 +1,42 @@
+// Command fixturegen writes a database fixture for the upgrade tests with
+// the store of the checked out version, see ../README.md
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+)
+
+func main() {
+	store, err := db.New(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	resources := []db.Resource{
+		{Name: "web", Namespace: "prod", Kind: "Deployment", APIVersion: "apps/v1", ResourceVersion: "10",
+			Data: `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"web","namespace":"prod","uid":"deploy-uid","labels":{"app":"web"},"generation":2},"spec":{"replicas":3},"status":{"observedGeneration":2,"replicas":3,"updatedReplicas":3,"availableReplicas":3}}`},
+		{Name: "web-1", Namespace: "prod", Kind: "Pod", APIVersion: "v1", ResourceVersion: "11",
+			Data: `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"web-1","namespace":"prod","uid":"pod-uid","labels":{"app":"web"},"ownerReferences":[{"apiVersion":"apps/v1","kind":"ReplicaSet","name":"web-abc","uid":"rs-uid","controller":true}]},"status":{"phase":"Running","containerStatuses":[{"name":"web","state":{"waiting":{"reason":"CrashLoopBackOff"}}}]}}`},
+		{Name: "settings", Namespace: "default", Kind: "ConfigMap", APIVersion: "v1", ResourceVersion: "12",
+			Data: `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"settings","namespace":"default"},"data":{"mode":"a"}}`},
+		{Name: "settings", Namespace: "default", Kind: "ConfigMap", APIVersion: "v1", ResourceVersion: "13",
+			Data: `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"settings","namespace":"default"},"data":{"mode":"b"}}`},
+		{Name: "old", Namespace: "default", Kind: "ConfigMap", APIVersion: "v1", ResourceVersion: "14",
+			Data: `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"old","namespace":"default"}}`},
+		{Name: "web-1.a", Namespace: "prod", Kind: "Event", APIVersion: "v1", ResourceVersion: "15",
+			Data: `{"apiVersion":"v1","kind":"Event","metadata":{"name":"web-1.a","namespace":"prod","uid":"event-uid"},"involvedObject":{"kind":"Pod","name":"web-1","uid":"pod-uid"},"type":"Warning","reason":"BackOff","count":3}`},
+	}
+	for _, r := range resources {
+		if err := store.Upsert(r); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := store.Delete("ConfigMap", "v1", "default", "old"); err != nil {
+		log.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
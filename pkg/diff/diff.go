@@ -0,0 +1,135 @@
+// Package diff computes field-level differences between Kubernetes objects
+package diff
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Change is a difference in a single field between two objects
+type Change struct {
+	// Path is the field path, e.g. spec.template.spec.containers[0].image
+	Path string `json:"path"`
+	// Old is the previous value, nil if the field was added
+	Old interface{} `json:"old,omitempty"`
+	// New is the new value, nil if the field was removed
+	New interface{} `json:"new,omitempty"`
+}
+
+// String formats the change as "path: old -> new"
+func (c Change) String() string {
+	switch {
+	case c.Old == nil:
+		return fmt.Sprintf("%s: added %s", c.Path, formatValue(c.New))
+	case c.New == nil:
+		return fmt.Sprintf("%s: removed %s", c.Path, formatValue(c.Old))
+	default:
+		return fmt.Sprintf("%s: %s -> %s", c.Path, formatValue(c.Old), formatValue(c.New))
+	}
+}
+
+// DefaultIgnored are fields that change on every write and carry no meaning
+// for a reader comparing two versions
+var DefaultIgnored = []string{
+	"metadata.resourceVersion",
+	"metadata.managedFields",
+	"metadata.generation",
+}
+
+// Objects returns the changed fields between two objects, sorted by path.
+// Fields under any of the ignored paths are skipped.
+func Objects(before, after map[string]interface{}, ignored ...string) []Change {
+	var changes []Change
+	compare("", before, after, ignored, &changes)
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes
+}
+
+// compare walks both values and collects differences at leaves
+func compare(path string, before, after interface{}, ignored []string, changes *[]Change) {
+	if isIgnored(path, ignored) {
+		return
+	}
+
+	beforeMap, beforeIsMap := before.(map[string]interface{})
+	afterMap, afterIsMap := after.(map[string]interface{})
+	if beforeIsMap && afterIsMap {
+		for key, value := range beforeMap {
+			compare(joinPath(path, key), value, afterMap[key], ignored, changes)
+		}
+		for key, value := range afterMap {
+			if _, ok := beforeMap[key]; !ok {
+				compare(joinPath(path, key), nil, value, ignored, changes)
+			}
+		}
+		return
+	}
+
+	beforeList, beforeIsList := before.([]interface{})
+	afterList, afterIsList := after.([]interface{})
+	if beforeIsList && afterIsList {
+		for i := 0; i < len(beforeList) || i < len(afterList); i++ {
+			var beforeItem, afterItem interface{}
+			if i < len(beforeList) {
+				beforeItem = beforeList[i]
+			}
+			if i < len(afterList) {
+				afterItem = afterList[i]
+			}
+			compare(fmt.Sprintf("%s[%d]", path, i), beforeItem, afterItem, ignored, changes)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		*changes = append(*changes, Change{Path: path, Old: before, New: after})
+	}
+}
+
+// simpleKey matches map keys that can be written with dot notation
+var simpleKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// joinPath appends a map key to a path, bracketing keys such as label names
+// that contain dots or slashes
+func joinPath(path, key string) string {
+	if !simpleKey.MatchString(key) {
+		return fmt.Sprintf("%s[%s]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// isIgnored reports whether path is one of the ignored paths or below one
+func isIgnored(path string, ignored []string) bool {
+	for _, prefix := range ignored {
+		if path == prefix || strings.HasPrefix(path, prefix+".") || strings.HasPrefix(path, prefix+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+// formatValue renders a value compactly for display
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case map[string]interface{}, []interface{}:
+		s := fmt.Sprintf("%v", v)
+		if len(s) > 80 {
+			s = s[:77] + "..."
+		}
+		return s
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,109 @@
+package diff
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// decode parses a JSON object
+func decode(t *testing.T, data string) map[string]interface{} {
+	t.Helper()
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &obj); err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestObjects(t *testing.T) {
+	tests := []struct {
+		name    string
+		before  string
+		after   string
+		ignored []string
+		want    []string
+	}{
+		{
+			name:   "unchanged",
+			before: `{"spec":{"replicas":1,"ports":[80]}}`,
+			after:  `{"spec":{"replicas":1,"ports":[80]}}`,
+		},
+		{
+			name:   "changed, added and removed fields sorted by path",
+			before: `{"spec":{"replicas":1,"paused":false},"status":{"ready":1}}`,
+			after:  `{"spec":{"replicas":3,"strategy":"Recreate"},"status":{"ready":1}}`,
+			want: []string{
+				"spec.paused: removed false",
+				"spec.replicas: 1 -> 3",
+				`spec.strategy: added "Recreate"`,
+			},
+		},
+		{
+			name:   "list elements by index",
+			before: `{"spec":{"containers":[{"name":"app","image":"app:1"},{"name":"proxy"}]}}`,
+			after:  `{"spec":{"containers":[{"name":"app","image":"app:2"}]}}`,
+			want: []string{
+				`spec.containers[0].image: "app:1" -> "app:2"`,
+				"spec.containers[1]: removed map[name:proxy]",
+			},
+		},
+		{
+			name:   "keys with dots and slashes are bracketed",
+			before: `{"metadata":{"labels":{"app.kubernetes.io/name":"web"}}}`,
+			after:  `{"metadata":{"labels":{"app.kubernetes.io/name":"api"}}}`,
+			want:   []string{`metadata.labels[app.kubernetes.io/name]: "web" -> "api"`},
+		},
+		{
+			name:   "type changes",
+			before: `{"spec":{"value":"3","items":{"a":1}}}`,
+			after:  `{"spec":{"value":3,"items":["a"]}}`,
+			want:   []string{"spec.items: map[a:1] -> [a]", `spec.value: "3" -> 3`},
+		},
+		{
+			name:    "ignored paths and everything below them",
+			before:  `{"metadata":{"resourceVersion":"1","managedFields":[{"manager":"a"}],"generation":1,"name":"a"}}`,
+			after:   `{"metadata":{"resourceVersion":"2","managedFields":[{"manager":"b"}],"generation":2,"name":"b"}}`,
+			ignored: DefaultIgnored,
+			want:    []string{`metadata.name: "a" -> "b"`},
+		},
+		{
+			name:    "ignoring a prefix doesn't ignore longer keys",
+			before:  `{"metadata":{"generation":1,"generationHint":1}}`,
+			after:   `{"metadata":{"generation":2,"generationHint":2}}`,
+			ignored: []string{"metadata.generation"},
+			want:    []string{"metadata.generationHint: 1 -> 2"},
+		},
+		{
+			name:   "long values are shortened",
+			before: `{"data":{}}`,
+			after:  `{"data":{"list":["` + strings.Repeat("x", 100) + `"]}}`,
+			want:   []string{"data.list: added [" + strings.Repeat("x", 76) + "..."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, change := range Objects(decode(t, tt.before), decode(t, tt.after), tt.ignored...) {
+				got = append(got, change.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Objects() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangeJSON(t *testing.T) {
+	changes := Objects(decode(t, `{"a":1,"b":"x"}`), decode(t, `{"a":2,"c":true}`))
+	data, err := json.Marshal(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"path":"a","old":1,"new":2},{"path":"b","old":"x"},{"path":"c","new":true}]`
+	if string(data) != want {
+		t.Errorf("json = %s, want %s", data, want)
+	}
+}
@@ -0,0 +1,209 @@
+// Package events reads Kubernetes Events, of both the core v1 and the
+// events.k8s.io/v1 API, and relates them to the objects they are about
+package events
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Event types
+const (
+	TypeNormal  = "Normal"
+	TypeWarning = "Warning"
+)
+
+// Event is a Kubernetes Event reduced to what both APIs have in common
+type Event struct {
+	UID       string
+	Name      string
+	Namespace string
+	// InvolvedUID is the UID of the object the event is about, its
+	// involvedObject in core v1 and regarding in events.k8s.io/v1
+	InvolvedUID  string
+	InvolvedKind string
+	InvolvedName string
+	// Type is Normal or Warning
+	Type    string
+	Reason  string
+	Message string
+	// Count is the number of occurrences the event stands for
+	Count          int
+	FirstTimestamp time.Time
+	LastTimestamp  time.Time
+	// Source is the controller or component that reported the event
+	Source string
+}
+
+// IsEvent reports whether a kind and API version are those of a Kubernetes
+// Event
+func IsEvent(kind, apiVersion string) bool {
+	return kind == "Event" && (apiVersion == "v1" || strings.HasPrefix(apiVersion, "events.k8s.io/"))
+}
+
+// FromObject reads an Event from its object. It reports false when the
+// object isn't about another object.
+func FromObject(obj map[string]interface{}) (Event, bool) {
+	involved := field(obj, "regarding")
+	if involved == nil {
+		involved = field(obj, "involvedObject")
+	}
+	e := Event{
+		UID:          stringField(obj, "metadata", "uid"),
+		Name:         stringField(obj, "metadata", "name"),
+		Namespace:    stringField(obj, "metadata", "namespace"),
+		InvolvedUID:  stringField(involved, "uid"),
+		InvolvedKind: stringField(involved, "kind"),
+		InvolvedName: stringField(involved, "name"),
+		Type:         stringField(obj, "type"),
+		Reason:       stringField(obj, "reason"),
+		Message:      firstString(obj, []string{"note"}, []string{"message"}),
+		Count:        firstInt(obj, []string{"series", "count"}, []string{"count"}, []string{"deprecatedCount"}),
+		FirstTimestamp: firstTime(obj, []string{"firstTimestamp"}, []string{"deprecatedFirstTimestamp"},
+			[]string{"eventTime"}, []string{"metadata", "creationTimestamp"}),
+		LastTimestamp: firstTime(obj, []string{"series", "lastObservedTime"}, []string{"lastTimestamp"},
+			[]string{"deprecatedLastTimestamp"}, []string{"eventTime"}, []string{"metadata", "creationTimestamp"}),
+		Source: firstString(obj, []string{"reportingController"}, []string{"source", "component"},
+			[]string{"deprecatedSource", "component"}, []string{"reportingComponent"}),
+	}
+	if e.Count == 0 {
+		e.Count = 1
+	}
+	if e.FirstTimestamp.IsZero() || e.FirstTimestamp.After(e.LastTimestamp) {
+		e.FirstTimestamp = e.LastTimestamp
+	}
+	return e, e.InvolvedUID != ""
+}
+
+// Parse reads an Event from its JSON, as stored in the resource database
+func Parse(data string) (Event, bool) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &obj); err != nil {
+		return Event{}, false
+	}
+	return FromObject(obj)
+}
+
+// Summary aggregates the events of an object with the same type and reason
+type Summary struct {
+	Type   string
+	Reason string
+	// Message is the message of the latest event
+	Message        string
+	Count          int
+	FirstTimestamp time.Time
+	LastTimestamp  time.Time
+}
+
+// Aggregate groups events by type and reason, the most recent first. An
+// Event read from both APIs is counted once.
+func Aggregate(events []Event) []Summary {
+	seen := map[string]bool{}
+	index := map[[2]string]int{}
+	var summaries []Summary
+	for _, e := range events {
+		if e.UID != "" {
+			if seen[e.UID] {
+				continue
+			}
+			seen[e.UID] = true
+		}
+
+		key := [2]string{e.Type, e.Reason}
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(summaries)
+			summaries = append(summaries, Summary{
+				Type:           e.Type,
+				Reason:         e.Reason,
+				Message:        e.Message,
+				Count:          e.Count,
+				FirstTimestamp: e.FirstTimestamp,
+				LastTimestamp:  e.LastTimestamp,
+			})
+			continue
+		}
+
+		s := &summaries[i]
+		s.Count += e.Count
+		if e.FirstTimestamp.Before(s.FirstTimestamp) {
+			s.FirstTimestamp = e.FirstTimestamp
+		}
+		if e.LastTimestamp.After(s.LastTimestamp) {
+			s.LastTimestamp = e.LastTimestamp
+			s.Message = e.Message
+		}
+	}
+
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].LastTimestamp.After(summaries[j].LastTimestamp)
+	})
+	return summaries
+}
+
+// field returns a nested object, or nil
+func field(obj map[string]interface{}, path ...string) map[string]interface{} {
+	for _, key := range path {
+		next, ok := obj[key].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		obj = next
+	}
+	return obj
+}
+
+// value returns a nested value, or nil
+func value(obj map[string]interface{}, path []string) interface{} {
+	parent := field(obj, path[:len(path)-1]...)
+	if parent == nil {
+		return nil
+	}
+	return parent[path[len(path)-1]]
+}
+
+// stringField returns a nested string, or ""
+func stringField(obj map[string]interface{}, path ...string) string {
+	s, _ := value(obj, path).(string)
+	return s
+}
+
+// firstString returns the first of the nested strings that is set
+func firstString(obj map[string]interface{}, paths ...[]string) string {
+	for _, path := range paths {
+		if s := stringField(obj, path...); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// firstInt returns the first of the nested numbers that is set, whether
+// decoded as JSON or by the API machinery
+func firstInt(obj map[string]interface{}, paths ...[]string) int {
+	for _, path := range paths {
+		switch n := value(obj, path).(type) {
+		case int64:
+			if n > 0 {
+				return int(n)
+			}
+		case float64:
+			if n > 0 {
+				return int(n)
+			}
+		}
+	}
+	return 0
+}
+
+// firstTime returns the first of the nested timestamps that is set
+func firstTime(obj map[string]interface{}, paths ...[]string) time.Time {
+	for _, path := range paths {
+		if t, err := time.Parse(time.RFC3339Nano, stringField(obj, path...)); err == nil && !t.IsZero() {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,91 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want Event
+	}{
+		{
+			name: "core v1",
+			data: `{"apiVersion":"v1","kind":"Event","metadata":{"name":"web-1.a","namespace":"prod","uid":"e1"},
+				"involvedObject":{"kind":"Pod","name":"web-1","uid":"pod-uid"},"type":"Warning","reason":"BackOff",
+				"message":"Back-off restarting failed container","count":5,"source":{"component":"kubelet"},
+				"firstTimestamp":"2024-01-01T10:00:00Z","lastTimestamp":"2024-01-01T10:05:00Z"}`,
+			want: Event{
+				UID: "e1", Name: "web-1.a", Namespace: "prod",
+				InvolvedUID: "pod-uid", InvolvedKind: "Pod", InvolvedName: "web-1",
+				Type: TypeWarning, Reason: "BackOff", Message: "Back-off restarting failed container", Count: 5,
+				FirstTimestamp: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+				LastTimestamp:  time.Date(2024, 1, 1, 10, 5, 0, 0, time.UTC),
+				Source:         "kubelet",
+			},
+		},
+		{
+			name: "events.k8s.io series",
+			data: `{"apiVersion":"events.k8s.io/v1","kind":"Event","metadata":{"name":"web-1.b","namespace":"prod","uid":"e2"},
+				"regarding":{"kind":"Pod","name":"web-1","uid":"pod-uid"},"type":"Normal","reason":"Pulled",
+				"note":"Image pulled","reportingController":"kubelet","eventTime":"2024-01-01T10:00:00.000000Z",
+				"series":{"count":3,"lastObservedTime":"2024-01-01T10:02:00.500000Z"}}`,
+			want: Event{
+				UID: "e2", Name: "web-1.b", Namespace: "prod",
+				InvolvedUID: "pod-uid", InvolvedKind: "Pod", InvolvedName: "web-1",
+				Type: TypeNormal, Reason: "Pulled", Message: "Image pulled", Count: 3,
+				FirstTimestamp: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+				LastTimestamp:  time.Date(2024, 1, 1, 10, 2, 0, 500000000, time.UTC),
+				Source:         "kubelet",
+			},
+		},
+		{
+			name: "events.k8s.io single",
+			data: `{"metadata":{"name":"web-1.c","uid":"e3"},"regarding":{"uid":"pod-uid"},"type":"Normal",
+				"reason":"Scheduled","eventTime":"2024-01-01T10:00:00.000000Z"}`,
+			want: Event{
+				UID: "e3", Name: "web-1.c", InvolvedUID: "pod-uid", Type: TypeNormal, Reason: "Scheduled", Count: 1,
+				FirstTimestamp: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+				LastTimestamp:  time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Parse(tt.data)
+			if !ok {
+				t.Fatal("Parse() = false, want an event")
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, ok := Parse(`{"metadata":{"name":"x"},"reason":"Orphan"}`); ok {
+		t.Error("Parse() accepted an event that isn't about an object")
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	at := func(minute int) time.Time { return time.Date(2024, 1, 1, 10, minute, 0, 0, time.UTC) }
+	events := []Event{
+		{UID: "a", Type: TypeWarning, Reason: "BackOff", Message: "old", Count: 2, FirstTimestamp: at(1), LastTimestamp: at(2)},
+		{UID: "b", Type: TypeNormal, Reason: "Pulled", Message: "pulled", Count: 1, FirstTimestamp: at(3), LastTimestamp: at(3)},
+		{UID: "c", Type: TypeWarning, Reason: "BackOff", Message: "new", Count: 3, FirstTimestamp: at(0), LastTimestamp: at(5)},
+		// The same Event read from the other API
+		{UID: "c", Type: TypeWarning, Reason: "BackOff", Message: "new", Count: 3, FirstTimestamp: at(0), LastTimestamp: at(5)},
+	}
+
+	want := []Summary{
+		{Type: TypeWarning, Reason: "BackOff", Message: "new", Count: 5, FirstTimestamp: at(0), LastTimestamp: at(5)},
+		{Type: TypeNormal, Reason: "Pulled", Message: "pulled", Count: 1, FirstTimestamp: at(3), LastTimestamp: at(3)},
+	}
+	if got := Aggregate(events); !reflect.DeepEqual(got, want) {
+		t.Errorf("Aggregate() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Warnings keeps the recent Warning events of every object, so they can be
+// reported along with changes to the object
+type Warnings struct {
+	maxAge time.Duration
+
+	mu sync.Mutex
+	// byObject holds the warnings by involved UID, then by event
+	byObject map[string]map[string]Event
+}
+
+// NewWarnings creates a tracker of the warnings seen within maxAge
+func NewWarnings(maxAge time.Duration) *Warnings {
+	return &Warnings{maxAge: maxAge, byObject: map[string]map[string]Event{}}
+}
+
+// eventKey identifies an event, which may be updated as it recurs
+func eventKey(e Event) string {
+	if e.UID != "" {
+		return e.UID
+	}
+	return e.Namespace + "/" + e.Name
+}
+
+// Add records an event, replacing an earlier version of it. Normal events
+// are ignored.
+func (w *Warnings) Add(e Event) {
+	if e.Type != TypeWarning || e.InvolvedUID == "" {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	object := w.byObject[e.InvolvedUID]
+	if object == nil {
+		object = map[string]Event{}
+		w.byObject[e.InvolvedUID] = object
+	}
+	object[eventKey(e)] = e
+	w.prune(e.InvolvedUID, time.Now())
+}
+
+// Remove forgets a deleted event
+func (w *Warnings) Remove(e Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.byObject[e.InvolvedUID], eventKey(e))
+	if len(w.byObject[e.InvolvedUID]) == 0 {
+		delete(w.byObject, e.InvolvedUID)
+	}
+}
+
+// Recent returns the warnings about an object last seen within the max
+// age, aggregated by reason
+func (w *Warnings) Recent(uid string) []Summary {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.prune(uid, time.Now())
+
+	var recent []Event
+	for _, e := range w.byObject[uid] {
+		recent = append(recent, e)
+	}
+	return Aggregate(recent)
+}
+
+// prune drops the warnings about an object that are older than the max age
+func (w *Warnings) prune(uid string, now time.Time) {
+	for key, e := range w.byObject[uid] {
+		if now.Sub(e.LastTimestamp) > w.maxAge {
+			delete(w.byObject[uid], key)
+		}
+	}
+	if len(w.byObject[uid]) == 0 {
+		delete(w.byObject, uid)
+	}
+}
@@ -0,0 +1,128 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWarnings(t *testing.T) {
+	now := time.Now()
+	warning := func(uid, involved, reason string, count int, age time.Duration) Event {
+		return Event{UID: uid, Namespace: "prod", Name: uid, InvolvedUID: involved, Type: TypeWarning,
+			Reason: reason, Count: count, FirstTimestamp: now.Add(-age), LastTimestamp: now.Add(-age)}
+	}
+
+	tests := []struct {
+		name   string
+		add    []Event
+		remove []Event
+		// want are the reasons and counts of the recent warnings about pod
+		want map[string]int
+	}{
+		{
+			name: "recent warnings",
+			add:  []Event{warning("a", "pod", "BackOff", 1, 0), warning("b", "pod", "Unhealthy", 2, 30*time.Minute)},
+			want: map[string]int{"BackOff": 1, "Unhealthy": 2},
+		},
+		{
+			name: "aggregated by reason",
+			add:  []Event{warning("a", "pod", "BackOff", 1, 0), warning("b", "pod", "BackOff", 2, time.Minute)},
+			want: map[string]int{"BackOff": 3},
+		},
+		{
+			name: "later version replaces an event",
+			add:  []Event{warning("a", "pod", "BackOff", 1, time.Minute), warning("a", "pod", "BackOff", 4, 0)},
+			want: map[string]int{"BackOff": 4},
+		},
+		{
+			name: "events without a UID are told apart by name",
+			add: []Event{
+				{Namespace: "prod", Name: "x", InvolvedUID: "pod", Type: TypeWarning, Reason: "BackOff", Count: 1, LastTimestamp: now},
+				{Namespace: "prod", Name: "y", InvolvedUID: "pod", Type: TypeWarning, Reason: "BackOff", Count: 1, LastTimestamp: now},
+				{Namespace: "prod", Name: "x", InvolvedUID: "pod", Type: TypeWarning, Reason: "BackOff", Count: 2, LastTimestamp: now},
+			},
+			want: map[string]int{"BackOff": 3},
+		},
+		{
+			name: "older than the max age",
+			add:  []Event{warning("a", "pod", "BackOff", 1, 2*time.Hour), warning("b", "pod", "Unhealthy", 1, 0)},
+			want: map[string]int{"Unhealthy": 1},
+		},
+		{
+			name: "normal events",
+			add: []Event{
+				{UID: "a", InvolvedUID: "pod", Type: TypeNormal, Reason: "Pulled", Count: 1, LastTimestamp: now},
+				warning("b", "pod", "BackOff", 1, 0),
+			},
+			want: map[string]int{"BackOff": 1},
+		},
+		{
+			name: "events about no object",
+			add:  []Event{warning("a", "", "BackOff", 1, 0)},
+			want: map[string]int{},
+		},
+		{
+			name: "other objects",
+			add:  []Event{warning("a", "other", "BackOff", 1, 0), warning("b", "pod", "Unhealthy", 1, 0)},
+			want: map[string]int{"Unhealthy": 1},
+		},
+		{
+			name:   "removed",
+			add:    []Event{warning("a", "pod", "BackOff", 1, 0), warning("b", "pod", "Unhealthy", 1, 0)},
+			remove: []Event{{UID: "a", InvolvedUID: "pod"}},
+			want:   map[string]int{"Unhealthy": 1},
+		},
+		{
+			name:   "removing unknown events",
+			add:    []Event{warning("a", "pod", "BackOff", 1, 0)},
+			remove: []Event{{UID: "b", InvolvedUID: "pod"}, {UID: "a", InvolvedUID: "other"}},
+			want:   map[string]int{"BackOff": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings := NewWarnings(time.Hour)
+			for _, e := range tt.add {
+				warnings.Add(e)
+			}
+			for _, e := range tt.remove {
+				warnings.Remove(e)
+			}
+
+			got := map[string]int{}
+			for _, s := range warnings.Recent("pod") {
+				got[s.Reason] = s.Count
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Recent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWarningsForgetObjects(t *testing.T) {
+	warnings := NewWarnings(time.Hour)
+	now := time.Now()
+
+	// Expired warnings are dropped when the object gets a new one
+	warnings.byObject["pod"] = map[string]Event{
+		"old": {UID: "old", InvolvedUID: "pod", Type: TypeWarning, LastTimestamp: now.Add(-2 * time.Hour)},
+	}
+	warnings.Add(Event{UID: "new", InvolvedUID: "pod", Type: TypeWarning, LastTimestamp: now})
+	if _, ok := warnings.byObject["pod"]["old"]; ok || len(warnings.byObject["pod"]) != 1 {
+		t.Errorf("warnings of pod = %v, want only the new one", warnings.byObject["pod"])
+	}
+
+	// Objects are forgotten with their last warning
+	warnings.Remove(Event{UID: "new", InvolvedUID: "pod"})
+	warnings.byObject["node"] = map[string]Event{
+		"old": {UID: "old", InvolvedUID: "node", Type: TypeWarning, LastTimestamp: now.Add(-2 * time.Hour)},
+	}
+	if recent := warnings.Recent("node"); len(recent) != 0 {
+		t.Errorf("Recent() of expired warnings = %+v, want none", recent)
+	}
+	if len(warnings.byObject) != 0 {
+		t.Errorf("objects tracked = %v, want none", warnings.byObject)
+	}
+}
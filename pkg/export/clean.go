@@ -0,0 +1,262 @@
+package export
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// serverMetadata are the metadata fields set by the API server
+var serverMetadata = []string{
+	"uid",
+	"resourceVersion",
+	"generation",
+	"creationTimestamp",
+	"deletionTimestamp",
+	"deletionGracePeriodSeconds",
+	"managedFields",
+	"selfLink",
+	"ownerReferences",
+}
+
+// serverAnnotations are annotations written by kubectl and controllers;
+// a trailing / matches every annotation with that prefix
+var serverAnnotations = []string{
+	"kubectl.kubernetes.io/last-applied-configuration",
+	"deployment.kubernetes.io/revision",
+	"pv.kubernetes.io/",
+	"volume.beta.kubernetes.io/storage-provisioner",
+	"volume.kubernetes.io/storage-provisioner",
+	"volume.kubernetes.io/selected-node",
+}
+
+// podDefaults are pod spec fields with the value the API server fills in
+// when they are left out
+var podDefaults = map[string]interface{}{
+	"dnsPolicy":                     "ClusterFirst",
+	"restartPolicy":                 "Always",
+	"schedulerName":                 "default-scheduler",
+	"terminationGracePeriodSeconds": 30,
+	"enableServiceLinks":            true,
+	"preemptionPolicy":              "PreemptLowerPriority",
+	"priority":                      0,
+}
+
+// containerDefaults are container fields with their default values
+var containerDefaults = map[string]interface{}{
+	"terminationMessagePath":   "/dev/termination-log",
+	"terminationMessagePolicy": "File",
+}
+
+// jobLabels are the labels the Job controller adds to the pod template of
+// a Job, matched by the selector it generates
+var jobLabels = []string{
+	"controller-uid",
+	"batch.kubernetes.io/controller-uid",
+	"job-name",
+	"batch.kubernetes.io/job-name",
+}
+
+// workloadDefaults are spec fields of Deployments, StatefulSets,
+// DaemonSets and ReplicaSets with their default values
+var workloadDefaults = map[string]interface{}{
+	"progressDeadlineSeconds": 600,
+	"revisionHistoryLimit":    10,
+	"podManagementPolicy":     "OrderedReady",
+}
+
+// Clean removes, in place, the status, the metadata set by the API server
+// and the spec fields it defaults, leaving what was originally applied
+func Clean(obj map[string]interface{}) {
+	delete(obj, "status")
+	cleanMetadata(obj)
+
+	kind, _ := obj["kind"].(string)
+	spec, _ := obj["spec"].(map[string]interface{})
+	if spec == nil {
+		return
+	}
+
+	switch kind {
+	case "Pod":
+		cleanPodSpec(spec)
+		// Set by the scheduler
+		delete(spec, "nodeName")
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "Job", "CronJob":
+		if kind == "Job" {
+			cleanJobSpec(spec)
+		}
+		removeDefaults(spec, workloadDefaults)
+		cleanTemplate(spec)
+		if jobTemplate, ok := spec["jobTemplate"].(map[string]interface{}); ok {
+			delete(jobTemplate, "metadata")
+			if jobSpec, ok := jobTemplate["spec"].(map[string]interface{}); ok {
+				cleanTemplate(jobSpec)
+			}
+		}
+	case "Service":
+		cleanServiceSpec(spec)
+	case "Namespace":
+		// The namespace lifecycle finalizer is always added
+		delete(spec, "finalizers")
+	}
+
+	if len(spec) == 0 {
+		delete(obj, "spec")
+	}
+}
+
+// cleanMetadata removes the metadata set by the API server and controllers
+func cleanMetadata(obj map[string]interface{}) {
+	metadata, ok := obj["metadata"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, field := range serverMetadata {
+		delete(metadata, field)
+	}
+
+	if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+		for key := range annotations {
+			for _, server := range serverAnnotations {
+				if key == server || (strings.HasSuffix(server, "/") && strings.HasPrefix(key, server)) {
+					delete(annotations, key)
+				}
+			}
+		}
+		if len(annotations) == 0 {
+			delete(metadata, "annotations")
+		}
+	}
+}
+
+// cleanJobSpec removes the selector the Job controller generates, and the
+// labels it adds to the template for it, which are rejected when the Job
+// is created again. Selectors chosen with manualSelector are kept.
+func cleanJobSpec(spec map[string]interface{}) {
+	if spec["manualSelector"] == true {
+		return
+	}
+	delete(spec, "selector")
+
+	template, _ := spec["template"].(map[string]interface{})
+	metadata, _ := template["metadata"].(map[string]interface{})
+	labels, ok := metadata["labels"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, label := range jobLabels {
+		delete(labels, label)
+	}
+	if len(labels) == 0 {
+		delete(metadata, "labels")
+	}
+}
+
+// cleanTemplate cleans the pod template of a workload spec
+func cleanTemplate(spec map[string]interface{}) {
+	template, ok := spec["template"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if metadata, ok := template["metadata"].(map[string]interface{}); ok {
+		// Templates are stored with creationTimestamp: null
+		delete(metadata, "creationTimestamp")
+		if len(metadata) == 0 {
+			delete(template, "metadata")
+		}
+	}
+	if podSpec, ok := template["spec"].(map[string]interface{}); ok {
+		cleanPodSpec(podSpec)
+	}
+}
+
+// cleanPodSpec removes defaulted pod spec fields
+func cleanPodSpec(spec map[string]interface{}) {
+	removeDefaults(spec, podDefaults)
+
+	// serviceAccount is the deprecated copy of serviceAccountName
+	delete(spec, "serviceAccount")
+	if ctx, ok := spec["securityContext"].(map[string]interface{}); ok && len(ctx) == 0 {
+		delete(spec, "securityContext")
+	}
+
+	// Tolerations for unreachable and not-ready nodes are added on admission
+	if tolerations, ok := spec["tolerations"].([]interface{}); ok {
+		var kept []interface{}
+		for _, t := range tolerations {
+			if t, ok := t.(map[string]interface{}); ok {
+				key, _ := t["key"].(string)
+				if (key == "node.kubernetes.io/not-ready" || key == "node.kubernetes.io/unreachable") &&
+					isNumber(t["tolerationSeconds"], 300) {
+					continue
+				}
+			}
+			kept = append(kept, t)
+		}
+		if len(kept) == 0 {
+			delete(spec, "tolerations")
+		} else {
+			spec["tolerations"] = kept
+		}
+	}
+
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, _ := spec[field].([]interface{})
+		for _, c := range containers {
+			if c, ok := c.(map[string]interface{}); ok {
+				removeDefaults(c, containerDefaults)
+			}
+		}
+	}
+}
+
+// cleanServiceSpec removes the addresses allocated to a Service and its
+// defaulted fields
+func cleanServiceSpec(spec map[string]interface{}) {
+	// Headless services keep clusterIP: None
+	if spec["clusterIP"] != "None" {
+		delete(spec, "clusterIP")
+		delete(spec, "clusterIPs")
+	}
+	removeDefaults(spec, map[string]interface{}{
+		"sessionAffinity":       "None",
+		"internalTrafficPolicy": "Cluster",
+		"ipFamilyPolicy":        "SingleStack",
+	})
+	delete(spec, "ipFamilies")
+	if spec["type"] == "ClusterIP" {
+		delete(spec, "type")
+	}
+}
+
+// removeDefaults deletes fields that hold their default value
+func removeDefaults(obj map[string]interface{}, defaults map[string]interface{}) {
+	for field, def := range defaults {
+		value, ok := obj[field]
+		if !ok {
+			continue
+		}
+		switch def := def.(type) {
+		case int:
+			if isNumber(value, def) {
+				delete(obj, field)
+			}
+		default:
+			if value == def {
+				delete(obj, field)
+			}
+		}
+	}
+}
+
+// isNumber reports whether a decoded JSON value is the number n
+func isNumber(value interface{}, n int) bool {
+	switch v := value.(type) {
+	case json.Number:
+		i, err := v.Int64()
+		return err == nil && i == int64(n)
+	case float64:
+		return v == float64(n)
+	}
+	return false
+}
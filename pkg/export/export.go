@@ -0,0 +1,293 @@
+// Package export turns stored resources into manifests that can be applied
+// again, e.g. to restore a resource after an accidental delete or to rebuild
+// a namespace from a database captured earlier
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"sigs.k8s.io/yaml"
+)
+
+// Options control what is exported
+type Options struct {
+	// RedactSecrets replaces the values of Secrets with a placeholder that
+	// fails to apply until it is filled in
+	RedactSecrets bool
+	// IncludeGenerated keeps resources that Kubernetes creates by itself,
+	// such as Pods owned by a ReplicaSet, Events, Endpoints and service
+	// account tokens
+	IncludeGenerated bool
+}
+
+// Redacted replaces the values of redacted Secrets. It isn't valid base64,
+// so applying a redacted Secret fails instead of storing the placeholder.
+const Redacted = "<redacted>"
+
+// Manifest is a cleaned resource ready to be written
+type Manifest struct {
+	Resource db.Resource
+	Object   map[string]interface{}
+}
+
+// Prepare decodes and cleans resources, skipping the generated ones unless
+// the options include them, and orders them so that dependencies such as
+// Namespaces are applied first
+func Prepare(resources []db.Resource, opts Options) ([]Manifest, error) {
+	var manifests []Manifest
+	for _, r := range resources {
+		obj, err := decode(r)
+		if err != nil {
+			return nil, err
+		}
+		if !opts.IncludeGenerated && generated(r.Kind, obj) {
+			continue
+		}
+
+		Clean(obj)
+		if opts.RedactSecrets && r.Kind == "Secret" {
+			redact(obj)
+		}
+		manifests = append(manifests, Manifest{Resource: r, Object: obj})
+	}
+
+	sort.SliceStable(manifests, func(i, j int) bool {
+		a, b := manifests[i].Resource, manifests[j].Resource
+		if pa, pb := kindPriority(a.Kind), kindPriority(b.Kind); pa != pb {
+			return pa < pb
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	return manifests, nil
+}
+
+// YAML returns a single resource as a clean manifest, redacting a Secret
+// if the options ask for it
+func YAML(r db.Resource, opts Options) ([]byte, error) {
+	obj, err := decode(r)
+	if err != nil {
+		return nil, err
+	}
+	Clean(obj)
+	if opts.RedactSecrets && r.Kind == "Secret" {
+		redact(obj)
+	}
+	return encode(Manifest{Resource: r, Object: obj})
+}
+
+// Write writes the manifests as one multi-document YAML stream, which
+// kubectl apply -f accepts
+func Write(w io.Writer, manifests []Manifest) error {
+	for i, m := range manifests {
+		data, err := encode(m)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			if _, err := io.WriteString(w, "---\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clusterDir holds cluster-scoped resources in a Kustomize tree
+const clusterDir = "_cluster"
+
+// WriteKustomize writes the manifests as a Kustomize tree with a directory
+// per namespace, _cluster for cluster-scoped resources, and a file per
+// resource named after its kind and name. Each directory and the root get
+// a kustomization.yaml, so kubectl apply -k works on the whole tree or on a
+// single namespace.
+func WriteKustomize(dir string, manifests []Manifest) error {
+	files := map[string][]string{}
+	used := map[string]bool{}
+	var dirs []string
+	for _, m := range manifests {
+		sub := m.Resource.Namespace
+		if sub == "" {
+			sub = clusterDir
+		}
+		if _, ok := files[sub]; !ok {
+			dirs = append(dirs, sub)
+		}
+
+		name := fileName(used, sub, m.Resource)
+		files[sub] = append(files[sub], name)
+
+		data, err := encode(m)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, sub, name), data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", name, err)
+		}
+	}
+
+	// Cluster-scoped resources such as Namespaces come first
+	sort.Slice(dirs, func(i, j int) bool {
+		if (dirs[i] == clusterDir) != (dirs[j] == clusterDir) {
+			return dirs[i] == clusterDir
+		}
+		return dirs[i] < dirs[j]
+	})
+
+	for _, sub := range dirs {
+		if err := writeKustomization(filepath.Join(dir, sub), files[sub]); err != nil {
+			return err
+		}
+	}
+	return writeKustomization(dir, dirs)
+}
+
+// fileName names the file of a resource in a Kustomize directory, e.g.
+// deployment-web.yaml. Kinds of different API groups with the same name
+// get the group added, e.g. ingress.extensions-web.yaml, and any other
+// collision a number.
+func fileName(used map[string]bool, dir string, r db.Resource) string {
+	kind := strings.ToLower(r.Kind)
+	candidates := []string{kind + "-" + r.Name}
+	if group, _, found := strings.Cut(r.APIVersion, "/"); found {
+		candidates = append(candidates, kind+"."+group+"-"+r.Name)
+	}
+
+	base := candidates[len(candidates)-1]
+	for i := 2; ; i++ {
+		for _, name := range candidates {
+			if !used[path.Join(dir, name)] {
+				used[path.Join(dir, name)] = true
+				return name + ".yaml"
+			}
+		}
+		candidates = []string{fmt.Sprintf("%s-%d", base, i)}
+	}
+}
+
+// writeKustomization writes a kustomization.yaml listing resources
+func writeKustomization(dir string, resources []string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	data, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion": "kustomize.config.k8s.io/v1beta1",
+		"kind":       "Kustomization",
+		"resources":  resources,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode kustomization: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "kustomization.yaml"), data, 0644); err != nil {
+		return fmt.Errorf("failed to write kustomization: %v", err)
+	}
+	return nil
+}
+
+// decode decodes the stored object, keeping numbers as written so large
+// integers keep their precision
+func decode(r db.Resource) (map[string]interface{}, error) {
+	var obj map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(r.Data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&obj); err != nil {
+		return nil, fmt.Errorf("failed to decode %s/%s: %v", r.Kind, r.Name, err)
+	}
+	return obj, nil
+}
+
+// encode returns a manifest as YAML
+func encode(m Manifest) ([]byte, error) {
+	data, err := yaml.Marshal(m.Object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s/%s: %v", m.Resource.Kind, m.Resource.Name, err)
+	}
+	return data, nil
+}
+
+// redact replaces the values of a Secret
+func redact(obj map[string]interface{}) {
+	for _, field := range []string{"data", "stringData"} {
+		values, ok := obj[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for k := range values {
+			values[k] = Redacted
+		}
+	}
+}
+
+// generated reports whether Kubernetes creates the resource by itself, so
+// applying it again would duplicate or conflict with the original
+func generated(kind string, obj map[string]interface{}) bool {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	refs, _ := metadata["ownerReferences"].([]interface{})
+	for _, ref := range refs {
+		if ref, ok := ref.(map[string]interface{}); ok && ref["controller"] == true {
+			return true
+		}
+	}
+
+	name, _ := metadata["name"].(string)
+	switch kind {
+	case "Event", "Endpoints", "EndpointSlice", "Lease":
+		return true
+	case "ConfigMap":
+		return name == "kube-root-ca.crt"
+	case "ServiceAccount":
+		return name == "default"
+	case "Secret":
+		return obj["type"] == "kubernetes.io/service-account-token"
+	}
+	return false
+}
+
+// kindOrder lists kinds that other resources depend on, in the order they
+// are applied; other kinds follow
+var kindOrder = []string{
+	"Namespace",
+	"CustomResourceDefinition",
+	"PriorityClass",
+	"StorageClass",
+	"ServiceAccount",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"Role",
+	"RoleBinding",
+	"Secret",
+	"ConfigMap",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"Service",
+}
+
+// kindPriority returns the position of a kind in kindOrder
+func kindPriority(kind string) int {
+	for i, k := range kindOrder {
+		if k == kind {
+			return i
+		}
+	}
+	return len(kindOrder)
+}
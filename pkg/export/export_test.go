@@ -0,0 +1,309 @@
+package export
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"sigs.k8s.io/yaml"
+)
+
+// secret is a stored Secret whose value also appears in the annotation
+// kubectl apply writes
+var secret = db.Resource{Name: "db", Namespace: "prod", Kind: "Secret", APIVersion: "v1",
+	Data: `{"apiVersion":"v1","kind":"Secret","metadata":{"name":"db","namespace":"prod","uid":"1",` +
+		`"annotations":{"kubectl.kubernetes.io/last-applied-configuration":"{\"data\":{\"password\":\"aHVudGVyMg==\"}}"}},` +
+		`"type":"Opaque","data":{"password":"aHVudGVyMg=="},"stringData":{"user":"admin"}}`}
+
+func TestYAMLRedactsSecrets(t *testing.T) {
+	data, err := YAML(secret, Options{RedactSecrets: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"aHVudGVyMg==", "admin", "uid"} {
+		if strings.Contains(string(data), value) {
+			t.Errorf("YAML() kept %q:\n%s", value, data)
+		}
+	}
+	if !strings.Contains(string(data), "password: <redacted>") || !strings.Contains(string(data), "user: <redacted>") {
+		t.Errorf("YAML() = %s, want the values redacted", data)
+	}
+
+	data, err = YAML(secret, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "password: aHVudGVyMg==") {
+		t.Errorf("YAML() without redaction = %s, want the value", data)
+	}
+}
+
+// object decodes a JSON object
+func object(t *testing.T, data string) map[string]interface{} {
+	t.Helper()
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &obj); err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "status and server metadata",
+			in: `{"kind":"ConfigMap","metadata":{"name":"a","uid":"1","resourceVersion":"2","generation":1,` +
+				`"creationTimestamp":"2024-01-01T00:00:00Z","managedFields":[{"manager":"kubectl"}],` +
+				`"ownerReferences":[{"kind":"X"}],"labels":{"app":"a"},"annotations":{` +
+				`"kubectl.kubernetes.io/last-applied-configuration":"{}","pv.kubernetes.io/bound-by-controller":"yes","team":"x"}},` +
+				`"data":{"k":"v"},"status":{"phase":"Active"}}`,
+			want: `{"kind":"ConfigMap","metadata":{"name":"a","labels":{"app":"a"},"annotations":{"team":"x"}},"data":{"k":"v"}}`,
+		},
+		{
+			name: "only server annotations",
+			in:   `{"kind":"ConfigMap","metadata":{"name":"a","annotations":{"deployment.kubernetes.io/revision":"3"}}}`,
+			want: `{"kind":"ConfigMap","metadata":{"name":"a"}}`,
+		},
+		{
+			name: "pod defaults",
+			in: `{"kind":"Pod","metadata":{"name":"p"},"spec":{"nodeName":"n1","dnsPolicy":"ClusterFirst",` +
+				`"restartPolicy":"Never","terminationGracePeriodSeconds":30,"priority":0,"serviceAccount":"sa",` +
+				`"serviceAccountName":"sa","securityContext":{},"tolerations":[` +
+				`{"key":"node.kubernetes.io/not-ready","effect":"NoExecute","tolerationSeconds":300},` +
+				`{"key":"node.kubernetes.io/unreachable","effect":"NoExecute","tolerationSeconds":60},` +
+				`{"key":"gpu","operator":"Exists"}],` +
+				`"containers":[{"name":"app","image":"app:1","terminationMessagePath":"/dev/termination-log",` +
+				`"terminationMessagePolicy":"File"}]}}`,
+			want: `{"kind":"Pod","metadata":{"name":"p"},"spec":{"restartPolicy":"Never","serviceAccountName":"sa",` +
+				`"tolerations":[{"key":"node.kubernetes.io/unreachable","effect":"NoExecute","tolerationSeconds":60},` +
+				`{"key":"gpu","operator":"Exists"}],"containers":[{"name":"app","image":"app:1"}]}}`,
+		},
+		{
+			name: "deployment defaults and template",
+			in: `{"kind":"Deployment","metadata":{"name":"web"},"spec":{"replicas":2,"progressDeadlineSeconds":600,` +
+				`"revisionHistoryLimit":5,"selector":{"matchLabels":{"app":"web"}},"template":{"metadata":` +
+				`{"creationTimestamp":null,"labels":{"app":"web"}},"spec":{"schedulerName":"default-scheduler",` +
+				`"enableServiceLinks":true,"containers":[{"name":"web"}]}}},"status":{"replicas":2}}`,
+			want: `{"kind":"Deployment","metadata":{"name":"web"},"spec":{"replicas":2,"revisionHistoryLimit":5,` +
+				`"selector":{"matchLabels":{"app":"web"}},"template":{"metadata":{"labels":{"app":"web"}},` +
+				`"spec":{"containers":[{"name":"web"}]}}}}`,
+		},
+		{
+			name: "generated job selector and labels",
+			in: `{"kind":"Job","metadata":{"name":"migrate"},"spec":{"backoffLimit":2,` +
+				`"selector":{"matchLabels":{"batch.kubernetes.io/controller-uid":"u1"}},"template":{"metadata":` +
+				`{"creationTimestamp":null,"labels":{"controller-uid":"u1","batch.kubernetes.io/controller-uid":"u1",` +
+				`"job-name":"migrate","batch.kubernetes.io/job-name":"migrate"}},"spec":{"restartPolicy":"Never",` +
+				`"containers":[{"name":"migrate"}]}}}}`,
+			want: `{"kind":"Job","metadata":{"name":"migrate"},"spec":{"backoffLimit":2,"template":{"spec":` +
+				`{"restartPolicy":"Never","containers":[{"name":"migrate"}]}}}}`,
+		},
+		{
+			name: "job labels of its own",
+			in: `{"kind":"Job","metadata":{"name":"migrate"},"spec":{"selector":{"matchLabels":{"controller-uid":"u1"}},` +
+				`"template":{"metadata":{"labels":{"controller-uid":"u1","job-name":"migrate","app":"db"}}}}}`,
+			want: `{"kind":"Job","metadata":{"name":"migrate"},"spec":{"template":{"metadata":{"labels":{"app":"db"}}}}}`,
+		},
+		{
+			name: "manual job selector",
+			in: `{"kind":"Job","metadata":{"name":"migrate"},"spec":{"manualSelector":true,` +
+				`"selector":{"matchLabels":{"job-name":"migrate"}},"template":{"metadata":{"labels":{"job-name":"migrate"}}}}}`,
+			want: `{"kind":"Job","metadata":{"name":"migrate"},"spec":{"manualSelector":true,` +
+				`"selector":{"matchLabels":{"job-name":"migrate"}},"template":{"metadata":{"labels":{"job-name":"migrate"}}}}}`,
+		},
+		{
+			name: "cronjob template",
+			in: `{"kind":"CronJob","metadata":{"name":"c"},"spec":{"schedule":"* * * * *","jobTemplate":` +
+				`{"metadata":{"creationTimestamp":null},"spec":{"template":{"spec":{"dnsPolicy":"ClusterFirst"}}}}}}`,
+			want: `{"kind":"CronJob","metadata":{"name":"c"},"spec":{"schedule":"* * * * *","jobTemplate":` +
+				`{"spec":{"template":{"spec":{}}}}}}`,
+		},
+		{
+			name: "service addresses",
+			in: `{"kind":"Service","metadata":{"name":"s"},"spec":{"type":"ClusterIP","clusterIP":"10.0.0.1",` +
+				`"clusterIPs":["10.0.0.1"],"ipFamilies":["IPv4"],"ipFamilyPolicy":"SingleStack",` +
+				`"sessionAffinity":"None","internalTrafficPolicy":"Cluster","ports":[{"port":80}]}}`,
+			want: `{"kind":"Service","metadata":{"name":"s"},"spec":{"ports":[{"port":80}]}}`,
+		},
+		{
+			name: "headless service",
+			in:   `{"kind":"Service","metadata":{"name":"s"},"spec":{"type":"NodePort","clusterIP":"None"}}`,
+			want: `{"kind":"Service","metadata":{"name":"s"},"spec":{"type":"NodePort","clusterIP":"None"}}`,
+		},
+		{
+			name: "namespace finalizers",
+			in:   `{"kind":"Namespace","metadata":{"name":"prod"},"spec":{"finalizers":["kubernetes"]},"status":{"phase":"Active"}}`,
+			want: `{"kind":"Namespace","metadata":{"name":"prod"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := object(t, tt.in)
+			Clean(obj)
+			if want := object(t, tt.want); !reflect.DeepEqual(obj, want) {
+				got, _ := json.Marshal(obj)
+				t.Errorf("Clean() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// resource returns a stored resource with a minimal object
+func resource(kind, apiVersion, namespace, name string) db.Resource {
+	return db.Resource{Name: name, Namespace: namespace, Kind: kind, APIVersion: apiVersion,
+		Data: `{"apiVersion":"` + apiVersion + `","kind":"` + kind + `","metadata":{"name":"` + name + `"}}`}
+}
+
+// manifestKeys returns kind/namespace/name of each manifest in order
+func manifestKeys(manifests []Manifest) []string {
+	var keys []string
+	for _, m := range manifests {
+		keys = append(keys, m.Resource.Kind+"/"+m.Resource.Namespace+"/"+m.Resource.Name)
+	}
+	return keys
+}
+
+func TestPrepare(t *testing.T) {
+	owned := resource("Pod", "v1", "prod", "web-1")
+	owned.Data = `{"kind":"Pod","metadata":{"name":"web-1","ownerReferences":[{"kind":"ReplicaSet","controller":true}]}}`
+	resources := []db.Resource{
+		resource("Deployment", "apps/v1", "prod", "web"),
+		resource("Service", "v1", "prod", "web"),
+		owned,
+		resource("Event", "v1", "prod", "web.1"),
+		resource("ConfigMap", "v1", "prod", "kube-root-ca.crt"),
+		resource("ConfigMap", "v1", "dev", "settings"),
+		secret,
+		resource("Namespace", "v1", "", "prod"),
+		resource("CustomResourceDefinition", "apiextensions.k8s.io/v1", "", "widgets.example.com"),
+		resource("Widget", "example.com/v1", "prod", "w"),
+	}
+
+	manifests, err := Prepare(resources, Options{RedactSecrets: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Dependencies first, then by namespace, kind and name
+	want := []string{
+		"Namespace//prod",
+		"CustomResourceDefinition//widgets.example.com",
+		"Secret/prod/db",
+		"ConfigMap/dev/settings",
+		"Service/prod/web",
+		"Deployment/prod/web",
+		"Widget/prod/w",
+	}
+	if got := manifestKeys(manifests); !reflect.DeepEqual(got, want) {
+		t.Errorf("Prepare() = %v, want %v", got, want)
+	}
+	values := manifests[2].Object["data"].(map[string]interface{})
+	if values["password"] != Redacted {
+		t.Errorf("Secret data = %v, want it redacted", values)
+	}
+	if strings.Contains(resources[6].Data, Redacted) {
+		t.Error("Prepare() changed the stored resource")
+	}
+
+	// Generated resources are kept when asked for
+	manifests, err = Prepare(resources, Options{IncludeGenerated: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != len(resources) {
+		t.Errorf("Prepare() kept %d of %d resources, want all", len(manifests), len(resources))
+	}
+
+	if _, err := Prepare([]db.Resource{{Kind: "Pod", Name: "bad", Data: "{"}}, Options{}); err == nil {
+		t.Error("Prepare() accepted invalid JSON")
+	}
+}
+
+func TestWriteKustomize(t *testing.T) {
+	resources := []db.Resource{
+		resource("Namespace", "v1", "", "prod"),
+		resource("ClusterRole", "rbac.authorization.k8s.io/v1", "", "reader"),
+		resource("Deployment", "apps/v1", "prod", "web"),
+		resource("Service", "v1", "prod", "web"),
+		// The same kind and name in different API groups
+		resource("Ingress", "networking.k8s.io/v1", "prod", "web"),
+		resource("Ingress", "extensions/v1beta1", "prod", "web"),
+		resource("Ingress", "extensions/v1", "prod", "web"),
+		resource("ConfigMap", "v1", "dev", "settings"),
+	}
+	manifests, err := Prepare(resources, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := WriteKustomize(dir, manifests); err != nil {
+		t.Fatal(err)
+	}
+
+	kustomization := func(sub string) []string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(dir, sub, "kustomization.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var k struct {
+			Kind      string   `json:"kind"`
+			Resources []string `json:"resources"`
+		}
+		if err := yaml.Unmarshal(data, &k); err != nil {
+			t.Fatal(err)
+		}
+		if k.Kind != "Kustomization" {
+			t.Errorf("%s/kustomization.yaml has kind %q", sub, k.Kind)
+		}
+		return k.Resources
+	}
+
+	if got, want := kustomization(""), []string{"_cluster", "dev", "prod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("root resources = %v, want %v", got, want)
+	}
+	if got, want := kustomization("_cluster"), []string{"namespace-prod.yaml", "clusterrole-reader.yaml"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("_cluster resources = %v, want %v", got, want)
+	}
+	want := []string{
+		"service-web.yaml",
+		"deployment-web.yaml",
+		"ingress-web.yaml",
+		"ingress.extensions-web.yaml",
+		"ingress.extensions-web-2.yaml",
+	}
+	got := kustomization("prod")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("prod resources = %v, want %v", got, want)
+	}
+
+	// Every listed file exists and holds a different resource
+	seen := map[string]bool{}
+	for _, name := range got {
+		data, err := os.ReadFile(filepath.Join(dir, "prod", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var obj map[string]interface{}
+		if err := yaml.Unmarshal(data, &obj); err != nil {
+			t.Fatal(err)
+		}
+		key := obj["apiVersion"].(string) + " " + obj["kind"].(string)
+		if seen[key] {
+			t.Errorf("%s is written twice", key)
+		}
+		seen[key] = true
+	}
+	if len(seen) != len(want) {
+		t.Errorf("wrote %d resources to prod, want %d", len(seen), len(want))
+	}
+}
@@ -0,0 +1,16 @@
+// Package flagutil holds command-line flag types shared by the commands
+package flagutil
+
+import "strings"
+
+// StringSlice collects the values of a flag that can be repeated
+type StringSlice []string
+
+func (f *StringSlice) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *StringSlice) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
@@ -0,0 +1,455 @@
+// Package health computes the health of Kubernetes resources from their
+// status: Pods, workloads, Jobs, PersistentVolumeClaims and Nodes by their
+// own rules, and other resources, such as custom resources, by their
+// status conditions
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Status is the health of a resource
+type Status string
+
+// Health statuses
+const (
+	// Healthy resources are in their desired state
+	Healthy Status = "Healthy"
+	// Progressing resources are on their way to it, e.g. during a rollout
+	Progressing Status = "Progressing"
+	// Degraded resources failed to reach it, e.g. crashing Pods
+	Degraded Status = "Degraded"
+	// Missing resources no longer exist; the stores give deleted resources
+	// this status
+	Missing Status = "Missing"
+	// Unknown resources have a status that can't be interpreted
+	Unknown Status = "Unknown"
+)
+
+// Statuses lists every status, healthiest first
+var Statuses = []Status{Healthy, Progressing, Degraded, Missing, Unknown}
+
+// ParseStatus returns the status with a name, ignoring case
+func ParseStatus(name string) (Status, bool) {
+	for _, s := range Statuses {
+		if strings.EqualFold(string(s), name) {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// Health is the status of a resource with a short reason, e.g. Degraded
+// because of CrashLoopBackOff
+type Health struct {
+	Status Status
+	Reason string
+}
+
+// Deleted is the health of a deleted resource
+var Deleted = Health{Status: Missing, Reason: "Deleted"}
+
+// String formats the status with its reason
+func (h Health) String() string {
+	if h.Reason == "" {
+		return string(h.Status)
+	}
+	return fmt.Sprintf("%s (%s)", h.Status, h.Reason)
+}
+
+// EvaluateJSON computes the health of a resource from its JSON
+func EvaluateJSON(kind, apiVersion, data string) Health {
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &obj); err != nil {
+		return Health{Status: Unknown, Reason: "invalid object"}
+	}
+	return Evaluate(kind, apiVersion, obj)
+}
+
+// Evaluate computes the health of a resource of a kind and API version
+func Evaluate(kind, apiVersion string, obj map[string]interface{}) Health {
+	if str(obj, "metadata", "deletionTimestamp") != "" {
+		return Health{Status: Progressing, Reason: "Terminating"}
+	}
+
+	// The core group has API versions without a group, e.g. v1
+	group := ""
+	if g, _, found := strings.Cut(apiVersion, "/"); found {
+		group = g
+	}
+
+	switch group + "/" + kind {
+	case "/Pod":
+		return pod(obj)
+	case "/PersistentVolumeClaim":
+		return persistentVolumeClaim(obj)
+	case "/Node":
+		return node(obj)
+	case "/Namespace":
+		return namespace(obj)
+	case "/Service":
+		return service(obj)
+	case "apps/Deployment":
+		return deployment(obj)
+	case "apps/StatefulSet":
+		return statefulSet(obj)
+	case "apps/DaemonSet":
+		return daemonSet(obj)
+	case "apps/ReplicaSet":
+		return replicaSet(obj)
+	case "batch/Job":
+		return job(obj)
+	}
+	return generic(obj)
+}
+
+// failingWaitReasons are reasons of waiting containers that won't recover
+// on their own
+var failingWaitReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"ErrImageNeverPull":          true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"RunContainerError":          true,
+}
+
+// pod follows the phase, then the container states and readiness
+func pod(obj map[string]interface{}) Health {
+	phase := str(obj, "status", "phase")
+	switch phase {
+	case "Succeeded":
+		return Health{Status: Healthy, Reason: "Completed"}
+	case "Failed":
+		return Health{Status: Degraded, Reason: firstNonEmpty(str(obj, "status", "reason"), "Failed")}
+	case "Unknown":
+		return Health{Status: Unknown, Reason: firstNonEmpty(str(obj, "status", "reason"), "PodStatusUnknown")}
+	}
+
+	for _, list := range []struct{ field, prefix string }{{"initContainerStatuses", "Init:"}, {"containerStatuses", ""}} {
+		for _, c := range slice(obj, "status", list.field) {
+			status, _ := c.(map[string]interface{})
+			if reason := str(status, "state", "waiting", "reason"); failingWaitReasons[reason] {
+				return Health{Status: Degraded, Reason: list.prefix + reason}
+			}
+			if list.prefix == "" && str(status, "state", "terminated", "reason") == "OOMKilled" {
+				return Health{Status: Degraded, Reason: "OOMKilled"}
+			}
+		}
+	}
+
+	if phase == "Pending" || phase == "" {
+		if c := condition(obj, "PodScheduled"); c != nil && str(c, "status") == "False" {
+			return Health{Status: Progressing, Reason: firstNonEmpty(str(c, "reason"), "Unschedulable")}
+		}
+		return Health{Status: Progressing, Reason: "Pending"}
+	}
+
+	if c := condition(obj, "Ready"); c != nil && str(c, "status") != "True" {
+		return Health{Status: Progressing, Reason: firstNonEmpty(str(c, "reason"), "NotReady")}
+	}
+	return Health{Status: Healthy, Reason: phase}
+}
+
+// deployment follows the rollout the way kubectl rollout status does
+func deployment(obj map[string]interface{}) Health {
+	if boolean(obj, "spec", "paused") {
+		return Health{Status: Progressing, Reason: "Paused"}
+	}
+	if h, ok := notObserved(obj); ok {
+		return h
+	}
+	if c := condition(obj, "ReplicaFailure"); c != nil && str(c, "status") == "True" {
+		return Health{Status: Degraded, Reason: firstNonEmpty(str(c, "reason"), "ReplicaFailure")}
+	}
+	if c := condition(obj, "Progressing"); c != nil && str(c, "reason") == "ProgressDeadlineExceeded" {
+		return Health{Status: Degraded, Reason: "ProgressDeadlineExceeded"}
+	}
+
+	desired := replicas(obj)
+	updated := integer(obj, "status", "updatedReplicas")
+	total := integer(obj, "status", "replicas")
+	available := integer(obj, "status", "availableReplicas")
+	switch {
+	case updated < desired:
+		return Health{Status: Progressing, Reason: fmt.Sprintf("%d of %d updated", updated, desired)}
+	case total > updated:
+		return Health{Status: Progressing, Reason: fmt.Sprintf("%d old replicas pending termination", total-updated)}
+	case available < updated:
+		return Health{Status: Progressing, Reason: fmt.Sprintf("%d of %d available", available, updated)}
+	}
+	return Health{Status: Healthy, Reason: fmt.Sprintf("%d/%d available", available, desired)}
+}
+
+// statefulSet follows the rollout, which may be partitioned
+func statefulSet(obj map[string]interface{}) Health {
+	if h, ok := notObserved(obj); ok {
+		return h
+	}
+
+	desired := replicas(obj)
+	ready := integer(obj, "status", "readyReplicas")
+	if ready < desired {
+		return Health{Status: Progressing, Reason: fmt.Sprintf("%d of %d ready", ready, desired)}
+	}
+	if str(obj, "spec", "updateStrategy", "type") == "OnDelete" {
+		return Health{Status: Healthy, Reason: fmt.Sprintf("%d/%d ready", ready, desired)}
+	}
+
+	if partition := integer(obj, "spec", "updateStrategy", "rollingUpdate", "partition"); partition > 0 {
+		if updated := integer(obj, "status", "updatedReplicas"); updated < desired-partition {
+			return Health{Status: Progressing, Reason: fmt.Sprintf("%d of %d updated", updated, desired-partition)}
+		}
+	} else if current, update := str(obj, "status", "currentRevision"), str(obj, "status", "updateRevision"); current != update {
+		return Health{Status: Progressing, Reason: "rolling out " + update}
+	}
+	return Health{Status: Healthy, Reason: fmt.Sprintf("%d/%d ready", ready, desired)}
+}
+
+// daemonSet follows the rollout to the scheduled nodes
+func daemonSet(obj map[string]interface{}) Health {
+	if h, ok := notObserved(obj); ok {
+		return h
+	}
+
+	desired := integer(obj, "status", "desiredNumberScheduled")
+	available := integer(obj, "status", "numberAvailable")
+	if str(obj, "spec", "updateStrategy", "type") != "OnDelete" {
+		if updated := integer(obj, "status", "updatedNumberScheduled"); updated < desired {
+			return Health{Status: Progressing, Reason: fmt.Sprintf("%d of %d updated", updated, desired)}
+		}
+	}
+	if available < desired {
+		return Health{Status: Progressing, Reason: fmt.Sprintf("%d of %d available", available, desired)}
+	}
+	return Health{Status: Healthy, Reason: fmt.Sprintf("%d/%d available", available, desired)}
+}
+
+// replicaSet compares the ready replicas with the desired ones
+func replicaSet(obj map[string]interface{}) Health {
+	if h, ok := notObserved(obj); ok {
+		return h
+	}
+	if c := condition(obj, "ReplicaFailure"); c != nil && str(c, "status") == "True" {
+		return Health{Status: Degraded, Reason: firstNonEmpty(str(c, "reason"), "ReplicaFailure")}
+	}
+
+	desired := replicas(obj)
+	ready := integer(obj, "status", "readyReplicas")
+	if ready < desired {
+		return Health{Status: Progressing, Reason: fmt.Sprintf("%d of %d ready", ready, desired)}
+	}
+	return Health{Status: Healthy, Reason: fmt.Sprintf("%d/%d ready", ready, desired)}
+}
+
+// job follows the completion conditions
+func job(obj map[string]interface{}) Health {
+	if c := condition(obj, "Failed"); c != nil && str(c, "status") == "True" {
+		return Health{Status: Degraded, Reason: firstNonEmpty(str(c, "reason"), "Failed")}
+	}
+	if c := condition(obj, "Complete"); c != nil && str(c, "status") == "True" {
+		return Health{Status: Healthy, Reason: "Completed"}
+	}
+	if boolean(obj, "spec", "suspend") {
+		return Health{Status: Progressing, Reason: "Suspended"}
+	}
+	return Health{Status: Progressing, Reason: fmt.Sprintf("%d active", integer(obj, "status", "active"))}
+}
+
+// persistentVolumeClaim follows the binding phase
+func persistentVolumeClaim(obj map[string]interface{}) Health {
+	switch phase := str(obj, "status", "phase"); phase {
+	case "Bound":
+		return Health{Status: Healthy, Reason: "Bound"}
+	case "Lost":
+		return Health{Status: Degraded, Reason: "Lost"}
+	case "Pending", "":
+		return Health{Status: Progressing, Reason: "Pending"}
+	default:
+		return Health{Status: Unknown, Reason: phase}
+	}
+}
+
+// nodePressures are the Node conditions that are bad when true
+var nodePressures = []string{"MemoryPressure", "DiskPressure", "PIDPressure", "NetworkUnavailable"}
+
+// node follows the Ready condition and the pressure conditions
+func node(obj map[string]interface{}) Health {
+	ready := condition(obj, "Ready")
+	switch {
+	case ready == nil || str(ready, "status") == "Unknown":
+		return Health{Status: Unknown, Reason: "NodeStatusUnknown"}
+	case str(ready, "status") != "True":
+		return Health{Status: Degraded, Reason: firstNonEmpty(str(ready, "reason"), "NotReady")}
+	}
+
+	for _, pressure := range nodePressures {
+		if c := condition(obj, pressure); c != nil && str(c, "status") == "True" {
+			return Health{Status: Degraded, Reason: pressure}
+		}
+	}
+	if boolean(obj, "spec", "unschedulable") {
+		return Health{Status: Healthy, Reason: "SchedulingDisabled"}
+	}
+	return Health{Status: Healthy, Reason: "Ready"}
+}
+
+// namespace follows the phase
+func namespace(obj map[string]interface{}) Health {
+	if str(obj, "status", "phase") == "Terminating" {
+		return Health{Status: Progressing, Reason: "Terminating"}
+	}
+	return Health{Status: Healthy}
+}
+
+// service waits for the address of a load balancer
+func service(obj map[string]interface{}) Health {
+	if str(obj, "spec", "type") == "LoadBalancer" && len(slice(obj, "status", "loadBalancer", "ingress")) == 0 {
+		return Health{Status: Progressing, Reason: "waiting for a load balancer"}
+	}
+	return Health{Status: Healthy}
+}
+
+// generic follows the conventions of custom resources: Stalled and
+// Reconciling conditions, an observed generation and a Ready or Available
+// condition. Resources without a status, e.g. ConfigMaps, are healthy.
+func generic(obj map[string]interface{}) Health {
+	status, hasStatus := obj["status"].(map[string]interface{})
+	if !hasStatus || len(status) == 0 {
+		return Health{Status: Healthy}
+	}
+
+	if c := condition(obj, "Stalled"); c != nil && str(c, "status") == "True" {
+		return Health{Status: Degraded, Reason: firstNonEmpty(str(c, "reason"), "Stalled")}
+	}
+	if c := condition(obj, "Reconciling"); c != nil && str(c, "status") == "True" {
+		return Health{Status: Progressing, Reason: firstNonEmpty(str(c, "reason"), "Reconciling")}
+	}
+	if h, ok := notObserved(obj); ok {
+		return h
+	}
+
+	for _, conditionType := range []string{"Ready", "Available"} {
+		c := condition(obj, conditionType)
+		if c == nil {
+			continue
+		}
+		switch str(c, "status") {
+		case "True":
+			return Health{Status: Healthy, Reason: firstNonEmpty(str(c, "reason"), conditionType)}
+		case "False":
+			reason := firstNonEmpty(str(c, "reason"), "Not"+conditionType)
+			if inProgress(reason) {
+				return Health{Status: Progressing, Reason: reason}
+			}
+			return Health{Status: Degraded, Reason: reason}
+		default:
+			return Health{Status: Progressing, Reason: firstNonEmpty(str(c, "reason"), conditionType+" unknown")}
+		}
+	}
+
+	if len(slice(obj, "status", "conditions")) > 0 {
+		return Health{Status: Unknown, Reason: "no Ready condition"}
+	}
+	return Health{Status: Unknown}
+}
+
+// inProgress reports whether the reason of a false Ready condition says
+// the resource is still being worked on
+func inProgress(reason string) bool {
+	lower := strings.ToLower(reason)
+	for _, word := range []string{"progress", "reconcil", "pending", "waiting", "creating", "provisioning", "updating"} {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// notObserved reports a resource whose latest spec its controller hasn't
+// seen yet
+func notObserved(obj map[string]interface{}) (Health, bool) {
+	generation := integer(obj, "metadata", "generation")
+	observed, found := number(obj, "status", "observedGeneration")
+	if found && observed < generation {
+		return Health{Status: Progressing, Reason: "waiting for the controller"}, true
+	}
+	return Health{}, false
+}
+
+// replicas returns the desired replicas, which default to 1
+func replicas(obj map[string]interface{}) int64 {
+	if n, found := number(obj, "spec", "replicas"); found {
+		return n
+	}
+	return 1
+}
+
+// condition returns the status condition of a type, or nil
+func condition(obj map[string]interface{}, conditionType string) map[string]interface{} {
+	for _, c := range slice(obj, "status", "conditions") {
+		if m, ok := c.(map[string]interface{}); ok && str(m, "type") == conditionType {
+			return m
+		}
+	}
+	return nil
+}
+
+// str returns a nested string, or ""
+func str(obj map[string]interface{}, fields ...string) string {
+	value, _, _ := unstructured.NestedFieldNoCopy(obj, fields...)
+	s, _ := value.(string)
+	return s
+}
+
+// boolean returns a nested bool, or false
+func boolean(obj map[string]interface{}, fields ...string) bool {
+	value, _, _ := unstructured.NestedFieldNoCopy(obj, fields...)
+	b, _ := value.(bool)
+	return b
+}
+
+// slice returns a nested list, or nil
+func slice(obj map[string]interface{}, fields ...string) []interface{} {
+	value, _, _ := unstructured.NestedFieldNoCopy(obj, fields...)
+	s, _ := value.([]interface{})
+	return s
+}
+
+// number returns a nested number, whether decoded as JSON or by the API
+// machinery
+func number(obj map[string]interface{}, fields ...string) (int64, bool) {
+	value, _, _ := unstructured.NestedFieldNoCopy(obj, fields...)
+	switch n := value.(type) {
+	case int64:
+		return n, true
+	case float64:
+		return int64(n), true
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// integer returns a nested number, or 0
+func integer(obj map[string]interface{}, fields ...string) int64 {
+	n, _ := number(obj, fields...)
+	return n
+}
+
+// firstNonEmpty returns the first string that isn't empty
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,168 @@
+package health
+
+import "testing"
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name       string
+		kind       string
+		apiVersion string
+		data       string
+		want       Health
+	}{
+		{
+			name: "running pod", kind: "Pod", apiVersion: "v1",
+			data: `{"status":{"phase":"Running","conditions":[{"type":"Ready","status":"True"}]}}`,
+			want: Health{Healthy, "Running"},
+		},
+		{
+			name: "crashing pod", kind: "Pod", apiVersion: "v1",
+			data: `{"status":{"phase":"Running","conditions":[{"type":"Ready","status":"False","reason":"ContainersNotReady"}],
+				"containerStatuses":[{"name":"app","state":{"waiting":{"reason":"CrashLoopBackOff"}}}]}}`,
+			want: Health{Degraded, "CrashLoopBackOff"},
+		},
+		{
+			name: "pod failing to pull an init image", kind: "Pod", apiVersion: "v1",
+			data: `{"status":{"phase":"Pending","initContainerStatuses":[{"state":{"waiting":{"reason":"ImagePullBackOff"}}}]}}`,
+			want: Health{Degraded, "Init:ImagePullBackOff"},
+		},
+		{
+			name: "unschedulable pod", kind: "Pod", apiVersion: "v1",
+			data: `{"status":{"phase":"Pending","conditions":[{"type":"PodScheduled","status":"False","reason":"Unschedulable"}]}}`,
+			want: Health{Progressing, "Unschedulable"},
+		},
+		{
+			name: "starting pod", kind: "Pod", apiVersion: "v1",
+			data: `{"status":{"phase":"Running","conditions":[{"type":"Ready","status":"False","reason":"ContainersNotReady"}]}}`,
+			want: Health{Progressing, "ContainersNotReady"},
+		},
+		{
+			name: "completed pod", kind: "Pod", apiVersion: "v1",
+			data: `{"status":{"phase":"Succeeded"}}`,
+			want: Health{Healthy, "Completed"},
+		},
+		{
+			name: "evicted pod", kind: "Pod", apiVersion: "v1",
+			data: `{"status":{"phase":"Failed","reason":"Evicted"}}`,
+			want: Health{Degraded, "Evicted"},
+		},
+		{
+			name: "terminating pod", kind: "Pod", apiVersion: "v1",
+			data: `{"metadata":{"deletionTimestamp":"2024-01-01T00:00:00Z"},"status":{"phase":"Running"}}`,
+			want: Health{Progressing, "Terminating"},
+		},
+		{
+			name: "available deployment", kind: "Deployment", apiVersion: "apps/v1",
+			data: `{"metadata":{"generation":2},"spec":{"replicas":3},
+				"status":{"observedGeneration":2,"replicas":3,"updatedReplicas":3,"availableReplicas":3}}`,
+			want: Health{Healthy, "3/3 available"},
+		},
+		{
+			name: "rolling deployment", kind: "Deployment", apiVersion: "apps/v1",
+			data: `{"metadata":{"generation":2},"spec":{"replicas":3},
+				"status":{"observedGeneration":2,"replicas":4,"updatedReplicas":1,"availableReplicas":3}}`,
+			want: Health{Progressing, "1 of 3 updated"},
+		},
+		{
+			name: "unobserved deployment", kind: "Deployment", apiVersion: "apps/v1",
+			data: `{"metadata":{"generation":3},"spec":{"replicas":1},"status":{"observedGeneration":2}}`,
+			want: Health{Progressing, "waiting for the controller"},
+		},
+		{
+			name: "stuck deployment", kind: "Deployment", apiVersion: "apps/v1",
+			data: `{"spec":{"replicas":1},"status":{"replicas":1,"conditions":[
+				{"type":"Progressing","status":"False","reason":"ProgressDeadlineExceeded"}]}}`,
+			want: Health{Degraded, "ProgressDeadlineExceeded"},
+		},
+		{
+			name: "partitioned statefulset", kind: "StatefulSet", apiVersion: "apps/v1",
+			data: `{"spec":{"replicas":3,"updateStrategy":{"type":"RollingUpdate","rollingUpdate":{"partition":2}}},
+				"status":{"readyReplicas":3,"updatedReplicas":1,"currentRevision":"a","updateRevision":"b"}}`,
+			want: Health{Healthy, "3/3 ready"},
+		},
+		{
+			name: "rolling statefulset", kind: "StatefulSet", apiVersion: "apps/v1",
+			data: `{"spec":{"replicas":2},"status":{"readyReplicas":2,"currentRevision":"a","updateRevision":"b"}}`,
+			want: Health{Progressing, "rolling out b"},
+		},
+		{
+			name: "daemonset", kind: "DaemonSet", apiVersion: "apps/v1",
+			data: `{"status":{"desiredNumberScheduled":3,"updatedNumberScheduled":3,"numberAvailable":2}}`,
+			want: Health{Progressing, "2 of 3 available"},
+		},
+		{
+			name: "failed job", kind: "Job", apiVersion: "batch/v1",
+			data: `{"status":{"conditions":[{"type":"Failed","status":"True","reason":"BackoffLimitExceeded"}]}}`,
+			want: Health{Degraded, "BackoffLimitExceeded"},
+		},
+		{
+			name: "complete job", kind: "Job", apiVersion: "batch/v1",
+			data: `{"status":{"conditions":[{"type":"Complete","status":"True"}]}}`,
+			want: Health{Healthy, "Completed"},
+		},
+		{
+			name: "pending claim", kind: "PersistentVolumeClaim", apiVersion: "v1",
+			data: `{"status":{"phase":"Pending"}}`,
+			want: Health{Progressing, "Pending"},
+		},
+		{
+			name: "lost claim", kind: "PersistentVolumeClaim", apiVersion: "v1",
+			data: `{"status":{"phase":"Lost"}}`,
+			want: Health{Degraded, "Lost"},
+		},
+		{
+			name: "node under pressure", kind: "Node", apiVersion: "v1",
+			data: `{"status":{"conditions":[{"type":"Ready","status":"True"},{"type":"DiskPressure","status":"True"}]}}`,
+			want: Health{Degraded, "DiskPressure"},
+		},
+		{
+			name: "unreachable node", kind: "Node", apiVersion: "v1",
+			data: `{"status":{"conditions":[{"type":"Ready","status":"Unknown"}]}}`,
+			want: Health{Unknown, "NodeStatusUnknown"},
+		},
+		{
+			name: "custom resource ready", kind: "Certificate", apiVersion: "cert-manager.io/v1",
+			data: `{"status":{"conditions":[{"type":"Ready","status":"True","reason":"Ready"}]}}`,
+			want: Health{Healthy, "Ready"},
+		},
+		{
+			name: "custom resource failing", kind: "HelmRelease", apiVersion: "helm.toolkit.fluxcd.io/v2",
+			data: `{"status":{"conditions":[{"type":"Ready","status":"False","reason":"InstallFailed"}]}}`,
+			want: Health{Degraded, "InstallFailed"},
+		},
+		{
+			name: "custom resource reconciling", kind: "Kustomization", apiVersion: "kustomize.toolkit.fluxcd.io/v1",
+			data: `{"status":{"conditions":[{"type":"Reconciling","status":"True","reason":"Progressing"},
+				{"type":"Ready","status":"Unknown"}]}}`,
+			want: Health{Progressing, "Progressing"},
+		},
+		{
+			name: "custom resource without a Ready condition", kind: "Widget", apiVersion: "example.com/v1",
+			data: `{"status":{"conditions":[{"type":"Synced","status":"True"}]}}`,
+			want: Health{Unknown, "no Ready condition"},
+		},
+		{
+			name: "configmap", kind: "ConfigMap", apiVersion: "v1",
+			data: `{"data":{"a":"b"}}`,
+			want: Health{Status: Healthy},
+		},
+		{
+			name: "pending load balancer", kind: "Service", apiVersion: "v1",
+			data: `{"spec":{"type":"LoadBalancer"},"status":{"loadBalancer":{}}}`,
+			want: Health{Progressing, "waiting for a load balancer"},
+		},
+		{
+			name: "a Pod of another group", kind: "Pod", apiVersion: "example.com/v1",
+			data: `{"status":{"phase":"Failed"}}`,
+			want: Health{Status: Unknown},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EvaluateJSON(tt.kind, tt.apiVersion, tt.data); got != tt.want {
+				t.Errorf("EvaluateJSON() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
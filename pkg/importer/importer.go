@@ -0,0 +1,204 @@
+// Package importer loads saved resources into a resource store, so that an
+// event log or dump captured elsewhere can be browsed without a cluster. It
+// reads the NDJSON written by watcher --output ndjson, the watch events of
+// kubectl get -w --output-watch-events -o json, and kubectl get -o json
+// output, including List dumps.
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// batchSize is the number of changes written per transaction
+const batchSize = 500
+
+// File imports a file into the store, reading stdin when path is "-", and
+// returns the number of changes imported
+func File(store *db.ResourceStore, path string) (int, error) {
+	in := os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open %s: %v", path, err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var batch []db.Change
+	count := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := store.Import(batch)
+		count += len(batch)
+		batch = batch[:0]
+		return err
+	}
+
+	err := Read(in, func(c db.Change) error {
+		batch = append(batch, c)
+		if len(batch) >= batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return count, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := flush(); err != nil {
+		return count, fmt.Errorf("%s: %v", path, err)
+	}
+	return count, nil
+}
+
+// Read decodes a stream of JSON values and calls fn with the changes they
+// describe, in order. A value is a watch event, a List whose items are
+// read as added, or a single object read as added. Values don't have to be
+// on separate lines, so indented kubectl output works as well.
+func Read(r io.Reader, fn func(db.Change) error) error {
+	decoder := json.NewDecoder(r)
+	// Keep numbers as written so large integers keep their precision
+	decoder.UseNumber()
+
+	for n := 1; ; n++ {
+		var value map[string]interface{}
+		err := decoder.Decode(&value)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("value %d: invalid JSON: %v", n, err)
+		}
+
+		changes, err := parseValue(value)
+		if err != nil {
+			return fmt.Errorf("value %d: %v", n, err)
+		}
+		for _, c := range changes {
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// parseValue returns the changes described by a decoded value
+func parseValue(value map[string]interface{}) ([]db.Change, error) {
+	// Watch events have a type and an object
+	if eventType, ok := value["type"].(string); ok {
+		if object, ok := value["object"].(map[string]interface{}); ok {
+			return parseEvent(eventType, value, object)
+		}
+	}
+
+	kind, _ := value["kind"].(string)
+	if items, ok := value["items"].([]interface{}); ok && strings.HasSuffix(kind, "List") {
+		return parseList(kind, value, items)
+	}
+
+	r, err := resource(value)
+	if err != nil {
+		return nil, err
+	}
+	return []db.Change{{Type: db.EventAdded, Resource: r}}, nil
+}
+
+// parseEvent returns the change of a watch event. Bookmarks and errors
+// don't change anything.
+func parseEvent(eventType string, value, object map[string]interface{}) ([]db.Change, error) {
+	// The time is written by watcher --output ndjson, see watcher.JSONEvent
+	var at time.Time
+	if s, ok := value["time"].(string); ok {
+		var err error
+		if at, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			return nil, fmt.Errorf("invalid event time: %v", err)
+		}
+	}
+
+	var changeType string
+	switch watch.EventType(eventType) {
+	case watch.Added:
+		changeType = db.EventAdded
+	case watch.Modified:
+		changeType = db.EventModified
+	case watch.Deleted:
+		changeType = db.EventDeleted
+	case watch.Bookmark, watch.Error:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown event type %q", eventType)
+	}
+
+	r, err := resource(object)
+	if err != nil {
+		return nil, err
+	}
+	return []db.Change{{Type: changeType, Resource: r, Time: at}}, nil
+}
+
+// parseList returns a change for every item of a List. Items of typed lists
+// such as PodList may leave out their kind, which comes from the list.
+func parseList(kind string, value map[string]interface{}, items []interface{}) ([]db.Change, error) {
+	apiVersion, _ := value["apiVersion"].(string)
+	itemKind := strings.TrimSuffix(kind, "List")
+
+	var changes []db.Change
+	for i, item := range items {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("item %d is not an object", i)
+		}
+		if _, ok := object["kind"]; !ok && itemKind != "" {
+			object["kind"] = itemKind
+		}
+		if _, ok := object["apiVersion"]; !ok && apiVersion != "" {
+			object["apiVersion"] = apiVersion
+		}
+
+		r, err := resource(object)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %v", i, err)
+		}
+		changes = append(changes, db.Change{Type: db.EventAdded, Resource: r})
+	}
+	return changes, nil
+}
+
+// resource converts an object into a stored resource
+func resource(object map[string]interface{}) (db.Resource, error) {
+	kind, _ := object["kind"].(string)
+	apiVersion, _ := object["apiVersion"].(string)
+	metadata, _ := object["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	if kind == "" || apiVersion == "" || name == "" {
+		return db.Resource{}, fmt.Errorf("expected an object with kind, apiVersion and metadata.name")
+	}
+
+	namespace, _ := metadata["namespace"].(string)
+	resourceVersion, _ := metadata["resourceVersion"].(string)
+
+	data, err := json.Marshal(object)
+	if err != nil {
+		return db.Resource{}, fmt.Errorf("failed to encode %s/%s: %v", kind, name, err)
+	}
+
+	return db.Resource{
+		Name:            name,
+		Namespace:       namespace,
+		Kind:            kind,
+		APIVersion:      apiVersion,
+		ResourceVersion: resourceVersion,
+		Data:            string(data),
+	}, nil
+}
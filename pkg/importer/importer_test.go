@@ -0,0 +1,229 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+)
+
+// summary describes a change as type kind apiVersion namespace/name@version,
+// with the time if it has one
+func summary(c db.Change) string {
+	s := fmt.Sprintf("%s %s %s %s/%s@%s", c.Type, c.Resource.Kind, c.Resource.APIVersion,
+		c.Resource.Namespace, c.Resource.Name, c.Resource.ResourceVersion)
+	if !c.Time.IsZero() {
+		s += " " + c.Time.UTC().Format("15:04:05.000")
+	}
+	return s
+}
+
+// read returns the summaries of the changes read from input
+func read(input string) ([]string, error) {
+	var got []string
+	err := Read(strings.NewReader(input), func(c db.Change) error {
+		got = append(got, summary(c))
+		return nil
+	})
+	return got, err
+}
+
+func TestRead(t *testing.T) {
+	pod := `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"web","namespace":"prod","resourceVersion":"%d"}}`
+
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+		err   string
+	}{
+		{name: "empty", input: ""},
+		{
+			name: "watcher ndjson with times",
+			input: `{"type":"ADDED","time":"2024-05-01T14:00:00.5Z","object":` + fmt.Sprintf(pod, 1) + "}\n" +
+				`{"type":"MODIFIED","time":"2024-05-01T14:00:01Z","object":` + fmt.Sprintf(pod, 2) + "}\n" +
+				`{"type":"DELETED","time":"2024-05-01T14:00:02Z","object":` + fmt.Sprintf(pod, 3) + "}\n",
+			want: []string{
+				"ADDED Pod v1 prod/web@1 14:00:00.500",
+				"MODIFIED Pod v1 prod/web@2 14:00:01.000",
+				"DELETED Pod v1 prod/web@3 14:00:02.000",
+			},
+		},
+		{
+			name: "kubectl watch events without times",
+			input: `{"type":"ADDED","object":` + fmt.Sprintf(pod, 1) + "}" +
+				`{"type":"BOOKMARK","object":{"kind":"Pod","apiVersion":"v1","metadata":{"resourceVersion":"5"}}}` +
+				`{"type":"ERROR","object":{"kind":"Status","apiVersion":"v1","message":"too old"}}` +
+				`{"type":"MODIFIED","object":` + fmt.Sprintf(pod, 6) + "}",
+			want: []string{"ADDED Pod v1 prod/web@1", "MODIFIED Pod v1 prod/web@6"},
+		},
+		{
+			name:  "single indented object",
+			input: "{\n  \"apiVersion\": \"apps/v1\",\n  \"kind\": \"Deployment\",\n  \"metadata\": {\n    \"name\": \"web\"\n  }\n}\n",
+			want:  []string{"ADDED Deployment apps/v1 /web@"},
+		},
+		{
+			name: "generic list with item kinds",
+			input: `{"apiVersion":"v1","kind":"List","items":[` + fmt.Sprintf(pod, 1) + `,` +
+				`{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"web","namespace":"prod"}}]}`,
+			want: []string{"ADDED Pod v1 prod/web@1", "ADDED Deployment apps/v1 prod/web@"},
+		},
+		{
+			name: "typed list whose items leave out their kind",
+			input: `{"apiVersion":"v1","kind":"PodList","metadata":{"resourceVersion":"9"},"items":[` +
+				`{"metadata":{"name":"a","namespace":"prod","resourceVersion":"7"}},` +
+				`{"kind":"Pod","apiVersion":"v1","metadata":{"name":"b","namespace":"prod"}}]}`,
+			want: []string{"ADDED Pod v1 prod/a@7", "ADDED Pod v1 prod/b@"},
+		},
+		{
+			name:  "empty list",
+			input: `{"apiVersion":"v1","kind":"ConfigMapList","items":[]}`,
+		},
+		{
+			name:  "invalid JSON",
+			input: fmt.Sprintf(pod, 1) + "\n{\"kind\":",
+			want:  []string{"ADDED Pod v1 prod/web@1"},
+			err:   "value 2: invalid JSON",
+		},
+		{
+			name:  "not an object",
+			input: `["a"]`,
+			err:   "value 1: invalid JSON",
+		},
+		{
+			name:  "object without a name",
+			input: `{"apiVersion":"v1","kind":"Pod","metadata":{}}`,
+			err:   "value 1: expected an object with kind, apiVersion and metadata.name",
+		},
+		{
+			name:  "untyped list item",
+			input: `{"apiVersion":"v1","kind":"List","items":[{"metadata":{"name":"a"}}]}`,
+			err:   "value 1: item 0: expected an object",
+		},
+		{
+			name:  "list item that isn't an object",
+			input: `{"apiVersion":"v1","kind":"List","items":["a"]}`,
+			err:   "value 1: item 0 is not an object",
+		},
+		{
+			name:  "unknown event type",
+			input: `{"type":"RESTARTED","object":` + fmt.Sprintf(pod, 1) + "}",
+			err:   `value 1: unknown event type "RESTARTED"`,
+		},
+		{
+			name:  "invalid event time",
+			input: `{"type":"ADDED","time":"yesterday","object":` + fmt.Sprintf(pod, 1) + "}",
+			err:   "value 1: invalid event time",
+		},
+		{
+			name:  "event without an object",
+			input: `{"type":"ADDED","kind":"Pod"}`,
+			err:   "value 1: expected an object",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := read(tt.input)
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("Read() error = %v, want %q", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Read() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadKeepsObjects(t *testing.T) {
+	input := `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"a","namespace":"prod","generation":9007199254740993},` +
+		`"data":{"k":"v"}}`
+	var changes []db.Change
+	if err := Read(strings.NewReader(input), func(c db.Change) error {
+		changes = append(changes, c)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || !strings.Contains(changes[0].Resource.Data, "9007199254740993") ||
+		!strings.Contains(changes[0].Resource.Data, `"data":{"k":"v"}`) {
+		t.Errorf("Read() = %+v, want the object with its numbers as written", changes)
+	}
+
+	// List items get the kind and API version of the list
+	input = `{"apiVersion":"v1","kind":"PodList","items":[{"metadata":{"name":"a"}}]}`
+	changes = nil
+	if err := Read(strings.NewReader(input), func(c db.Change) error {
+		changes = append(changes, c)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"a"}}`; changes[0].Resource.Data != want {
+		t.Errorf("item = %s, want %s", changes[0].Resource.Data, want)
+	}
+}
+
+func TestReadStopsOnError(t *testing.T) {
+	input := `{"apiVersion":"v1","kind":"List","items":[` +
+		`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"a"}},{"apiVersion":"v1","kind":"Pod","metadata":{"name":"b"}}]}`
+	stop := errors.New("stop")
+	calls := 0
+	err := Read(strings.NewReader(input), func(c db.Change) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("Read() = %v after %d calls, want the callback's error after 1", err, calls)
+	}
+}
+
+func TestFile(t *testing.T) {
+	store, err := db.New(filepath.Join(t.TempDir(), "import.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// More changes than fit in one batch
+	var b strings.Builder
+	for i := 0; i < batchSize+10; i++ {
+		fmt.Fprintf(&b, `{"type":"ADDED","time":"2024-05-01T14:00:00Z","object":`+
+			`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"c%d","namespace":"prod","resourceVersion":"%d"}}}`+"\n", i, i)
+	}
+	b.WriteString(`{"type":"DELETED","time":"2024-05-01T14:01:00Z","object":` +
+		`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"c0","namespace":"prod","resourceVersion":"999"}}}` + "\n")
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := File(store, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != batchSize+11 {
+		t.Errorf("File() imported %d changes, want %d", n, batchSize+11)
+	}
+	if count, _ := store.ResourceCount(); count != batchSize+9 {
+		t.Errorf("store has %d resources, want %d", count, batchSize+9)
+	}
+
+	if _, err := File(store, filepath.Join(t.TempDir(), "missing.ndjson")); err == nil {
+		t.Error("File() accepted a missing file")
+	}
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := File(store, path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("File() error = %v, want it to name the file", err)
+	}
+}
@@ -0,0 +1,154 @@
+package query
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/health"
+)
+
+// CommonFields are field paths suggested when completing a path
+var CommonFields = []string{
+	"metadata.name",
+	"metadata.ownerReferences[0].kind",
+	"spec.replicas",
+	"spec.nodeName",
+	"spec.type",
+	"status.phase",
+	"status.replicas",
+	"status.readyReplicas",
+	"status.availableReplicas",
+}
+
+// ValueSource returns the known values for a key, e.g. the stored kinds for
+// "kind" or the label keys for "label"
+type ValueSource func(key string) []string
+
+// Suggestion is a completion for the word being typed
+type Suggestion struct {
+	// Text replaces the word being typed
+	Text string
+	// Description explains the suggestion, empty for values
+	Description string
+}
+
+// maxSuggestions limits how many completions are returned
+const maxSuggestions = 10
+
+// Complete suggests completions for the last word of the input. Values for
+// kind, ns, api and label keys come from values, which may be nil; health
+// values are fixed.
+func Complete(input string, values ValueSource) []Suggestion {
+	_, word := splitLastWord(input)
+
+	negate := ""
+	if strings.HasPrefix(word, "-") {
+		negate, word = "-", word[1:]
+	}
+
+	var suggestions []Suggestion
+	idx, op := findOperator(word)
+
+	if idx == -1 {
+		lower := strings.ToLower(word)
+		for _, k := range Keys {
+			if strings.HasPrefix(k.Key, lower) {
+				text := k.Key + string(OpMatch)
+				if k.Key == KeyAge {
+					text = k.Key + string(OpLess)
+				}
+				suggestions = append(suggestions, Suggestion{Text: negate + text, Description: k.Description + ", e.g. " + k.Example})
+			}
+		}
+		if word != "" {
+			for _, f := range CommonFields {
+				if strings.HasPrefix(f, word) && f != word {
+					suggestions = append(suggestions, Suggestion{Text: negate + f + string(OpEqual), Description: "field path"})
+				}
+			}
+		}
+		return limit(suggestions)
+	}
+
+	if values == nil {
+		return nil
+	}
+
+	key, value := strings.ToLower(word[:idx]), word[idx+len(op):]
+	if alias, ok := keyAliases[key]; ok {
+		key = alias
+	}
+
+	switch key {
+	case KeyKind, KeyNamespace, KeyAPIVersion:
+		// Complete the last of a comma separated list
+		done, partial := "", value
+		if i := strings.LastIndex(value, ","); i != -1 {
+			done, partial = value[:i+1], value[i+1:]
+		}
+		for _, v := range matching(values(key), partial) {
+			suggestions = append(suggestions, Suggestion{Text: negate + word[:idx] + string(op) + done + v})
+		}
+
+	case KeyHealth:
+		done, partial := "", value
+		if i := strings.LastIndex(value, ","); i != -1 {
+			done, partial = value[:i+1], value[i+1:]
+		}
+		var statuses []string
+		for _, status := range health.Statuses {
+			statuses = append(statuses, strings.ToLower(string(status)))
+		}
+		for _, v := range matching(statuses, partial) {
+			suggestions = append(suggestions, Suggestion{Text: negate + word[:idx] + string(op) + done + v})
+		}
+
+	case KeyDeleted:
+		for _, v := range matching([]string{"true", "false"}, value) {
+			suggestions = append(suggestions, Suggestion{Text: negate + word[:idx] + string(op) + v})
+		}
+
+	case KeyLabel:
+		if strings.Contains(value, "=") {
+			return nil
+		}
+		for _, v := range matching(values(key), value) {
+			suggestions = append(suggestions, Suggestion{Text: negate + word[:idx] + string(op) + v + "="})
+		}
+	}
+
+	return limit(suggestions)
+}
+
+// Accept replaces the word being typed with a suggestion
+func Accept(input string, s Suggestion) string {
+	prefix, _ := splitLastWord(input)
+	return prefix + s.Text
+}
+
+// splitLastWord splits the input before its last word
+func splitLastWord(input string) (prefix, word string) {
+	i := strings.LastIndexAny(input, " \t")
+	return input[:i+1], input[i+1:]
+}
+
+// matching returns the sorted values that start with prefix, ignoring case
+func matching(values []string, prefix string) []string {
+	var result []string
+	lower := strings.ToLower(prefix)
+	for _, v := range values {
+		if strings.HasPrefix(strings.ToLower(v), lower) && v != prefix {
+			result = append(result, v)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// limit truncates suggestions to maxSuggestions
+func limit(suggestions []Suggestion) []Suggestion {
+	if len(suggestions) > maxSuggestions {
+		return suggestions[:maxSuggestions]
+	}
+	return suggestions
+}
@@ -0,0 +1,229 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Record is a resource as seen by Match
+type Record struct {
+	Name       string
+	Namespace  string
+	Kind       string
+	APIVersion string
+	// Object is the decoded resource
+	Object map[string]interface{}
+	// Data is the encoded resource, searched by text terms
+	Data string
+	// Deleted is set for the final state of a deleted resource
+	Deleted bool
+	// Health is the health status of the resource
+	Health string
+}
+
+// Match evaluates the query against a resource in memory, for stores
+// without SQL. It follows the semantics of the compiled SQL.
+func (q *Query) Match(r Record, now time.Time) bool {
+	if r.Deleted && !q.IncludesDeleted() {
+		return false
+	}
+	for _, t := range q.Terms {
+		if t.match(r, now) == t.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+// match evaluates a single term, ignoring negation
+func (t Term) match(r Record, now time.Time) bool {
+	switch {
+	case t.Key == "":
+		return containsFold(r.Name, t.Value) || containsFold(r.Namespace, t.Value) || containsFold(r.Kind, t.Value)
+
+	case t.Key == KeyKind:
+		return t.matchColumn(r.Kind, true)
+	case t.Key == KeyNamespace:
+		return t.matchColumn(r.Namespace, false)
+	case t.Key == KeyName:
+		return t.matchColumn(r.Name, false)
+	case t.Key == KeyAPIVersion:
+		return t.matchColumn(r.APIVersion, false)
+	case t.Key == KeyHealth:
+		return t.matchColumn(r.Health, true)
+
+	case t.Key == KeyLabel:
+		key, op, value, _ := ParseLabel(t.Value)
+		labels, _ := lookup(r.Object, "metadata.labels").(map[string]interface{})
+		actual, found := labels[key]
+		switch op {
+		case "":
+			return found
+		case OpNotEqual:
+			return !found || !valueEquals(actual, value)
+		default:
+			return found && valueEquals(actual, value)
+		}
+
+	case t.Key == KeyText:
+		return containsFold(r.Data, t.Value)
+
+	case t.Key == KeyDeleted:
+		return r.Deleted == (t.Value == "true")
+
+	case t.Key == KeyAge:
+		age, _ := ParseDuration(t.Value)
+		created, ok := lookup(r.Object, "metadata.creationTimestamp").(string)
+		if !ok {
+			return false
+		}
+		cutoff := now.Add(-age).UTC().Format(time.RFC3339)
+		// Younger resources were created after the cutoff
+		switch t.Op {
+		case OpLess:
+			return created > cutoff
+		case OpLessEqual:
+			return created >= cutoff
+		case OpGreater:
+			return created < cutoff
+		default:
+			return created <= cutoff
+		}
+
+	default:
+		actual := lookup(r.Object, t.Key)
+		switch t.Op {
+		case OpEqual:
+			return actual != nil && valueEquals(actual, t.Value)
+		case OpNotEqual:
+			return actual == nil || !valueEquals(actual, t.Value)
+		default:
+			return compareValue(actual, t.Op, t.Value)
+		}
+	}
+}
+
+// matchColumn matches comma separated alternatives against a value
+func (t Term) matchColumn(actual string, ignoreCase bool) bool {
+	matched := false
+	for _, value := range strings.Split(t.Value, ",") {
+		switch {
+		case strings.Contains(value, "*"):
+			matched = globMatch(value, actual)
+		case ignoreCase:
+			matched = strings.EqualFold(value, actual)
+		default:
+			matched = value == actual
+		}
+		if matched {
+			break
+		}
+	}
+	if t.Op == OpNotEqual {
+		return !matched
+	}
+	return matched
+}
+
+// lookup returns the value at a field path, or nil
+func lookup(obj map[string]interface{}, path string) interface{} {
+	var current interface{} = obj
+	for _, segment := range parsePath(path) {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[segment.name]
+		for _, idx := range segment.indexes {
+			list, ok := current.([]interface{})
+			if !ok || idx >= len(list) {
+				return nil
+			}
+			current = list[idx]
+		}
+	}
+	return current
+}
+
+// valueEquals compares a JSON value with a query value
+func valueEquals(actual interface{}, value string) bool {
+	if strings.Contains(value, "*") {
+		s, ok := actual.(string)
+		return ok && globMatch(value, s)
+	}
+
+	switch v := actual.(type) {
+	case string:
+		return v == value
+	case bool:
+		return fmt.Sprint(v) == value
+	case nil:
+		return false
+	default:
+		n, ok := number(value)
+		actualNumber, actualOK := number(fmt.Sprint(v))
+		return ok && actualOK && n == actualNumber
+	}
+}
+
+// compareValue orders a JSON value against a query value: numbers compare
+// numerically and strings as text
+func compareValue(actual interface{}, op Op, value string) bool {
+	var cmp int
+	if n, ok := number(value); ok {
+		if _, isString := actual.(string); isString || actual == nil {
+			return false
+		}
+		actualNumber, ok := number(fmt.Sprint(actual))
+		if !ok {
+			return false
+		}
+		cmp = compareOrdered(actualNumber, n)
+	} else {
+		s, ok := actual.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(s, value)
+	}
+
+	switch op {
+	case OpLess:
+		return cmp < 0
+	case OpLessEqual:
+		return cmp <= 0
+	case OpGreater:
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// compareOrdered returns -1, 0 or 1
+func compareOrdered(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// containsFold reports whether s contains substr, ignoring case
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// globMatch matches a pattern where * matches anything, ignoring case like
+// SQL LIKE
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	re, err := regexp.Compile("(?is)^" + strings.Join(parts, ".*") + "$")
+	return err == nil && re.MatchString(s)
+}
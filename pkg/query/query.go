@@ -0,0 +1,382 @@
+// Package query parses resource queries such as
+//
+//	kind:Pod ns:prod label:app=nginx status.phase!=Running age<1h
+//
+// and compiles them into SQL over the resources table
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/health"
+)
+
+// Op is a comparison operator
+type Op string
+
+// Operators, longest first so that "!=" is matched before "="
+const (
+	OpNotEqual     Op = "!="
+	OpLessEqual    Op = "<="
+	OpGreaterEqual Op = ">="
+	OpMatch        Op = ":"
+	OpEqual        Op = "="
+	OpLess         Op = "<"
+	OpGreater      Op = ">"
+)
+
+var operators = []Op{OpNotEqual, OpLessEqual, OpGreaterEqual, OpMatch, OpEqual, OpLess, OpGreater}
+
+// Keys that can be used with ':'
+const (
+	KeyKind       = "kind"
+	KeyNamespace  = "ns"
+	KeyName       = "name"
+	KeyAPIVersion = "api"
+	KeyLabel      = "label"
+	KeyAge        = "age"
+	KeyText       = "text"
+	KeyDeleted    = "deleted"
+	KeyHealth     = "health"
+)
+
+// keyAliases maps alternative spellings to their key
+var keyAliases = map[string]string{
+	"namespace":  KeyNamespace,
+	"apiversion": KeyAPIVersion,
+	"labels":     KeyLabel,
+}
+
+// Keys lists the query keys with a short description, in display order
+var Keys = []struct {
+	Key         string
+	Example     string
+	Description string
+}{
+	{KeyKind, "kind:Pod", "resource kind, comma separated for several"},
+	{KeyNamespace, "ns:prod", "namespace, comma separated for several"},
+	{KeyName, "name:web-*", "resource name, * matches anything"},
+	{KeyAPIVersion, "api:apps/v1", "API version"},
+	{KeyLabel, "label:app=nginx", "label value, label:app for presence, label:app!=x"},
+	{KeyAge, "age<1h", "time since creation, with s, m, h, d or w units"},
+	{KeyText, "text:DATABASE_URL", "anywhere in the object"},
+	{KeyDeleted, "deleted:true", "deleted resources, which are hidden otherwise"},
+	{KeyHealth, "health:degraded", "healthy, progressing, degraded, missing (deleted) or unknown"},
+}
+
+// Query is a parsed query. All terms must match.
+type Query struct {
+	Terms []Term
+}
+
+// Term is a single condition of a query
+type Term struct {
+	// Key is one of the Key constants, a field path such as status.phase, or
+	// empty for a bare word matched against name, namespace and kind
+	Key string
+	// Field is set when Key is a field path
+	Field bool
+	Op    Op
+	Value string
+	// Negate inverts the term, written with a leading '-'
+	Negate bool
+	// Pos is the byte offset of the term in the input
+	Pos int
+}
+
+// String formats the term as it would be written
+func (t Term) String() string {
+	var b strings.Builder
+	if t.Negate {
+		b.WriteString("-")
+	}
+	if t.Key != "" {
+		b.WriteString(t.Key)
+		b.WriteString(string(t.Op))
+	}
+	if strings.ContainsAny(t.Value, " \t\"") {
+		b.WriteString(fmt.Sprintf("%q", t.Value))
+	} else {
+		b.WriteString(t.Value)
+	}
+	return b.String()
+}
+
+// String formats the query as it would be written
+func (q *Query) String() string {
+	terms := make([]string, len(q.Terms))
+	for i, t := range q.Terms {
+		terms[i] = t.String()
+	}
+	return strings.Join(terms, " ")
+}
+
+// IncludesDeleted reports whether the query has a deleted term, or asks for
+// missing resources, which are the deleted ones. Deleted resources are
+// hidden from other queries.
+func (q *Query) IncludesDeleted() bool {
+	for _, t := range q.Terms {
+		if t.Key == KeyDeleted {
+			return true
+		}
+		if t.Key == KeyHealth && !t.Negate && t.Op != OpNotEqual {
+			for _, value := range strings.Split(t.Value, ",") {
+				if status, _ := health.ParseStatus(value); status == health.Missing {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// ParseError describes invalid query syntax
+type ParseError struct {
+	Input string
+	// Pos is the byte offset of the problem in Input
+	Pos int
+	Msg string
+}
+
+// Error reports the problem with its 1-based column
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Pos+1, e.Msg)
+}
+
+// Context shows the input with a caret under the problem
+func (e *ParseError) Context() string {
+	return e.Input + "\n" + strings.Repeat(" ", e.Pos) + "^"
+}
+
+// fieldPath matches paths such as status.phase and spec.containers[0].image
+var fieldPath = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*(\[[0-9]+\])*(\.[A-Za-z_][A-Za-z0-9_-]*(\[[0-9]+\])*)*$`)
+
+// Parse parses a query. An empty query matches everything.
+func Parse(input string) (*Query, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Query{}
+	for _, tok := range tokens {
+		term, err := parseTerm(input, tok)
+		if err != nil {
+			return nil, err
+		}
+		q.Terms = append(q.Terms, term)
+	}
+	return q, nil
+}
+
+// token is a whitespace separated part of the input with quotes removed
+type token struct {
+	text string
+	pos  int
+	// quoted records where quoted text starts so operators inside quotes
+	// aren't parsed
+	quoted int
+}
+
+// tokenize splits the input on whitespace outside double quotes
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	var current *token
+	var b strings.Builder
+	inQuote, quoteStart := false, 0
+
+	for i, c := range input {
+		switch {
+		case c == '"':
+			if current == nil {
+				current = &token{pos: i, quoted: -1}
+			}
+			if !inQuote && current.quoted == -1 {
+				current.quoted = b.Len()
+			}
+			inQuote = !inQuote
+			quoteStart = i
+		case (c == ' ' || c == '\t') && !inQuote:
+			if current != nil {
+				current.text = b.String()
+				tokens = append(tokens, *current)
+				current = nil
+				b.Reset()
+			}
+		default:
+			if current == nil {
+				current = &token{pos: i, quoted: -1}
+			}
+			b.WriteRune(c)
+		}
+	}
+
+	if inQuote {
+		return nil, &ParseError{Input: input, Pos: quoteStart, Msg: "unterminated quote"}
+	}
+	if current != nil {
+		current.text = b.String()
+		tokens = append(tokens, *current)
+	}
+	return tokens, nil
+}
+
+// parseTerm parses a single token
+func parseTerm(input string, tok token) (Term, error) {
+	term := Term{Pos: tok.pos}
+	text := tok.text
+	offset := 0
+
+	fail := func(at int, format string, args ...interface{}) (Term, error) {
+		return Term{}, &ParseError{Input: input, Pos: tok.pos + at, Msg: fmt.Sprintf(format, args...)}
+	}
+
+	if strings.HasPrefix(text, "-") && len(text) > 1 {
+		term.Negate = true
+		text = text[1:]
+		offset = 1
+	}
+
+	// Operators inside quoted text belong to the value
+	searchable := text
+	if tok.quoted >= offset {
+		searchable = text[:tok.quoted-offset]
+	}
+
+	idx, op := findOperator(searchable)
+	if idx == -1 {
+		if text == "" {
+			return fail(0, "expected a search term")
+		}
+		term.Value = text
+		return term, nil
+	}
+
+	key, value := text[:idx], text[idx+len(op):]
+	term.Op = op
+	term.Value = value
+
+	if key == "" {
+		return fail(offset, "expected a key before %q", op)
+	}
+	if value == "" && tok.quoted == -1 {
+		return fail(offset+idx+len(op), "expected a value after %s%s", key, op)
+	}
+
+	if alias, ok := keyAliases[strings.ToLower(key)]; ok {
+		key = alias
+	}
+
+	switch strings.ToLower(key) {
+	case KeyKind, KeyNamespace, KeyName, KeyAPIVersion:
+		term.Key = strings.ToLower(key)
+		if op != OpMatch && op != OpEqual && op != OpNotEqual {
+			return fail(offset+idx, "%s can only be compared with ':', '=' or '!='", term.Key)
+		}
+
+	case KeyLabel:
+		term.Key = KeyLabel
+		if op != OpMatch {
+			return fail(offset+idx, "use label:key=value to match labels")
+		}
+		if _, _, _, err := ParseLabel(value); err != nil {
+			return fail(offset+idx+1, "%v", err)
+		}
+
+	case KeyText:
+		term.Key = KeyText
+		if op != OpMatch {
+			return fail(offset+idx, "use text:word to search the whole object")
+		}
+
+	case KeyDeleted:
+		term.Key = KeyDeleted
+		if op != OpMatch && op != OpEqual {
+			return fail(offset+idx, "use deleted:true to find deleted resources")
+		}
+		if value != "true" && value != "false" {
+			return fail(offset+idx+len(op), "deleted must be true or false")
+		}
+
+	case KeyHealth:
+		term.Key = KeyHealth
+		if op != OpMatch && op != OpEqual && op != OpNotEqual {
+			return fail(offset+idx, "health can only be compared with ':', '=' or '!='")
+		}
+		for _, value := range strings.Split(value, ",") {
+			if _, ok := health.ParseStatus(value); !ok {
+				return fail(offset+idx+len(op), "unknown health %q; expected healthy, progressing, degraded, missing or unknown", value)
+			}
+		}
+
+	case KeyAge:
+		term.Key = KeyAge
+		switch op {
+		case OpLess, OpLessEqual, OpGreater, OpGreaterEqual:
+		default:
+			return fail(offset+idx, "age must be compared with <, <=, > or >=, e.g. age<1h")
+		}
+		if _, err := ParseDuration(value); err != nil {
+			return fail(offset+idx+len(op), "%v", err)
+		}
+
+	default:
+		if !strings.ContainsAny(key, ".[") {
+			return fail(offset, "unknown key %q; expected one of %s, or a field path such as status.phase",
+				key, strings.Join(keyNames(), ", "))
+		}
+		if !fieldPath.MatchString(key) {
+			return fail(offset, "invalid field path %q", key)
+		}
+		if op == OpMatch {
+			return fail(offset+idx, "compare field paths with =, !=, <, <=, > or >=, e.g. %s=%s", key, value)
+		}
+		term.Key = key
+		term.Field = true
+	}
+
+	return term, nil
+}
+
+// findOperator returns the position of the first operator in text
+func findOperator(text string) (int, Op) {
+	for i := range text {
+		for _, op := range operators {
+			if strings.HasPrefix(text[i:], string(op)) {
+				return i, op
+			}
+		}
+	}
+	return -1, ""
+}
+
+// ParseLabel splits a label term value into key, operator and value.
+// The operator is empty when only the presence of the key is tested.
+func ParseLabel(value string) (key string, op Op, labelValue string, err error) {
+	if i := strings.Index(value, "!="); i != -1 {
+		key, op, labelValue = value[:i], OpNotEqual, value[i+2:]
+	} else if i := strings.Index(value, "="); i != -1 {
+		key, op, labelValue = value[:i], OpEqual, value[i+1:]
+	} else {
+		key = value
+	}
+
+	if key == "" {
+		return "", "", "", fmt.Errorf("expected a label key")
+	}
+	if strings.ContainsAny(key, `"*`) {
+		return "", "", "", fmt.Errorf("invalid label key %q", key)
+	}
+	return key, op, labelValue, nil
+}
+
+// keyNames returns the query keys in display order
+func keyNames() []string {
+	names := make([]string, len(Keys))
+	for i, k := range Keys {
+		names[i] = k.Key
+	}
+	return names
+}
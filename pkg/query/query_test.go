@@ -0,0 +1,550 @@
+package query
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []Term
+	}{
+		{"", nil},
+		{"kind:Pod", []Term{{Key: KeyKind, Op: OpMatch, Value: "Pod"}}},
+		{"KIND=Pod", []Term{{Key: KeyKind, Op: OpEqual, Value: "Pod"}}},
+		{"namespace!=prod", []Term{{Key: KeyNamespace, Op: OpNotEqual, Value: "prod"}}},
+		{"apiVersion:apps/v1", []Term{{Key: KeyAPIVersion, Op: OpMatch, Value: "apps/v1"}}},
+		{"name:web-*", []Term{{Key: KeyName, Op: OpMatch, Value: "web-*"}}},
+		{"labels:app!=web", []Term{{Key: KeyLabel, Op: OpMatch, Value: "app!=web"}}},
+		{"-label:app", []Term{{Key: KeyLabel, Op: OpMatch, Value: "app", Negate: true}}},
+		{"age>=7d", []Term{{Key: KeyAge, Op: OpGreaterEqual, Value: "7d"}}},
+		{"age<90m", []Term{{Key: KeyAge, Op: OpLess, Value: "90m"}}},
+		{"deleted=false", []Term{{Key: KeyDeleted, Op: OpEqual, Value: "false"}}},
+		{"health:degraded,unknown", []Term{{Key: KeyHealth, Op: OpMatch, Value: "degraded,unknown"}}},
+		{"status.phase!=Running", []Term{{Key: "status.phase", Field: true, Op: OpNotEqual, Value: "Running"}}},
+		{"spec.containers[0].image=nginx:*", []Term{{Key: "spec.containers[0].image", Field: true, Op: OpEqual, Value: "nginx:*"}}},
+		{"spec.replicas<=3", []Term{{Key: "spec.replicas", Field: true, Op: OpLessEqual, Value: "3"}}},
+		{"status.replicas>1", []Term{{Key: "status.replicas", Field: true, Op: OpGreater, Value: "1"}}},
+		// Quoted text keeps spaces and operators
+		{`name:"my app"`, []Term{{Key: KeyName, Op: OpMatch, Value: "my app"}}},
+		{`text:"a:b=c"`, []Term{{Key: KeyText, Op: OpMatch, Value: "a:b=c"}}},
+		{`"kind:Pod"`, []Term{{Value: "kind:Pod"}}},
+		{`name:""`, []Term{{Key: KeyName, Op: OpMatch, Value: ""}}},
+		// Bare words and positions of several terms
+		{"web  ns:prod\t-kind:Pod", []Term{
+			{Value: "web"},
+			{Key: KeyNamespace, Op: OpMatch, Value: "prod", Pos: 5},
+			{Key: KeyKind, Op: OpMatch, Value: "Pod", Negate: true, Pos: 13},
+		}},
+		{"-", []Term{{Value: "-"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			q, err := Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(q.Terms, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.input, q.Terms, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   int
+		msg   string
+	}{
+		{`kind:Pod "unterminated`, 9, "unterminated quote"},
+		{`""`, 0, "expected a search term"},
+		{":Pod", 0, `expected a key before ":"`},
+		{"-=x", 1, `expected a key before "="`},
+		{"kind:", 5, "expected a value after kind:"},
+		{"age<=", 5, "expected a value after age<="},
+		{"kind<Pod", 4, "kind can only be compared"},
+		{"ns>=prod", 2, "ns can only be compared"},
+		{"label=app", 5, "use label:key=value"},
+		{"label:=web", 6, "expected a label key"},
+		{`label:a*=b`, 6, "invalid label key"},
+		{"text=word", 4, "use text:word"},
+		{"deleted>true", 7, "use deleted:true"},
+		{"deleted:maybe", 8, "deleted must be true or false"},
+		{"health<healthy", 6, "health can only be compared"},
+		{"health:healthy,sick", 7, `unknown health "sick"`},
+		{"age:1h", 3, "age must be compared"},
+		{"age<soon", 4, `invalid duration "soon"`},
+		{"foo:bar", 0, `unknown key "foo"`},
+		{"kind:Pod -foo:bar", 10, `unknown key "foo"`},
+		{"status..phase=x", 0, `invalid field path "status..phase"`},
+		{"spec.x[a]=1", 0, "invalid field path"},
+		{"status.phase:Running", 12, "compare field paths with"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := Parse(tt.input)
+			perr, ok := err.(*ParseError)
+			if !ok {
+				t.Fatalf("Parse(%q) error = %v, want a ParseError", tt.input, err)
+			}
+			if perr.Pos != tt.pos || !strings.Contains(perr.Msg, tt.msg) {
+				t.Errorf("Parse(%q) = %q at %d, want %q at %d", tt.input, perr.Msg, perr.Pos, tt.msg, tt.pos)
+			}
+			if perr.Input != tt.input {
+				t.Errorf("ParseError.Input = %q, want %q", perr.Input, tt.input)
+			}
+		})
+	}
+}
+
+func TestParseErrorFormat(t *testing.T) {
+	_, err := Parse("kind:Pod age:1h")
+	if err == nil {
+		t.Fatal("Parse() succeeded")
+	}
+	if !strings.HasPrefix(err.Error(), "column 13: ") {
+		t.Errorf("Error() = %q, want the 1-based column", err.Error())
+	}
+	if want := "kind:Pod age:1h\n            ^"; err.(*ParseError).Context() != want {
+		t.Errorf("Context() = %q, want %q", err.(*ParseError).Context(), want)
+	}
+}
+
+func TestString(t *testing.T) {
+	for _, input := range []string{
+		"kind:Pod",
+		"-label:app=web ns!=prod",
+		`name:"my app" text:"a b"`,
+		"status.phase!=Running age>=1d web",
+	} {
+		q, err := Parse(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := q.String(); got != input {
+			t.Errorf("String() = %q, want %q", got, input)
+		}
+	}
+}
+
+func TestIncludesDeleted(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"kind:Pod", false},
+		{"deleted:true", true},
+		{"deleted:false", true},
+		{"health:missing", true},
+		{"health:degraded,Missing", true},
+		{"-health:missing", false},
+		{"health!=missing", false},
+		{"health:degraded", false},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := q.IncludesDeleted(); got != tt.want {
+			t.Errorf("IncludesDeleted(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{value: "90m", want: 90 * time.Minute},
+		{value: "1h30m", want: 90 * time.Minute},
+		{value: "1d12h", want: 36 * time.Hour},
+		{value: "2w", want: 14 * 24 * time.Hour},
+		{value: "1w2d", want: 9 * 24 * time.Hour},
+		{value: "d", err: true},
+		{value: "5x", err: true},
+		{value: "1d2w", want: 15 * 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.value)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseDuration(%q) = %v, %v; want %v, error %v", tt.value, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestParseLabel(t *testing.T) {
+	tests := []struct {
+		value string
+		key   string
+		op    Op
+		label string
+		err   bool
+	}{
+		{value: "app", key: "app"},
+		{value: "app=web", key: "app", op: OpEqual, label: "web"},
+		{value: "app!=web", key: "app", op: OpNotEqual, label: "web"},
+		{value: "app.kubernetes.io/name=web=1", key: "app.kubernetes.io/name", op: OpEqual, label: "web=1"},
+		{value: "app=", key: "app", op: OpEqual},
+		{value: "=web", err: true},
+		{value: `"app"`, err: true},
+	}
+	for _, tt := range tests {
+		key, op, label, err := ParseLabel(tt.value)
+		if (err != nil) != tt.err || key != tt.key || op != tt.op || label != tt.label {
+			t.Errorf("ParseLabel(%q) = %q %q %q %v", tt.value, key, op, label, err)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	values := func(key string) []string {
+		return map[string][]string{
+			KeyKind:       {"Pod", "Deployment", "ConfigMap"},
+			KeyNamespace:  {"prod", "staging"},
+			KeyAPIVersion: {"v1", "apps/v1"},
+			KeyLabel:      {"tier", "app"},
+		}[key]
+	}
+
+	tests := []struct {
+		input  string
+		values ValueSource
+		want   []string
+	}{
+		{input: "k", values: values, want: []string{"kind:"}},
+		{input: "a", values: values, want: []string{"api:", "age<"}},
+		{input: "ns:prod -KI", values: values, want: []string{"-kind:"}},
+		{input: "ns:prod -ki", values: values, want: []string{"-kind:"}},
+		{input: "status.r", values: values, want: []string{"status.replicas=", "status.readyReplicas="}},
+		{input: "status.phase", values: values},
+		{input: "kind:", values: values, want: []string{"kind:ConfigMap", "kind:Deployment", "kind:Pod"}},
+		{input: "kind:p", values: values, want: []string{"kind:Pod"}},
+		{input: "kind:Pod", values: values},
+		{input: "kind:Pod,d", values: values, want: []string{"kind:Pod,Deployment"}},
+		{input: "-namespace=st", values: values, want: []string{"-namespace=staging"}},
+		{input: "api:a", values: values, want: []string{"api:apps/v1"}},
+		{input: "health:", values: values, want: []string{"health:degraded", "health:healthy", "health:missing", "health:progressing", "health:unknown"}},
+		{input: "health:healthy,p", values: values, want: []string{"health:healthy,progressing"}},
+		{input: "deleted:", values: values, want: []string{"deleted:false", "deleted:true"}},
+		{input: "label:", values: values, want: []string{"label:app=", "label:tier="}},
+		{input: "label:app=", values: values},
+		{input: "name:w", values: values},
+		{input: "kind:", values: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var got []string
+			for _, s := range Complete(tt.input, tt.values) {
+				got = append(got, s.Text)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Complete(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+
+	// Every key is suggested for an empty word, with a description
+	suggestions := Complete("kind:Pod ", values)
+	if len(suggestions) != len(Keys) || suggestions[0].Description == "" {
+		t.Errorf("Complete() after a space = %+v, want every key", suggestions)
+	}
+	if got := Accept("kind:Pod -na", Suggestion{Text: "-namespace:"}); got != "kind:Pod -namespace:" {
+		t.Errorf("Accept() = %q", got)
+	}
+}
+
+// testResource is a row of the resources table, also matched in memory
+type testResource struct {
+	name, namespace, kind, apiVersion string
+	deleted                           bool
+	health                            string
+	data                              string
+}
+
+// testNow is the reference time of the age terms
+var testNow = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// created formats a creation time some time before testNow
+func created(ago time.Duration) string {
+	return testNow.Add(-ago).Format(time.RFC3339)
+}
+
+var testResources = []testResource{
+	{"web", "prod", "Deployment", "apps/v1", false, "Healthy",
+		`{"metadata":{"name":"web","creationTimestamp":"` + created(2*time.Hour) + `","labels":{"app":"web","tier":"frontend"}},` +
+			`"spec":{"replicas":3},"status":{"readyReplicas":3}}`},
+	{"web-1", "prod", "Pod", "v1", false, "Healthy",
+		`{"metadata":{"name":"web-1","creationTimestamp":"` + created(30*time.Minute) + `","labels":{"app":"web"}},` +
+			`"spec":{"containers":[{"name":"web","image":"nginx:1.25"}]},"status":{"phase":"Running"}}`},
+	{"worker", "staging", "Pod", "v1", false, "Degraded",
+		`{"metadata":{"name":"worker","creationTimestamp":"` + created(72*time.Hour) + `","labels":{"app":"worker"}},` +
+			`"spec":{"priority":10,"containers":[{"name":"worker","image":"worker:2"}]},"status":{"phase":"Failed"}}`},
+	{"batch-job", "staging", "Job", "batch/v1", false, "Progressing",
+		`{"metadata":{"name":"batch-job","creationTimestamp":"` + created(time.Hour) + `","labels":{"app":"web_1"}},` +
+			`"status":{"succeeded":1}}`},
+	{"settings", "default", "ConfigMap", "v1", false, "Healthy",
+		`{"metadata":{"name":"settings","creationTimestamp":"` + created(240*time.Hour) + `"},` +
+			`"data":{"DATABASE_URL":"postgres://db","replicas":"3"}}`},
+	{"node-a", "", "Node", "v1", false, "Healthy",
+		`{"metadata":{"name":"node-a","creationTimestamp":"` + created(168*time.Hour) + `",` +
+			`"labels":{"kubernetes.io/hostname":"node-a"}},"spec":{"unschedulable":true}}`},
+	{"old", "default", "ConfigMap", "v1", true, "Missing",
+		`{"metadata":{"name":"old","creationTimestamp":"` + created(480*time.Hour) + `","labels":{"app":"web"}}}`},
+}
+
+// openTestDB stores the resources and their labels in an in-memory
+// database with the columns queries are compiled for
+func openTestDB(t *testing.T, resources []testResource) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection would open its own in-memory database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(`
+		CREATE TABLE resources (
+			id INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			namespace TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			api_version TEXT NOT NULL,
+			data TEXT NOT NULL,
+			deleted_at INTEGER,
+			health TEXT NOT NULL
+		);
+		CREATE TABLE labels (resource_id INTEGER NOT NULL, key TEXT NOT NULL, value TEXT NOT NULL);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range resources {
+		var deletedAt interface{}
+		if r.deleted {
+			deletedAt = testNow.Unix()
+		}
+		result, err := db.Exec(`
+			INSERT INTO resources (name, namespace, kind, api_version, data, deleted_at, health)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, r.name, r.namespace, r.kind, r.apiVersion, r.data, deletedAt, r.health)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := result.LastInsertId()
+		labels, _ := lookup(r.record(t).Object, "metadata.labels").(map[string]interface{})
+		for key, value := range labels {
+			if _, err := db.Exec("INSERT INTO labels (resource_id, key, value) VALUES (?, ?, ?)", id, key, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return db
+}
+
+// record returns the resource as matched in memory
+func (r testResource) record(t *testing.T) Record {
+	t.Helper()
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(r.data), &obj); err != nil {
+		t.Fatal(err)
+	}
+	return Record{Name: r.name, Namespace: r.namespace, Kind: r.kind, APIVersion: r.apiVersion,
+		Object: obj, Data: r.data, Deleted: r.deleted, Health: r.health}
+}
+
+// selectNames runs the compiled query and returns the sorted names
+func selectNames(t *testing.T, db *sql.DB, q *Query) []string {
+	t.Helper()
+	where, args := q.Where(Options{Now: testNow})
+	rows, err := db.Query("SELECT name FROM resources WHERE "+where, args...)
+	if err != nil {
+		t.Fatalf("query %q failed: %v", where, err)
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// matchNames returns the sorted names of the resources Match accepts
+func matchNames(t *testing.T, q *Query) []string {
+	t.Helper()
+	names := []string{}
+	for _, r := range testResources {
+		if q.Match(r.record(t), testNow) {
+			names = append(names, r.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestWhereAndMatch(t *testing.T) {
+	db := openTestDB(t, testResources)
+	live := []string{"batch-job", "node-a", "settings", "web", "web-1", "worker"}
+
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"", live},
+		// Bare words match name, namespace or kind, ignoring case
+		{"work", []string{"worker"}},
+		{"PROD", []string{"web", "web-1"}},
+		{"configmap", []string{"settings"}},
+		{"-web", []string{"batch-job", "node-a", "settings", "worker"}},
+
+		{"kind:Pod", []string{"web-1", "worker"}},
+		{"kind=pod,CONFIGMAP", []string{"settings", "web-1", "worker"}},
+		{"kind!=Pod", []string{"batch-job", "node-a", "settings", "web"}},
+		{"-kind:Pod", []string{"batch-job", "node-a", "settings", "web"}},
+		{"kind:p*", []string{"web-1", "worker"}},
+		{"kind:*map", []string{"settings"}},
+		{"ns:prod", []string{"web", "web-1"}},
+		{"namespace=staging,default", []string{"batch-job", "settings", "worker"}},
+		{"ns:PROD", []string{}},
+		{"name:web*", []string{"web", "web-1"}},
+		{"name:WEB", []string{}},
+		{`name:"web-1"`, []string{"web-1"}},
+		{"api:apps/v1", []string{"web"}},
+		{"api!=v1", []string{"batch-job", "web"}},
+
+		{"label:app", []string{"batch-job", "web", "web-1", "worker"}},
+		{"label:app=web", []string{"web", "web-1"}},
+		{"label:app!=web", []string{"batch-job", "node-a", "settings", "worker"}},
+		{"-label:app", []string{"node-a", "settings"}},
+		{"label:app=web*", []string{"batch-job", "web", "web-1"}},
+		{"label:app=web_*", []string{"batch-job"}},
+		{"label:app=w_b", []string{}},
+		{"label:kubernetes.io/hostname=node-a", []string{"node-a"}},
+
+		{"age<1h", []string{"web-1"}},
+		{"age<=1h", []string{"batch-job", "web-1"}},
+		{"age>2d", []string{"node-a", "settings", "worker"}},
+		{"age>=1w", []string{"node-a", "settings"}},
+		{"-age<1d", []string{"node-a", "settings", "worker"}},
+
+		{"text:DATABASE_URL", []string{"settings"}},
+		{"text:database_url", []string{"settings"}},
+		{`text:"postgres://db"`, []string{"settings"}},
+		{"text:100%", []string{}},
+
+		{"deleted:true", []string{"old"}},
+		{"deleted:false", live},
+		{"deleted:true label:app=web", []string{"old"}},
+
+		{"health:degraded", []string{"worker"}},
+		{"health:missing", []string{"old"}},
+		{"health!=healthy", []string{"batch-job", "worker"}},
+		{"health=Healthy,progressing", []string{"batch-job", "node-a", "settings", "web", "web-1"}},
+		{"-health:healthy", []string{"batch-job", "worker"}},
+
+		{"status.phase=Running", []string{"web-1"}},
+		{"status.phase!=Running", []string{"batch-job", "node-a", "settings", "web", "worker"}},
+		{"-status.phase=Running kind:Pod", []string{"worker"}},
+		{"status.phase>Pending", []string{"web-1"}},
+		{"status.phase<=Failed", []string{"worker"}},
+		{"spec.replicas=3", []string{"web"}},
+		{"spec.replicas>=2", []string{"web"}},
+		{"spec.replicas<3", []string{}},
+		{"data.replicas=3", []string{"settings"}},
+		// Numbers and text don't compare with each other
+		{"data.replicas>2", []string{}},
+		{"spec.replicas<abc", []string{}},
+		{"spec.unschedulable>0", []string{}},
+		{"status.readyReplicas=spec.replicas", []string{}},
+		{"spec.priority<20", []string{"worker"}},
+		{"status.succeeded>0.5", []string{"batch-job"}},
+		{"spec.unschedulable=true", []string{"node-a"}},
+		{"spec.unschedulable!=true", []string{"batch-job", "settings", "web", "web-1", "worker"}},
+		{"spec.containers[0].image=nginx:*", []string{"web-1"}},
+		{"spec.containers[1].image=nginx:*", []string{}},
+		{"spec.containers[0].name!=web", []string{"batch-job", "node-a", "settings", "web", "worker"}},
+		{`metadata.labels.app=web`, []string{"web", "web-1"}},
+
+		{"kind:Pod -name:worker ns:prod", []string{"web-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			q, err := Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			selected := selectNames(t, db, q)
+			matched := matchNames(t, q)
+			if !reflect.DeepEqual(selected, tt.want) {
+				t.Errorf("Where(%q) selected %v, want %v", tt.input, selected, tt.want)
+			}
+			if !reflect.DeepEqual(matched, selected) {
+				t.Errorf("Match(%q) = %v, but Where selected %v", tt.input, matched, selected)
+			}
+		})
+	}
+}
+
+func TestWherePostgres(t *testing.T) {
+	tests := []struct {
+		input string
+		where string
+		args  []interface{}
+	}{
+		{"kind:Pod", "deleted_at IS NULL AND lower(kind) = ?", []interface{}{"pod"}},
+		{"ns:prod,dev", "deleted_at IS NULL AND (namespace = ? OR namespace = ?)", []interface{}{"prod", "dev"}},
+		{"name:web_*", `deleted_at IS NULL AND name ILIKE ? ESCAPE '\'`, []interface{}{`web\_%`}},
+		{"label:app=web", "deleted_at IS NULL AND data @> ?::jsonb",
+			[]interface{}{`{"metadata":{"labels":{"app":"web"}}}`}},
+		{"label:app", "deleted_at IS NULL AND data -> 'metadata' -> 'labels' ->> ?::text IS NOT NULL", []interface{}{"app"}},
+		{"text:x", `deleted_at IS NULL AND data::text ILIKE ? ESCAPE '\'`, []interface{}{"%x%"}},
+		{"status.phase!=Running", "deleted_at IS NULL AND NOT COALESCE(data #>> ?::text[] = ?, FALSE)",
+			[]interface{}{`{"status","phase"}`, "Running"}},
+		{"spec.containers[0].image=x", "deleted_at IS NULL AND data #>> ?::text[] = ?",
+			[]interface{}{`{"spec","containers",0,"image"}`, "x"}},
+		{"status.phase<b", "deleted_at IS NULL AND CASE WHEN jsonb_typeof(data #> ?::text[]) = 'string' THEN data #>> ?::text[] END < ?",
+			[]interface{}{`{"status","phase"}`, `{"status","phase"}`, "b"}},
+		{"spec.replicas>2", "deleted_at IS NULL AND CASE WHEN jsonb_typeof(data #> ?::text[]) = 'number' THEN (data #>> ?::text[])::numeric END > ?",
+			[]interface{}{`{"spec","replicas"}`, `{"spec","replicas"}`, 2.0}},
+		{"deleted:true", "deleted_at IS NOT NULL", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			q, err := Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			where, args := q.Where(Options{Now: testNow, Dialect: Postgres})
+			if where != tt.where || !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("Where(%q) = %q %v, want %q %v", tt.input, where, args, tt.where, tt.args)
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialect is the SQL flavour a query is compiled for
+type Dialect int
+
+// Supported dialects
+const (
+	// SQLite queries JSON with json_extract and labels through the labels table
+	SQLite Dialect = iota
+	// Postgres queries a JSONB data column
+	Postgres
+)
+
+// Options control how a query is compiled
+type Options struct {
+	// Now is the reference time for age terms
+	Now time.Time
+	// Dialect selects the SQL flavour, SQLite by default
+	Dialect Dialect
+	// FullTextTable names an SQLite FTS5 table indexing resources by id. When
+	// empty, text terms are substring matches on the object data.
+	FullTextTable string
+}
+
+// Where compiles the query into a condition over the columns of the
+// resources table (id, name, namespace, kind, api_version, data,
+// deleted_at, health); with SQLite, label terms use the labels table. Values are
+// returned as arguments for the ? placeholders.
+func (q *Query) Where(opts Options) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if !q.IncludesDeleted() {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+
+	for _, t := range q.Terms {
+		cond, termArgs := t.where(opts)
+		if t.Negate {
+			cond = not(cond)
+		}
+		conditions = append(conditions, cond)
+		args = append(args, termArgs...)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// where compiles a single term
+func (t Term) where(opts Options) (string, []interface{}) {
+	like := opts.like()
+
+	switch {
+	case t.Key == "":
+		pattern := likePattern(t.Value, true)
+		return fmt.Sprintf(`(name %[1]s ? ESCAPE '\' OR namespace %[1]s ? ESCAPE '\' OR kind %[1]s ? ESCAPE '\')`, like),
+			[]interface{}{pattern, pattern, pattern}
+
+	case t.Key == KeyKind:
+		return t.columnWhere(opts, "kind", true)
+	case t.Key == KeyNamespace:
+		return t.columnWhere(opts, "namespace", false)
+	case t.Key == KeyName:
+		return t.columnWhere(opts, "name", false)
+	case t.Key == KeyAPIVersion:
+		return t.columnWhere(opts, "api_version", false)
+	case t.Key == KeyHealth:
+		return t.columnWhere(opts, "health", true)
+
+	case t.Key == KeyLabel:
+		key, op, value, _ := ParseLabel(t.Value)
+		cond, args := labelWhere(opts, key, op, value)
+		if op == OpNotEqual {
+			// Resources without the label are also not equal
+			cond = not(cond)
+		}
+		return cond, args
+
+	case t.Key == KeyText:
+		if opts.FullTextTable != "" {
+			phrase := `"` + strings.ReplaceAll(t.Value, `"`, `""`) + `"`
+			return fmt.Sprintf("id IN (SELECT rowid FROM %s WHERE %s MATCH ?)", opts.FullTextTable, opts.FullTextTable),
+				[]interface{}{phrase}
+		}
+		data := "data"
+		if opts.Dialect == Postgres {
+			data = "data::text"
+		}
+		return fmt.Sprintf(`%s %s ? ESCAPE '\'`, data, like), []interface{}{likePattern(t.Value, true)}
+
+	case t.Key == KeyDeleted:
+		if t.Value == "true" {
+			return "deleted_at IS NOT NULL", nil
+		}
+		return "deleted_at IS NULL", nil
+
+	case t.Key == KeyAge:
+		// A younger resource was created after the cutoff
+		age, _ := ParseDuration(t.Value)
+		cutoff := opts.Now.Add(-age).UTC().Format(time.RFC3339)
+		ops := map[Op]string{OpLess: ">", OpLessEqual: ">=", OpGreater: "<", OpGreaterEqual: "<="}
+		expr, pathArg := opts.field("metadata.creationTimestamp")
+		return fmt.Sprintf("%s %s ?", expr, ops[t.Op]), []interface{}{pathArg, cutoff}
+
+	default:
+		expr, pathArg := opts.field(t.Key)
+		switch t.Op {
+		case OpEqual:
+			cond, args := opts.matchValue(expr, t.Value)
+			return cond, append([]interface{}{pathArg}, args...)
+		case OpNotEqual:
+			// Missing fields are also not equal
+			cond, args := opts.matchValue(expr, t.Value)
+			return not(cond), append([]interface{}{pathArg}, args...)
+		default:
+			return opts.compare(t.Key, t.Op, t.Value)
+		}
+	}
+}
+
+// columnWhere compiles a term on a column, accepting comma separated values
+func (t Term) columnWhere(opts Options, column string, ignoreCase bool) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	for _, value := range strings.Split(t.Value, ",") {
+		expr := column
+		switch {
+		case !ignoreCase || strings.Contains(value, "*"):
+		case opts.Dialect == Postgres:
+			expr, value = "lower("+column+")", strings.ToLower(value)
+		default:
+			expr = column + " COLLATE NOCASE"
+		}
+
+		cond, valueArgs := opts.matchValue(expr, value)
+		conditions = append(conditions, cond)
+		args = append(args, valueArgs...)
+	}
+
+	cond := strings.Join(conditions, " OR ")
+	if len(conditions) > 1 {
+		cond = "(" + cond + ")"
+	}
+	if t.Op == OpNotEqual {
+		cond = not(cond)
+	}
+	return cond, args
+}
+
+// labelWhere compiles a label presence or equality test
+func labelWhere(opts Options, key string, op Op, value string) (string, []interface{}) {
+	if opts.Dialect == Postgres {
+		labels := "data -> 'metadata' -> 'labels'"
+		switch {
+		case op == "":
+			return labels + " ->> ?::text IS NOT NULL", []interface{}{key}
+		case strings.Contains(value, "*"):
+			return labels + ` ->> ?::text ILIKE ? ESCAPE '\'`, []interface{}{key, likePattern(value, false)}
+		default:
+			// Containment can use a GIN index on data
+			doc, _ := json.Marshal(map[string]interface{}{
+				"metadata": map[string]interface{}{"labels": map[string]string{key: value}},
+			})
+			return "data @> ?::jsonb", []interface{}{string(doc)}
+		}
+	}
+
+	if op == "" {
+		return "id IN (SELECT resource_id FROM labels WHERE key = ?)", []interface{}{key}
+	}
+	cond, args := opts.matchValue("value", value)
+	return "id IN (SELECT resource_id FROM labels WHERE key = ? AND " + cond + ")", append([]interface{}{key}, args...)
+}
+
+// like returns the case-insensitive LIKE operator of the dialect
+func (opts Options) like() string {
+	if opts.Dialect == Postgres {
+		return "ILIKE"
+	}
+	return "LIKE"
+}
+
+// field returns an expression extracting a field path from data, with the
+// path as its argument. Postgres extracts fields as text.
+func (opts Options) field(path string) (string, interface{}) {
+	if opts.Dialect == Postgres {
+		return "data #>> ?::text[]", postgresPath(path)
+	}
+	return "json_extract(data, ?)", jsonPath(path)
+}
+
+// compare compiles an ordering comparison of a field. Numbers compare
+// numerically with numeric fields and anything else as text with text
+// fields.
+func (opts Options) compare(path string, op Op, value string) (string, []interface{}) {
+	n, numeric := number(value)
+
+	if opts.Dialect == Postgres {
+		p := postgresPath(path)
+		if numeric {
+			return fmt.Sprintf("CASE WHEN jsonb_typeof(data #> ?::text[]) = 'number' THEN (data #>> ?::text[])::numeric END %s ?", op),
+				[]interface{}{p, p, n}
+		}
+		return fmt.Sprintf("CASE WHEN jsonb_typeof(data #> ?::text[]) = 'string' THEN data #>> ?::text[] END %s ?", op),
+			[]interface{}{p, p, value}
+	}
+
+	// SQLite orders numbers before text, so values of the other type are
+	// excluded rather than compared
+	p := jsonPath(path)
+	if numeric {
+		return fmt.Sprintf("CASE WHEN json_type(data, ?) IN ('integer', 'real') THEN json_extract(data, ?) END %s ?", op),
+			[]interface{}{p, p, n}
+	}
+	return fmt.Sprintf("CASE WHEN json_type(data, ?) = 'text' THEN json_extract(data, ?) END %s ?", op),
+		[]interface{}{p, p, value}
+}
+
+// matchValue compares an expression with a value, where * matches anything.
+// With SQLite, numbers and booleans also match their JSON typed form.
+func (opts Options) matchValue(expr, value string) (string, []interface{}) {
+	if strings.Contains(value, "*") {
+		return fmt.Sprintf(`%s %s ? ESCAPE '\'`, expr, opts.like()), []interface{}{likePattern(value, false)}
+	}
+
+	if opts.Dialect == SQLite {
+		if n, ok := number(value); ok {
+			return expr + " IN (?, ?)", []interface{}{value, n}
+		}
+		switch value {
+		case "true":
+			return expr + " IN (?, ?)", []interface{}{value, 1}
+		case "false":
+			return expr + " IN (?, ?)", []interface{}{value, 0}
+		}
+	}
+
+	return expr + " = ?", []interface{}{value}
+}
+
+// not negates a condition, treating NULL (a missing field) as false
+func not(cond string) string {
+	return "NOT COALESCE(" + cond + ", FALSE)"
+}
+
+// number parses a numeric value
+func number(value string) (float64, bool) {
+	n, err := strconv.ParseFloat(value, 64)
+	return n, err == nil
+}
+
+// likePattern escapes a value for LIKE, translating * to %. Contains
+// patterns match the value anywhere.
+func likePattern(value string, contains bool) string {
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`, "*", "%").Replace(value)
+	if contains {
+		return "%" + escaped + "%"
+	}
+	return escaped
+}
+
+// pathSegment is a field name with optional list indexes
+type pathSegment struct {
+	name    string
+	indexes []int
+}
+
+// segmentPattern matches a field name with optional list indexes
+var segmentPattern = regexp.MustCompile(`^([^\[]+)((?:\[[0-9]+\])*)$`)
+
+// indexPattern matches a single list index
+var indexPattern = regexp.MustCompile(`\[([0-9]+)\]`)
+
+// parsePath splits a field path such as spec.containers[0].image
+func parsePath(path string) []pathSegment {
+	var segments []pathSegment
+	for _, part := range strings.Split(path, ".") {
+		m := segmentPattern.FindStringSubmatch(part)
+		if m == nil {
+			continue
+		}
+		segment := pathSegment{name: m[1]}
+		for _, idx := range indexPattern.FindAllStringSubmatch(m[2], -1) {
+			n, _ := strconv.Atoi(idx[1])
+			segment.indexes = append(segment.indexes, n)
+		}
+		segments = append(segments, segment)
+	}
+	return segments
+}
+
+// jsonPath converts a field path into an SQLite JSON path with quoted
+// field names
+func jsonPath(path string) string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, segment := range parsePath(path) {
+		b.WriteString(`."` + segment.name + `"`)
+		for _, idx := range segment.indexes {
+			fmt.Fprintf(&b, "[%d]", idx)
+		}
+	}
+	return b.String()
+}
+
+// postgresPath converts a field path into a Postgres text array literal for
+// the #> and #>> operators
+func postgresPath(path string) string {
+	var parts []string
+	for _, segment := range parsePath(path) {
+		parts = append(parts, `"`+strings.ReplaceAll(segment.name, `"`, `\"`)+`"`)
+		for _, idx := range segment.indexes {
+			parts = append(parts, strconv.Itoa(idx))
+		}
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// durationPart matches a number with a day or week unit
+var durationPart = regexp.MustCompile(`^([0-9]+)([dw])`)
+
+// ParseDuration parses a Go duration that may also use d (days) and
+// w (weeks), e.g. 90m, 1d12h or 2w
+func ParseDuration(value string) (time.Duration, error) {
+	var total time.Duration
+	rest := value
+	for {
+		m := durationPart.FindStringSubmatch(rest)
+		if m == nil {
+			break
+		}
+		n, _ := strconv.Atoi(m[1])
+		unit := 24 * time.Hour
+		if m[2] == "w" {
+			unit *= 7
+		}
+		total += time.Duration(n) * unit
+		rest = rest[len(m[0]):]
+	}
+
+	if rest != "" {
+		d, err := time.ParseDuration(rest)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q, expected e.g. 30m, 1h or 7d", value)
+		}
+		total += d
+	}
+	return total, nil
+}
@@ -0,0 +1,358 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// State is the state of an alert
+type State string
+
+const (
+	// Pending alerts match but haven't matched for the rule's duration yet
+	Pending State = "PENDING"
+	// Firing alerts have matched for at least the rule's duration
+	Firing State = "FIRING"
+	// Resolved is reported once when a firing alert stops matching
+	Resolved State = "RESOLVED"
+)
+
+// Alert is the state of a rule for a single object
+type Alert struct {
+	Rule       string    `json:"rule"`
+	Severity   string    `json:"severity"`
+	State      State     `json:"state"`
+	Message    string    `json:"message"`
+	Kind       string    `json:"kind"`
+	APIVersion string    `json:"apiVersion"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Name       string    `json:"name"`
+	Since      time.Time `json:"since"`
+	// Object is the latest state of the object, available to message templates
+	Object map[string]interface{} `json:"-"`
+}
+
+// Subject returns namespace/name of the object the alert is about
+func (a Alert) Subject() string {
+	if a.Namespace == "" {
+		return a.Name
+	}
+	return a.Namespace + "/" + a.Name
+}
+
+// compiledRule is a rule with its expression and template prepared
+type compiledRule struct {
+	Rule
+	filter   *watcher.Filter
+	template *template.Template
+}
+
+const (
+	// queueSize is how many notifications wait for delivery before new
+	// ones are dropped
+	queueSize = 100
+	// notifyTimeout bounds the delivery of an alert to a single notifier
+	notifyTimeout = 10 * time.Second
+	// closeTimeout is how long Close waits for queued notifications
+	closeTimeout = 5 * time.Second
+)
+
+// Engine evaluates rules against resource events and tracks pending and
+// firing alerts per rule and object. Alerts are delivered to the notifiers
+// in the background, so a slow notifier doesn't hold up the events.
+type Engine struct {
+	rules     []*compiledRule
+	notifiers []Notifier
+	alerts    map[string]*Alert
+	mu        sync.Mutex
+	now       func() time.Time
+
+	// ctx is cancelled when Close gives up on the queued notifications
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	queueMu sync.RWMutex
+	queue   chan Alert
+	closed  bool
+}
+
+// NewEngine compiles the rules and creates an engine that reports to the
+// notifiers until Close is called
+func NewEngine(rules []Rule, notifiers ...Notifier) (*Engine, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	engine := &Engine{
+		notifiers: notifiers,
+		alerts:    make(map[string]*Alert),
+		now:       time.Now,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		queue:     make(chan Alert, queueSize),
+	}
+
+	for _, rule := range rules {
+		filter, err := watcher.CompileFilter(rule.Match)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %v", rule.Name, err)
+		}
+
+		message := rule.Message
+		if message == "" {
+			message = DefaultMessage
+		}
+		tmpl, err := template.New(rule.Name).Option("missingkey=zero").Parse(message)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: invalid message template: %v", rule.Name, err)
+		}
+
+		if rule.Severity == "" {
+			rule.Severity = "warning"
+		}
+
+		engine.rules = append(engine.rules, &compiledRule{
+			Rule:     rule,
+			filter:   filter,
+			template: tmpl,
+		})
+	}
+
+	go engine.deliver()
+	return engine, nil
+}
+
+// HandleEvent evaluates every rule against the event.
+// It can be used directly as a watcher.EventHandler.
+func (e *Engine) HandleEvent(event watcher.ResourceEvent) {
+	if event.Type == watch.Error {
+		return
+	}
+
+	var notifications []Alert
+
+	e.mu.Lock()
+	now := e.now()
+	for _, rule := range e.rules {
+		key := alertKey(rule.Name, event)
+		alert, active := e.alerts[key]
+
+		matched, err := rule.filter.Match(event)
+		if err != nil {
+			log.Printf("Failed to evaluate rule %s for %s/%s: %v", rule.Name, event.Namespace, event.Name, err)
+			matched = false
+		}
+
+		switch {
+		case event.Type == watch.Deleted:
+			// The object is gone, so the rule can't keep matching it.
+			// A rule without a duration matching the deletion fires
+			// immediately, unless its alert was firing and is resolved by
+			// the deletion. Rules with a duration never matched for long
+			// enough.
+			resolved := false
+			if active {
+				delete(e.alerts, key)
+				if alert.State == Firing {
+					notifications = append(notifications, e.resolve(alert, event, now))
+					resolved = true
+				}
+			}
+			if matched && rule.For.Duration == 0 && !resolved {
+				notifications = append(notifications, e.fire(rule, newAlert(rule, event, now)))
+			}
+
+		case matched && !active:
+			alert = newAlert(rule, event, now)
+			e.alerts[key] = alert
+			if rule.For.Duration == 0 {
+				notifications = append(notifications, e.fire(rule, alert))
+			}
+
+		case matched && active:
+			alert.Object = event.Object
+
+		case !matched && active:
+			delete(e.alerts, key)
+			if alert.State == Firing {
+				notifications = append(notifications, e.resolve(alert, event, now))
+			}
+		}
+	}
+	e.mu.Unlock()
+
+	e.notify(notifications)
+}
+
+// Run promotes pending alerts to firing once they have matched for long
+// enough. It blocks until the context is canceled.
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.evaluatePending()
+		}
+	}
+}
+
+// Alerts returns the pending and firing alerts
+func (e *Engine) Alerts() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	alerts := make([]Alert, 0, len(e.alerts))
+	for _, alert := range e.alerts {
+		alerts = append(alerts, *alert)
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].Since.Before(alerts[j].Since)
+	})
+
+	return alerts
+}
+
+// evaluatePending fires pending alerts whose duration has elapsed
+func (e *Engine) evaluatePending() {
+	var notifications []Alert
+
+	e.mu.Lock()
+	now := e.now()
+	for _, rule := range e.rules {
+		for _, alert := range e.alerts {
+			if alert.Rule == rule.Name && alert.State == Pending && now.Sub(alert.Since) >= rule.For.Duration {
+				notifications = append(notifications, e.fire(rule, alert))
+			}
+		}
+	}
+	e.mu.Unlock()
+
+	e.notify(notifications)
+}
+
+// fire marks the alert as firing and returns a copy for notification
+func (e *Engine) fire(rule *compiledRule, alert *Alert) Alert {
+	alert.State = Firing
+
+	var message bytes.Buffer
+	if err := rule.template.Execute(&message, alert); err != nil {
+		log.Printf("Failed to render message for rule %s: %v", rule.Name, err)
+		alert.Message = fmt.Sprintf("%s %s matched rule %s", alert.Kind, alert.Subject(), rule.Name)
+	} else {
+		alert.Message = message.String()
+	}
+
+	return *alert
+}
+
+// resolve returns a resolved copy of a firing alert
+func (e *Engine) resolve(alert *Alert, event watcher.ResourceEvent, now time.Time) Alert {
+	resolved := *alert
+	resolved.State = Resolved
+	resolved.Since = now
+	if event.Object != nil {
+		resolved.Object = event.Object
+	}
+	return resolved
+}
+
+// notify queues alerts for delivery, dropping them when the queue is full
+func (e *Engine) notify(alerts []Alert) {
+	e.queueMu.RLock()
+	defer e.queueMu.RUnlock()
+	if e.closed {
+		return
+	}
+
+	for _, alert := range alerts {
+		select {
+		case e.queue <- alert:
+		default:
+			log.Printf("Dropping alert %s for %s, %d alerts are waiting for delivery", alert.Rule, alert.Subject(), queueSize)
+		}
+	}
+}
+
+// deliver sends the queued alerts to every notifier, logging failures,
+// until the queue is closed
+func (e *Engine) deliver() {
+	defer close(e.done)
+	for alert := range e.queue {
+		for _, notifier := range e.notifiers {
+			if e.ctx.Err() != nil {
+				break
+			}
+			ctx, cancel := context.WithTimeout(e.ctx, notifyTimeout)
+			if err := notifier.Notify(ctx, alert); err != nil {
+				log.Printf("Failed to deliver alert %s for %s: %v", alert.Rule, alert.Subject(), err)
+			}
+			cancel()
+		}
+	}
+}
+
+// Close stops accepting alerts, waits a few seconds for the queued ones to
+// be delivered and closes the notifiers that hold resources, such as files
+func (e *Engine) Close() error {
+	e.queueMu.Lock()
+	if e.closed {
+		e.queueMu.Unlock()
+		return nil
+	}
+	e.closed = true
+	close(e.queue)
+	e.queueMu.Unlock()
+
+	select {
+	case <-e.done:
+	case <-time.After(closeTimeout):
+		log.Printf("Dropping undelivered alerts at shutdown")
+		e.cancel()
+		<-e.done
+	}
+	e.cancel()
+
+	var firstErr error
+	for _, notifier := range e.notifiers {
+		if closer, ok := notifier.(io.Closer); ok {
+			if err := closer.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// newAlert creates a pending alert for the object in the event
+func newAlert(rule *compiledRule, event watcher.ResourceEvent, now time.Time) *Alert {
+	return &Alert{
+		Rule:       rule.Name,
+		Severity:   rule.Severity,
+		State:      Pending,
+		Kind:       event.Resource.Kind,
+		APIVersion: event.Resource.APIVersion,
+		Namespace:  event.Namespace,
+		Name:       event.Name,
+		Since:      now,
+		Object:     event.Object,
+	}
+}
+
+// alertKey identifies the alert of a rule for the object in an event
+func alertKey(rule string, event watcher.ResourceEvent) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s", rule, event.Resource.APIVersion, event.Resource.Kind, event.Namespace, event.Name)
+}
@@ -0,0 +1,303 @@
+package rules
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+var podResource = watcher.ResourceToWatch{Kind: "Pod", APIVersion: "v1", Namespaced: true}
+
+// podEvent returns an event about the web Pod in the given phase
+func podEvent(eventType watch.EventType, phase string) watcher.ResourceEvent {
+	return watcher.ResourceEvent{
+		Type:      eventType,
+		Resource:  podResource,
+		Name:      "web",
+		Namespace: "prod",
+		Object: map[string]interface{}{
+			"metadata": map[string]interface{}{"name": "web", "namespace": "prod"},
+			"status":   map[string]interface{}{"phase": phase},
+		},
+	}
+}
+
+// recordingNotifier collects the alerts it is notified of
+type recordingNotifier struct {
+	mu     sync.Mutex
+	alerts []Alert
+	closed bool
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, alert Alert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+func (n *recordingNotifier) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.closed = true
+	return nil
+}
+
+// states returns the rule and state of every alert notified so far
+func (n *recordingNotifier) states() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var states []string
+	for _, alert := range n.alerts {
+		states = append(states, alert.Rule+" "+string(alert.State))
+	}
+	return states
+}
+
+// fakeClock is a clock the test moves forward
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTestEngine creates an engine on a fake clock that reports to a
+// recording notifier
+func newTestEngine(t *testing.T, rules ...Rule) (*Engine, *fakeClock, *recordingNotifier) {
+	t.Helper()
+	notifier := &recordingNotifier{}
+	engine, err := NewEngine(rules, notifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)}
+	engine.now = clock.Now
+	t.Cleanup(func() { engine.Close() })
+	return engine, clock, notifier
+}
+
+// delivered waits for the queued alerts to be delivered and returns them
+func delivered(t *testing.T, engine *Engine, notifier *recordingNotifier) []string {
+	t.Helper()
+	if err := engine.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return notifier.states()
+}
+
+func TestEngineFor(t *testing.T) {
+	failing := Rule{Name: "pod-failed", Match: "object.status.phase == 'Failed'", For: Duration{5 * time.Minute}}
+	engine, clock, notifier := newTestEngine(t, failing)
+
+	engine.HandleEvent(podEvent(watch.Added, "Failed"))
+	alerts := engine.Alerts()
+	if len(alerts) != 1 || alerts[0].State != Pending {
+		t.Fatalf("Alerts() = %+v, want one pending alert", alerts)
+	}
+
+	// Not firing before the duration has elapsed, even as the object changes
+	clock.Advance(4 * time.Minute)
+	engine.HandleEvent(podEvent(watch.Modified, "Failed"))
+	engine.evaluatePending()
+	if alerts := engine.Alerts(); alerts[0].State != Pending {
+		t.Fatalf("state after 4m = %s, want %s", alerts[0].State, Pending)
+	}
+
+	clock.Advance(time.Minute)
+	engine.evaluatePending()
+	if alerts := engine.Alerts(); alerts[0].State != Firing {
+		t.Fatalf("state after 5m = %s, want %s", alerts[0].State, Firing)
+	}
+	// Firing is only reported once
+	clock.Advance(time.Minute)
+	engine.evaluatePending()
+
+	engine.HandleEvent(podEvent(watch.Modified, "Running"))
+	if alerts := engine.Alerts(); len(alerts) != 0 {
+		t.Errorf("Alerts() after recovering = %+v, want none", alerts)
+	}
+
+	want := []string{"pod-failed FIRING", "pod-failed RESOLVED"}
+	if got := delivered(t, engine, notifier); !reflect.DeepEqual(got, want) {
+		t.Errorf("notified %v, want %v", got, want)
+	}
+}
+
+func TestEnginePendingNeverFires(t *testing.T) {
+	failing := Rule{Name: "pod-failed", Match: "object.status.phase == 'Failed'", For: Duration{5 * time.Minute}}
+	engine, clock, notifier := newTestEngine(t, failing)
+
+	// A pending alert that stops matching is dropped without a notification
+	engine.HandleEvent(podEvent(watch.Added, "Failed"))
+	clock.Advance(time.Minute)
+	engine.HandleEvent(podEvent(watch.Modified, "Running"))
+	clock.Advance(10 * time.Minute)
+	engine.evaluatePending()
+
+	// The duration starts over when it matches again
+	engine.HandleEvent(podEvent(watch.Modified, "Failed"))
+	clock.Advance(4 * time.Minute)
+	engine.evaluatePending()
+
+	if alerts := engine.Alerts(); len(alerts) != 1 || alerts[0].State != Pending {
+		t.Errorf("Alerts() = %+v, want one pending alert", alerts)
+	}
+	if got := delivered(t, engine, notifier); len(got) != 0 {
+		t.Errorf("notified %v, want nothing", got)
+	}
+}
+
+func TestEngineImmediateAndDeletion(t *testing.T) {
+	rules := []Rule{
+		{Name: "pod-failed", Match: "object.status.phase == 'Failed'", Severity: "critical",
+			Message: "{{ .Subject }} is {{ .Object.status.phase }}"},
+		{Name: "pod-deleted", Match: "eventType == 'DELETED'"},
+	}
+	engine, _, notifier := newTestEngine(t, rules...)
+
+	// Rules without a duration fire right away
+	engine.HandleEvent(podEvent(watch.Added, "Failed"))
+	alerts := engine.Alerts()
+	if len(alerts) != 1 || alerts[0].State != Firing {
+		t.Fatalf("Alerts() = %+v, want one firing alert", alerts)
+	}
+	if alerts[0].Message != "prod/web is Failed" || alerts[0].Severity != "critical" {
+		t.Errorf("alert = %+v, want the rendered message and severity", alerts[0])
+	}
+
+	// Deleting the object resolves its alerts, and fires rules matching the
+	// deletion without keeping them active
+	engine.HandleEvent(podEvent(watch.Deleted, "Succeeded"))
+	if alerts := engine.Alerts(); len(alerts) != 0 {
+		t.Errorf("Alerts() after deletion = %+v, want none", alerts)
+	}
+
+	// Error events are ignored
+	engine.HandleEvent(watcher.ResourceEvent{Type: watch.Error, Resource: podResource})
+
+	want := []string{"pod-failed FIRING", "pod-failed RESOLVED", "pod-deleted FIRING"}
+	if got := delivered(t, engine, notifier); !reflect.DeepEqual(got, want) {
+		t.Errorf("notified %v, want %v", got, want)
+	}
+	if !notifier.closed {
+		t.Error("Close() didn't close the notifier")
+	}
+}
+
+func TestEngineDeletion(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		// before are the phases the Pod goes through before its deletion
+		before []string
+		want   []string
+	}{
+		{
+			name:   "pending rule with a duration",
+			rule:   Rule{Name: "pod-failed", Match: "object.status.phase == 'Failed'", For: Duration{5 * time.Minute}},
+			before: []string{"Failed"},
+		},
+		{
+			name: "rule with a duration matching only the deletion",
+			rule: Rule{Name: "pod-failed", Match: "object.status.phase == 'Failed'", For: Duration{5 * time.Minute}},
+		},
+		{
+			name:   "firing rule is resolved, not fired again",
+			rule:   Rule{Name: "pod-failed", Match: "object.status.phase == 'Failed'"},
+			before: []string{"Failed"},
+			want:   []string{"pod-failed FIRING", "pod-failed RESOLVED"},
+		},
+		{
+			name: "rule without a duration matching the deletion",
+			rule: Rule{Name: "pod-failed", Match: "object.status.phase == 'Failed'"},
+			want: []string{"pod-failed FIRING"},
+		},
+		{
+			name: "evaluation errors don't match",
+			rule: Rule{Name: "pod-failed", Match: "object.status.missing == 'Failed'"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, clock, notifier := newTestEngine(t, tt.rule)
+			for _, phase := range tt.before {
+				engine.HandleEvent(podEvent(watch.Modified, phase))
+			}
+			clock.Advance(time.Second)
+			engine.HandleEvent(podEvent(watch.Deleted, "Failed"))
+
+			if alerts := engine.Alerts(); len(alerts) != 0 {
+				t.Errorf("Alerts() after deletion = %+v, want none", alerts)
+			}
+			if got := delivered(t, engine, notifier); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("notified %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// blockingNotifier holds every notification until it is released
+type blockingNotifier struct {
+	release chan struct{}
+}
+
+func (n *blockingNotifier) Notify(ctx context.Context, alert Alert) error {
+	select {
+	case <-n.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func TestEngineSlowNotifier(t *testing.T) {
+	notifier := &blockingNotifier{release: make(chan struct{})}
+	engine, err := NewEngine([]Rule{{Name: "any", Match: "true"}}, notifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	defer close(notifier.release)
+
+	handled := make(chan struct{})
+	go func() {
+		for i := 0; i < queueSize+10; i++ {
+			event := podEvent(watch.Added, "Running")
+			event.Name = string(rune('a' + i%26))
+			event.Namespace = string(rune('a' + i/26))
+			engine.HandleEvent(event)
+		}
+		close(handled)
+	}()
+
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("HandleEvent blocked on a slow notifier")
+	}
+}
+
+func TestNewEngineErrors(t *testing.T) {
+	if _, err := NewEngine([]Rule{{Name: "bad", Match: "object."}}); err == nil {
+		t.Error("NewEngine() accepted an invalid expression")
+	}
+	if _, err := NewEngine([]Rule{{Name: "bad", Match: "true", Message: "{{ .Name"}}); err == nil {
+		t.Error("NewEngine() accepted an invalid message template")
+	}
+}
@@ -0,0 +1,135 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Notifier delivers alert state changes. Notifiers that hold resources
+// also implement io.Closer, and are closed with the engine.
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// NewNotifiers creates the notifiers enabled in the configuration
+func NewNotifiers(config NotifierConfig) ([]Notifier, error) {
+	var notifiers []Notifier
+
+	if config.Stdout {
+		notifiers = append(notifiers, NewWriterNotifier(os.Stdout))
+	}
+	if config.Webhook != nil {
+		notifiers = append(notifiers, NewWebhookNotifier(config.Webhook.URL))
+	}
+	if config.File != nil {
+		file, err := NewFileNotifier(config.File.Path)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, file)
+	}
+
+	return notifiers, nil
+}
+
+// WriterNotifier prints alerts as single lines of text
+type WriterNotifier struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+// NewWriterNotifier creates a notifier that prints to w
+func NewWriterNotifier(w io.Writer) *WriterNotifier {
+	return &WriterNotifier{w: w}
+}
+
+// Notify prints the alert
+func (n *WriterNotifier) Notify(ctx context.Context, alert Alert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	_, err := fmt.Fprintf(n.w, "[%s] %s %s %s: %s\n",
+		alert.State, alert.Severity, alert.Rule, alert.Subject(), alert.Message)
+	return err
+}
+
+// WebhookNotifier posts alerts as JSON to a URL
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookNotifier creates a notifier that posts to url
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Notify posts the alert, giving up when ctx is done
+func (n *WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to encode alert: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post alert: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook rejected alert with status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// FileNotifier appends alerts as JSON lines to a file
+type FileNotifier struct {
+	file *os.File
+	mu   sync.Mutex
+}
+
+// NewFileNotifier opens path for appending
+func NewFileNotifier(path string) (*FileNotifier, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open alert file: %v", err)
+	}
+	return &FileNotifier{file: file}, nil
+}
+
+// Notify appends the alert
+func (n *FileNotifier) Notify(ctx context.Context, alert Alert) error {
+	line, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to encode alert: %v", err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	_, err = n.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the underlying file
+func (n *FileNotifier) Close() error {
+	return n.file.Close()
+}
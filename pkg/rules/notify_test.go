@@ -0,0 +1,112 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testAlert = Alert{
+	Rule: "pod-failed", Severity: "critical", State: Firing, Message: "web failed",
+	Kind: "Pod", APIVersion: "v1", Namespace: "prod", Name: "web",
+	Since: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+}
+
+func TestWriterNotifier(t *testing.T) {
+	var out bytes.Buffer
+	if err := NewWriterNotifier(&out).Notify(t.Context(), testAlert); err != nil {
+		t.Fatal(err)
+	}
+	if want := "[FIRING] critical pod-failed prod/web: web failed\n"; out.String() != want {
+		t.Errorf("printed %q, want %q", out.String(), want)
+	}
+}
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.log")
+	notifier, err := NewFileNotifier(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved := testAlert
+	resolved.State = Resolved
+	for _, alert := range []Alert{testAlert, resolved} {
+		if err := notifier.Notify(t.Context(), alert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := notifier.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("wrote %d lines, want 2", len(lines))
+	}
+	var decoded Alert
+	if err := json.Unmarshal([]byte(lines[1]), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.State != Resolved || decoded.Subject() != "prod/web" || !decoded.Since.Equal(testAlert.Since) {
+		t.Errorf("second line = %s, want the resolved alert", lines[1])
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var got Alert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		if got.State == Resolved {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	notifier := NewWebhookNotifier(server.URL)
+
+	if err := notifier.Notify(t.Context(), testAlert); err != nil {
+		t.Fatal(err)
+	}
+	if got.Rule != testAlert.Rule || got.Message != testAlert.Message {
+		t.Errorf("posted %+v, want %+v", got, testAlert)
+	}
+
+	resolved := testAlert
+	resolved.State = Resolved
+	if err := notifier.Notify(t.Context(), resolved); err == nil {
+		t.Error("Notify() succeeded, want the status reported")
+	}
+}
+
+func TestWebhookNotifierTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	if err := NewWebhookNotifier(server.URL).Notify(ctx, testAlert); err == nil {
+		t.Error("Notify() succeeded, want it to give up when the context is done")
+	}
+}
@@ -0,0 +1,135 @@
+// Package rules provides an alerting engine that evaluates declarative rules
+// against the resource event stream
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/template"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultMessage is used for rules that don't define a message template
+const DefaultMessage = "{{ .Kind }} {{ .Namespace }}/{{ .Name }} matched rule {{ .Rule }}"
+
+// Rule describes a condition that raises an alert when it matches an object
+type Rule struct {
+	// Name uniquely identifies the rule
+	Name string `json:"name"`
+	// Match is a CEL expression evaluated against each event (see watcher.Filter)
+	Match string `json:"match"`
+	// For is how long the expression must keep matching before the alert fires
+	For Duration `json:"for,omitempty"`
+	// Severity is a free-form label such as warning or critical
+	Severity string `json:"severity,omitempty"`
+	// Message is a text/template rendered with the Alert when it fires
+	Message string `json:"message,omitempty"`
+}
+
+// NotifierConfig selects where alerts are delivered
+type NotifierConfig struct {
+	// Stdout prints alerts to standard output
+	Stdout bool `json:"stdout,omitempty"`
+	// Webhook posts alerts as JSON to a URL
+	Webhook *WebhookConfig `json:"webhook,omitempty"`
+	// File appends alerts as JSON lines to a local file
+	File *FileConfig `json:"file,omitempty"`
+}
+
+// WebhookConfig configures the webhook notifier
+type WebhookConfig struct {
+	URL string `json:"url"`
+}
+
+// FileConfig configures the file notifier
+type FileConfig struct {
+	Path string `json:"path"`
+}
+
+// Config is the content of a rules file
+type Config struct {
+	Notifiers NotifierConfig `json:"notifiers"`
+	Rules     []Rule         `json:"rules"`
+}
+
+// Duration is a time.Duration that is written as a string like "5m" in YAML
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5m\": %v", err)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = parsed
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// LoadConfig reads and validates a rules file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %v", err)
+	}
+
+	var config Config
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse rules file %s: %v", path, err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid rules file %s: %v", path, err)
+	}
+
+	return &config, nil
+}
+
+// Validate checks that rules are named uniquely and have valid templates.
+// Match expressions are checked when the engine compiles them.
+func (c *Config) Validate() error {
+	names := make(map[string]bool)
+	for i, rule := range c.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d has no name", i+1)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("duplicate rule name %q", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if rule.Match == "" {
+			return fmt.Errorf("rule %q has no match expression", rule.Name)
+		}
+		if rule.For.Duration < 0 {
+			return fmt.Errorf("rule %q has a negative for duration", rule.Name)
+		}
+		if _, err := template.New(rule.Name).Parse(rule.Message); err != nil {
+			return fmt.Errorf("rule %q has an invalid message template: %v", rule.Name, err)
+		}
+	}
+
+	if c.Notifiers.Webhook != nil && c.Notifiers.Webhook.URL == "" {
+		return fmt.Errorf("webhook notifier requires a url")
+	}
+	if c.Notifiers.File != nil && c.Notifiers.File.Path == "" {
+		return fmt.Errorf("file notifier requires a path")
+	}
+
+	return nil
+}
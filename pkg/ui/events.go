@@ -0,0 +1,141 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/events"
+)
+
+var warningStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+
+// eventsView shows the Kubernetes Events about a resource, aggregated by
+// reason with the most recent first
+type eventsView struct {
+	resource  db.Resource
+	summaries []events.Summary
+	selected  int
+	width     int
+	height    int
+}
+
+// eventsMsg carries the aggregated events of a resource
+type eventsMsg struct {
+	resource  db.Resource
+	summaries []events.Summary
+}
+
+// loadEvents reads the events about a resource from the store
+func (r *ResourceUI) loadEvents(resource db.Resource) tea.Cmd {
+	store, ok := r.db.(db.EventStore)
+	if !ok {
+		r.queryErr = fmt.Errorf("events aren't supported by this store")
+		return nil
+	}
+
+	return func() tea.Msg {
+		stored, err := store.ObjectEvents(resource)
+		if err != nil {
+			return errMsg{err}
+		}
+		var parsed []events.Event
+		for _, e := range stored {
+			if event, ok := events.Parse(e.Data); ok {
+				parsed = append(parsed, event)
+			}
+		}
+		return eventsMsg{resource: resource, summaries: events.Aggregate(parsed)}
+	}
+}
+
+// newEventsView creates a view with the most recent events selected
+func newEventsView(resource db.Resource, summaries []events.Summary, width, height int) *eventsView {
+	return &eventsView{resource: resource, summaries: summaries, width: width, height: height}
+}
+
+// Update moves the selection; it reports false when the view should close
+func (v *eventsView) Update(msg tea.KeyMsg) bool {
+	switch msg.String() {
+	case "esc", "q":
+		return false
+	case "up", "k":
+		if v.selected > 0 {
+			v.selected--
+		}
+	case "down", "j":
+		if v.selected < len(v.summaries)-1 {
+			v.selected++
+		}
+	case "home", "g":
+		v.selected = 0
+	case "end", "G":
+		v.selected = max(len(v.summaries)-1, 0)
+	}
+	return true
+}
+
+// View renders a line per reason and the full message of the selected one
+func (v *eventsView) View() string {
+	var b strings.Builder
+
+	title := fmt.Sprintf("Events of %s/%s", v.resource.Kind, v.resource.Name)
+	if v.resource.Namespace != "" {
+		title += fmt.Sprintf(" in %s", v.resource.Namespace)
+	}
+	b.WriteString(historyTitleStyle.Render(title))
+	b.WriteString("\n\n")
+
+	if len(v.summaries) == 0 {
+		b.WriteString(itemStyle.Render("No stored events, they're recorded while Events are watched"))
+		b.WriteString("\n\n")
+		b.WriteString(helpStyle.Render("esc: back"))
+		return b.String()
+	}
+
+	b.WriteString(itemStyle.Render(dimStyle.Render(fmt.Sprintf("%-9s  %-7s  %-24s  %6s  %s", "LAST SEEN", "TYPE", "REASON", "COUNT", "MESSAGE"))))
+	b.WriteString("\n")
+
+	listHeight := min(len(v.summaries), max(v.height-12, 5))
+	start := max(0, min(v.selected-listHeight/2, len(v.summaries)-listHeight))
+	for i := start; i < start+listHeight && i < len(v.summaries); i++ {
+		s := v.summaries[i]
+		eventType := fmt.Sprintf("%-7s", s.Type)
+		if s.Type == events.TypeWarning {
+			eventType = warningStyle.Render(eventType)
+		}
+		line := fmt.Sprintf("%-9s  %s  %-24s  %6d  ", formatAge(time.Since(s.LastTimestamp))+" ago", eventType, s.Reason, s.Count)
+		line += truncate(strings.Join(strings.Fields(s.Message), " "), v.width-10-lipgloss.Width(line))
+		if i == v.selected {
+			b.WriteString(selectedItemStyle.Render("> " + line))
+		} else {
+			b.WriteString(itemStyle.Render(line))
+		}
+		b.WriteString("\n")
+	}
+
+	selected := v.summaries[v.selected]
+	b.WriteString("\n")
+	b.WriteString(itemStyle.Render(dimStyle.Render(fmt.Sprintf("First seen %s ago, last seen %s ago",
+		formatAge(time.Since(selected.FirstTimestamp)), formatAge(time.Since(selected.LastTimestamp))))))
+	b.WriteString("\n")
+	message := lipgloss.NewStyle().Width(max(v.width-10, 40)).Render(selected.Message)
+	b.WriteString(itemStyle.Render(message))
+	b.WriteString("\n\n")
+
+	b.WriteString(helpStyle.Render("↑/↓: select reason • esc: back"))
+	return b.String()
+}
+
+// truncate shortens text to a number of characters, leaving it as is when
+// the width isn't known yet
+func truncate(text string, width int) string {
+	runes := []rune(text)
+	if width <= 0 || len(runes) <= width {
+		return text
+	}
+	return string(runes[:width-1]) + "…"
+}
@@ -0,0 +1,63 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/export"
+)
+
+// exportedMsg reports the file a manifest was written to
+type exportedMsg struct {
+	path string
+	// redacted is set when the values of a Secret were left out
+	redacted bool
+	err      error
+}
+
+// exportYAML writes the selected resource as a manifest that can be applied
+// again, e.g. to restore a deleted resource, to the working directory. The
+// values of Secrets are redacted so they don't end up in a plain file.
+func exportYAML(resource db.Resource) tea.Cmd {
+	return func() tea.Msg {
+		data, err := export.YAML(resource, export.Options{RedactSecrets: true})
+		if err != nil {
+			return exportedMsg{err: err}
+		}
+
+		path := manifestName(resource)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return exportedMsg{err: fmt.Errorf("failed to write %s: %v", path, err)}
+		}
+		return exportedMsg{path: path, redacted: resource.Kind == "Secret"}
+	}
+}
+
+// manifestName names the file of an exported resource, e.g.
+// prod-deployment-web.yaml
+func manifestName(r db.Resource) string {
+	parts := []string{strings.ToLower(r.Kind), r.Name}
+	if r.Namespace != "" {
+		parts = append([]string{r.Namespace}, parts...)
+	}
+	return strings.Join(parts, "-") + ".yaml"
+}
+
+// formatAge formats a duration the way kubectl shows ages, e.g. 45s, 12m,
+// 5h or 3d
+func formatAge(d time.Duration) string {
+	switch {
+	case d < 2*time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < 2*time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
@@ -0,0 +1,131 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+)
+
+var (
+	historyTitleStyle = lipgloss.NewStyle().Bold(true).MarginLeft(2)
+	dimStyle          = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+)
+
+// historyView browses the recorded versions of a single resource
+type historyView struct {
+	resource db.Resource
+	events   []db.Event
+	selected int
+	width    int
+	height   int
+}
+
+// historyMsg carries the loaded history of a resource
+type historyMsg struct {
+	resource db.Resource
+	events   []db.Event
+}
+
+// loadHistory reads the history of a resource from the store
+func (r *ResourceUI) loadHistory(resource db.Resource) tea.Cmd {
+	return func() tea.Msg {
+		events, err := r.db.History(resource.Key())
+		if err != nil {
+			return errMsg{err}
+		}
+		return historyMsg{resource: resource, events: events}
+	}
+}
+
+// newHistoryView creates a view that starts at the latest version
+func newHistoryView(resource db.Resource, events []db.Event, width, height int) *historyView {
+	return &historyView{
+		resource: resource,
+		events:   events,
+		selected: len(events) - 1,
+		width:    width,
+		height:   height,
+	}
+}
+
+// Update moves the selection; it reports false when the view should close
+func (h *historyView) Update(msg tea.KeyMsg) bool {
+	switch msg.String() {
+	case "esc", "q":
+		return false
+	case "up", "k":
+		if h.selected > 0 {
+			h.selected--
+		}
+	case "down", "j":
+		if h.selected < len(h.events)-1 {
+			h.selected++
+		}
+	case "home", "g":
+		h.selected = 0
+	case "end", "G":
+		h.selected = len(h.events) - 1
+	}
+	return true
+}
+
+// View renders the list of versions and the selected version's object
+func (h *historyView) View() string {
+	var b strings.Builder
+
+	title := fmt.Sprintf("History of %s/%s", h.resource.Kind, h.resource.Name)
+	if h.resource.Namespace != "" {
+		title += fmt.Sprintf(" in %s", h.resource.Namespace)
+	}
+	b.WriteString(historyTitleStyle.Render(title))
+	b.WriteString("\n\n")
+
+	if len(h.events) == 0 {
+		b.WriteString(itemStyle.Render("No recorded history"))
+		b.WriteString("\n")
+		return b.String()
+	}
+
+	// Show a window of versions around the selection
+	listHeight := min(len(h.events), max(h.height/3, 5))
+	start := max(0, min(h.selected-listHeight/2, len(h.events)-listHeight))
+	for i := start; i < start+listHeight && i < len(h.events); i++ {
+		e := h.events[i]
+		line := fmt.Sprintf("%s  %-8s  rv %s", e.RecordedAt.Format("2006-01-02 15:04:05"), e.Type, e.ResourceVersion)
+		if e.PreviousResourceVersion != "" && e.Type == db.EventModified {
+			line += fmt.Sprintf(" (was %s)", e.PreviousResourceVersion)
+		}
+		if i == h.selected {
+			b.WriteString(selectedItemStyle.Render("> " + line))
+		} else {
+			b.WriteString(itemStyle.Render(line))
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n")
+	b.WriteString(itemStyle.Render(dimStyle.Render(fmt.Sprintf("Version %d of %d", h.selected+1, len(h.events)))))
+	b.WriteString("\n")
+
+	// Render as much of the selected object as fits
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, []byte(h.events[h.selected].Data), "", "  "); err != nil {
+		pretty.WriteString(h.events[h.selected].Data)
+	}
+	lines := strings.Split(pretty.String(), "\n")
+	if available := h.height - listHeight - 8; available > 0 && len(lines) > available {
+		lines = append(lines[:available], "...")
+	}
+	for _, line := range lines {
+		b.WriteString(itemStyle.Render(line))
+		b.WriteString("\n")
+	}
+
+	b.WriteString(helpStyle.Render("↑/↓: select version • esc: back"))
+	return b.String()
+}
@@ -0,0 +1,185 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// paletteHelp lists the commands of the palette
+const paletteHelp = "watch TYPES [-n NS] • unwatch TYPES [-n NS] [--purge] • purge TYPES [-n NS] • watched"
+
+// WatchController changes the resource types watched for the TUI while it
+// runs. Resource types are given the way kubectl get takes them, e.g.
+// NetworkPolicy, netpol, deployments.apps, po,svc or all, or as
+// KIND[.GROUP]/VERSION, and an empty namespace means all namespaces.
+type WatchController interface {
+	// Watch starts watching a resource type and returns once its resources
+	// have been stored
+	Watch(resource, namespace string) error
+	// Unwatch stops watching a resource type
+	Unwatch(resource, namespace string) error
+	// Purge removes the stored resources of a type and their history, and
+	// returns the number removed
+	Purge(resource, namespace string) (int, error)
+	// Watched describes the resource types being watched
+	Watched() []string
+}
+
+// paletteCommand is a parsed palette command
+type paletteCommand struct {
+	name      string
+	resource  string
+	namespace string
+	purge     bool
+}
+
+// parsePaletteCommand parses a command such as "watch netpol,svc -n x"
+func parsePaletteCommand(input string) (paletteCommand, error) {
+	fields := strings.Fields(input)
+	if len(fields) == 0 {
+		return paletteCommand{}, fmt.Errorf("enter a command: %s", paletteHelp)
+	}
+
+	cmd := paletteCommand{name: fields[0]}
+	switch cmd.name {
+	case "watch", "unwatch", "purge", "watched":
+	default:
+		return cmd, fmt.Errorf("unknown command %q: %s", cmd.name, paletteHelp)
+	}
+
+	var args []string
+	for i := 1; i < len(fields); i++ {
+		switch field := fields[i]; {
+		case field == "-n" || field == "--namespace":
+			if i+1 == len(fields) {
+				return cmd, fmt.Errorf("%s needs a namespace", field)
+			}
+			i++
+			cmd.namespace = fields[i]
+		case strings.HasPrefix(field, "--namespace="):
+			cmd.namespace = strings.TrimPrefix(field, "--namespace=")
+		case field == "--purge" && cmd.name == "unwatch":
+			cmd.purge = true
+		case strings.HasPrefix(field, "-"):
+			return cmd, fmt.Errorf("unknown option %s for %s", field, cmd.name)
+		default:
+			args = append(args, field)
+		}
+	}
+
+	if cmd.name == "watched" {
+		if len(args) > 0 || cmd.namespace != "" {
+			return cmd, fmt.Errorf("watched takes no arguments")
+		}
+		return cmd, nil
+	}
+	if len(args) != 1 {
+		return cmd, fmt.Errorf("usage: %s", usage(cmd.name))
+	}
+	cmd.resource = args[0]
+	return cmd, nil
+}
+
+// usage returns the syntax of a command
+func usage(name string) string {
+	for _, u := range strings.Split(paletteHelp, " • ") {
+		if strings.HasPrefix(u, name+" ") {
+			return u
+		}
+	}
+	return name
+}
+
+// paletteMsg reports the outcome of a palette command
+type paletteMsg struct {
+	status string
+	err    error
+	// changed is set when stored resources changed, so the results are
+	// refreshed
+	changed bool
+}
+
+// newPalette creates the input of the command palette
+func newPalette() textinput.Model {
+	ti := textinput.New()
+	ti.Prompt = "> "
+	ti.Placeholder = paletteHelp
+	ti.Focus()
+	ti.Width = 80
+	return ti
+}
+
+// runPaletteCommand carries out a palette command in the background, as
+// watching a resource type waits for it to be listed
+func (r *ResourceUI) runPaletteCommand(input string) tea.Cmd {
+	cmd, err := parsePaletteCommand(input)
+	if err != nil {
+		return func() tea.Msg { return paletteMsg{err: err} }
+	}
+	controller := r.controller
+
+	where := ""
+	if cmd.namespace != "" {
+		where = " in " + cmd.namespace
+	}
+
+	return func() tea.Msg {
+		switch cmd.name {
+		case "watch":
+			if err := controller.Watch(cmd.resource, cmd.namespace); err != nil {
+				return paletteMsg{err: err}
+			}
+			return paletteMsg{status: fmt.Sprintf("Watching %s%s", cmd.resource, where), changed: true}
+
+		case "unwatch":
+			if err := controller.Unwatch(cmd.resource, cmd.namespace); err != nil {
+				return paletteMsg{err: err}
+			}
+			status := fmt.Sprintf("Stopped watching %s%s", cmd.resource, where)
+			if !cmd.purge {
+				return paletteMsg{status: status + ", stored resources are kept"}
+			}
+			n, err := controller.Purge(cmd.resource, cmd.namespace)
+			if err != nil {
+				return paletteMsg{err: fmt.Errorf("%s, but purging failed: %v", status, err)}
+			}
+			return paletteMsg{status: fmt.Sprintf("%s and purged %d stored resources", status, n), changed: true}
+
+		case "purge":
+			n, err := controller.Purge(cmd.resource, cmd.namespace)
+			if err != nil {
+				return paletteMsg{err: err}
+			}
+			return paletteMsg{status: fmt.Sprintf("Purged %d stored %s resources%s", n, cmd.resource, where), changed: true}
+
+		default:
+			watched := controller.Watched()
+			if len(watched) == 0 {
+				return paletteMsg{status: "Not watching anything"}
+			}
+			return paletteMsg{status: "Watching " + strings.Join(watched, ", ")}
+		}
+	}
+}
+
+// updatePalette handles keys while the palette is open
+func (r *ResourceUI) updatePalette(msg tea.KeyMsg) tea.Cmd {
+	switch msg.Type {
+	case tea.KeyEsc:
+		r.palette = nil
+		return nil
+	case tea.KeyEnter:
+		input := r.palette.Value()
+		r.palette = nil
+		r.queryErr = nil
+		r.status = "Running " + input + "..."
+		return r.runPaletteCommand(input)
+	}
+
+	palette, cmd := r.palette.Update(msg)
+	r.palette = &palette
+	return cmd
+}
@@ -0,0 +1,139 @@
+package ui
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePaletteCommand(t *testing.T) {
+	tests := []struct {
+		input string
+		want  paletteCommand
+		// err is a substring of the expected error
+		err string
+	}{
+		{input: "watch netpol", want: paletteCommand{name: "watch", resource: "netpol"}},
+		{input: "  watch   po,svc  -n x ", want: paletteCommand{name: "watch", resource: "po,svc", namespace: "x"}},
+		{input: "watch deploy --namespace prod", want: paletteCommand{name: "watch", resource: "deploy", namespace: "prod"}},
+		{input: "watch -n prod deploy", want: paletteCommand{name: "watch", resource: "deploy", namespace: "prod"}},
+		{input: "watch deploy --namespace=prod", want: paletteCommand{name: "watch", resource: "deploy", namespace: "prod"}},
+		{input: "unwatch events", want: paletteCommand{name: "unwatch", resource: "events"}},
+		{input: "unwatch events -n x --purge", want: paletteCommand{name: "unwatch", resource: "events", namespace: "x", purge: true}},
+		{input: "purge Secret/v1 -n x", want: paletteCommand{name: "purge", resource: "Secret/v1", namespace: "x"}},
+		{input: "watched", want: paletteCommand{name: "watched"}},
+
+		{input: "", err: "enter a command"},
+		{input: "   ", err: "enter a command"},
+		{input: "list pods", err: `unknown command "list"`},
+		{input: "watch", err: "usage: watch TYPES [-n NS]"},
+		{input: "watch po svc", err: "usage: watch TYPES [-n NS]"},
+		{input: "watch po -n", err: "-n needs a namespace"},
+		{input: "watch po --namespace", err: "--namespace needs a namespace"},
+		{input: "watch po --purge", err: "unknown option --purge for watch"},
+		{input: "purge po --purge", err: "unknown option --purge for purge"},
+		{input: "unwatch po -A", err: "unknown option -A for unwatch"},
+		{input: "unwatch --purge", err: "usage: unwatch TYPES [-n NS] [--purge]"},
+		{input: "purge", err: "usage: purge TYPES [-n NS]"},
+		{input: "watched po", err: "watched takes no arguments"},
+		{input: "watched -n x", err: "watched takes no arguments"},
+	}
+	for _, tt := range tests {
+		got, err := parsePaletteCommand(tt.input)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parsePaletteCommand(%q) error = %v, want %q", tt.input, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePaletteCommand(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parsePaletteCommand(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
+
+// fakeController records the calls made by palette commands
+type fakeController struct {
+	calls   []string
+	watched []string
+	err     error
+}
+
+func (c *fakeController) Watch(resource, namespace string) error {
+	c.calls = append(c.calls, fmt.Sprintf("watch %s %s", resource, namespace))
+	return c.err
+}
+
+func (c *fakeController) Unwatch(resource, namespace string) error {
+	c.calls = append(c.calls, fmt.Sprintf("unwatch %s %s", resource, namespace))
+	return c.err
+}
+
+func (c *fakeController) Purge(resource, namespace string) (int, error) {
+	c.calls = append(c.calls, fmt.Sprintf("purge %s %s", resource, namespace))
+	return 3, c.err
+}
+
+func (c *fakeController) Watched() []string {
+	return c.watched
+}
+
+func TestRunPaletteCommand(t *testing.T) {
+	failed := fmt.Errorf("failed")
+	tests := []struct {
+		input      string
+		err        error
+		watched    []string
+		wantCalls  []string
+		wantStatus string
+		wantErr    string
+		changed    bool
+	}{
+		{
+			input: "watch netpol -n x", wantCalls: []string{"watch netpol x"},
+			wantStatus: "Watching netpol in x", changed: true,
+		},
+		{
+			input: "unwatch events", wantCalls: []string{"unwatch events "},
+			wantStatus: "Stopped watching events, stored resources are kept",
+		},
+		{
+			input: "unwatch events -n x --purge", wantCalls: []string{"unwatch events x", "purge events x"},
+			wantStatus: "Stopped watching events in x and purged 3 stored resources", changed: true,
+		},
+		{
+			input: "purge po", wantCalls: []string{"purge po "},
+			wantStatus: "Purged 3 stored po resources", changed: true,
+		},
+		{input: "watched", wantStatus: "Not watching anything"},
+		{input: "watched", watched: []string{"Pod/v1", "Event/v1 in x"}, wantStatus: "Watching Pod/v1, Event/v1 in x"},
+
+		{input: "watch", wantErr: "usage: watch"},
+		{input: "watch po", err: failed, wantCalls: []string{"watch po "}, wantErr: "failed"},
+		{input: "unwatch po --purge", err: failed, wantCalls: []string{"unwatch po "}, wantErr: "failed"},
+		{input: "purge po", err: failed, wantCalls: []string{"purge po "}, wantErr: "failed"},
+	}
+	for _, tt := range tests {
+		controller := &fakeController{err: tt.err, watched: tt.watched}
+		r := NewResourceUI(nil, controller)
+		msg := r.runPaletteCommand(tt.input)().(paletteMsg)
+
+		if !reflect.DeepEqual(controller.calls, tt.wantCalls) {
+			t.Errorf("%q: calls = %q, want %q", tt.input, controller.calls, tt.wantCalls)
+		}
+		if tt.wantErr != "" {
+			if msg.err == nil || !strings.Contains(msg.err.Error(), tt.wantErr) {
+				t.Errorf("%q: error = %v, want %q", tt.input, msg.err, tt.wantErr)
+			}
+			continue
+		}
+		if msg.err != nil || msg.status != tt.wantStatus || msg.changed != tt.changed {
+			t.Errorf("%q: msg = %+v, want status %q and changed %v", tt.input, msg, tt.wantStatus, tt.changed)
+		}
+	}
+}
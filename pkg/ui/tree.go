@@ -0,0 +1,176 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+)
+
+var (
+	focusStyle   = lipgloss.NewStyle().Bold(true)
+	missingStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("241")).Italic(true)
+)
+
+// treeView shows the ownership tree around a resource with collapsible nodes
+type treeView struct {
+	resource  db.Resource
+	root      *db.Node
+	collapsed map[*db.Node]bool
+	selected  int
+	width     int
+	height    int
+}
+
+// treeMsg carries the loaded tree of a resource
+type treeMsg struct {
+	resource db.Resource
+	root     *db.Node
+}
+
+// treeLine is a visible node with its depth
+type treeLine struct {
+	node  *db.Node
+	depth int
+}
+
+// loadTree reads the tree containing a resource from the store
+func (r *ResourceUI) loadTree(resource db.Resource) tea.Cmd {
+	graph, ok := r.db.(db.GraphStore)
+	if !ok {
+		r.queryErr = fmt.Errorf("relations aren't supported by this store")
+		return nil
+	}
+
+	return func() tea.Msg {
+		root, err := graph.Tree(resource)
+		if err != nil {
+			return errMsg{err}
+		}
+		return treeMsg{resource: resource, root: root}
+	}
+}
+
+// newTreeView creates a view with the selection on the resource it was
+// opened for
+func newTreeView(resource db.Resource, root *db.Node, width, height int) *treeView {
+	t := &treeView{
+		resource:  resource,
+		root:      root,
+		collapsed: map[*db.Node]bool{},
+		width:     width,
+		height:    height,
+	}
+	for i, line := range t.lines() {
+		if !line.node.Missing && line.node.Resource.Key() == resource.Key() {
+			t.selected = i
+			break
+		}
+	}
+	return t
+}
+
+// lines returns the visible nodes in display order
+func (t *treeView) lines() []treeLine {
+	var lines []treeLine
+	var walk func(n *db.Node, depth int)
+	walk = func(n *db.Node, depth int) {
+		lines = append(lines, treeLine{node: n, depth: depth})
+		if t.collapsed[n] {
+			return
+		}
+		for _, child := range n.Children {
+			walk(child, depth+1)
+		}
+	}
+	walk(t.root, 0)
+	return lines
+}
+
+// Update moves the selection and toggles nodes; it reports false when the
+// view should close
+func (t *treeView) Update(msg tea.KeyMsg) bool {
+	lines := t.lines()
+	current := lines[t.selected].node
+
+	switch msg.String() {
+	case "esc", "q":
+		return false
+	case "up", "k":
+		if t.selected > 0 {
+			t.selected--
+		}
+	case "down", "j":
+		if t.selected < len(lines)-1 {
+			t.selected++
+		}
+	case "home", "g":
+		t.selected = 0
+	case "end", "G":
+		t.selected = len(lines) - 1
+	case "enter", " ":
+		if len(current.Children) > 0 {
+			t.collapsed[current] = !t.collapsed[current]
+		}
+	case "left", "h":
+		t.collapsed[current] = len(current.Children) > 0
+	case "right", "l":
+		delete(t.collapsed, current)
+	}
+	return true
+}
+
+// View renders the visible part of the tree
+func (t *treeView) View() string {
+	var b strings.Builder
+
+	b.WriteString(historyTitleStyle.Render(fmt.Sprintf("Relations of %s/%s", t.resource.Kind, t.resource.Name)))
+	b.WriteString("\n\n")
+
+	lines := t.lines()
+	listHeight := max(t.height-6, 5)
+	start := max(0, min(t.selected-listHeight/2, len(lines)-listHeight))
+	for i := start; i < start+listHeight && i < len(lines); i++ {
+		b.WriteString(t.renderLine(lines[i], i == t.selected))
+		b.WriteString("\n")
+	}
+
+	b.WriteString(helpStyle.Render("↑/↓: select • enter: expand/collapse • esc: back"))
+	return b.String()
+}
+
+// renderLine formats a node with its indentation, marker and relation
+func (t *treeView) renderLine(line treeLine, selected bool) string {
+	n := line.node
+
+	marker := "  "
+	if len(n.Children) > 0 {
+		marker = "▾ "
+		if t.collapsed[n] {
+			marker = "▸ "
+		}
+	}
+
+	name := fmt.Sprintf("%s/%s", n.Resource.Kind, n.Resource.Name)
+	switch {
+	case n.Missing:
+		name = missingStyle.Render(name + " (not stored)")
+	case n.Resource.Key() == t.resource.Key():
+		name = focusStyle.Render(name)
+	}
+
+	text := strings.Repeat("  ", line.depth) + marker + name
+	if n.Relation != "" && n.Relation != db.RelationOwns {
+		text += " " + dimStyle.Render(string(n.Relation))
+	}
+	if n.Resource.Namespace != "" && line.depth == 0 {
+		text += " " + dimStyle.Render("in "+n.Resource.Namespace)
+	}
+
+	if selected {
+		return selectedItemStyle.Render("> " + text)
+	}
+	return itemStyle.Render(text)
+}
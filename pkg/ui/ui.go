@@ -11,6 +11,8 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/health"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/query"
 )
 
 var (
@@ -21,11 +23,25 @@ var (
 	helpStyle         = list.DefaultStyles().HelpStyle.PaddingLeft(4).PaddingBottom(1)
 	inputStyle        = lipgloss.NewStyle().Border(lipgloss.NormalBorder()).Padding(1).Width(80)
 	appStyle          = lipgloss.NewStyle().Padding(1, 2, 0, 2)
+	matchStyle        = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("212"))
+	errorStyle        = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+	deletedStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("203")).Strikethrough(true)
 )
 
+// healthStyles colour each health status, missing and unknown are grey
+var healthStyles = map[health.Status]lipgloss.Style{
+	health.Healthy:     lipgloss.NewStyle().Foreground(lipgloss.Color("42")),
+	health.Progressing: lipgloss.NewStyle().Foreground(lipgloss.Color("220")),
+	health.Degraded:    lipgloss.NewStyle().Foreground(lipgloss.Color("196")),
+	health.Missing:     lipgloss.NewStyle().Foreground(lipgloss.Color("241")),
+	health.Unknown:     lipgloss.NewStyle().Foreground(lipgloss.Color("241")),
+}
+
 // ResourceItem represents a Kubernetes resource in the list
 type ResourceItem struct {
 	resource db.Resource
+	// snippet is the matching content of a full-text search result
+	snippet string
 }
 
 // FilterValue returns the string to use for filtering
@@ -35,6 +51,9 @@ func (i ResourceItem) FilterValue() string {
 
 // Title returns the title of the item
 func (i ResourceItem) Title() string {
+	if i.resource.Deleted() {
+		return fmt.Sprintf("%s/%s (deleted)", i.resource.Kind, i.resource.Name)
+	}
 	return fmt.Sprintf("%s/%s", i.resource.Kind, i.resource.Name)
 }
 
@@ -44,34 +63,94 @@ func (i ResourceItem) Description() string {
 	if ns == "" {
 		ns = "cluster-scoped"
 	}
-	return fmt.Sprintf("Namespace: %s, API Version: %s", ns, i.resource.APIVersion)
+	if i.snippet != "" {
+		return fmt.Sprintf("%s: %s", ns, highlightSnippet(i.snippet))
+	}
+	if i.resource.Deleted() {
+		deleted := deletedStyle.Render("Deleted " + formatAge(time.Since(i.resource.DeletedAt)) + " ago")
+		return fmt.Sprintf("%s, Namespace: %s", deleted, ns)
+	}
+	description := fmt.Sprintf("Namespace: %s, API Version: %s", ns, i.resource.APIVersion)
+	if i.resource.Health == "" {
+		return description
+	}
+	return renderHealth(i.resource.Health, i.resource.HealthReason) + ", " + description
+}
+
+// renderHealth colours a health status and its reason
+func renderHealth(status health.Status, reason string) string {
+	text := string(status)
+	if reason != "" {
+		text += " (" + reason + ")"
+	}
+	return healthStyles[status].Render(text)
+}
+
+// highlightSnippet renders a search snippet on one line with its matches styled
+func highlightSnippet(snippet string) string {
+	snippet = strings.Join(strings.Fields(snippet), " ")
+
+	var b strings.Builder
+	for {
+		before, rest, found := strings.Cut(snippet, db.HighlightStart)
+		b.WriteString(before)
+		if !found {
+			break
+		}
+		match, after, _ := strings.Cut(rest, db.HighlightEnd)
+		b.WriteString(matchStyle.Render(match))
+		snippet = after
+	}
+	return b.String()
 }
 
 // ResourceUI is the main TUI application
 type ResourceUI struct {
 	list       list.Model
 	input      textinput.Model
-	db         *db.ResourceStore
+	db         db.Store
 	err        error
 	resources  []db.Resource
 	lastSearch string
-	width      int
-	height     int
+	// fullText is set when the results come from a full-text search
+	fullText bool
+	// queryErr is shown in the hint line, e.g. why the typed query couldn't
+	// be parsed
+	queryErr error
+	// status is shown in the hint line after an action, e.g. an export
+	status string
+	// suggestions complete the word being typed
+	suggestions []query.Suggestion
+	width       int
+	height      int
+	// history is shown instead of the list while browsing versions
+	history *historyView
+	// tree is shown instead of the list while browsing relations
+	tree *treeView
+	// events is shown instead of the list while browsing Kubernetes Events
+	events *eventsView
+	// palette is the command input while the command palette is open
+	palette *textinput.Model
+	// controller carries out palette commands, nil without a cluster
+	controller WatchController
 }
 
-// NewResourceUI creates a new TUI application
-func NewResourceUI(store *db.ResourceStore) *ResourceUI {
+// NewResourceUI creates a new TUI application. The controller lets the
+// command palette change the watched resource types; it is nil when there
+// is no cluster to watch.
+func NewResourceUI(store db.Store, controller WatchController) *ResourceUI {
 	// Create text input field
 	ti := textinput.New()
-	ti.Placeholder = "Search resources..."
+	ti.Placeholder = "Query resources, e.g. kind:Pod ns:prod label:app=nginx status.phase!=Running"
 	ti.Focus()
 	ti.Width = 80
 
 	// Create the UI
 	return &ResourceUI{
-		list:  list.New([]list.Item{}, list.NewDefaultDelegate(), 0, 0),
-		input: ti,
-		db:    store,
+		list:       list.New([]list.Item{}, list.NewDefaultDelegate(), 0, 0),
+		input:      ti,
+		db:         store,
+		controller: controller,
 	}
 }
 
@@ -83,24 +162,78 @@ func (r *ResourceUI) Init() tea.Cmd {
 	)
 }
 
-// performSearch executes the search and updates the list
-func (r *ResourceUI) performSearch(query string) tea.Cmd {
+// performSearch runs a query and updates the list
+func (r *ResourceUI) performSearch(input string) tea.Cmd {
+	q, err := query.Parse(input)
+	if err != nil {
+		r.queryErr = err
+		return nil
+	}
+	r.queryErr = nil
+
 	return func() tea.Msg {
-		resources, err := r.db.Search(query)
+		resources, err := r.db.Query(q)
 		if err != nil {
 			return errMsg{err}
 		}
 		return resourcesMsg{
 			resources: resources,
-			query:     query,
+			query:     input,
 		}
 	}
 }
 
+// queryValues supplies stored kinds, namespaces and labels for completion
+func (r *ResourceUI) queryValues(key string) []string {
+	values, err := r.db.QueryValues(key)
+	if err != nil {
+		return nil
+	}
+	return values
+}
+
+// performFullTextSearch searches resource contents and shows the matching
+// snippet of each result. Stores without full-text search fall back to a
+// text: query, without snippets.
+func (r *ResourceUI) performFullTextSearch(input string) tea.Cmd {
+	searcher, ok := r.db.(db.FullTextSearcher)
+	if !ok {
+		return func() tea.Msg {
+			q := &query.Query{Terms: []query.Term{{Key: query.KeyText, Value: input}}}
+			resources, err := r.db.Query(q)
+			if err != nil {
+				return errMsg{err}
+			}
+			return resourcesMsg{resources: resources, query: input, fullText: true}
+		}
+	}
+
+	return func() tea.Msg {
+		results, err := searcher.FullTextSearch(input, 100)
+		if err != nil {
+			return errMsg{err}
+		}
+
+		msg := resourcesMsg{
+			query:    input,
+			snippets: make(map[int64]string, len(results)),
+			fullText: true,
+		}
+		for _, result := range results {
+			msg.resources = append(msg.resources, result.Resource)
+			msg.snippets[result.ID] = result.Snippet
+		}
+		return msg
+	}
+}
+
 // resourcesMsg is a message containing search results
 type resourcesMsg struct {
 	resources []db.Resource
 	query     string
+	// snippets holds the matching content of full-text results by resource ID
+	snippets map[int64]string
+	fullText bool
 }
 
 // errMsg represents an error message
@@ -114,29 +247,154 @@ func (r *ResourceUI) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if msg.Type == tea.KeyCtrlC {
+			return r, tea.Quit
+		}
+
+		// The history view takes all keys while it is open
+		if r.history != nil {
+			if !r.history.Update(msg) {
+				r.history = nil
+			}
+			return r, nil
+		}
+		if r.tree != nil {
+			if !r.tree.Update(msg) {
+				r.tree = nil
+			}
+			return r, nil
+		}
+		if r.events != nil {
+			if !r.events.Update(msg) {
+				r.events = nil
+			}
+			return r, nil
+		}
+		if r.palette != nil {
+			return r, r.updatePalette(msg)
+		}
+
 		switch msg.Type {
-		case tea.KeyCtrlC, tea.KeyEsc:
+		case tea.KeyEsc:
 			return r, tea.Quit
 		case tea.KeyEnter:
 			// Perform search when Enter is pressed
 			r.lastSearch = r.input.Value()
 			return r, r.performSearch(r.input.Value())
+		case tea.KeyCtrlF:
+			// Search the contents of resources
+			r.queryErr = nil
+			return r, r.performFullTextSearch(r.input.Value())
+		case tea.KeyTab:
+			// Accept the first completion
+			if len(r.suggestions) > 0 {
+				r.input.SetValue(query.Accept(r.input.Value(), r.suggestions[0]))
+				r.input.CursorEnd()
+				r.suggestions = query.Complete(r.input.Value(), r.queryValues)
+			}
+			return r, nil
+		case tea.KeyCtrlR:
+			// Browse the history of the selected resource
+			if item, ok := r.list.SelectedItem().(ResourceItem); ok {
+				return r, r.loadHistory(item.resource)
+			}
+			return r, nil
+		case tea.KeyCtrlT:
+			// Browse the owners, owned and related resources of the selected resource
+			if item, ok := r.list.SelectedItem().(ResourceItem); ok {
+				if item.resource.Deleted() {
+					r.queryErr = fmt.Errorf("deleted resources have no relations")
+					return r, nil
+				}
+				return r, r.loadTree(item.resource)
+			}
+			return r, nil
+		case tea.KeyCtrlE:
+			// Browse the Kubernetes Events about the selected resource
+			if item, ok := r.list.SelectedItem().(ResourceItem); ok {
+				return r, r.loadEvents(item.resource)
+			}
+			return r, nil
+		case tea.KeyCtrlP:
+			// Change the watched resource types
+			if r.controller == nil {
+				r.queryErr = fmt.Errorf("commands need a cluster, the TUI is offline")
+				return r, nil
+			}
+			palette := newPalette()
+			r.palette = &palette
+			return r, textinput.Blink
+		case tea.KeyCtrlS:
+			// Save the selected resource as a manifest, e.g. to restore it
+			if item, ok := r.list.SelectedItem().(ResourceItem); ok {
+				return r, exportYAML(item.resource)
+			}
+			return r, nil
+		}
+
+	case historyMsg:
+		r.history = newHistoryView(msg.resource, msg.events, r.width, r.height)
+		return r, nil
+
+	case exportedMsg:
+		if msg.err != nil {
+			r.queryErr = msg.err
+		} else if msg.redacted {
+			r.queryErr = nil
+			r.status = fmt.Sprintf("Saved %s with its values redacted, fill them in and restore with: kubectl apply -f %s",
+				msg.path, msg.path)
+		} else {
+			r.queryErr = nil
+			r.status = fmt.Sprintf("Saved %s, restore with: kubectl apply -f %s", msg.path, msg.path)
+		}
+		return r, nil
+
+	case paletteMsg:
+		if msg.err != nil {
+			r.status = ""
+			r.queryErr = msg.err
+		} else {
+			r.queryErr = nil
+			r.status = msg.status
+		}
+		if msg.changed {
+			return r, r.performSearch(r.lastSearch)
 		}
+		return r, nil
+
+	case treeMsg:
+		r.tree = newTreeView(msg.resource, msg.root, r.width, r.height)
+		return r, nil
+
+	case eventsMsg:
+		r.events = newEventsView(msg.resource, msg.summaries, r.width, r.height)
+		return r, nil
 
 	case tea.WindowSizeMsg:
 		r.width = msg.Width
 		r.height = msg.Height
-		inputHeight := 3 // Height of input field with padding
-		r.list.SetSize(msg.Width, msg.Height-inputHeight)
+		inputHeight := 4 // Height of input field with padding and the hint line
+		helpHeight := 2  // Height of the key help line
+		r.list.SetSize(msg.Width, msg.Height-inputHeight-helpHeight)
+		if r.history != nil {
+			r.history.width, r.history.height = msg.Width, msg.Height
+		}
+		if r.tree != nil {
+			r.tree.width, r.tree.height = msg.Width, msg.Height
+		}
+		if r.events != nil {
+			r.events.width, r.events.height = msg.Width, msg.Height
+		}
 
 	case resourcesMsg:
 		r.resources = msg.resources
 		r.lastSearch = msg.query
+		r.fullText = msg.fullText
 
 		// Convert resources to list items
 		var items []list.Item
 		for _, resource := range r.resources {
-			items = append(items, ResourceItem{resource: resource})
+			items = append(items, ResourceItem{resource: resource, snippet: msg.snippets[resource.ID]})
 		}
 		r.list.SetItems(items)
 
@@ -146,8 +404,13 @@ func (r *ResourceUI) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	}
 
 	var cmd tea.Cmd
+	before := r.input.Value()
 	r.input, cmd = r.input.Update(msg)
 	cmds = append(cmds, cmd)
+	if r.input.Value() != before {
+		r.suggestions = query.Complete(r.input.Value(), r.queryValues)
+		r.status = ""
+	}
 
 	r.list, cmd = r.list.Update(msg)
 	cmds = append(cmds, cmd)
@@ -161,23 +424,65 @@ func (r *ResourceUI) View() string {
 		return fmt.Sprintf("Error: %v", r.err)
 	}
 
+	if r.history != nil {
+		return appStyle.Render(r.history.View())
+	}
+	if r.tree != nil {
+		return appStyle.Render(r.tree.View())
+	}
+	if r.events != nil {
+		return appStyle.Render(r.events.View())
+	}
+
 	// Build the view
 	var b strings.Builder
-	b.WriteString(appStyle.Render(inputStyle.Render(r.input.View())))
+	if r.palette != nil {
+		b.WriteString(appStyle.Render(inputStyle.Render(r.palette.View())))
+	} else {
+		b.WriteString(appStyle.Render(inputStyle.Render(r.input.View())))
+	}
+	b.WriteString("\n")
+	b.WriteString(itemStyle.Render(r.queryHint()))
 	b.WriteString("\n\n")
 	b.WriteString(fmt.Sprintf("Found %d resources", len(r.resources)))
 	if r.lastSearch != "" {
-		b.WriteString(fmt.Sprintf(" matching '%s'", r.lastSearch))
+		if r.fullText {
+			b.WriteString(fmt.Sprintf(" containing '%s'", r.lastSearch))
+		} else {
+			b.WriteString(fmt.Sprintf(" matching '%s'", r.lastSearch))
+		}
 	}
 	b.WriteString("\n\n")
 	b.WriteString(r.list.View())
+	b.WriteString("\n")
+	b.WriteString(helpStyle.Render("enter: query • tab: complete • ctrl+f: search contents • ctrl+r: history • ctrl+t: relations • ctrl+e: events • ctrl+s: export YAML • ctrl+p: commands • esc: quit"))
 
 	return b.String()
 }
 
-// Run starts the TUI application
-func Run(store *db.ResourceStore) error {
-	p := tea.NewProgram(NewResourceUI(store), tea.WithAltScreen())
+// queryHint describes a query error or the available completions
+func (r *ResourceUI) queryHint() string {
+	if r.queryErr != nil {
+		return errorStyle.Render(r.queryErr.Error())
+	}
+	if r.status != "" {
+		return dimStyle.Render(r.status)
+	}
+
+	var hints []string
+	for _, s := range r.suggestions {
+		hints = append(hints, s.Text)
+	}
+	if len(r.suggestions) == 1 && r.suggestions[0].Description != "" {
+		hints[0] += " (" + r.suggestions[0].Description + ")"
+	}
+	return dimStyle.Render(strings.Join(hints, "  "))
+}
+
+// Run starts the TUI application, with a controller for the command palette
+// or nil when there is no cluster
+func Run(store db.Store, controller WatchController) error {
+	p := tea.NewProgram(NewResourceUI(store, controller), tea.WithAltScreen())
 	_, err := p.Run()
 	return err
 }
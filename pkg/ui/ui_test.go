@@ -0,0 +1,79 @@
+package ui
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/db/storetest"
+)
+
+// replayedUI returns a UI browsing a store filled from the rollout recording
+func replayedUI(t *testing.T) *ResourceUI {
+	t.Helper()
+	store, err := db.New(filepath.Join(t.TempDir(), "resources.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	storetest.Replay(t, store)
+
+	r := NewResourceUI(store, nil)
+	r.Update(tea.WindowSizeMsg{Width: 120, Height: 60})
+	return r
+}
+
+// run delivers the message of a command to the UI
+func run(t *testing.T, r *ResourceUI, cmd tea.Cmd) {
+	t.Helper()
+	if cmd == nil {
+		t.Fatal("no command to run")
+	}
+	r.Update(cmd())
+}
+
+func TestQueryReplayedResources(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{query: "", want: []string{"Found 2 resources", "Deployment/web", "Pod/web-2", "Healthy"}},
+		{query: "kind:Pod", want: []string{"Found 1 resources matching 'kind:Pod'", "Pod/web-2"}},
+		{query: "deleted:true", want: []string{"Found 1 resources matching 'deleted:true'", "Pod/web-1 (deleted)"}},
+		{query: "health:degraded", want: []string{"Found 0 resources matching 'health:degraded'"}},
+	}
+
+	r := replayedUI(t)
+	for _, tt := range tests {
+		run(t, r, r.performSearch(tt.query))
+		view := r.View()
+		for _, want := range tt.want {
+			if !strings.Contains(view, want) {
+				t.Errorf("query %q: view doesn't contain %q:\n%s", tt.query, want, view)
+			}
+		}
+	}
+}
+
+func TestBrowseReplayedHistory(t *testing.T) {
+	r := replayedUI(t)
+	run(t, r, r.performSearch("deleted:true"))
+
+	_, cmd := r.Update(tea.KeyMsg{Type: tea.KeyCtrlR})
+	run(t, r, cmd)
+	view := r.View()
+	for _, want := range []string{"History of Pod/web-1 in prod", "DELETED", "rv 15", "Version 3 of 3", "CrashLoopBackOff"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("history view doesn't contain %q:\n%s", want, view)
+		}
+	}
+
+	// The first version was running
+	r.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("g")})
+	view = r.View()
+	if !strings.Contains(view, "Version 1 of 3") || strings.Contains(view, "CrashLoopBackOff") {
+		t.Errorf("first version view:\n%s", view)
+	}
+}
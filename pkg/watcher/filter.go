@@ -0,0 +1,122 @@
+package watcher
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+)
+
+// Filter is a set of compiled CEL expressions evaluated against resource events.
+//
+// Expressions can refer to the following variables:
+//   - eventType: the event type (ADDED, MODIFIED, DELETED); "type" itself
+//     is a reserved identifier in CEL
+//   - object: the object involved in the event
+//   - oldObject: the previous state of the object (empty if unknown)
+//   - resource: the resource type, with kind, apiVersion, group, version,
+//     resource and namespaced fields
+//
+// For example: object.status.phase == 'Failed' && resource.kind == 'Pod'
+//
+// Optional field access is enabled, so fields that may be missing can be
+// read with a default: object.status.?readyReplicas.orValue(0)
+type Filter struct {
+	exprs    []string
+	programs []cel.Program
+}
+
+// filterEnv declares the variables available to filter expressions
+func filterEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("eventType", cel.StringType),
+		cel.Variable("object", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("oldObject", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("resource", cel.MapType(cel.StringType, cel.DynType)),
+		cel.OptionalTypes(),
+	)
+}
+
+// CompileFilter compiles CEL expressions into a Filter.
+// An event matches the filter when every expression evaluates to true.
+func CompileFilter(exprs ...string) (*Filter, error) {
+	env, err := filterEnv()
+	if err != nil {
+		return nil, fmt.Errorf("error creating CEL environment: %v", err)
+	}
+
+	filter := &Filter{}
+	for _, expr := range exprs {
+		ast, iss := env.Compile(expr)
+		if iss.Err() != nil {
+			return nil, fmt.Errorf("invalid filter %q: %v", expr, iss.Err())
+		}
+
+		if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+			return nil, fmt.Errorf("invalid filter %q: must evaluate to a bool, not %s", expr, ast.OutputType())
+		}
+
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter %q: %v", expr, err)
+		}
+
+		filter.exprs = append(filter.exprs, expr)
+		filter.programs = append(filter.programs, program)
+	}
+
+	return filter, nil
+}
+
+// Match reports whether the event satisfies every expression of the filter.
+// Evaluation errors, such as accessing a missing field, are returned
+// together with a false result.
+func (f *Filter) Match(event ResourceEvent) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+
+	activation := eventActivation(event)
+	for i, program := range f.programs {
+		out, _, err := program.Eval(activation)
+		if err != nil {
+			return false, fmt.Errorf("error evaluating filter %q: %v", f.exprs[i], err)
+		}
+
+		matched, ok := out.Value().(bool)
+		if !ok {
+			return false, fmt.Errorf("filter %q returned %v instead of a bool", f.exprs[i], out.Value())
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// eventActivation builds the CEL variables for an event
+func eventActivation(event ResourceEvent) map[string]interface{} {
+	object := event.Object
+	if object == nil {
+		object = map[string]interface{}{}
+	}
+	oldObject := event.OldObject
+	if oldObject == nil {
+		oldObject = map[string]interface{}{}
+	}
+
+	gvr := event.Resource.GroupVersionResource()
+	return map[string]interface{}{
+		"eventType": string(event.Type),
+		"object":    object,
+		"oldObject": oldObject,
+		"resource": map[string]interface{}{
+			"kind":       event.Resource.Kind,
+			"apiVersion": event.Resource.APIVersion,
+			"group":      gvr.Group,
+			"version":    gvr.Version,
+			"resource":   gvr.Resource,
+			"namespaced": event.Resource.Namespaced,
+		},
+	}
+}
@@ -0,0 +1,611 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ErrStopped is the terminal error of a watcher that was stopped with Stop
+var ErrStopped = errors.New("watcher stopped")
+
+// WatchedResource is a resource type watched in a namespace, or in all
+// namespaces when Namespace is empty. The selectors of the resource include
+// those of the options.
+type WatchedResource struct {
+	Resource  ResourceToWatch
+	Namespace string
+}
+
+// String returns the resource type, its namespace and its selectors
+func (w WatchedResource) String() string {
+	s := w.Resource.String()
+	if w.Namespace != "" {
+		s += " in " + w.Namespace
+	}
+	var selectors []string
+	if w.Resource.LabelSelector != "" {
+		selectors = append(selectors, "labels "+w.Resource.LabelSelector)
+	}
+	if w.Resource.FieldSelector != "" {
+		selectors = append(selectors, "fields "+w.Resource.FieldSelector)
+	}
+	if len(selectors) > 0 {
+		s += " (" + strings.Join(selectors, ", ") + ")"
+	}
+	return s
+}
+
+// sameWatch reports whether two watches are of the same resource type in the
+// same namespace, whatever their selectors. A resource type is watched once
+// per namespace.
+func (w WatchedResource) sameWatch(other WatchedResource) bool {
+	return w.Resource.Kind == other.Resource.Kind &&
+		w.Resource.APIVersion == other.Resource.APIVersion &&
+		w.Namespace == other.Namespace
+}
+
+// resourceWatch is the handle of one resource watcher, which can be
+// canceled on its own
+type resourceWatch struct {
+	WatchedResource
+	ctx    context.Context
+	cancel context.CancelFunc
+	// unwatched is set when Unwatch canceled the watch
+	unwatched bool
+
+	// synced is closed once the resources have been listed, or when the
+	// watch ended before that
+	synced     chan struct{}
+	syncedOnce sync.Once
+	// syncErr is why the watch ended before it synced, set before synced
+	// is closed
+	syncErr error
+	// exited is closed when the watch has returned
+	exited chan struct{}
+}
+
+// markSynced records that the resources have been listed
+func (rw *resourceWatch) markSynced() {
+	rw.syncedOnce.Do(func() { close(rw.synced) })
+}
+
+// run is one Start of a watcher. A watcher can be started again once its
+// last run is done.
+type run struct {
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	handler EventHandler
+	// done is closed when every resource watcher of the run has exited
+	done chan struct{}
+	// err is why the run ended, set before done is closed
+	err error
+
+	// activeWatchers counts the resource watchers that haven't exited. No
+	// watchers are added once ctx is done.
+	activeWatchers sync.WaitGroup
+
+	mu sync.Mutex
+	// watches are the resource watchers that haven't exited
+	watches []*resourceWatch
+	// started is set once Start has added the configured watches
+	started bool
+}
+
+// newRun creates a run that ends when ctx is done
+func newRun(ctx context.Context, handler EventHandler) *run {
+	runCtx, cancel := context.WithCancelCause(ctx)
+	return &run{
+		ctx:     runCtx,
+		cancel:  cancel,
+		handler: handler,
+		done:    make(chan struct{}),
+	}
+}
+
+// isDone reports whether the run has ended
+func (r *run) isDone() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// add registers a watch of a resource type in a namespace
+func (r *run) add(watched WatchedResource) (*resourceWatch, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.ctx.Err(); err != nil {
+		return nil, fmt.Errorf("watcher isn't running: %v", context.Cause(r.ctx))
+	}
+	for _, rw := range r.watches {
+		if rw.sameWatch(watched) && !rw.unwatched {
+			return nil, fmt.Errorf("already watching %s", watched)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	rw := &resourceWatch{
+		WatchedResource: watched,
+		ctx:             ctx,
+		cancel:          cancel,
+		synced:          make(chan struct{}),
+		exited:          make(chan struct{}),
+	}
+	r.watches = append(r.watches, rw)
+	r.activeWatchers.Add(1)
+	return rw, nil
+}
+
+// exit removes a watch that has returned, err being why it gave up. The run
+// ends when its last watch gives up.
+func (r *run) exit(rw *resourceWatch, err error) {
+	r.mu.Lock()
+	for i, other := range r.watches {
+		if other == rw {
+			r.watches = append(r.watches[:i], r.watches[i+1:]...)
+			break
+		}
+	}
+	gaveUp := !rw.unwatched && r.ctx.Err() == nil
+	last := r.started && len(r.watches) == 0
+	r.mu.Unlock()
+
+	rw.syncedOnce.Do(func() {
+		rw.syncErr = err
+		if rw.syncErr == nil {
+			rw.syncErr = context.Cause(rw.ctx)
+		}
+		close(rw.synced)
+	})
+	rw.cancel()
+	close(rw.exited)
+
+	if gaveUp && last {
+		r.cancel(errors.New("every resource watcher gave up"))
+	}
+	r.activeWatchers.Done()
+}
+
+// watched returns the resource types being watched
+func (r *run) watched() []WatchedResource {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var watched []WatchedResource
+	for _, rw := range r.watches {
+		if !rw.unwatched {
+			watched = append(watched, rw.WatchedResource)
+		}
+	}
+	return watched
+}
+
+// unwatch cancels a watch and waits for its watcher to exit
+func (r *run) unwatch(watched WatchedResource) error {
+	r.mu.Lock()
+	var rw *resourceWatch
+	for _, other := range r.watches {
+		if other.sameWatch(watched) && !other.unwatched {
+			rw = other
+			rw.unwatched = true
+			break
+		}
+	}
+	r.mu.Unlock()
+	if rw == nil {
+		return fmt.Errorf("not watching %s", watched)
+	}
+
+	log.Printf("No longer watching %s", rw.WatchedResource)
+	rw.cancel()
+	<-rw.exited
+	return nil
+}
+
+// running returns the resource types whose watchers haven't exited
+func (r *run) running() []ResourceToWatch {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var running []ResourceToWatch
+	for _, rw := range r.watches {
+		running = append(running, rw.Resource)
+	}
+	return running
+}
+
+// supervise ends the run once its context is done and every resource
+// watcher has exited
+func (r *run) supervise() {
+	<-r.ctx.Done()
+
+	// Watches are only added while holding the lock with ctx not done, so
+	// none are added after this
+	r.mu.Lock()
+	r.mu.Unlock()
+
+	r.activeWatchers.Wait()
+	r.err = context.Cause(r.ctx)
+	close(r.done)
+}
+
+// waitForSync blocks until the given watches have synced or ended, and
+// returns the number that synced and the errors of those that ended
+func (r *run) waitForSync(watches []*resourceWatch) (int, error) {
+	synced := 0
+	var errs []error
+	for _, rw := range watches {
+		select {
+		case <-rw.synced:
+		case <-r.ctx.Done():
+			return synced, context.Cause(r.ctx)
+		}
+		if rw.syncErr != nil {
+			errs = append(errs, rw.syncErr)
+		} else {
+			synced++
+		}
+	}
+	return synced, errors.Join(errs...)
+}
+
+// Start begins watching resources and returns once every resource type has
+// been listed, so the handler has seen the current state of the cluster.
+// Events keep being delivered until ctx is done or Stop is called.
+//
+// Start fails, leaving the watcher stopped, when resources can't be resolved
+// or the initial list of a configured resource type fails. With WatchAll,
+// resource types that can't be listed are skipped instead. A watcher that
+// is done can be started again.
+func (w *K8sWatcher) Start(ctx context.Context, handler EventHandler) error {
+	w.mu.Lock()
+	if w.run != nil && !w.run.isDone() {
+		w.mu.Unlock()
+		return fmt.Errorf("watcher is already running")
+	}
+	r := newRun(ctx, handler)
+	w.run = r
+	w.mu.Unlock()
+	go r.supervise()
+
+	// fail stops the run and waits for its watchers, so that a failed Start
+	// doesn't leave anything behind
+	fail := func(err error) error {
+		r.cancel(err)
+		<-r.done
+		return err
+	}
+
+	options := w.currentOptions()
+	desired, err := w.watchesFor(options)
+	if err != nil {
+		return fail(err)
+	}
+
+	log.Printf("Starting to watch %d resource types", len(desired))
+
+	// Start watchers for all resource types
+	var watches []*resourceWatch
+	for _, watched := range desired {
+		rw, err := r.add(watched)
+		if err != nil {
+			if r.ctx.Err() != nil {
+				return fail(err)
+			}
+			log.Printf("Skipping %s: %v", watched, err)
+			continue
+		}
+		w.startResourceWatcher(r, rw)
+		watches = append(watches, rw)
+	}
+	r.mu.Lock()
+	r.started = true
+	r.mu.Unlock()
+
+	synced, err := r.waitForSync(watches)
+	if err != nil {
+		if r.ctx.Err() != nil || !options.WatchAll {
+			return fail(err)
+		}
+		if synced == 0 {
+			return fail(fmt.Errorf("no resource type could be watched: %v", err))
+		}
+		log.Printf("Skipping resource types that couldn't be listed: %v", err)
+	}
+
+	return nil
+}
+
+// resourcesToWatch returns the resource types to watch, discovered or
+// resolved from the options
+func (w *K8sWatcher) resourcesToWatch(options Options) ([]ResourceToWatch, error) {
+	if options.WatchAll {
+		resources, err := w.discoverAllResources()
+		if err != nil {
+			return nil, fmt.Errorf("error discovering resources: %v", err)
+		}
+		if len(resources) == 0 {
+			return nil, fmt.Errorf("no watchable resources were discovered")
+		}
+		return resources, nil
+	}
+
+	var resources []ResourceToWatch
+	for _, resource := range options.ResourceTypes {
+		if resource.APIVersion != "" {
+			resolved, err := w.ResolveResource(resource)
+			if err != nil {
+				return nil, err
+			}
+			resources = append(resources, resolved)
+			continue
+		}
+
+		// Without an API version, the kind can name several resource types
+		// like kubectl takes them, e.g. po,svc or all
+		resolved, err := w.ResolveResources(resource.Kind)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range resolved {
+			r.LabelSelector = resource.LabelSelector
+			r.FieldSelector = resource.FieldSelector
+			resources = append(resources, r)
+		}
+	}
+	return resources, nil
+}
+
+// watchesFor returns the watches that the options ask for: every resource
+// type in each of the namespaces, with the selectors of the options
+func (w *K8sWatcher) watchesFor(options Options) ([]WatchedResource, error) {
+	resources, err := w.resourcesToWatch(options)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := options.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{options.Namespace}
+	}
+
+	var watches []WatchedResource
+	for _, resource := range resources {
+		if !resource.Namespaced {
+			watches = append(watches, watchFor(options, resource, ""))
+			continue
+		}
+		for _, namespace := range namespaces {
+			if contains(options.ExcludeNamespaces, namespace) {
+				continue
+			}
+			watches = append(watches, watchFor(options, resource, namespace))
+		}
+	}
+	return watches, nil
+}
+
+// watchFor returns the watch of a resource type in a namespace, adding the
+// selectors of the options to those of the resource type. Excluded
+// namespaces are left out with field selectors when watching all
+// namespaces.
+func watchFor(options Options, resource ResourceToWatch, namespace string) WatchedResource {
+	if !resource.Namespaced {
+		namespace = ""
+	}
+
+	labels := []string{resource.LabelSelector, options.LabelSelector}
+	fields := []string{resource.FieldSelector, options.FieldSelector}
+	if resource.Namespaced && namespace == "" {
+		for _, excluded := range options.ExcludeNamespaces {
+			fields = append(fields, "metadata.namespace!="+excluded)
+		}
+	}
+	resource.LabelSelector = joinSelectors(labels)
+	resource.FieldSelector = joinSelectors(fields)
+
+	return WatchedResource{Resource: resource, Namespace: namespace}
+}
+
+// joinSelectors combines selectors, which all have to match
+func joinSelectors(selectors []string) string {
+	var nonEmpty []string
+	for _, s := range selectors {
+		if s != "" {
+			nonEmpty = append(nonEmpty, s)
+		}
+	}
+	return strings.Join(nonEmpty, ",")
+}
+
+// Watch adds a resource type to a running watcher, in a namespace or in
+// all namespaces when namespace is empty. Events go to the handler given
+// to Start. Watch returns once the resources have been listed.
+func (w *K8sWatcher) Watch(resource ResourceToWatch, namespace string) error {
+	r := w.currentRun()
+	if r == nil {
+		return fmt.Errorf("watcher isn't running")
+	}
+
+	resolved, err := w.ResolveResource(resource)
+	if err != nil {
+		return err
+	}
+	rw, err := r.add(watchFor(w.currentOptions(), resolved, namespace))
+	if err != nil {
+		return err
+	}
+	log.Printf("Watching %s", rw.WatchedResource)
+	w.startResourceWatcher(r, rw)
+
+	select {
+	case <-rw.synced:
+		return rw.syncErr
+	case <-r.ctx.Done():
+		return fmt.Errorf("watcher stopped: %v", context.Cause(r.ctx))
+	}
+}
+
+// Unwatch stops watching a resource type in a namespace, as given to Watch
+// or in the options, and waits for its watcher to exit. The selectors of
+// the resource type are ignored.
+func (w *K8sWatcher) Unwatch(resource ResourceToWatch, namespace string) error {
+	r := w.currentRun()
+	if r == nil {
+		return fmt.Errorf("watcher isn't running")
+	}
+
+	resolved, err := w.ResolveResource(resource)
+	if err != nil {
+		return err
+	}
+	if !resolved.Namespaced {
+		namespace = ""
+	}
+	return r.unwatch(WatchedResource{Resource: resolved, Namespace: namespace})
+}
+
+// Reconfigure replaces the options of the watcher. When it is running, the
+// watches are changed to those the options ask for: watches that are no
+// longer asked for, or whose selectors changed, are stopped and new ones
+// are started, while the others keep running without a relist. Watches
+// added with Watch are stopped too, unless the options ask for them. A new
+// backoff applies to the next retry of running watches.
+//
+// Nothing changes when the filters are invalid or the resource types
+// can't be resolved. Otherwise Reconfigure returns once the new watches
+// have synced, with the errors of those that couldn't be started. The
+// kubeconfig and context are only used when the watcher is created.
+func (w *K8sWatcher) Reconfigure(options Options) error {
+	filter, err := compileFilters(options.Filters)
+	if err != nil {
+		return err
+	}
+	options = withDefaults(options)
+
+	r := w.currentRun()
+	var desired []WatchedResource
+	if r != nil {
+		if desired, err = w.watchesFor(options); err != nil {
+			return err
+		}
+	}
+
+	w.mu.Lock()
+	w.options = options
+	w.mu.Unlock()
+	w.filter.Store(filter)
+	if r == nil {
+		return nil
+	}
+
+	current := r.watched()
+	var errs []error
+	for _, watched := range current {
+		if !slices.Contains(desired, watched) {
+			if err := r.unwatch(watched); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	var watches []*resourceWatch
+	for _, watched := range desired {
+		if slices.Contains(current, watched) {
+			continue
+		}
+		rw, err := r.add(watched)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Printf("Watching %s", rw.WatchedResource)
+		w.startResourceWatcher(r, rw)
+		watches = append(watches, rw)
+	}
+
+	if _, err := r.waitForSync(watches); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Watched returns the resource types being watched
+func (w *K8sWatcher) Watched() []WatchedResource {
+	r := w.currentRun()
+	if r == nil {
+		return nil
+	}
+	return r.watched()
+}
+
+// currentRun returns the run of a running watcher, or nil
+func (w *K8sWatcher) currentRun() *run {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.run == nil || w.run.isDone() {
+		return nil
+	}
+	return w.run
+}
+
+// Stop halts all watchers and waits for them to exit until ctx is done. It
+// returns the resource types whose watchers were still running then, which
+// is nil after a clean stop. Stopping a watcher that isn't running does
+// nothing.
+func (w *K8sWatcher) Stop(ctx context.Context) []ResourceToWatch {
+	w.mu.Lock()
+	r := w.run
+	w.mu.Unlock()
+
+	if r == nil {
+		return nil
+	}
+	r.cancel(ErrStopped)
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		running := r.running()
+		log.Printf("Timed out waiting for %d watchers to stop", len(running))
+		return running
+	}
+}
+
+// Done returns a channel that is closed when the watcher started last has
+// ended, because it was stopped, its context was done or every resource
+// watcher gave up. It is nil before the first Start.
+func (w *K8sWatcher) Done() <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.run == nil {
+		return nil
+	}
+	return w.run.done
+}
+
+// Err returns why the watcher ended: ErrStopped after Stop, the cause of
+// its context when that was done, or the error that made it give up. It is
+// nil while the watcher is running.
+func (w *K8sWatcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.run == nil || !w.run.isDone() {
+		return nil
+	}
+	return w.run.err
+}
+
+// IsWatching returns true if the watcher is currently active
+func (w *K8sWatcher) IsWatching() bool {
+	return w.currentRun() != nil
+}
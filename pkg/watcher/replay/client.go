@@ -0,0 +1,307 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// ErrNotRecorded is returned for calls the fixture has no recording for
+var ErrNotRecorded = errors.New("replay: call not recorded")
+
+// Client is a dynamic.Interface serving a recorded fixture. The list and
+// watch calls for each resource type and namespace get the recorded results
+// in the order they were recorded. Watch events are delivered with their
+// recorded timing divided by the speed, or without delay when the speed is
+// 0. Watches that were still open when the recording ended stay open.
+type Client struct {
+	fixture *Fixture
+	speed   float64
+	// now and after are the clock events are timed with, replaced in tests
+	now   func() time.Time
+	after func(time.Duration) <-chan time.Time
+
+	mu    sync.Mutex
+	calls map[callKey][]*Call
+}
+
+// callKey identifies the calls of a resource type in a namespace
+type callKey struct {
+	resource  Resource
+	namespace string
+}
+
+// NewClient creates a client serving a fixture at the given speed, e.g. 1
+// for real time, 10 for ten times faster and 0 for no delays
+func NewClient(fixture *Fixture, speed float64) *Client {
+	c := &Client{
+		fixture: fixture,
+		speed:   speed,
+		now:     time.Now,
+		after:   time.After,
+		calls:   make(map[callKey][]*Call),
+	}
+	for _, call := range fixture.Calls {
+		key := callKey{resource: call.Resource, namespace: call.Namespace}
+		c.calls[key] = append(c.calls[key], call)
+	}
+	return c
+}
+
+// Discovery returns a discovery client serving the recorded API resources
+func (c *Client) Discovery() discovery.DiscoveryInterface {
+	return &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: c.fixture.Resources}}
+}
+
+// Remaining returns the number of recorded calls that haven't been made yet
+func (c *Client) Remaining() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for _, calls := range c.calls {
+		n += len(calls)
+	}
+	return n
+}
+
+// Resource returns a client for a recorded resource type
+func (c *Client) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return &replayResource{client: c, resource: resourceFor(gvr)}
+}
+
+// next takes the next recorded call of a resource type and namespace if
+// it has the given type
+func (c *Client) next(key callKey, callType string) (*Call, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	calls := c.calls[key]
+	if len(calls) == 0 {
+		return nil, nil
+	}
+	if calls[0].Type != callType {
+		return nil, fmt.Errorf("%w: %s of %s, the next recorded call is a %s",
+			ErrNotRecorded, callType, describe(key), calls[0].Type)
+	}
+	c.calls[key] = calls[1:]
+	return calls[0], nil
+}
+
+// delay returns how long to wait for a recorded duration
+func (c *Client) delay(d Duration) time.Duration {
+	if c.speed <= 0 {
+		return 0
+	}
+	return time.Duration(float64(d) / c.speed)
+}
+
+// replayResource serves the calls of a resource type, optionally in a
+// namespace
+type replayResource struct {
+	client    *Client
+	resource  Resource
+	namespace string
+}
+
+// Namespace returns a client for the resource type in a namespace
+func (r *replayResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return &replayResource{client: r.client, resource: r.resource, namespace: namespace}
+}
+
+// List returns the next recorded list
+func (r *replayResource) List(ctx context.Context, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	key := r.key()
+	call, err := r.client.next(key, CallList)
+	if err != nil {
+		return nil, err
+	}
+	if call == nil {
+		return nil, fmt.Errorf("%w: no more lists of %s", ErrNotRecorded, describe(key))
+	}
+	if call.Error != nil {
+		return nil, call.Error.err()
+	}
+
+	list := &unstructured.UnstructuredList{}
+	list.SetUnstructuredContent(runtime.DeepCopyJSON(call.List))
+	return list, nil
+}
+
+// Watch returns the next recorded watch. When every recorded watch has been
+// served the watch stays open without events, as the recording ended.
+func (r *replayResource) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	call, err := r.client.next(r.key(), CallWatch)
+	if err != nil {
+		return nil, err
+	}
+	if call == nil {
+		call = &Call{Type: CallWatch}
+	}
+	if call.Error != nil {
+		return nil, call.Error.err()
+	}
+
+	w := &replayWatch{
+		result:  make(chan watch.Event),
+		stopped: make(chan struct{}),
+	}
+	go w.run(ctx, r.client, call)
+	return w, nil
+}
+
+// key identifies the calls served by this client
+func (r *replayResource) key() callKey {
+	return callKey{resource: r.resource, namespace: r.namespace}
+}
+
+// errNotSupported is returned by calls that change or read single objects
+func (r *replayResource) errNotSupported(call string) error {
+	return fmt.Errorf("replay: %s isn't supported, only list and watch calls are recorded", call)
+}
+
+func (r *replayResource) Create(ctx context.Context, obj *unstructured.Unstructured, options metav1.CreateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	return nil, r.errNotSupported("create")
+}
+
+func (r *replayResource) Update(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	return nil, r.errNotSupported("update")
+}
+
+func (r *replayResource) UpdateStatus(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions) (*unstructured.Unstructured, error) {
+	return nil, r.errNotSupported("update")
+}
+
+func (r *replayResource) Delete(ctx context.Context, name string, options metav1.DeleteOptions, subresources ...string) error {
+	return r.errNotSupported("delete")
+}
+
+func (r *replayResource) DeleteCollection(ctx context.Context, options metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	return r.errNotSupported("delete")
+}
+
+func (r *replayResource) Get(ctx context.Context, name string, options metav1.GetOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	return nil, r.errNotSupported("get")
+}
+
+func (r *replayResource) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, options metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	return nil, r.errNotSupported("patch")
+}
+
+func (r *replayResource) Apply(ctx context.Context, name string, obj *unstructured.Unstructured, options metav1.ApplyOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	return nil, r.errNotSupported("apply")
+}
+
+func (r *replayResource) ApplyStatus(ctx context.Context, name string, obj *unstructured.Unstructured, options metav1.ApplyOptions) (*unstructured.Unstructured, error) {
+	return nil, r.errNotSupported("apply")
+}
+
+// replayWatch delivers the events of a recorded watch
+type replayWatch struct {
+	result chan watch.Event
+
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+// run sends the recorded events on time, then closes the watch if the
+// server closed it or waits until it is stopped
+func (w *replayWatch) run(ctx context.Context, client *Client, call *Call) {
+	defer close(w.result)
+	start := client.now()
+
+	// wait sleeps until a recorded time and reports whether to go on
+	wait := func(at Duration) bool {
+		select {
+		case <-client.after(start.Add(client.delay(at)).Sub(client.now())):
+			return true
+		case <-w.stopped:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for _, recorded := range call.Events {
+		if !wait(recorded.At) {
+			return
+		}
+		event, err := recorded.event()
+		if err != nil {
+			event = watch.Event{Type: watch.Error, Object: &apierrors.NewInternalError(err).ErrStatus}
+		}
+		select {
+		case w.result <- event:
+		case <-w.stopped:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	if call.ClosedAt != nil {
+		wait(*call.ClosedAt)
+		return
+	}
+
+	select {
+	case <-w.stopped:
+	case <-ctx.Done():
+	}
+}
+
+// Stop ends the watch
+func (w *replayWatch) Stop() {
+	w.stopOnce.Do(func() { close(w.stopped) })
+}
+
+// ResultChan returns the recorded events
+func (w *replayWatch) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+// event converts a recorded event back into a watch event. Errors carry a
+// Status like the events of a live watch.
+func (e Event) event() (watch.Event, error) {
+	if watch.EventType(e.Type) == watch.Error {
+		var status metav1.Status
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(e.Object, &status); err != nil {
+			return watch.Event{}, fmt.Errorf("invalid recorded status: %v", err)
+		}
+		return watch.Event{Type: watch.Error, Object: &status}, nil
+	}
+
+	obj := &unstructured.Unstructured{Object: runtime.DeepCopyJSON(e.Object)}
+	return watch.Event{Type: watch.EventType(e.Type), Object: obj}, nil
+}
+
+// err returns the recorded error, as an API error if it had a status
+func (e *CallError) err() error {
+	if e.Status != nil {
+		return &apierrors.StatusError{ErrStatus: *e.Status}
+	}
+	return errors.New(e.Message)
+}
+
+// describe formats a resource type and namespace for errors
+func describe(key callKey) string {
+	gvr := key.resource.GroupVersionResource().String()
+	if key.namespace == "" {
+		return gvr
+	}
+	return gvr + " in " + key.namespace
+}
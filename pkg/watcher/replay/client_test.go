@@ -0,0 +1,83 @@
+package replay
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// fakeClock records the waits of a replay and ends them right away
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After moves the clock forward by d and fires
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+	fired := make(chan time.Time, 1)
+	fired <- c.now
+	return fired
+}
+
+func TestReplaySpeed(t *testing.T) {
+	closed := Duration(4 * time.Second)
+	fixture := &Fixture{Calls: []*Call{{
+		Type: CallWatch, Resource: Resource{Version: "v1", Resource: "configmaps"},
+		Events: []Event{
+			{At: Duration(time.Second), Type: "ADDED", Object: map[string]interface{}{
+				"kind": "ConfigMap", "metadata": map[string]interface{}{"name": "a"}}},
+			{At: Duration(3 * time.Second), Type: "MODIFIED", Object: map[string]interface{}{
+				"kind": "ConfigMap", "metadata": map[string]interface{}{"name": "a"}}},
+		},
+		ClosedAt: &closed,
+	}}}
+
+	tests := []struct {
+		speed float64
+		waits []time.Duration
+	}{
+		{speed: 1, waits: []time.Duration{time.Second, 2 * time.Second, time.Second}},
+		{speed: 10, waits: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 100 * time.Millisecond}},
+		{speed: 0, waits: []time.Duration{0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+		client := NewClient(fixture, tt.speed)
+		client.now, client.after = clock.Now, clock.After
+
+		w, err := client.Resource(schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}).
+			Watch(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var types []string
+		for event := range w.ResultChan() {
+			types = append(types, string(event.Type))
+		}
+		w.Stop()
+
+		if want := []string{"ADDED", "MODIFIED"}; !reflect.DeepEqual(types, want) {
+			t.Errorf("speed %v: events = %v, want %v", tt.speed, types, want)
+		}
+		if !reflect.DeepEqual(clock.waits, tt.waits) {
+			t.Errorf("speed %v: waited %v, want %v", tt.speed, clock.waits, tt.waits)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Package replay records the list and watch calls a watcher makes against a
+// cluster and serves them again without one, so the watcher, the database
+// and the UI can be exercised offline and deterministically.
+//
+// A Recorder wraps a live dynamic.Interface and captures every list and
+// watch call with its result: the listed objects, the watch events with
+// their timing, watches closed by the server, errors and expired resource
+// versions (410 Gone). A Client serves a recorded Fixture as a
+// dynamic.Interface, in the order the calls were recorded, at real or
+// accelerated speed.
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Call types
+const (
+	CallList  = "list"
+	CallWatch = "watch"
+)
+
+// Fixture is a recording of the calls made for each resource
+type Fixture struct {
+	// Resources is the API discovery of the recorded cluster
+	Resources []*metav1.APIResourceList `json:"resources,omitempty"`
+	// Calls are in the order they were made
+	Calls []*Call `json:"calls"`
+}
+
+// Call is a recorded list or watch call
+type Call struct {
+	Type      string   `json:"type"`
+	Resource  Resource `json:"resource"`
+	Namespace string   `json:"namespace,omitempty"`
+	// ResourceVersion the call was made with
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	// At is when the call was made, since the start of the recording
+	At Duration `json:"at"`
+	// Error is set when the call failed
+	Error *CallError `json:"error,omitempty"`
+	// List is the result of a list call
+	List map[string]interface{} `json:"list,omitempty"`
+	// Events are the events received from a watch
+	Events []Event `json:"events,omitempty"`
+	// ClosedAt is when the server closed the watch, since the call. Watches
+	// that were open when the recording ended stay open when replayed.
+	ClosedAt *Duration `json:"closedAt,omitempty"`
+}
+
+// Resource identifies a recorded resource type
+type Resource struct {
+	Group    string `json:"group,omitempty"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+}
+
+// GroupVersionResource returns the resource as a GVR
+func (r Resource) GroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: r.Group, Version: r.Version, Resource: r.Resource}
+}
+
+// resourceFor returns the recorded form of a GVR
+func resourceFor(gvr schema.GroupVersionResource) Resource {
+	return Resource{Group: gvr.Group, Version: gvr.Version, Resource: gvr.Resource}
+}
+
+// Event is a recorded watch event
+type Event struct {
+	// At is when the event was received, since the watch call
+	At     Duration               `json:"at"`
+	Type   string                 `json:"type"`
+	Object map[string]interface{} `json:"object"`
+}
+
+// CallError is a recorded error. API errors keep their status, so errors
+// such as 410 Gone are replayed as the same API error.
+type CallError struct {
+	Message string         `json:"message"`
+	Status  *metav1.Status `json:"status,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "1.5s"
+type Duration time.Duration
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON reads a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %v", s, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Load reads a fixture file
+func Load(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %v", err)
+	}
+	fixture, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return fixture, nil
+}
+
+// Parse reads a fixture from its JSON encoding
+func Parse(data []byte) (*Fixture, error) {
+	var fixture Fixture
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fixture); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture: %v", err)
+	}
+
+	// Objects hold integers as int64, like the objects of a live client
+	for _, call := range fixture.Calls {
+		call.List = convertNumbers(call.List)
+		for i := range call.Events {
+			call.Events[i].Object = convertNumbers(call.Events[i].Object)
+		}
+	}
+	return &fixture, nil
+}
+
+// convertNumbers replaces the JSON numbers in an object with int64 or
+// float64 values
+func convertNumbers(obj map[string]interface{}) map[string]interface{} {
+	if obj == nil {
+		return nil
+	}
+	return convertNumber(obj).(map[string]interface{})
+}
+
+// convertNumber converts the JSON numbers in a decoded value
+func convertNumber(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = convertNumber(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = convertNumber(item)
+		}
+	}
+	return value
+}
+
+// Save writes the fixture to a file
+func (f *Fixture) Save(path string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode fixture: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write fixture: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+)
+
+// Recorder is a dynamic.Interface that passes calls to a live client and
+// records the list and watch calls into a fixture
+type Recorder struct {
+	client dynamic.Interface
+	start  time.Time
+
+	mu      sync.Mutex
+	fixture Fixture
+}
+
+// NewRecorder creates a recorder in front of a live client
+func NewRecorder(client dynamic.Interface) *Recorder {
+	return &Recorder{client: client, start: time.Now()}
+}
+
+// RecordDiscovery records the API resources of the cluster, so that
+// replays can resolve kinds and watch all resources
+func (r *Recorder) RecordDiscovery(client discovery.DiscoveryInterface) error {
+	_, lists, err := client.ServerGroupsAndResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return fmt.Errorf("failed to discover resources: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fixture.Resources = lists
+	return nil
+}
+
+// Fixture returns a copy of what has been recorded so far
+func (r *Recorder) Fixture() *Fixture {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fixture := &Fixture{Resources: r.fixture.Resources}
+	for _, call := range r.fixture.Calls {
+		c := *call
+		c.Events = append([]Event(nil), call.Events...)
+		fixture.Calls = append(fixture.Calls, &c)
+	}
+	return fixture
+}
+
+// Save writes what has been recorded so far to a file
+func (r *Recorder) Save(path string) error {
+	return r.Fixture().Save(path)
+}
+
+// Resource returns a client for a resource type that records its calls
+func (r *Recorder) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	resource := r.client.Resource(gvr)
+	return &recordingResource{
+		ResourceInterface: resource,
+		namespaceable:     resource,
+		recorder:          r,
+		gvr:               gvr,
+	}
+}
+
+// since returns the time since the recording started
+func (r *Recorder) since() Duration {
+	return Duration(time.Since(r.start))
+}
+
+// add appends a call to the fixture
+func (r *Recorder) add(call *Call) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fixture.Calls = append(r.fixture.Calls, call)
+}
+
+// recordingResource records the list and watch calls of one resource type,
+// optionally in a namespace. Other calls go straight to the live client.
+type recordingResource struct {
+	dynamic.ResourceInterface
+	namespaceable dynamic.NamespaceableResourceInterface
+	recorder      *Recorder
+	gvr           schema.GroupVersionResource
+	namespace     string
+}
+
+// Namespace returns a client for the resource type in a namespace
+func (c *recordingResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return &recordingResource{
+		ResourceInterface: c.namespaceable.Namespace(namespace),
+		namespaceable:     c.namespaceable,
+		recorder:          c.recorder,
+		gvr:               c.gvr,
+		namespace:         namespace,
+	}
+}
+
+// List records the listed objects or the error
+func (c *recordingResource) List(ctx context.Context, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	call := c.newCall(CallList, opts)
+	list, err := c.ResourceInterface.List(ctx, opts)
+	if err != nil {
+		call.Error = callError(err)
+	} else {
+		call.List = list.UnstructuredContent()
+	}
+	c.recorder.add(call)
+	return list, err
+}
+
+// Watch records the events of the watch as they arrive
+func (c *recordingResource) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	call := c.newCall(CallWatch, opts)
+	w, err := c.ResourceInterface.Watch(ctx, opts)
+	if err != nil {
+		call.Error = callError(err)
+		c.recorder.add(call)
+		return nil, err
+	}
+	c.recorder.add(call)
+
+	rw := &recordingWatch{
+		source:   w,
+		recorder: c.recorder,
+		call:     call,
+		start:    time.Now(),
+		result:   make(chan watch.Event),
+		stopped:  make(chan struct{}),
+	}
+	go rw.run(ctx)
+	return rw, nil
+}
+
+// newCall starts recording a call
+func (c *recordingResource) newCall(callType string, opts metav1.ListOptions) *Call {
+	return &Call{
+		Type:            callType,
+		Resource:        resourceFor(c.gvr),
+		Namespace:       c.namespace,
+		ResourceVersion: opts.ResourceVersion,
+		At:              c.recorder.since(),
+	}
+}
+
+// recordingWatch passes on the events of a live watch while recording them
+type recordingWatch struct {
+	source   watch.Interface
+	recorder *Recorder
+	call     *Call
+	start    time.Time
+	result   chan watch.Event
+
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+// run forwards events until the source closes or the watch is stopped
+func (w *recordingWatch) run(ctx context.Context) {
+	defer close(w.result)
+
+	for {
+		select {
+		case <-w.stopped:
+			return
+		case event, ok := <-w.source.ResultChan():
+			if !ok {
+				// Closes caused by the client aren't part of the recording
+				select {
+				case <-w.stopped:
+				default:
+					if ctx.Err() == nil {
+						at := Duration(time.Since(w.start))
+						w.recorder.mu.Lock()
+						w.call.ClosedAt = &at
+						w.recorder.mu.Unlock()
+					}
+				}
+				return
+			}
+
+			w.record(event)
+			select {
+			case w.result <- event:
+			case <-w.stopped:
+				return
+			}
+		}
+	}
+}
+
+// record appends an event to the call
+func (w *recordingWatch) record(event watch.Event) {
+	recorded := Event{At: Duration(time.Since(w.start)), Type: string(event.Type)}
+	switch obj := event.Object.(type) {
+	case runtime.Unstructured:
+		recorded.Object = obj.UnstructuredContent()
+	case nil:
+	default:
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			recorded.Object = map[string]interface{}{"error": err.Error()}
+		} else {
+			recorded.Object = content
+		}
+	}
+
+	w.recorder.mu.Lock()
+	defer w.recorder.mu.Unlock()
+	w.call.Events = append(w.call.Events, recorded)
+}
+
+// Stop stops the live watch
+func (w *recordingWatch) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopped)
+		w.source.Stop()
+	})
+}
+
+// ResultChan returns the events of the live watch
+func (w *recordingWatch) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+// callError records an error, keeping the status of API errors
+func callError(err error) *CallError {
+	recorded := &CallError{Message: err.Error()}
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		s := status.Status()
+		recorded.Status = &s
+	}
+	return recorded
+}
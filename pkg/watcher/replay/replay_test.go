@@ -0,0 +1,210 @@
+package replay_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher"
+	"github.com/worldsayshi/go-k8s-watcher/pkg/watcher/replay"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var configMaps = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// discoveredResources lists ConfigMaps as the only API resource
+var discoveredResources = []*metav1.APIResourceList{{
+	GroupVersion: "v1",
+	APIResources: []metav1.APIResource{{
+		Name: "configmaps", Kind: "ConfigMap", Namespaced: true,
+		Verbs: metav1.Verbs{"list", "watch"},
+	}},
+}}
+
+// configMap returns a ConfigMap object
+func configMap(name, resourceVersion string) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":            name,
+			"namespace":       "default",
+			"resourceVersion": resourceVersion,
+		},
+	}
+}
+
+// collector records the events passed to a handler
+type collector struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (c *collector) handle(event watcher.ResourceEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, fmt.Sprintf("%s %s@%s", event.Type, event.Name, event.ResourceVersion))
+}
+
+// waitFor waits until n events have been collected and returns them
+func (c *collector) waitFor(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		events := append([]string(nil), c.events...)
+		c.mu.Unlock()
+		if len(events) >= n || time.Now().After(deadline) {
+			return events
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startWatcher watches ConfigMaps in all namespaces through the given clients
+func startWatcher(t *testing.T, client *replay.Client) *collector {
+	t.Helper()
+	w, err := watcher.NewWatcherWithClients(watcher.Options{
+		ResourceTypes: []watcher.ResourceToWatch{{Kind: "ConfigMap", APIVersion: "v1"}},
+	}, watcher.Clients{Dynamic: client, Discovery: client.Discovery()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &collector{}
+	if err := w.Start(context.Background(), c.handle); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { stop(t, w) })
+	return c
+}
+
+// stop stops a watcher, failing if its watchers don't exit
+func stop(t *testing.T, w *watcher.K8sWatcher) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if running := w.Stop(ctx); running != nil {
+		t.Errorf("watchers still running after Stop: %v", running)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	live := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{configMaps: "ConfigMapList"},
+		&unstructured.Unstructured{Object: configMap("first", "1")})
+	discovery := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: discoveredResources}}
+
+	recorder := replay.NewRecorder(live)
+	if err := recorder.RecordDiscovery(discovery); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := watcher.NewWatcherWithClients(watcher.Options{
+		ResourceTypes: []watcher.ResourceToWatch{{Kind: "ConfigMap", APIVersion: "v1"}},
+	}, watcher.Clients{Dynamic: recorder, Discovery: discovery})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := &collector{}
+	if err := w.Start(context.Background(), recorded.handle); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fake client only sends changes made after the watch started
+	deadline := time.Now().Add(5 * time.Second)
+	for len(recorder.Fixture().Calls) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the watch")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	second := &unstructured.Unstructured{Object: configMap("second", "2")}
+	if _, err := live.Resource(configMaps).Namespace("default").Create(context.Background(), second, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want := recorded.waitFor(t, 2)
+	stop(t, w)
+
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	if err := recorder.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	fixture, err := replay.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := replay.NewClient(fixture, 0)
+	got := startWatcher(t, client).waitFor(t, len(want))
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("replayed events = %v, want %v", got, want)
+	}
+	if n := client.Remaining(); n != 0 {
+		t.Errorf("%d recorded calls weren't replayed", n)
+	}
+}
+
+func TestReplayClosesAndExpiredVersions(t *testing.T) {
+	gone := apierrors.NewResourceExpired("too old resource version")
+	goneStatus, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&gone.ErrStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(resourceVersion string, items ...map[string]interface{}) map[string]interface{} {
+		var listed []interface{}
+		for _, item := range items {
+			listed = append(listed, item)
+		}
+		return map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMapList",
+			"metadata":   map[string]interface{}{"resourceVersion": resourceVersion},
+			"items":      listed,
+		}
+	}
+	closed := replay.Duration(0)
+	resource := replay.Resource{Version: "v1", Resource: "configmaps"}
+
+	fixture := &replay.Fixture{
+		Resources: discoveredResources,
+		Calls: []*replay.Call{
+			{Type: replay.CallList, Resource: resource, List: list("1", configMap("a", "1"))},
+			// The server closes the watch, which resumes from the last event
+			{Type: replay.CallWatch, Resource: resource, Events: []replay.Event{
+				{Type: "MODIFIED", Object: configMap("a", "2")},
+			}, ClosedAt: &closed},
+			// The version has expired by then, which needs a relist
+			{Type: replay.CallWatch, Resource: resource, Error: &replay.CallError{Message: gone.Error(), Status: &gone.ErrStatus}},
+			{Type: replay.CallList, Resource: resource, List: list("4", configMap("a", "3"), configMap("b", "4"))},
+			// Expiry reported within the watch also relists
+			{Type: replay.CallWatch, Resource: resource, Events: []replay.Event{
+				{Type: "ERROR", Object: goneStatus},
+			}},
+			{Type: replay.CallList, Resource: resource, List: list("5", configMap("b", "4"))},
+		},
+	}
+
+	client := replay.NewClient(fixture, 0)
+	got := startWatcher(t, client).waitFor(t, 5)
+
+	want := []string{"ADDED a@1", "MODIFIED a@2", "MODIFIED a@3", "ADDED b@4", "DELETED a@3"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+	if n := client.Remaining(); n != 0 {
+		t.Errorf("%d recorded calls weren't replayed", n)
+	}
+}
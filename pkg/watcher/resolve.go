@@ -0,0 +1,139 @@
+package watcher
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ResolveResources resolves resource types written the way kubectl get takes
+// them: a comma-separated list of plural, singular or short resource names
+// or kinds, e.g. po,svc,cm, optionally qualified by group, e.g.
+// deployments.apps, or by version and group, e.g. deployments.v1.apps, and
+// categories such as all. Types written as KIND[.GROUP]/VERSION are accepted
+// too.
+func (w *K8sWatcher) ResolveResources(arg string) ([]ResourceToWatch, error) {
+	var resources []ResourceToWatch
+	for _, name := range strings.Split(arg, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("invalid resource type %q: empty name in list", arg)
+		}
+
+		var resolved []ResourceToWatch
+		if strings.Contains(name, "/") {
+			resource, err := ParseResource(name)
+			if err != nil {
+				return nil, err
+			}
+			if resource, err = w.ResolveResource(resource); err != nil {
+				return nil, err
+			}
+			resolved = []ResourceToWatch{resource}
+		} else {
+			var err error
+			if resolved, err = w.resolveName(name); err != nil {
+				return nil, err
+			}
+		}
+
+		// Skip types given twice, e.g. as po,pods
+		for _, resource := range resolved {
+			duplicate := false
+			for _, other := range resources {
+				if other.Kind == resource.Kind && other.APIVersion == resource.APIVersion {
+					duplicate = true
+					break
+				}
+			}
+			if !duplicate {
+				resources = append(resources, resource)
+			}
+		}
+	}
+	return resources, nil
+}
+
+// resolveName resolves the resource types of a category, or of a resource
+// name or kind
+func (w *K8sWatcher) resolveName(name string) ([]ResourceToWatch, error) {
+	// Categories take precedence, as with kubectl
+	if groupResources, ok := w.categories.Expand(name); ok {
+		// Discovery is cached by group in a map, so the members come in any
+		// order; resolve them core group first, then by group and resource
+		groupResources = slices.Clone(groupResources)
+		slices.SortFunc(groupResources, func(a, b schema.GroupResource) int {
+			return cmp.Or(cmp.Compare(a.Group, b.Group), cmp.Compare(a.Resource, b.Resource))
+		})
+		var resources []ResourceToWatch
+		for _, gr := range groupResources {
+			gvk, err := w.restMapper.KindFor(gr.WithVersion(""))
+			if err != nil {
+				return nil, fmt.Errorf("error resolving %s in category %s: %v", gr, name, err)
+			}
+			mapping, err := w.restMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+			if err != nil {
+				return nil, fmt.Errorf("error resolving %s in category %s: %v", gr, name, err)
+			}
+			resources = append(resources, resourceForMapping(mapping))
+		}
+		return resources, nil
+	}
+
+	mapping, err := w.mappingFor(name)
+	if err != nil {
+		return nil, err
+	}
+	return []ResourceToWatch{resourceForMapping(mapping)}, nil
+}
+
+// mappingFor finds the mapping of a resource name or kind the way kubectl
+// does: first as a resource, e.g. deploy or deployments.v1.apps, then as a
+// kind, e.g. Deployment or Deployment.v1.apps
+func (w *K8sWatcher) mappingFor(name string) (*meta.RESTMapping, error) {
+	fullySpecifiedGVR, groupResource := schema.ParseResourceArg(name)
+	gvk := schema.GroupVersionKind{}
+	if fullySpecifiedGVR != nil {
+		gvk, _ = w.restMapper.KindFor(*fullySpecifiedGVR)
+	}
+	if gvk.Empty() {
+		gvk, _ = w.restMapper.KindFor(groupResource.WithVersion(""))
+	}
+	if !gvk.Empty() {
+		return w.restMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+
+	fullySpecifiedGVK, groupKind := schema.ParseKindArg(name)
+	if fullySpecifiedGVK == nil {
+		gvk := groupKind.WithVersion("")
+		fullySpecifiedGVK = &gvk
+	}
+	if !fullySpecifiedGVK.Empty() {
+		if mapping, err := w.restMapper.RESTMapping(fullySpecifiedGVK.GroupKind(), fullySpecifiedGVK.Version); err == nil {
+			return mapping, nil
+		}
+	}
+
+	mapping, err := w.restMapper.RESTMapping(groupKind, gvk.Version)
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil, fmt.Errorf("the server doesn't have a resource type %q", name)
+		}
+		return nil, err
+	}
+	return mapping, nil
+}
+
+// resourceForMapping returns the resource type of a REST mapping
+func resourceForMapping(mapping *meta.RESTMapping) ResourceToWatch {
+	return ResourceToWatch{
+		Kind:       mapping.GroupVersionKind.Kind,
+		APIVersion: mapping.GroupVersionKind.GroupVersion().String(),
+		Resource:   mapping.Resource.Resource,
+		Namespaced: mapping.Scope.Name() == meta.RESTScopeNameNamespace,
+	}
+}
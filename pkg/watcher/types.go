@@ -3,15 +3,26 @@ package watcher
 
 import (
 	"context"
+	"time"
 
 	"k8s.io/apimachinery/pkg/watch"
 )
 
 // ResourceToWatch represents a Kubernetes resource to watch
+// When APIVersion is empty, the watcher resolves it from the Kind, which in
+// Options.ResourceTypes can also be resource names as kubectl takes them,
+// e.g. po,svc or deployments.apps (see ResolveResources).
 type ResourceToWatch struct {
 	Kind       string
 	APIVersion string
 	Namespaced bool
+	// Resource is the plural resource name (e.g. deployments).
+	// When empty it is derived from Kind.
+	Resource string
+	// LabelSelector and FieldSelector restrict the watched objects of this
+	// resource type, in addition to the selectors of the options
+	LabelSelector string
+	FieldSelector string
 }
 
 // ResourceEvent represents an event that occurred on a Kubernetes resource
@@ -30,10 +41,22 @@ type ResourceEvent struct {
 	PreviousResourceVersion string
 	// Object is the raw object data
 	Object map[string]interface{}
+	// OldObject is the previous state of the object for modification and
+	// deletion events, if it was seen by the watcher
+	OldObject map[string]interface{}
 	// Error information if the event type is Error
 	Error error
 }
 
+// JSONEvent is a ResourceEvent as written by watcher --output ndjson. It has
+// the shape of the watch events printed by kubectl get --output-watch-events
+// -o json, plus the time the event was seen.
+type JSONEvent struct {
+	Type   watch.EventType        `json:"type"`
+	Time   time.Time              `json:"time,omitzero"`
+	Object map[string]interface{} `json:"object"`
+}
+
 // EventHandler is a callback function that is invoked when resource events occur
 type EventHandler func(event ResourceEvent)
 
@@ -41,22 +64,93 @@ type EventHandler func(event ResourceEvent)
 type Options struct {
 	// Namespace to watch (empty string for all namespaces)
 	Namespace string
+	// Namespaces to watch, each with its own watch, instead of Namespace
+	Namespaces []string
+	// ExcludeNamespaces are left out when watching all namespaces
+	ExcludeNamespaces []string
 	// ResourceTypes to watch (empty for default set)
 	ResourceTypes []ResourceToWatch
 	// WatchAll resources discovered in the API
 	WatchAll bool
+	// LabelSelector restricts the watched objects by label (e.g. app=nginx)
+	LabelSelector string
+	// FieldSelector restricts the watched objects by field (e.g. metadata.name=x)
+	FieldSelector string
 	// KubeconfigPath explicitly sets a kubeconfig file path
 	KubeconfigPath string
+	// Context is the kubeconfig context to use (empty for the current one)
+	Context string
+	// Filters are CEL expressions that an event must satisfy before it is
+	// passed to the handler (see Filter). Error events are always delivered.
+	Filters []string
+	// Backoff controls the retries of failing lists and watches
+	Backoff Backoff
+}
+
+// Backoff controls how a resource watcher retries. Zero values use the
+// defaults.
+type Backoff struct {
+	// Initial is the delay before the first retry, which grows by the same
+	// amount with every retry (default 2s)
+	Initial time.Duration
+	// Max caps the delay between retries (default no cap)
+	Max time.Duration
+	// MaxRetries is the number of retries before a watcher gives up
+	// (default 5)
+	MaxRetries int
+}
+
+// delay returns how long to wait before a retry, counted from 1
+func (b Backoff) delay(retry int) time.Duration {
+	initial := b.Initial
+	if initial <= 0 {
+		initial = 2 * time.Second
+	}
+	d := time.Duration(retry) * initial
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	return d
+}
+
+// maxRetries returns the number of retries before giving up
+func (b Backoff) maxRetries() int {
+	if b.MaxRetries <= 0 {
+		return 5
+	}
+	return b.MaxRetries
 }
 
 // ResourceWatcher defines the interface for watching Kubernetes resources
 type ResourceWatcher interface {
-	// Start begins watching resources and calls the handler for events
-	// Returns an error if the watcher could not be started
+	// Start begins watching resources and calls the handler for events. It
+	// returns once the current state has been listed, or an error if the
+	// watcher could not be started.
 	Start(ctx context.Context, handler EventHandler) error
 
-	// Stop halts all watchers
-	Stop()
+	// Stop halts all watchers and waits for them until ctx is done, returning
+	// the resource types whose watchers were still running then
+	Stop(ctx context.Context) []ResourceToWatch
+
+	// Watch adds a resource type to a running watcher, in a namespace or in
+	// all namespaces when namespace is empty
+	Watch(resource ResourceToWatch, namespace string) error
+
+	// Unwatch stops watching a resource type in a namespace
+	Unwatch(resource ResourceToWatch, namespace string) error
+
+	// Watched returns the resource types being watched
+	Watched() []WatchedResource
+
+	// Reconfigure replaces the options, changing only the watches that
+	// differ when the watcher is running
+	Reconfigure(options Options) error
+
+	// Done is closed when the watcher has ended
+	Done() <-chan struct{}
+
+	// Err returns why the watcher ended, or nil while it is running
+	Err() error
 
 	// IsWatching returns true if the watcher is currently active
 	IsWatching() bool
@@ -3,8 +3,58 @@ package watcher
 import (
 	"fmt"
 	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
+// GroupVersionResource returns the GroupVersionResource for the resource type
+func (r ResourceToWatch) GroupVersionResource() schema.GroupVersionResource {
+	group, version := SplitAPIVersion(r.APIVersion)
+
+	resource := r.Resource
+	if resource == "" {
+		resource = getResourceNameFromKind(r.Kind)
+	}
+
+	return schema.GroupVersionResource{
+		Group:    group,
+		Version:  version,
+		Resource: resource,
+	}
+}
+
+// String returns the kind with its group and version, e.g. Deployment.apps/v1
+func (r ResourceToWatch) String() string {
+	group, version := SplitAPIVersion(r.APIVersion)
+	if group != "" {
+		return fmt.Sprintf("%s.%s/%s", r.Kind, group, version)
+	}
+	return fmt.Sprintf("%s/%s", r.Kind, version)
+}
+
+// ParseResource parses a resource type written like ResourceToWatch.String,
+// e.g. Deployment.apps/v1 or Pod/v1, or a kind alone, which leaves the API
+// version to be resolved
+func ParseResource(s string) (ResourceToWatch, error) {
+	kind, version, hasVersion := strings.Cut(s, "/")
+	if !hasVersion {
+		if s == "" || strings.Contains(s, ".") {
+			return ResourceToWatch{}, fmt.Errorf("invalid resource type %q, expected KIND or KIND[.GROUP]/VERSION", s)
+		}
+		return ResourceToWatch{Kind: s}, nil
+	}
+
+	kind, group, _ := strings.Cut(kind, ".")
+	if kind == "" || version == "" {
+		return ResourceToWatch{}, fmt.Errorf("invalid resource type %q, expected KIND or KIND[.GROUP]/VERSION", s)
+	}
+	apiVersion := version
+	if group != "" {
+		apiVersion = group + "/" + version
+	}
+	return ResourceToWatch{Kind: kind, APIVersion: apiVersion}, nil
+}
+
 // Helper function to pluralize common Kubernetes resource kinds
 func getResourceNameFromKind(kind string) string {
 	kindToResource := map[string]string{
@@ -6,8 +6,11 @@ import (
 	"log"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -22,14 +25,20 @@ import (
 
 // K8sWatcher implements ResourceWatcher
 type K8sWatcher struct {
-	options        Options
-	dynamicClient  dynamic.Interface
-	discovery      *discovery.DiscoveryClient
-	restMapper     *restmapper.DeferredDiscoveryRESTMapper
-	activeWatchers sync.WaitGroup
-	stopCh         chan struct{}
-	watching       bool
-	mu             sync.RWMutex
+	dynamicClient dynamic.Interface
+	discovery     discovery.DiscoveryInterface
+	// restMapper expands the short names of resources found by discovery
+	restMapper meta.RESTMapper
+	// categories expands categories such as all into resource types
+	categories restmapper.CategoryExpander
+	// filter is swapped by Reconfigure while events are handled
+	filter atomic.Pointer[Filter]
+
+	mu sync.Mutex
+	// options are replaced by Reconfigure
+	options Options
+	// run is the last Start of the watcher
+	run *run
 }
 
 // DefaultResourceTypes returns a set of common resource types to watch
@@ -45,130 +54,146 @@ func DefaultResourceTypes() []ResourceToWatch {
 
 // NewWatcher creates a new Kubernetes resource watcher
 func NewWatcher(options Options) (*K8sWatcher, error) {
+	clients, err := NewClients(options.KubeconfigPath, options.Context)
+	if err != nil {
+		return nil, err
+	}
+	return NewWatcherWithClients(options, clients)
+}
+
+// Clients are the Kubernetes clients used by a watcher
+type Clients struct {
+	Dynamic   dynamic.Interface
+	Discovery discovery.DiscoveryInterface
+	// RESTMapper resolves kinds to resources. When nil, a mapper backed by
+	// Discovery is used.
+	RESTMapper meta.RESTMapper
+}
+
+// NewClients creates the clients for a kubeconfig and one of its contexts,
+// using the default loading rules when the path is empty and the current
+// context when context is empty
+func NewClients(kubeconfigPath, context string) (Clients, error) {
 	// Build Kubernetes client configuration
 	configLoadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
-	if options.KubeconfigPath != "" {
-		configLoadingRules.ExplicitPath = options.KubeconfigPath
+	if kubeconfigPath != "" {
+		configLoadingRules.ExplicitPath = kubeconfigPath
 	}
 
 	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
 		configLoadingRules,
-		&clientcmd.ConfigOverrides{})
+		&clientcmd.ConfigOverrides{CurrentContext: context})
 
 	// Get REST config
 	config, err := clientConfig.ClientConfig()
 	if err != nil {
-		return nil, fmt.Errorf("error building kubeconfig: %v", err)
+		return Clients{}, fmt.Errorf("error building kubeconfig: %v", err)
 	}
 
 	// Create dynamic client
 	dynamicClient, err := dynamic.NewForConfig(config)
 	if err != nil {
-		return nil, fmt.Errorf("error creating dynamic client: %v", err)
+		return Clients{}, fmt.Errorf("error creating dynamic client: %v", err)
 	}
 
 	// Create discovery client
 	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
 	if err != nil {
-		return nil, fmt.Errorf("error creating discovery client: %v", err)
-	}
-
-	// Create REST mapper for resource discovery
-	cachedDiscoveryClient := memory.NewMemCacheClient(discoveryClient)
-	restMapper := restmapper.NewDeferredDiscoveryRESTMapper(cachedDiscoveryClient)
-
-	// Set defaults if not specified
-	if len(options.ResourceTypes) == 0 && !options.WatchAll {
-		options.ResourceTypes = DefaultResourceTypes()
+		return Clients{}, fmt.Errorf("error creating discovery client: %v", err)
 	}
 
-	return &K8sWatcher{
-		options:       options,
-		dynamicClient: dynamicClient,
-		discovery:     discoveryClient,
-		restMapper:    restMapper,
-		stopCh:        make(chan struct{}),
-	}, nil
+	return Clients{Dynamic: dynamicClient, Discovery: discoveryClient}, nil
 }
 
-// Start begins watching resources
-func (w *K8sWatcher) Start(ctx context.Context, handler EventHandler) error {
-	w.mu.Lock()
-	if w.watching {
-		w.mu.Unlock()
-		return fmt.Errorf("watcher is already running")
+// NewWatcherWithClients creates a watcher that uses the given clients, e.g.
+// fakes or a recording from the replay package. The kubeconfig path of the
+// options is ignored.
+func NewWatcherWithClients(options Options, clients Clients) (*K8sWatcher, error) {
+	if clients.Dynamic == nil || clients.Discovery == nil {
+		return nil, fmt.Errorf("a dynamic and a discovery client are required")
 	}
-	w.watching = true
-	w.stopCh = make(chan struct{})
-	w.mu.Unlock()
-
-	// Context that can be canceled to stop all watchers
-	watchCtx, cancel := context.WithCancel(ctx)
-	go func() {
-		select {
-		case <-watchCtx.Done():
-			// Context was canceled externally
-		case <-w.stopCh:
-			// Stop was called
-			cancel()
-		}
-	}()
 
-	var resourcesToWatch []ResourceToWatch
-
-	if w.options.WatchAll {
-		var err error
-		resourcesToWatch, err = w.discoverAllResources()
-		if err != nil {
-			return fmt.Errorf("error discovering resources: %v", err)
-		}
-	} else {
-		resourcesToWatch = w.options.ResourceTypes
+	// Compile filters first so invalid expressions are reported at startup
+	filter, err := compileFilters(options.Filters)
+	if err != nil {
+		return nil, err
 	}
 
-	log.Printf("Starting to watch %d resource types", len(resourcesToWatch))
+	// Create REST mapper for resource discovery. Discovery is cached until
+	// the mapper finds no match and resets it, e.g. for a CRD created after
+	// the watcher.
+	cachedDiscoveryClient := memory.NewMemCacheClient(clients.Discovery)
+	restMapper := clients.RESTMapper
+	if restMapper == nil {
+		restMapper = restmapper.NewDeferredDiscoveryRESTMapper(cachedDiscoveryClient)
+	}
+	restMapper = restmapper.NewShortcutExpander(restMapper, cachedDiscoveryClient, func(warning string) {
+		log.Printf("Warning: %s", warning)
+	})
+
+	w := &K8sWatcher{
+		options:       withDefaults(options),
+		dynamicClient: clients.Dynamic,
+		discovery:     clients.Discovery,
+		restMapper:    restMapper,
+		categories:    restmapper.NewDiscoveryCategoryExpander(cachedDiscoveryClient),
+	}
+	w.filter.Store(filter)
+	return w, nil
+}
 
-	// Start watchers for all resource types
-	for _, resource := range resourcesToWatch {
-		w.startResourceWatcher(watchCtx, resource, w.options.Namespace, handler)
+// compileFilters compiles the filters of the options, which are nil when
+// there are none
+func compileFilters(filters []string) (*Filter, error) {
+	if len(filters) == 0 {
+		return nil, nil
 	}
+	return CompileFilter(filters...)
+}
 
-	return nil
+// withDefaults sets the defaults of options that aren't specified
+func withDefaults(options Options) Options {
+	if len(options.ResourceTypes) == 0 && !options.WatchAll {
+		options.ResourceTypes = DefaultResourceTypes()
+	}
+	return options
 }
 
-// Stop halts all watchers
-func (w *K8sWatcher) Stop() {
+// currentOptions returns the options the watcher was last configured with
+func (w *K8sWatcher) currentOptions() Options {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	return w.options
+}
 
-	if !w.watching {
-		return
+// ResolveResource completes a resource type using the RESTMapper: it finds
+// the API version when only a kind is given, and the plural resource name and
+// scope of the kind. Without an API version, the kind can also be a resource
+// name as kubectl takes it, e.g. deploy or deployments.apps. Resources that
+// can't be mapped are returned unchanged if they have an API version, so the
+// watcher can report them as unavailable.
+func (w *K8sWatcher) ResolveResource(resource ResourceToWatch) (ResourceToWatch, error) {
+	if resource.APIVersion == "" {
+		mapping, err := w.mappingFor(resource.Kind)
+		if err != nil {
+			return resource, fmt.Errorf("error resolving kind %s: %v", resource.Kind, err)
+		}
+		resolved := resourceForMapping(mapping)
+		resolved.LabelSelector = resource.LabelSelector
+		resolved.FieldSelector = resource.FieldSelector
+		return resolved, nil
 	}
 
-	close(w.stopCh)
-	w.watching = false
-
-	// Wait for all watchers to finish (with a timeout)
-	done := make(chan struct{})
-	go func() {
-		w.activeWatchers.Wait()
-		close(done)
-	}()
-
-	select {
-	case <-done:
-		// All watchers finished cleanly
-	case <-time.After(10 * time.Second):
-		// Timeout reached, some watchers might still be running
-		log.Printf("Timed out waiting for all watchers to stop")
+	group, version := SplitAPIVersion(resource.APIVersion)
+	mapping, err := w.restMapper.RESTMapping(schema.GroupKind{Group: group, Kind: resource.Kind}, version)
+	if err != nil {
+		log.Printf("Couldn't map %s %s, using defaults: %v", resource.APIVersion, resource.Kind, err)
+		return resource, nil
 	}
-}
 
-// IsWatching returns true if the watcher is currently active
-func (w *K8sWatcher) IsWatching() bool {
-	w.mu.RLock()
-	defer w.mu.RUnlock()
-	return w.watching
+	resource.Resource = mapping.Resource.Resource
+	resource.Namespaced = mapping.Scope.Name() == meta.RESTScopeNameNamespace
+	return resource, nil
 }
 
 // discoverAllResources finds all watchable resources in the cluster
@@ -212,6 +237,7 @@ func (w *K8sWatcher) discoverAllResources() ([]ResourceToWatch, error) {
 				Kind:       r.Kind,
 				APIVersion: apiVersion,
 				Namespaced: r.Namespaced,
+				Resource:   r.Name,
 			})
 		}
 	}
@@ -219,49 +245,63 @@ func (w *K8sWatcher) discoverAllResources() ([]ResourceToWatch, error) {
 	return resources, nil
 }
 
-// startResourceWatcher begins watching a specific resource type
-func (w *K8sWatcher) startResourceWatcher(
-	ctx context.Context,
-	resource ResourceToWatch,
-	namespace string,
-	handler EventHandler,
-) {
-	group, version := SplitAPIVersion(resource.APIVersion)
-
-	// Create GroupVersionResource
-	gvr := schema.GroupVersionResource{
-		Group:    group,
-		Version:  version,
-		Resource: getResourceNameFromKind(resource.Kind),
-	}
+// startResourceWatcher begins watching a resource type for a run, until the
+// watch is canceled or gives up
+func (w *K8sWatcher) startResourceWatcher(r *run, rw *resourceWatch) {
+	ctx := rw.ctx
+	resource := rw.Resource
+	handler := r.handler
+	gvr := resource.GroupVersionResource()
 
 	// Determine if we should watch a specific namespace
 	var resourceInterface dynamic.ResourceInterface
-	if resource.Namespaced && namespace != "" {
-		resourceInterface = w.dynamicClient.Resource(gvr).Namespace(namespace)
+	if resource.Namespaced && rw.Namespace != "" {
+		resourceInterface = w.dynamicClient.Resource(gvr).Namespace(rw.Namespace)
 	} else {
 		resourceInterface = w.dynamicClient.Resource(gvr)
 	}
 
-	resourceStr := resource.Kind
-	if group != "" {
-		resourceStr = fmt.Sprintf("%s.%s/%s", resourceStr, group, version)
-	} else {
-		resourceStr = fmt.Sprintf("%s/%s", resourceStr, version)
-	}
-
+	resourceStr := rw.WatchedResource.String()
 	log.Printf("Starting watcher for: %s", resourceStr)
 
-	// Increment active watcher counter
-	w.activeWatchers.Add(1)
-
 	go func() {
-		defer w.activeWatchers.Done()
-
-		// Track resource versions for detecting real changes
-		resourceVersions := make(map[string]string)
+		// Watchers that give up report why
+		var giveUpErr error
+		defer func() { r.exit(rw, giveUpErr) }()
+
+		// Track the last seen state of each object for detecting real changes
+		objects := make(map[string]map[string]interface{})
+		// Resource version to resume watching from, empty when a relist is needed
+		resourceVersion := ""
 		retries := 0
 
+		// retry waits before the next attempt and reports whether to continue.
+		// The backoff is read on each retry, so Reconfigure changes it for
+		// running watches too.
+		retry := func(action string, err error) bool {
+			backoff := w.currentOptions().Backoff
+			if retries >= backoff.maxRetries() {
+				log.Printf("Giving up on watching %s after multiple failures: %v", resourceStr, err)
+				giveUpErr = fmt.Errorf("%s: %v", resourceStr, err)
+				return false
+			}
+
+			if strings.Contains(err.Error(), "could not find the requested resource") {
+				log.Printf("Resource %s isn't available in this cluster, skipping", resourceStr)
+				giveUpErr = fmt.Errorf("resource %s isn't available in this cluster", resourceStr)
+				return false
+			}
+
+			log.Printf("Error %s %s: %v (will retry)", action, resourceStr, err)
+			retries++
+			select {
+			case <-ctx.Done():
+				return false
+			case <-time.After(backoff.delay(retries)):
+				return true
+			}
+		}
+
 		for {
 			// Check if context is done
 			select {
@@ -272,66 +312,167 @@ func (w *K8sWatcher) startResourceWatcher(
 				// Continue
 			}
 
+			// List the current state first, then watch from the listed version
+			if resourceVersion == "" {
+				list, err := resourceInterface.List(ctx, listOptions(resource))
+				if err != nil {
+					if ctx.Err() != nil || !retry("listing", err) {
+						return
+					}
+					continue
+				}
+
+				w.handleList(list, resource, objects, handler, resourceStr)
+				resourceVersion = list.GetResourceVersion()
+
+				rw.markSynced()
+			}
+
 			// Create watcher with timeout to ensure connection doesn't hang
 			watchContext, watchCancel := context.WithTimeout(ctx, 60*time.Minute)
 
-			watcher, err := resourceInterface.Watch(watchContext, metav1.ListOptions{
-				TimeoutSeconds: ptr.To(int64(3600)), // 1 hour server-side timeout
-			})
+			watchOptions := listOptions(resource)
+			watchOptions.ResourceVersion = resourceVersion
+			watchOptions.AllowWatchBookmarks = true
+			watchOptions.TimeoutSeconds = ptr.To(int64(3600)) // 1 hour server-side timeout
+
+			watcher, err := resourceInterface.Watch(watchContext, watchOptions)
 
 			if err != nil {
-				if retries > 5 {
-					log.Printf("Giving up on watching %s after multiple failures: %v", resourceStr, err)
-					watchCancel()
-					return
+				watchCancel()
+				if apierrors.IsGone(err) || apierrors.IsResourceExpired(err) {
+					log.Printf("Resource version for %s expired, relisting", resourceStr)
+					resourceVersion = ""
+					continue
 				}
-
-				if strings.Contains(err.Error(), "could not find the requested resource") {
-					log.Printf("Resource %s isn't available in this cluster, skipping", resourceStr)
-					watchCancel()
+				if ctx.Err() != nil || !retry("watching", err) {
 					return
 				}
-
-				log.Printf("Error watching %s: %v (will retry)", resourceStr, err)
-				watchCancel()
-				retries++
-				time.Sleep(time.Duration(2*retries) * time.Second) // Exponential backoff
 				continue
 			}
 
 			retries = 0 // Reset retries on successful watch
 
 			log.Printf("Watcher started for %s", resourceStr)
-			ch := watcher.ResultChan()
-
-			for {
-				select {
-				case <-ctx.Done():
-					watcher.Stop()
-					watchCancel()
-					log.Printf("Stopping watcher for %s (context canceled)", resourceStr)
-					return
+			resourceVersion = w.consumeWatch(ctx, watcher, resource, objects, handler, resourceStr, resourceVersion)
+			watchCancel()
 
-				case event, ok := <-ch:
-					if !ok {
-						watchCancel()
-						log.Printf("Watch channel closed for %s, restarting...", resourceStr)
-						time.Sleep(1 * time.Second)
-						break
-					}
+			if ctx.Err() != nil {
+				log.Printf("Stopping watcher for %s (context canceled)", resourceStr)
+				return
+			}
+		}
+	}()
+}
+
+// consumeWatch handles events until the watch ends and returns the resource
+// version to resume from, or an empty string if a relist is needed
+func (w *K8sWatcher) consumeWatch(
+	ctx context.Context,
+	watcher watch.Interface,
+	resource ResourceToWatch,
+	objects map[string]map[string]interface{},
+	handler EventHandler,
+	resourceStr string,
+	resourceVersion string,
+) string {
+	defer watcher.Stop()
+
+	ch := watcher.ResultChan()
+	for {
+		select {
+		case <-ctx.Done():
+			return resourceVersion
+
+		case event, ok := <-ch:
+			if !ok {
+				log.Printf("Watch channel closed for %s, restarting...", resourceStr)
+				return resourceVersion
+			}
+
+			switch event.Type {
+			case watch.Bookmark:
+				// Bookmarks only move the resume point forward
+				if obj, ok := event.Object.(*unstructured.Unstructured); ok {
+					resourceVersion = obj.GetResourceVersion()
+				}
+				continue
 
-					w.handleEvent(event, resource, resourceVersions, handler, resourceStr)
+			case watch.Error:
+				err := apierrors.FromObject(event.Object)
+				if apierrors.IsGone(err) || apierrors.IsResourceExpired(err) {
+					log.Printf("Resource version for %s expired, relisting", resourceStr)
+					return ""
 				}
+				handler(ResourceEvent{
+					Type:     watch.Error,
+					Resource: resource,
+					Error:    fmt.Errorf("error event: %v", err),
+				})
+				continue
+			}
+
+			w.handleEvent(event, resource, objects, handler, resourceStr)
+			if obj, ok := event.Object.(*unstructured.Unstructured); ok {
+				resourceVersion = obj.GetResourceVersion()
 			}
 		}
-	}()
+	}
+}
+
+// handleList reconciles the known objects with a full list, emitting events
+// for objects that were added, changed or removed since the last list
+func (w *K8sWatcher) handleList(
+	list *unstructured.UnstructuredList,
+	resource ResourceToWatch,
+	objects map[string]map[string]interface{},
+	handler EventHandler,
+	resourceStr string,
+) {
+	listed := make(map[string]bool, len(list.Items))
+
+	for i := range list.Items {
+		item := &list.Items[i]
+		resourceKey := fmt.Sprintf("%s/%s", item.GetNamespace(), item.GetName())
+		listed[resourceKey] = true
+
+		eventType := watch.Added
+		if oldObj, ok := objects[resourceKey]; ok {
+			oldRV, _, _ := unstructured.NestedString(oldObj, "metadata", "resourceVersion")
+			if oldRV == item.GetResourceVersion() {
+				continue
+			}
+			eventType = watch.Modified
+		}
+
+		w.handleEvent(watch.Event{Type: eventType, Object: item}, resource, objects, handler, resourceStr)
+	}
+
+	// Objects that are no longer listed were deleted while we weren't watching
+	for resourceKey, oldObj := range objects {
+		if !listed[resourceKey] {
+			w.handleEvent(watch.Event{
+				Type:   watch.Deleted,
+				Object: &unstructured.Unstructured{Object: oldObj},
+			}, resource, objects, handler, resourceStr)
+		}
+	}
+}
+
+// listOptions returns the selectors applied to lists and watches of a
+// resource type, which include those of the options once it is watched
+func listOptions(resource ResourceToWatch) metav1.ListOptions {
+	return metav1.ListOptions{
+		LabelSelector: resource.LabelSelector,
+		FieldSelector: resource.FieldSelector,
+	}
 }
 
 // handleEvent processes an event from the watch channel
 func (w *K8sWatcher) handleEvent(
 	event watch.Event,
 	resource ResourceToWatch,
-	resourceVersions map[string]string,
+	objects map[string]map[string]interface{},
 	handler EventHandler,
 	resourceStr string,
 ) {
@@ -361,23 +502,27 @@ func (w *K8sWatcher) handleEvent(
 
 	switch event.Type {
 	case watch.Added:
-		resourceVersions[resourceKey] = resourceVersion
+		objects[resourceKey] = obj.Object
 
 	case watch.Modified:
-		oldRV := resourceVersions[resourceKey]
-		resourceEvent.PreviousResourceVersion = oldRV
-		resourceVersions[resourceKey] = resourceVersion
+		if oldObj, ok := objects[resourceKey]; ok {
+			resourceEvent.OldObject = oldObj
+			resourceEvent.PreviousResourceVersion, _, _ = unstructured.NestedString(oldObj, "metadata", "resourceVersion")
+		}
+		objects[resourceKey] = obj.Object
 
 	case watch.Deleted:
-		delete(resourceVersions, resourceKey)
-
-	case watch.Error:
-		status, ok := event.Object.(*metav1.Status)
-		if ok {
-			resourceEvent.Error = fmt.Errorf("error event: %s", status.Message)
-		} else {
-			resourceEvent.Error = fmt.Errorf("unknown error event")
+		resourceEvent.OldObject = objects[resourceKey]
+		delete(objects, resourceKey)
+	}
+
+	// Drop events that don't satisfy the configured filters
+	matched, err := w.filter.Load().Match(resourceEvent)
+	if !matched {
+		if err != nil {
+			log.Printf("Filter did not match %s %s: %v", resourceStr, resourceKey, err)
 		}
+		return
 	}
 
 	// Call the handler with the event
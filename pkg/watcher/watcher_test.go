@@ -0,0 +1,934 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var (
+	configMapsGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	secretsGVR    = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+)
+
+var configMapResource = ResourceToWatch{Kind: "ConfigMap", APIVersion: "v1", Namespaced: true, Resource: "configmaps"}
+
+// configMap returns a ConfigMap in the default namespace
+func configMap(name, resourceVersion string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":            name,
+			"namespace":       "default",
+			"resourceVersion": resourceVersion,
+		},
+	}}
+}
+
+// fakeCluster serves ConfigMaps through a fake dynamic client. Lists return
+// the items and version set on it, and every watch gets a fake watcher the
+// test sends events on.
+type fakeCluster struct {
+	client    *dynamicfake.FakeDynamicClient
+	discovery *fakediscovery.FakeDiscovery
+
+	mu          sync.Mutex
+	items       []*unstructured.Unstructured
+	listVersion string
+	// listError fails the lists when set
+	listError error
+	lists     int
+	watches   []*watch.RaceFreeFakeWatcher
+	// watchVersions are the resource versions watches started from
+	watchVersions []string
+}
+
+func newFakeCluster(listVersion string, items ...*unstructured.Unstructured) *fakeCluster {
+	c := &fakeCluster{
+		client: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+			map[schema.GroupVersionResource]string{configMapsGVR: "ConfigMapList", secretsGVR: "SecretList"}),
+		discovery: &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: []*metav1.APIResourceList{{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{{
+				Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: metav1.Verbs{"list", "watch"},
+			}},
+		}}}},
+		items:       items,
+		listVersion: listVersion,
+	}
+
+	c.client.PrependReactor("list", "configmaps", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.lists++
+		if c.listError != nil {
+			return true, nil, c.listError
+		}
+
+		list := &unstructured.UnstructuredList{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMapList",
+			"metadata":   map[string]interface{}{"resourceVersion": c.listVersion},
+		}}
+		for _, item := range c.items {
+			list.Items = append(list.Items, *item.DeepCopy())
+		}
+		return true, list, nil
+	})
+
+	c.client.PrependWatchReactor("configmaps", func(action clienttesting.Action) (bool, watch.Interface, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		w := watch.NewRaceFreeFake()
+		c.watches = append(c.watches, w)
+		c.watchVersions = append(c.watchVersions, action.(clienttesting.WatchAction).GetWatchRestrictions().ResourceVersion)
+		return true, w, nil
+	})
+
+	return c
+}
+
+// clients returns the fake clients
+func (c *fakeCluster) clients() Clients {
+	return Clients{Dynamic: c.client, Discovery: c.discovery}
+}
+
+// setList changes what the next list returns
+func (c *fakeCluster) setList(listVersion string, items ...*unstructured.Unstructured) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listVersion = listVersion
+	c.items = items
+}
+
+// listCount returns the number of list calls
+func (c *fakeCluster) listCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lists
+}
+
+// waitForWatch waits until n watches have been started and returns the
+// last one with the resource version it started from
+func (c *fakeCluster) waitForWatch(t *testing.T, n int) (*watch.RaceFreeFakeWatcher, string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		if len(c.watches) >= n {
+			w, rv := c.watches[n-1], c.watchVersions[n-1]
+			c.mu.Unlock()
+			return w, rv
+		}
+		c.mu.Unlock()
+
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for watch %d", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// events collects the events passed to a handler
+type events chan ResourceEvent
+
+func (e events) handle(event ResourceEvent) {
+	e <- event
+}
+
+// next returns the next event
+func (e events) next(t *testing.T) ResourceEvent {
+	t.Helper()
+	select {
+	case event := <-e:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return ResourceEvent{}
+	}
+}
+
+// expect checks the type, name and resource version of the next event
+func (e events) expect(t *testing.T, eventType watch.EventType, name, resourceVersion string) ResourceEvent {
+	t.Helper()
+	event := e.next(t)
+	if event.Type != eventType || event.Name != name || event.ResourceVersion != resourceVersion {
+		t.Fatalf("event = %s %s@%s, want %s %s@%s",
+			event.Type, event.Name, event.ResourceVersion, eventType, name, resourceVersion)
+	}
+	return event
+}
+
+// expectNone checks that no event arrives for a short while
+func (e events) expectNone(t *testing.T) {
+	t.Helper()
+	select {
+	case event := <-e:
+		t.Fatalf("unexpected event %s %s@%s", event.Type, event.Name, event.ResourceVersion)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// startWatcher watches ConfigMaps in the fake cluster
+func startWatcher(t *testing.T, cluster *fakeCluster, options Options) (*K8sWatcher, events) {
+	t.Helper()
+	if options.ResourceTypes == nil {
+		options.ResourceTypes = []ResourceToWatch{configMapResource}
+	}
+
+	w, err := NewWatcherWithClients(options, cluster.clients())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(events, 100)
+	if err := w.Start(context.Background(), received.handle); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { stopWatcher(t, w) })
+	return w, received
+}
+
+// stopWatcher stops a watcher, failing if its watchers don't exit
+func stopWatcher(t *testing.T, w *K8sWatcher) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if running := w.Stop(ctx); running != nil {
+		t.Errorf("watchers still running after Stop: %v", running)
+	}
+}
+
+// waitForDone waits until the watcher has ended
+func waitForDone(t *testing.T, w *K8sWatcher) {
+	t.Helper()
+	select {
+	case <-w.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the watcher to end")
+	}
+}
+
+func TestWatcherEvents(t *testing.T) {
+	cluster := newFakeCluster("1", configMap("a", "1"))
+	_, received := startWatcher(t, cluster, Options{})
+
+	received.expect(t, watch.Added, "a", "1")
+	fw, rv := cluster.waitForWatch(t, 1)
+	if rv != "1" {
+		t.Errorf("watch started from %q, want the listed version 1", rv)
+	}
+
+	fw.Add(configMap("b", "2"))
+	added := received.expect(t, watch.Added, "b", "2")
+	if added.Namespace != "default" || added.Resource.Kind != "ConfigMap" {
+		t.Errorf("added event = %+v, want ConfigMap in default", added)
+	}
+
+	fw.Modify(configMap("a", "3"))
+	modified := received.expect(t, watch.Modified, "a", "3")
+	if modified.PreviousResourceVersion != "1" {
+		t.Errorf("PreviousResourceVersion = %q, want 1", modified.PreviousResourceVersion)
+	}
+	if rv, _, _ := unstructured.NestedString(modified.OldObject, "metadata", "resourceVersion"); rv != "1" {
+		t.Errorf("OldObject has version %q, want 1", rv)
+	}
+
+	// The previous version follows every modification
+	fw.Modify(configMap("a", "5"))
+	if modified := received.expect(t, watch.Modified, "a", "5"); modified.PreviousResourceVersion != "3" {
+		t.Errorf("PreviousResourceVersion = %q, want 3", modified.PreviousResourceVersion)
+	}
+
+	// Modifications of objects that weren't seen have no previous version
+	fw.Modify(configMap("c", "6"))
+	if modified := received.expect(t, watch.Modified, "c", "6"); modified.PreviousResourceVersion != "" || modified.OldObject != nil {
+		t.Errorf("modification of an unseen object = %+v, want no previous state", modified)
+	}
+
+	fw.Delete(configMap("b", "7"))
+	deleted := received.expect(t, watch.Deleted, "b", "7")
+	if rv, _, _ := unstructured.NestedString(deleted.OldObject, "metadata", "resourceVersion"); rv != "2" {
+		t.Errorf("OldObject of deleted object has version %q, want 2", rv)
+	}
+}
+
+func TestWatcherErrorEvents(t *testing.T) {
+	cluster := newFakeCluster("1")
+	_, received := startWatcher(t, cluster, Options{})
+	fw, _ := cluster.waitForWatch(t, 1)
+
+	fw.Error(&apierrors.NewInternalError(context.DeadlineExceeded).ErrStatus)
+	event := received.next(t)
+	if event.Type != watch.Error || event.Error == nil {
+		t.Fatalf("event = %+v, want an error event", event)
+	}
+	if event.Resource.Kind != "ConfigMap" {
+		t.Errorf("error event for %q, want ConfigMap", event.Resource.Kind)
+	}
+
+	// Errors don't end the watch
+	fw.Add(configMap("a", "2"))
+	received.expect(t, watch.Added, "a", "2")
+	if n := cluster.listCount(); n != 1 {
+		t.Errorf("listed %d times, want 1", n)
+	}
+}
+
+func TestWatcherReconnects(t *testing.T) {
+	cluster := newFakeCluster("1", configMap("a", "1"))
+	_, received := startWatcher(t, cluster, Options{})
+	received.expect(t, watch.Added, "a", "1")
+
+	// A closed watch resumes from the last seen version without a relist
+	fw, _ := cluster.waitForWatch(t, 1)
+	fw.Add(configMap("b", "5"))
+	received.expect(t, watch.Added, "b", "5")
+	fw.Stop()
+
+	fw, rv := cluster.waitForWatch(t, 2)
+	if rv != "5" {
+		t.Errorf("watch resumed from %q, want 5", rv)
+	}
+	if n := cluster.listCount(); n != 1 {
+		t.Errorf("listed %d times after a closed watch, want 1", n)
+	}
+
+	// Bookmarks move the resume point
+	bookmark := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1", "kind": "ConfigMap",
+		"metadata": map[string]interface{}{"resourceVersion": "8"},
+	}}
+	fw.Action(watch.Bookmark, bookmark)
+	fw.Stop()
+	if _, rv := cluster.waitForWatch(t, 3); rv != "8" {
+		t.Errorf("watch resumed from %q after a bookmark, want 8", rv)
+	}
+	received.expectNone(t)
+
+	// An expired version relists, reporting what changed in between
+	cluster.setList("10", configMap("b", "5"), configMap("c", "9"))
+	fw, _ = cluster.waitForWatch(t, 3)
+	fw.Error(&apierrors.NewResourceExpired("too old resource version").ErrStatus)
+
+	got := []string{}
+	for i := 0; i < 2; i++ {
+		event := received.next(t)
+		got = append(got, string(event.Type)+" "+event.Name)
+	}
+	sort.Strings(got)
+	if got[0] != "ADDED c" || got[1] != "DELETED a" {
+		t.Errorf("events after relist = %v, want ADDED c and DELETED a", got)
+	}
+	if _, rv := cluster.waitForWatch(t, 4); rv != "10" {
+		t.Errorf("watch after relist started from %q, want 10", rv)
+	}
+	if n := cluster.listCount(); n != 2 {
+		t.Errorf("listed %d times, want 2", n)
+	}
+}
+
+func TestWatcherStop(t *testing.T) {
+	cluster := newFakeCluster("1")
+	w, received := startWatcher(t, cluster, Options{})
+	fw, _ := cluster.waitForWatch(t, 1)
+
+	if !w.IsWatching() {
+		t.Fatal("IsWatching() = false after Start")
+	}
+	if err := w.Err(); err != nil {
+		t.Errorf("Err() = %v while running, want nil", err)
+	}
+	if err := w.Start(context.Background(), received.handle); err == nil {
+		t.Error("Start() on a running watcher succeeded, want an error")
+	}
+
+	stopWatcher(t, w)
+	if w.IsWatching() {
+		t.Error("IsWatching() = true after Stop")
+	}
+	if !fw.IsStopped() {
+		t.Error("the watch wasn't stopped")
+	}
+	waitForDone(t, w)
+	if err := w.Err(); !errors.Is(err, ErrStopped) {
+		t.Errorf("Err() = %v after Stop, want ErrStopped", err)
+	}
+
+	// Nothing is delivered after Stop, and stopping again is harmless
+	fw.Add(configMap("late", "2"))
+	received.expectNone(t)
+	stopWatcher(t, w)
+}
+
+func TestWatcherRestart(t *testing.T) {
+	cluster := newFakeCluster("1", configMap("a", "1"))
+	w, received := startWatcher(t, cluster, Options{})
+	received.expect(t, watch.Added, "a", "1")
+	stopWatcher(t, w)
+	firstDone := w.Done()
+
+	// A restarted watcher lists again and watches from there
+	cluster.setList("2", configMap("a", "1"), configMap("b", "2"))
+	if err := w.Start(context.Background(), received.handle); err != nil {
+		t.Fatal(err)
+	}
+	if !w.IsWatching() || w.Err() != nil {
+		t.Errorf("restarted watcher: IsWatching() = %v, Err() = %v", w.IsWatching(), w.Err())
+	}
+	if w.Done() == firstDone {
+		t.Error("Done() returned the channel of the previous run")
+	}
+
+	// Each run tracks objects afresh, so everything listed is added
+	got := []string{}
+	for i := 0; i < 2; i++ {
+		event := received.next(t)
+		got = append(got, string(event.Type)+" "+event.Name)
+	}
+	sort.Strings(got)
+	if got[0] != "ADDED a" || got[1] != "ADDED b" {
+		t.Errorf("events after restart = %v, want ADDED a and ADDED b", got)
+	}
+
+	fw, rv := cluster.waitForWatch(t, 2)
+	if rv != "2" {
+		t.Errorf("restarted watch started from %q, want 2", rv)
+	}
+	fw.Add(configMap("c", "3"))
+	received.expect(t, watch.Added, "c", "3")
+}
+
+func TestWatcherStopsWithContext(t *testing.T) {
+	cluster := newFakeCluster("1")
+	w, err := NewWatcherWithClients(Options{ResourceTypes: []ResourceToWatch{configMapResource}}, cluster.clients())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := w.Start(ctx, func(ResourceEvent) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	fw, _ := cluster.waitForWatch(t, 1)
+	cancel()
+
+	waitForDone(t, w)
+	if !fw.IsStopped() {
+		t.Error("the watch wasn't stopped after the context was canceled")
+	}
+	if w.IsWatching() {
+		t.Error("IsWatching() = true after the context was canceled")
+	}
+	if err := w.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Err() = %v, want context.Canceled", err)
+	}
+}
+
+func TestStopReturnsWatchersThatDidNotExit(t *testing.T) {
+	cluster := newFakeCluster("1")
+	w, err := NewWatcherWithClients(Options{ResourceTypes: []ResourceToWatch{configMapResource}}, cluster.clients())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A handler that blocks keeps its watcher from exiting
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	if err := w.Start(context.Background(), func(ResourceEvent) {
+		close(blocked)
+		<-release
+	}); err != nil {
+		t.Fatal(err)
+	}
+	fw, _ := cluster.waitForWatch(t, 1)
+	fw.Add(configMap("a", "2"))
+	<-blocked
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	running := w.Stop(ctx)
+	if len(running) != 1 || running[0] != configMapResource {
+		t.Errorf("Stop() = %v, want the ConfigMap watcher", running)
+	}
+
+	// The watcher can't be started again until it has ended
+	if err := w.Start(context.Background(), func(ResourceEvent) {}); err == nil {
+		t.Error("Start() while still stopping succeeded, want an error")
+	}
+
+	close(release)
+	waitForDone(t, w)
+	if err := w.Err(); !errors.Is(err, ErrStopped) {
+		t.Errorf("Err() = %v, want ErrStopped", err)
+	}
+}
+
+func TestStartFailsWhenListFails(t *testing.T) {
+	cluster := newFakeCluster("1")
+	cluster.listError = errors.New("the server could not find the requested resource")
+
+	w, err := NewWatcherWithClients(Options{ResourceTypes: []ResourceToWatch{configMapResource}}, cluster.clients())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Start(context.Background(), func(ResourceEvent) {}); err == nil {
+		t.Fatal("Start() succeeded, want the list error")
+	}
+
+	// A failed Start leaves nothing running
+	if w.IsWatching() {
+		t.Error("IsWatching() = true after a failed Start")
+	}
+	waitForDone(t, w)
+	if w.Err() == nil {
+		t.Error("Err() = nil after a failed Start")
+	}
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	if n := len(cluster.watches); n != 0 {
+		t.Errorf("%d watches were started", n)
+	}
+}
+
+func TestWatchAndUnwatch(t *testing.T) {
+	cluster := newFakeCluster("1", configMap("a", "1"))
+	cluster.discovery.Resources[0].APIResources = append(cluster.discovery.Resources[0].APIResources,
+		metav1.APIResource{Name: "secrets", Kind: "Secret", Namespaced: true, Verbs: metav1.Verbs{"list", "watch"}})
+
+	secret := configMap("s", "5")
+	secret.SetKind("Secret")
+	secret.SetNamespace("prod")
+	listedIn := make(chan string, 1)
+	cluster.client.PrependReactor("list", "secrets", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		listedIn <- action.GetNamespace()
+		list := &unstructured.UnstructuredList{Object: map[string]interface{}{
+			"apiVersion": "v1", "kind": "SecretList",
+			"metadata": map[string]interface{}{"resourceVersion": "5"},
+		}}
+		list.Items = append(list.Items, *secret.DeepCopy())
+		return true, list, nil
+	})
+	secretWatches := make(chan *watch.RaceFreeFakeWatcher, 1)
+	cluster.client.PrependWatchReactor("secrets", func(action clienttesting.Action) (bool, watch.Interface, error) {
+		fw := watch.NewRaceFreeFake()
+		secretWatches <- fw
+		return true, fw, nil
+	})
+
+	w, received := startWatcher(t, cluster, Options{})
+	received.expect(t, watch.Added, "a", "1")
+
+	// Watch returns once the new type has been listed
+	if err := w.Watch(ResourceToWatch{Kind: "Secret"}, "prod"); err != nil {
+		t.Fatal(err)
+	}
+	if ns := <-listedIn; ns != "prod" {
+		t.Errorf("secrets listed in %q, want prod", ns)
+	}
+	if event := received.expect(t, watch.Added, "s", "5"); event.Resource.Kind != "Secret" {
+		t.Errorf("event for %s, want Secret", event.Resource.Kind)
+	}
+	if err := w.Watch(ResourceToWatch{Kind: "Secret", APIVersion: "v1"}, "prod"); err == nil {
+		t.Error("watching Secrets in prod twice succeeded, want an error")
+	}
+
+	secretResource := ResourceToWatch{Kind: "Secret", APIVersion: "v1", Namespaced: true, Resource: "secrets"}
+	want := []WatchedResource{{Resource: configMapResource}, {Resource: secretResource, Namespace: "prod"}}
+	if got := w.Watched(); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Watched() = %v, want %v", got, want)
+	}
+
+	fw := <-secretWatches
+	secret.SetResourceVersion("6")
+	fw.Modify(secret)
+	received.expect(t, watch.Modified, "s", "6")
+
+	// Unwatch stops the watch of that type only
+	if err := w.Unwatch(ResourceToWatch{Kind: "Secret"}, "prod"); err != nil {
+		t.Fatal(err)
+	}
+	if !fw.IsStopped() {
+		t.Error("the Secret watch wasn't stopped")
+	}
+	if err := w.Unwatch(ResourceToWatch{Kind: "Secret"}, "prod"); err == nil {
+		t.Error("unwatching Secrets twice succeeded, want an error")
+	}
+	if got := w.Watched(); len(got) != 1 || got[0] != want[0] {
+		t.Errorf("Watched() = %v after Unwatch, want %v", got, want[:1])
+	}
+
+	cm, _ := cluster.waitForWatch(t, 1)
+	cm.Add(configMap("b", "7"))
+	received.expect(t, watch.Added, "b", "7")
+
+	// Unwatching every type keeps the watcher running for new watches
+	if err := w.Unwatch(configMapResource, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !w.IsWatching() {
+		t.Error("IsWatching() = false after unwatching every type")
+	}
+	if err := w.Watch(ResourceToWatch{Kind: "ConfigMap"}, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	cluster := newFakeCluster("1", configMap("a", "1"))
+	w, received := startWatcher(t, cluster, Options{Namespaces: []string{"x"}})
+	received.expect(t, watch.Added, "a", "1")
+
+	// Only the watch of the new namespace is started
+	if err := w.Reconfigure(Options{
+		ResourceTypes: []ResourceToWatch{configMapResource},
+		Namespaces:    []string{"x", "y"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n := cluster.listCount(); n != 2 {
+		t.Errorf("%d lists after adding a namespace, want 2", n)
+	}
+	want := []WatchedResource{{Resource: configMapResource, Namespace: "x"}, {Resource: configMapResource, Namespace: "y"}}
+	if got := w.Watched(); !slices.Equal(got, want) {
+		t.Errorf("Watched() = %v, want %v", got, want)
+	}
+
+	// Changed selectors restart the watch they apply to
+	selected := configMapResource
+	selected.LabelSelector = "app=web"
+	if err := w.Reconfigure(Options{
+		ResourceTypes: []ResourceToWatch{configMapResource},
+		Namespaces:    []string{"x", "y"},
+		LabelSelector: "app=web",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n := cluster.listCount(); n != 4 {
+		t.Errorf("%d lists after changing the selector, want 4", n)
+	}
+	want = []WatchedResource{{Resource: selected, Namespace: "x"}, {Resource: selected, Namespace: "y"}}
+	if got := w.Watched(); !slices.Equal(got, want) {
+		t.Errorf("Watched() = %v, want %v", got, want)
+	}
+
+	// Removing a namespace stops its watch, and new filters apply to the
+	// watches that keep running
+	if err := w.Reconfigure(Options{
+		ResourceTypes: []ResourceToWatch{configMapResource},
+		Namespaces:    []string{"y"},
+		LabelSelector: "app=web",
+		Filters:       []string{"object.metadata.name == 'c'"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Watched(); !slices.Equal(got, want[1:]) {
+		t.Errorf("Watched() = %v, want %v", got, want[1:])
+	}
+	for len(received) > 0 {
+		<-received
+	}
+	for _, n := range []int{3, 4} {
+		// The watch of the removed namespace is stopped and drops these
+		fw, _ := cluster.waitForWatch(t, n)
+		fw.Add(configMap("b", "2"))
+		fw.Add(configMap("c", "3"))
+	}
+	received.expect(t, watch.Added, "c", "3")
+	received.expectNone(t)
+
+	// Invalid options change nothing
+	if err := w.Reconfigure(Options{Filters: []string{"object."}}); err == nil {
+		t.Error("Reconfigure() with an invalid filter succeeded, want an error")
+	}
+	if got := w.Watched(); !slices.Equal(got, want[1:]) {
+		t.Errorf("Watched() = %v after a failed Reconfigure, want %v", got, want[1:])
+	}
+	if n := cluster.listCount(); n != 4 {
+		t.Errorf("%d lists after a failed Reconfigure, want 4", n)
+	}
+}
+
+func TestWatchFor(t *testing.T) {
+	options := Options{
+		LabelSelector:     "tier=backend",
+		ExcludeNamespaces: []string{"kube-system", "kube-public"},
+	}
+	resource := configMapResource
+	resource.FieldSelector = "metadata.name=a"
+
+	all := watchFor(options, resource, "")
+	if all.Resource.LabelSelector != "tier=backend" {
+		t.Errorf("label selector = %q, want that of the options", all.Resource.LabelSelector)
+	}
+	wantFields := "metadata.name=a,metadata.namespace!=kube-system,metadata.namespace!=kube-public"
+	if all.Resource.FieldSelector != wantFields {
+		t.Errorf("field selector = %q, want %q", all.Resource.FieldSelector, wantFields)
+	}
+
+	// Excluded namespaces only matter when watching all namespaces
+	if one := watchFor(options, resource, "prod"); one.Resource.FieldSelector != "metadata.name=a" {
+		t.Errorf("field selector in prod = %q, want metadata.name=a", one.Resource.FieldSelector)
+	}
+}
+
+func TestWatchRequiresRunningWatcher(t *testing.T) {
+	cluster := newFakeCluster("1")
+	w, err := NewWatcherWithClients(Options{ResourceTypes: []ResourceToWatch{configMapResource}}, cluster.clients())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Watch(configMapResource, ""); err == nil {
+		t.Error("Watch() before Start succeeded, want an error")
+	}
+	if err := w.Unwatch(configMapResource, ""); err == nil {
+		t.Error("Unwatch() before Start succeeded, want an error")
+	}
+}
+
+func TestStartWatchAllSkipsUnlistableResources(t *testing.T) {
+	cluster := newFakeCluster("1", configMap("a", "1"))
+	cluster.discovery.Resources[0].APIResources = append(cluster.discovery.Resources[0].APIResources,
+		metav1.APIResource{Name: "secrets", Kind: "Secret", Namespaced: true, Verbs: metav1.Verbs{"list", "watch"}})
+	cluster.client.PrependReactor("list", "secrets", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("the server could not find the requested resource")
+	})
+
+	w, received := startWatcher(t, cluster, Options{WatchAll: true})
+	received.expect(t, watch.Added, "a", "1")
+	if !w.IsWatching() {
+		t.Error("IsWatching() = false, want the ConfigMaps to be watched")
+	}
+}
+
+func TestWatcherFilters(t *testing.T) {
+	cluster := newFakeCluster("1", configMap("a", "1"), configMap("b", "1"))
+	_, received := startWatcher(t, cluster, Options{Filters: []string{"object.metadata.name == 'b'"}})
+
+	received.expect(t, watch.Added, "b", "1")
+	received.expectNone(t)
+}
+
+func TestStartWaitsForSync(t *testing.T) {
+	cluster := newFakeCluster("1", configMap("a", "1"))
+	_, received := startWatcher(t, cluster, Options{})
+
+	// The initial list has been delivered when Start returns
+	select {
+	case event := <-received:
+		if event.Name != "a" {
+			t.Errorf("first event for %q, want a", event.Name)
+		}
+	default:
+		t.Error("Start() returned before the initial list was handled")
+	}
+}
+
+func TestDiscoverAllResources(t *testing.T) {
+	discovery := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "pods", Kind: "Pod", Namespaced: true, Verbs: metav1.Verbs{"get", "list", "watch"}},
+				{Name: "pods/log", Kind: "Pod", Namespaced: true, Verbs: metav1.Verbs{"get"}},
+				{Name: "pods/status", Kind: "Pod", Namespaced: true, Verbs: metav1.Verbs{"get", "watch"}},
+				{Name: "bindings", Kind: "Binding", Namespaced: true, Verbs: metav1.Verbs{"create"}},
+				{Name: "namespaces", Kind: "Namespace", Verbs: metav1.Verbs{"list", "watch"}},
+			},
+		},
+		{
+			GroupVersion: "apps/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "deployments", Kind: "Deployment", Namespaced: true, Verbs: metav1.Verbs{"list", "watch"}},
+				{Name: "deployments/scale", Kind: "Scale", Namespaced: true, Verbs: metav1.Verbs{"get", "watch"}},
+			},
+		},
+	}}}
+
+	cluster := newFakeCluster("1")
+	w, err := NewWatcherWithClients(Options{WatchAll: true}, Clients{Dynamic: cluster.client, Discovery: discovery})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resources, err := w.discoverAllResources()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ResourceToWatch{
+		{Kind: "Pod", APIVersion: "v1", Namespaced: true, Resource: "pods"},
+		{Kind: "Namespace", APIVersion: "v1", Namespaced: false, Resource: "namespaces"},
+		{Kind: "Deployment", APIVersion: "apps/v1", Namespaced: true, Resource: "deployments"},
+	}
+	if len(resources) != len(want) {
+		t.Fatalf("discoverAllResources() = %+v, want %+v", resources, want)
+	}
+	for i := range want {
+		if resources[i] != want[i] {
+			t.Errorf("resource %d = %+v, want %+v", i, resources[i], want[i])
+		}
+	}
+}
+
+func TestResolveResources(t *testing.T) {
+	discovery := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "pods", SingularName: "pod", Kind: "Pod", Namespaced: true, ShortNames: []string{"po"},
+					Categories: []string{"all"}, Verbs: metav1.Verbs{"list", "watch"}},
+				{Name: "nodes", SingularName: "node", Kind: "Node", ShortNames: []string{"no"}, Verbs: metav1.Verbs{"list", "watch"}},
+			},
+		},
+		{
+			GroupVersion: "apps/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "deployments", SingularName: "deployment", Kind: "Deployment", Namespaced: true,
+					ShortNames: []string{"deploy"}, Categories: []string{"all"}, Verbs: metav1.Verbs{"list", "watch"}},
+			},
+		},
+		{
+			GroupVersion: "example.com/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "apps", SingularName: "app", Kind: "App", Namespaced: true, Verbs: metav1.Verbs{"list", "watch"}},
+			},
+		},
+	}}}
+
+	cluster := newFakeCluster("1")
+	w, err := NewWatcherWithClients(Options{}, Clients{Dynamic: cluster.client, Discovery: discovery})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pods := ResourceToWatch{Kind: "Pod", APIVersion: "v1", Namespaced: true, Resource: "pods"}
+	nodes := ResourceToWatch{Kind: "Node", APIVersion: "v1", Resource: "nodes"}
+	deployments := ResourceToWatch{Kind: "Deployment", APIVersion: "apps/v1", Namespaced: true, Resource: "deployments"}
+	apps := ResourceToWatch{Kind: "App", APIVersion: "example.com/v1", Namespaced: true, Resource: "apps"}
+
+	tests := []struct {
+		arg  string
+		want []ResourceToWatch
+	}{
+		{"pods", []ResourceToWatch{pods}},
+		{"pod", []ResourceToWatch{pods}},
+		{"po", []ResourceToWatch{pods}},
+		{"Pod", []ResourceToWatch{pods}},
+		{"no", []ResourceToWatch{nodes}},
+		{"deploy", []ResourceToWatch{deployments}},
+		{"deployments.apps", []ResourceToWatch{deployments}},
+		{"deployments.v1.apps", []ResourceToWatch{deployments}},
+		{"Deployment.apps", []ResourceToWatch{deployments}},
+		{"Deployment.apps/v1", []ResourceToWatch{deployments}},
+		{"apps.example.com", []ResourceToWatch{apps}},
+		{"po,deploy, pods", []ResourceToWatch{pods, deployments}},
+		{"all", []ResourceToWatch{pods, deployments}},
+	}
+	for _, tt := range tests {
+		got, err := w.ResolveResources(tt.arg)
+		if err != nil {
+			t.Errorf("ResolveResources(%q) failed: %v", tt.arg, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ResolveResources(%q) = %v, want %v", tt.arg, got, tt.want)
+		}
+	}
+
+	for _, arg := range []string{"widgets", "po,,deploy", "Pod/"} {
+		if _, err := w.ResolveResources(arg); err == nil {
+			t.Errorf("ResolveResources(%q) succeeded, want an error", arg)
+		}
+	}
+
+	// Short names work wherever a kind is resolved
+	if got, err := w.ResolveResource(ResourceToWatch{Kind: "deploy", LabelSelector: "app=web"}); err != nil {
+		t.Error(err)
+	} else if got.Kind != "Deployment" || got.LabelSelector != "app=web" {
+		t.Errorf("ResolveResource(deploy) = %+v, want a Deployment with the selector", got)
+	}
+}
+
+func TestResolveResourceWithRESTMapper(t *testing.T) {
+	widgets := schema.GroupVersion{Group: "example.com", Version: "v1"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{widgets})
+	mapper.Add(widgets.WithKind("Widget"), meta.RESTScopeNamespace)
+
+	cluster := newFakeCluster("1")
+	clients := cluster.clients()
+	clients.RESTMapper = mapper
+	w, err := NewWatcherWithClients(Options{}, clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolved, err := w.ResolveResource(ResourceToWatch{Kind: "Widget"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ResourceToWatch{Kind: "Widget", APIVersion: "example.com/v1", Namespaced: true, Resource: "widgets"}
+	if resolved != want {
+		t.Errorf("ResolveResource(Widget) = %+v, want %+v", resolved, want)
+	}
+
+	if _, err := w.ResolveResource(ResourceToWatch{Kind: "Gadget"}); err == nil {
+		t.Error("ResolveResource(Gadget) succeeded, want an error for an unknown kind")
+	}
+}
+
+func TestNewWatcherWithClientsRequiresClients(t *testing.T) {
+	if _, err := NewWatcherWithClients(Options{}, Clients{}); err == nil {
+		t.Error("NewWatcherWithClients() without clients succeeded, want an error")
+	}
+}
+
+func TestParseResource(t *testing.T) {
+	tests := []struct {
+		input string
+		want  ResourceToWatch
+	}{
+		{"NetworkPolicy", ResourceToWatch{Kind: "NetworkPolicy"}},
+		{"Pod/v1", ResourceToWatch{Kind: "Pod", APIVersion: "v1"}},
+		{"Deployment.apps/v1", ResourceToWatch{Kind: "Deployment", APIVersion: "apps/v1"}},
+		{"Widget.example.com/v1alpha1", ResourceToWatch{Kind: "Widget", APIVersion: "example.com/v1alpha1"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseResource(tt.input)
+		if err != nil {
+			t.Errorf("ParseResource(%q): %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseResource(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+		// Resolved types read back the same
+		if tt.want.APIVersion != "" && got.String() != tt.input {
+			t.Errorf("ParseResource(%q).String() = %q", tt.input, got.String())
+		}
+	}
+
+	for _, input := range []string{"", "Deployment.apps", "/v1", "Pod/"} {
+		if _, err := ParseResource(input); err == nil {
+			t.Errorf("ParseResource(%q) succeeded, want an error", input)
+		}
+	}
+}